@@ -0,0 +1,207 @@
+package apperrors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Code string
+
+const (
+	BadRequest            Code = "BAD_REQUEST"
+	ValidationFailed      Code = "VALIDATION_FAILED"
+	Unauthorized          Code = "UNAUTHORIZED"
+	SessionLocked         Code = "SESSION_LOCKED"
+	Forbidden             Code = "FORBIDDEN"
+	EmailNotVerified      Code = "EMAIL_NOT_VERIFIED"
+	MfaEnrollmentRequired Code = "MFA_ENROLLMENT_REQUIRED"
+	NotFound              Code = "NOT_FOUND"
+	Conflict              Code = "CONFLICT"
+	PreconditionFailed    Code = "PRECONDITION_FAILED"
+	PreconditionRequired  Code = "PRECONDITION_REQUIRED"
+	AccountLocked         Code = "ACCOUNT_LOCKED"
+	RateLimited           Code = "RATE_LIMITED"
+	Internal              Code = "INTERNAL"
+)
+
+var statusByCode = map[Code]int{
+	BadRequest:            http.StatusBadRequest,
+	ValidationFailed:      http.StatusBadRequest,
+	Unauthorized:          http.StatusUnauthorized,
+	SessionLocked:         http.StatusUnauthorized,
+	Forbidden:             http.StatusForbidden,
+	EmailNotVerified:      http.StatusForbidden,
+	MfaEnrollmentRequired: http.StatusForbidden,
+	NotFound:              http.StatusNotFound,
+	Conflict:              http.StatusConflict,
+	PreconditionFailed:    http.StatusPreconditionFailed,
+	PreconditionRequired:  http.StatusPreconditionRequired,
+	AccountLocked:         http.StatusLocked,
+	RateLimited:           http.StatusTooManyRequests,
+	Internal:              http.StatusInternalServerError,
+}
+
+// Status is the HTTP status a code is reported with.
+func Status(code Code) int {
+	if status, ok := statusByCode[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is an error that knows how it should be reported to a client.
+// Message is shown to the client for every code except Internal, whose
+// message and cause are only logged. RetryAfter, when set, is sent as a
+// Retry-After header.
+type Error struct {
+	Code       Code
+	Message    string
+	Fields     []FieldError
+	Cause      error
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Wrap(code Code, message string, cause error) *Error {
+	return &Error{Code: code, Message: message, Cause: cause}
+}
+
+// FromDB classifies an error returned by the Mongo driver. resource names
+// the kind of object that was being read or written, e.g. "food".
+func FromDB(err error, resource string) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return Wrap(NotFound, capitalize(resource)+" not found", err)
+	case mongo.IsDuplicateKeyError(err):
+		return Wrap(Conflict, capitalize(resource)+" already exists", err)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return Wrap(Internal, "The "+resource+" request timed out", err)
+	}
+
+	return Wrap(Internal, "There was an error while accessing the "+resource+" collection", err)
+}
+
+// Reference classifies the error from looking up an object that the request
+// body refers to by id. A missing object is the client's mistake, so it is
+// reported against the field rather than as a 404 for the whole request.
+func Reference(err error, field string, resource string) *Error {
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return FromDB(err, resource)
+	}
+
+	return &Error{
+		Code:    ValidationFailed,
+		Message: "The request body is not valid",
+		Fields:  []FieldError{{Field: field, Rule: "exists", Message: "does not match an existing " + resource}},
+		Cause:   err,
+	}
+}
+
+// Validation turns a request binding or validator error into a
+// VALIDATION_FAILED error with one entry per offending field.
+func Validation(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	validationErr := &Error{Code: ValidationFailed, Message: "The request body is not valid", Cause: err}
+
+	var fieldErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &fieldErrs):
+		for _, fieldErr := range fieldErrs {
+			validationErr.Fields = append(validationErr.Fields, FieldError{
+				Field:   fieldErr.Field(),
+				Rule:    fieldErr.Tag(),
+				Message: ruleMessage(fieldErr),
+			})
+		}
+	case errors.As(err, &typeErr):
+		validationErr.Fields = append(validationErr.Fields, FieldError{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: "must be a " + typeErr.Type.String(),
+		})
+	case errors.Is(err, io.EOF):
+		validationErr.Code = BadRequest
+		validationErr.Message = "The request body is empty"
+	case errors.As(err, &syntaxErr):
+		validationErr.Code = BadRequest
+		validationErr.Message = "The request body is not valid JSON"
+	default:
+		validationErr.Code = BadRequest
+		validationErr.Message = "The request body could not be read"
+	}
+
+	return validationErr
+}
+
+func ruleMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fieldErr.Param()
+	case "max":
+		return "must be at most " + fieldErr.Param()
+	case "email":
+		return "must be a valid email address"
+	case "eq":
+		return "must be " + fieldErr.Param()
+	}
+
+	if strings.Contains(fieldErr.Tag(), "|") {
+		var allowed []string
+		for _, rule := range strings.Split(fieldErr.Tag(), "|") {
+			allowed = append(allowed, strings.TrimPrefix(rule, "eq="))
+		}
+		return "must be one of " + strings.Join(allowed, ", ")
+	}
+
+	return "failed the " + fieldErr.Tag() + " rule"
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
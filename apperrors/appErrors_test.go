@@ -0,0 +1,151 @@
+package apperrors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestFromDB(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	forbidden := New(Forbidden, "User not authorized.")
+
+	tests := []struct {
+		name    string
+		err     error
+		code    Code
+		message string
+	}{
+		{"not found", mongo.ErrNoDocuments, NotFound, "Food not found"},
+		{"wrapped not found", fmt.Errorf("finding: %w", mongo.ErrNoDocuments), NotFound, "Food not found"},
+		{"duplicate key", duplicate, Conflict, "Food already exists"},
+		{"timed out", context.DeadlineExceeded, Internal, "The food request timed out"},
+		{"anything else", errors.New("connection reset"), Internal, "There was an error while accessing the food collection"},
+		{"already classified", forbidden, Forbidden, "User not authorized."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := FromDB(test.err, "food")
+			if err.Code != test.code || err.Message != test.message {
+				t.Errorf("FromDB = %s %q, want %s %q", err.Code, err.Message, test.code, test.message)
+			}
+			// The cause is kept for the logs.
+			if !strings.Contains(err.Error(), test.err.Error()) {
+				t.Errorf("FromDB(%v) = %v, which loses the cause", test.err, err)
+			}
+		})
+	}
+}
+
+func TestReference(t *testing.T) {
+	err := Reference(mongo.ErrNoDocuments, "menu_id", "menu")
+	if err.Code != ValidationFailed || len(err.Fields) != 1 || err.Fields[0] != (FieldError{Field: "menu_id", Rule: "exists", Message: "does not match an existing menu"}) {
+		t.Errorf("missing reference = %+v, want a menu_id field error", err)
+	}
+
+	if err := Reference(context.DeadlineExceeded, "menu_id", "menu"); err.Code != Internal {
+		t.Errorf("failed lookup = %s, want %s", err.Code, Internal)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	type food struct {
+		Name     string   `json:"name" validate:"required,min=2"`
+		Price    float64  `json:"price" validate:"min=0.01"`
+		Currency string   `json:"currency" validate:"eq=USD|eq=EUR"`
+		Tags     []string `json:"tags" validate:"max=1"`
+	}
+
+	validate := validator.New()
+	err := Validation(validate.Struct(food{Currency: "GBP", Tags: []string{"a", "b"}}))
+	want := []FieldError{
+		{Field: "Name", Rule: "required", Message: "is required"},
+		{Field: "Price", Rule: "min", Message: "must be at least 0.01"},
+		{Field: "Currency", Rule: "eq=USD|eq=EUR", Message: "must be one of USD, EUR"},
+		{Field: "Tags", Rule: "max", Message: "must be at most 1"},
+	}
+	if err.Code != ValidationFailed || fmt.Sprint(err.Fields) != fmt.Sprint(want) {
+		t.Errorf("Validation = %s %+v, want %s %+v", err.Code, err.Fields, ValidationFailed, want)
+	}
+
+	var body food
+	tests := []struct {
+		name    string
+		body    string
+		code    Code
+		message string
+	}{
+		{"wrong type", `{"price":"free"}`, ValidationFailed, "The request body is not valid"},
+		{"not JSON", `{"price":`, BadRequest, "The request body could not be read"},
+		{"bad syntax", `{"price" 1}`, BadRequest, "The request body is not valid JSON"},
+		{"empty", ``, BadRequest, "The request body is empty"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validation(json.NewDecoder(strings.NewReader(test.body)).Decode(&body))
+			if err.Code != test.code || err.Message != test.message {
+				t.Errorf("Validation = %s %q, want %s %q", err.Code, err.Message, test.code, test.message)
+			}
+		})
+	}
+
+	typeErr := Validation(json.Unmarshal([]byte(`{"price":"free"}`), &body))
+	if len(typeErr.Fields) != 1 || typeErr.Fields[0] != (FieldError{Field: "price", Rule: "type", Message: "must be a float64"}) {
+		t.Errorf("wrong type fields = %+v, want price must be a float64", typeErr.Fields)
+	}
+}
+
+func TestRespond(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		detail     string
+		retryAfter string
+	}{
+		{"client error", &Error{Code: ValidationFailed, Message: "The request body is not valid", Fields: []FieldError{{Field: "name", Rule: "required", Message: "is required"}}}, http.StatusBadRequest, "The request body is not valid", ""},
+		{"internal", Wrap(Internal, "Mongo password is hunter2", errors.New("auth failed")), http.StatusInternalServerError, "An internal error occurred. Please try again later", ""},
+		{"plain error", errors.New("something broke"), http.StatusInternalServerError, "An internal error occurred. Please try again later", ""},
+		{"rate limited", &Error{Code: RateLimited, Message: "Too many requests", RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests, "Too many requests", "2"},
+		{"unknown code", New("TEAPOT", "I'm a teapot"), http.StatusInternalServerError, "I'm a teapot", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/foods", nil)
+
+			Respond(c, test.err)
+
+			if !c.IsAborted() {
+				t.Error("Respond didn't abort the request")
+			}
+			if w.Code != test.status || w.Header().Get("Content-Type") != ProblemContentType {
+				t.Errorf("Respond wrote %d %s, want %d %s", w.Code, w.Header().Get("Content-Type"), test.status, ProblemContentType)
+			}
+			if got := w.Header().Get("Retry-After"); got != test.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, test.retryAfter)
+			}
+
+			var problem Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Status != test.status || problem.Detail != test.detail || problem.Instance != "/foods" {
+				t.Errorf("problem = %+v, want status %d with detail %q at /foods", problem, test.status, test.detail)
+			}
+		})
+	}
+}
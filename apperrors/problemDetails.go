@@ -0,0 +1,67 @@
+package apperrors
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/logging"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     Code         `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+func NewProblem(err *Error, instance string) Problem {
+	status := Status(err.Code)
+
+	problem := Problem{
+		Type:     "urn:restaurant-project:problem:" + strings.ToLower(strings.ReplaceAll(string(err.Code), "_", "-")),
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Message,
+		Instance: instance,
+		Code:     err.Code,
+		Errors:   err.Fields,
+	}
+
+	// Internal errors are logged in full but never described to the client.
+	if err.Code == Internal {
+		problem.Detail = "An internal error occurred. Please try again later"
+	}
+
+	return problem
+}
+
+// Respond writes err as application/problem+json and aborts the request.
+// Errors that are not an *Error are treated as internal.
+func Respond(c *gin.Context, err error) {
+	var appErr *Error
+	if !errors.As(err, &appErr) {
+		appErr = Wrap(Internal, "Unhandled error", err)
+	}
+
+	if appErr.Code == Internal {
+		ctx := c.Request.Context()
+		logging.FromContext(ctx).ErrorContext(ctx, "internal error", "method", c.Request.Method, "path", c.Request.URL.Path, "error", appErr)
+	}
+
+	if appErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(appErr.RetryAfter.Seconds()))))
+	}
+
+	c.Header("Content-Type", ProblemContentType)
+	c.AbortWithStatusJSON(Status(appErr.Code), NewProblem(appErr, c.Request.URL.Path))
+}
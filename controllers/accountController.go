@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/mailer"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+type ForgotPasswordRequest struct {
+	Email *string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string  `json:"token" validate:"required"`
+	Password *string `json:"password" validate:"required,min=6"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ForgotPassword mails a password reset link. It answers the same way
+// whether or not the email has an account.
+func ForgotPassword() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req ForgotPasswordRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		accepted := gin.H{"message": "If that email has an account, a password reset link has been sent to it"}
+
+		var user models.User
+		err := userCollection.FindOne(ctx, bson.M{"email": req.Email}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		token, err := accountTokens.Issue(ctx, user.User_id, services.TokenPurposePasswordReset, passwordResetTTL)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "account token"))
+			return
+		}
+
+		err = mail.Send(ctx, mailer.Message{
+			To:      *user.Email,
+			Subject: "Reset your password",
+			Body:    "Someone asked to reset the password for your account. If it was you, open this link within an hour:\n\n" + frontEndLink("/reset-password", token) + "\n\nIf it wasn't you, you can ignore this email.",
+		})
+		// Failing here would tell the caller the email has an account.
+		if err != nil {
+			logging.FromContext(ctx).Error("could not send password reset email", "user_id", user.User_id, "error", err)
+		}
+
+		c.JSON(http.StatusAccepted, accepted)
+	}
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. The
+// user's stored tokens and any login lockout are cleared.
+func ResetPassword() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req ResetPasswordRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		userId, err := accountTokens.Redeem(ctx, req.Token, services.TokenPurposePasswordReset)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "account token"))
+			return
+		}
+
+		hashedPassword, err := HashPassword(*req.Password)
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while hashing the password", err))
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		update := bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "password", Value: hashedPassword},
+				{Key: "updated_at", Value: updatedAt},
+			}},
+			{Key: "$unset", Value: bson.D{{Key: "token", Value: ""}, {Key: "refresh_token", Value: ""}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+
+		var user models.User
+		err = userCollection.FindOneAndUpdate(ctx, bson.M{"user_id": userId}, update).Decode(&user)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if user.Email != nil {
+			if err := loginGuard.Unlock(ctx, *user.Email); err != nil {
+				logging.FromContext(ctx).Warn("could not clear failed logins", "error", err)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Password has been reset",
+		})
+	}
+}
+
+// VerifyEmail confirms that the user owns their email address using a token
+// from the verification email.
+func VerifyEmail() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req VerifyEmailRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		userId, err := accountTokens.Redeem(ctx, req.Token, services.TokenPurposeEmailVerification)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "account token"))
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		update := bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "email_verified", Value: true},
+				{Key: "email_verified_at", Value: now},
+				{Key: "updated_at", Value: now},
+			}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+
+		result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userId}, update)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			apperrors.Respond(c, apperrors.FromDB(mongo.ErrNoDocuments, "user"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Email address verified",
+		})
+	}
+}
+
+// ResendVerification mails a new verification link to the signed in user.
+func ResendVerification() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if user.Email_Verified {
+			apperrors.Respond(c, apperrors.New(apperrors.Conflict, "Email address is already verified"))
+			return
+		}
+
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while sending the verification email", err))
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "A verification link has been sent to " + *user.Email,
+		})
+	}
+}
+
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := accountTokens.Issue(ctx, user.User_id, services.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return mail.Send(ctx, mailer.Message{
+		To:      *user.Email,
+		Subject: "Confirm your email address",
+		Body:    "Open this link within 48 hours to confirm your email address:\n\n" + frontEndLink("/verify-email", token),
+	})
+}
+
+// frontEndLink builds a link into the front end at APP_BASE_URL, which is
+// expected to post the token back to the API.
+func frontEndLink(path string, token string) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return base + path + "?token=" + url.QueryEscape(token)
+}
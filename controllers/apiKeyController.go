@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultRotationGrace = 24 * time.Hour
+
+type ApiKeyIssued struct {
+	Api_Key models.ApiKey `json:"api_key"`
+	Key     string        `json:"key"`
+}
+
+type RotateApiKeyRequest struct {
+	Grace_Period *int       `json:"grace_period" validate:"omitempty,min=0,max=604800"`
+	Expires_At   *time.Time `json:"expires_at"`
+}
+
+// CreateApiKey issues a key for a machine client. The key is only returned
+// here; afterwards only its prefix is shown.
+func CreateApiKey() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var apiKey models.ApiKey
+
+		if err := c.ShouldBindJSON(&apiKey); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(apiKey); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if !validApiKeyRequest(c, apiKey.Scopes, apiKey.Expires_At) {
+			return
+		}
+
+		issued, err := insertApiKey(ctx, *apiKey.Name, apiKey.Scopes, apiKey.Expires_At, c.GetString("uid"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "API key"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, issued)
+	}
+}
+
+func GetApiKeys() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		results, err := apiKeyCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "API key"))
+			return
+		}
+
+		allApiKeys := []models.ApiKey{}
+
+		if err := results.All(ctx, &allApiKeys); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "API key"))
+			return
+		}
+
+		c.JSON(http.StatusOK, allApiKeys)
+	}
+}
+
+// RotateApiKey issues a replacement with the same name and scopes. The old
+// key keeps working for grace_period seconds, a day by default, so the client
+// can switch over without downtime.
+func RotateApiKey() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var req RotateApiKeyRequest
+
+		// The body is optional.
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		var old models.ApiKey
+		apiKeyId := c.Param("api_key_id")
+
+		if err := apiKeyCollection.FindOne(ctx, bson.M{"api_key_id": apiKeyId, "revoked_at": nil, "replaced_by": nil}).Decode(&old); err != nil {
+			if err == mongo.ErrNoDocuments {
+				apperrors.Respond(c, apperrors.New(apperrors.NotFound, "No active API key with that api_key_id"))
+				return
+			}
+			apperrors.Respond(c, apperrors.FromDB(err, "API key"))
+			return
+		}
+
+		expiresAt := old.Expires_At
+		if req.Expires_At != nil {
+			expiresAt = req.Expires_At
+		}
+
+		if !validApiKeyRequest(c, old.Scopes, expiresAt) {
+			return
+		}
+
+		issued, err := insertApiKey(ctx, *old.Name, old.Scopes, expiresAt, c.GetString("uid"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "API key"))
+			return
+		}
+
+		grace := defaultRotationGrace
+		if req.Grace_Period != nil {
+			grace = time.Duration(*req.Grace_Period) * time.Second
+		}
+
+		now := time.Now()
+		retireAt := now.Add(grace)
+		if old.Expires_At != nil && old.Expires_At.Before(retireAt) {
+			retireAt = *old.Expires_At
+		}
+
+		update := bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "expires_at", Value: retireAt},
+				{Key: "replaced_by", Value: issued.Api_Key.Api_key_id},
+				{Key: "updated_at", Value: now},
+			}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+
+		// Matching on replaced_by stops two rotations of the same key both
+		// succeeding.
+		result, err := apiKeyCollection.UpdateOne(ctx, bson.M{"api_key_id": apiKeyId, "replaced_by": nil}, update)
+		if err == nil && result.MatchedCount == 0 {
+			err = mongo.ErrNoDocuments
+		}
+		if err != nil {
+			apiKeyCollection.DeleteOne(ctx, bson.M{"api_key_id": issued.Api_Key.Api_key_id})
+			apperrors.Respond(c, apperrors.New(apperrors.Conflict, "API key was rotated or revoked at the same time"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, issued)
+	}
+}
+
+// RevokeApiKey stops a key from working straight away. The key is kept so
+// the changes made with it can still be traced.
+func RevokeApiKey() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		update := bson.D{
+			{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: now}, {Key: "updated_at", Value: now}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+
+		result, err := apiKeyCollection.UpdateOne(ctx, bson.M{"api_key_id": c.Param("api_key_id"), "revoked_at": nil}, update)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "API key"))
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			apperrors.Respond(c, apperrors.New(apperrors.NotFound, "API key with that api_key_id not found or already revoked"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "API key revoked",
+		})
+	}
+}
+
+func insertApiKey(ctx context.Context, name string, scopes []string, expiresAt *time.Time, createdBy string) (ApiKeyIssued, error) {
+	key, hash, err := helpers.NewApiKey()
+	if err != nil {
+		return ApiKeyIssued{}, err
+	}
+
+	apiKey := models.ApiKey{
+		ID:         primitive.NewObjectID(),
+		Name:       &name,
+		Scopes:     scopes,
+		Prefix:     key[:len(helpers.ApiKeyPrefix)+6],
+		Key_Hash:   hash,
+		Expires_At: expiresAt,
+		Created_By: createdBy,
+		Version:    1,
+	}
+	apiKey.Api_key_id = apiKey.ID.Hex()
+	apiKey.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	apiKey.Updated_At = apiKey.Created_At
+
+	if _, err := apiKeyCollection.InsertOne(ctx, apiKey); err != nil {
+		return ApiKeyIssued{}, err
+	}
+
+	return ApiKeyIssued{Api_Key: apiKey, Key: key}, nil
+}
+
+func validApiKeyRequest(c *gin.Context, scopes []string, expiresAt *time.Time) bool {
+	var fields []apperrors.FieldError
+
+	for _, scope := range scopes {
+		if !helpers.HasScope(helpers.ApiKeyScopes, scope) {
+			fields = append(fields, apperrors.FieldError{Field: "scopes", Rule: "oneof", Message: "unknown scope " + scope})
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		fields = append(fields, apperrors.FieldError{Field: "expires_at", Rule: "gt", Message: "must be in the future"})
+	}
+
+	if len(fields) > 0 {
+		apperrors.Respond(c, &apperrors.Error{Code: apperrors.ValidationFailed, Message: "The request body is not valid", Fields: fields})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+
+func CheckoutOrder() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var checkoutReq services.CheckoutRequest
+
+		if err := c.ShouldBindJSON(&checkoutReq); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(checkoutReq); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		checkoutReq.Order_id = c.Param("order_id")
+		checkoutReq.Actor = helpers.ActorFrom(c)
+
+		result, err := checkoutService.Checkout(ctx, checkoutReq)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Checkout Successful",
+			"results": result,
+		})
+	}
+}
+
+func RefundInvoice() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		refund, err := checkoutService.Refund(ctx, c.Param("invoice_id"), helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Refund Successful",
+			"results": refund,
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/geo"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+func GetDeliveryZones() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		zones, err := deliveryZones.List(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+			return
+		}
+
+		c.JSON(http.StatusOK, zones)
+	}
+}
+
+func GetDeliveryZone() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		zone, err := deliveryZones.Find(ctx, c.Param("zone_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+			return
+		}
+
+		helpers.SetETag(c, zone.Version)
+		c.JSON(http.StatusOK, zone)
+	}
+}
+
+func CreateDeliveryZone() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var zone models.DeliveryZone
+
+		if err := c.ShouldBindJSON(&zone); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(zone); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		zone, err := deliveryZones.Create(ctx, zone, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+			return
+		}
+
+		helpers.SetETag(c, zone.Version)
+		c.JSON(http.StatusCreated, zone)
+	}
+}
+
+func UpdateDeliveryZone() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		var change services.DeliveryZoneUpdate
+
+		if err := c.ShouldBindJSON(&change); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(change); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		zone, err := deliveryZones.Update(ctx, c.Param("zone_id"), version, change, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+			return
+		}
+
+		helpers.SetETag(c, zone.Version)
+		c.JSON(http.StatusOK, zone)
+	}
+}
+
+// DeleteDeliveryZone stops deliveries to the zone. Orders already placed keep
+// the fee they were quoted.
+func DeleteDeliveryZone() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		if err := deliveryZones.Delete(ctx, c.Param("zone_id"), version); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Deletion Successful"})
+	}
+}
+
+// QuoteDelivery tells whether the restaurant delivers to an address, and
+// for how much, before an order is placed.
+func QuoteDelivery() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.DeliveryQuoteRequest
+
+		if err := c.ShouldBindQuery(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		quote, err := deliveryZones.Quote(ctx, geo.Point{Lat: *req.Latitude, Lng: *req.Longitude})
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+			return
+		}
+
+		c.JSON(http.StatusOK, quote)
+	}
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FloorTable is a table as shown on the board. Server_id is the server
+// looking after the table, which is the section's server unless the table
+// has one of its own.
+type FloorTable struct {
+	models.Table
+	Open_Order_id *string `json:"open_order_id"`
+}
+
+type FloorSection struct {
+	models.Section
+	Tables []FloorTable `json:"tables"`
+}
+
+type FloorRoom struct {
+	Name     string         `json:"name"`
+	Sections []FloorSection `json:"sections"`
+}
+
+// Floor is the live table board hosts seat guests from.
+type Floor struct {
+	Rooms        []FloorRoom    `json:"rooms"`
+	Unassigned   []FloorTable   `json:"unassigned"`
+	Summary      map[string]int `json:"summary"`
+	Generated_At time.Time      `json:"generated_at"`
+}
+
+func GetFloor() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var sections []models.Section
+		sectionResults, err := sectionCollection.Find(ctx, bson.M{})
+		if err == nil {
+			err = sectionResults.All(ctx, &sections)
+		}
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "section"))
+			return
+		}
+
+		var tables []models.Table
+		tableResults, err := tableCollection.Find(ctx, bson.M{})
+		if err == nil {
+			err = tableResults.All(ctx, &tables)
+		}
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		openOrders, err := openOrdersByTable(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
+			return
+		}
+
+		c.JSON(http.StatusOK, buildFloor(sections, tables, openOrders))
+	}
+}
+
+// openOrdersByTable maps each table with an unpaid order to that order.
+func openOrdersByTable(ctx context.Context) (map[string]string, error) {
+	filter := bson.M{"order_status": services.OrderStatusOpen, "table_id": bson.M{"$ne": nil}}
+	opts := options.Find().SetProjection(bson.M{"order_id": 1, "table_id": 1}).SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	results, err := orderCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []models.Order
+	if err := results.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	openOrders := map[string]string{}
+	for _, order := range orders {
+		openOrders[*order.Table_id] = order.Order_id
+	}
+	return openOrders, nil
+}
+
+func buildFloor(sections []models.Section, tables []models.Table, openOrders map[string]string) Floor {
+	floor := Floor{
+		Rooms:        []FloorRoom{},
+		Unassigned:   []FloorTable{},
+		Summary:      map[string]int{},
+		Generated_At: time.Now(),
+	}
+
+	for _, status := range services.TableStatuses {
+		floor.Summary[status] = 0
+	}
+
+	sort.SliceStable(sections, func(i, j int) bool {
+		if *sections[i].Room != *sections[j].Room {
+			return *sections[i].Room < *sections[j].Room
+		}
+		return sortOrder(sections[i]) < sortOrder(sections[j])
+	})
+
+	sort.SliceStable(tables, func(i, j int) bool {
+		return *tables[i].Table_number < *tables[j].Table_number
+	})
+
+	byId := map[string]*FloorSection{}
+	var floorSections []*FloorSection
+	for _, section := range sections {
+		floorSection := &FloorSection{Section: section, Tables: []FloorTable{}}
+		byId[section.Section_id] = floorSection
+		floorSections = append(floorSections, floorSection)
+	}
+
+	for _, table := range tables {
+		floorTable := FloorTable{Table: table}
+		if orderId, ok := openOrders[table.Table_id]; ok {
+			floorTable.Open_Order_id = &orderId
+		}
+
+		if table.Table_status != nil {
+			floor.Summary[*table.Table_status]++
+		}
+
+		var section *FloorSection
+		if table.Section_id != nil {
+			section = byId[*table.Section_id]
+		}
+
+		if section == nil {
+			floor.Unassigned = append(floor.Unassigned, floorTable)
+			continue
+		}
+
+		if floorTable.Server_id == nil {
+			floorTable.Server_id = section.Server_id
+		}
+		section.Tables = append(section.Tables, floorTable)
+	}
+
+	for _, section := range floorSections {
+		last := len(floor.Rooms) - 1
+		if last < 0 || floor.Rooms[last].Name != *section.Room {
+			floor.Rooms = append(floor.Rooms, FloorRoom{Name: *section.Room, Sections: []FloorSection{}})
+			last++
+		}
+		floor.Rooms[last].Sections = append(floor.Rooms[last].Sections, *section)
+	}
+
+	return floor
+}
+
+func sortOrder(section models.Section) int {
+	if section.Sort_Order == nil {
+		return 0
+	}
+	return *section.Sort_Order
+}
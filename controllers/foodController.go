@@ -2,22 +2,23 @@ package controllers
 
 import (
 	"context"
-	"math"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
 	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 
 
 func GetFoods() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		// Attempt at Pagination and Mongoose Aggregrated Queries
 		// Will continue in the future 
@@ -59,23 +60,17 @@ func GetFoods() gin.HandlerFunc{
 		// Project State
 
 
-		results, err := foodCollection.Find(context.TODO(), bson.M{})
+		results, err := foodCollection.Find(ctx, bson.M{})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying food collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "food"))
 			return
 		}
 
 		var allFoods []bson.M
 
 		if err := results.All(ctx, &allFoods); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error during iteration of all foods",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "food"))
 			return
 		}
 		defer cancel()
@@ -86,7 +81,7 @@ func GetFoods() gin.HandlerFunc{
 
 func GetFood() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		foodId := c.Param("food_id")
 		var food models.Food
@@ -94,30 +89,25 @@ func GetFood() gin.HandlerFunc{
 		err := foodCollection.FindOne(ctx, bson.M{"food_id": foodId}).Decode(&food)
 		defer cancel()
 		if err != nil{
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the food collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "food"))
 			return
 		}
 
+		helpers.SetETag(c, food.Version)
 		c.JSON(http.StatusOK, food)
 	}
 }
 
 func CreateFood() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var menu models.Menu
 		var food models.Food
 
 		
-		if err := c.BindJSON(&food); err != nil{
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while trying to read request body data",
-				"error": err.Error(),
-			})
+		if err := c.ShouldBindJSON(&food); err != nil{
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
@@ -125,10 +115,7 @@ func CreateFood() gin.HandlerFunc{
 
 		validationErr := validate.Struct(food)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "The request body data could not be validated",
-				"error": validationErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.Validation(validationErr))
 			defer cancel()
 			return
 		}
@@ -136,10 +123,7 @@ func CreateFood() gin.HandlerFunc{
 		err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_ID}).Decode(&menu)
 		defer cancel()
 		if err != nil{
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the menu collection.",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.Reference(err, "menu_id", "menu"))
 			return
 		}
 
@@ -147,35 +131,39 @@ func CreateFood() gin.HandlerFunc{
 		food.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.ID = primitive.NewObjectID()
 		food.Food_ID = food.ID.Hex()
-		var price = toFixed(*food.Price, 2)
+		food.Version = 1
+		var price = helpers.ToFixed(*food.Price, 2)
 		food.Price = &price
 
 		result, insertErr := foodCollection.InsertOne(ctx, food)
 		defer cancel()
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while trying to insert object into food collection",
-				"error": insertErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(insertErr, "food"))
 			return
 		}
-		
+
+		services.PublishMenuChange(ctx, services.MenuChange{Menu_id: *food.Menu_ID, Food_id: food.Food_ID, Change: services.MenuChangeCreated, Changed_At: food.Created_At})
+
+		helpers.SetETag(c, food.Version)
 		c.JSON(http.StatusOK, result)
 	}
 }
 
 func UpdateFood() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 		
 		var food models.Food
 		foodId := c.Param("food_id")
 
-		if err := c.BindJSON(&food); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "Request body data was unable to processed",
-				"error": err.Error(),
-			})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		if err := c.ShouldBindJSON(&food); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
@@ -189,7 +177,7 @@ func UpdateFood() gin.HandlerFunc{
 		}
 
 		if food.Price != nil {
-			updatedFood = append(updatedFood, bson.E{Key: "price", Value: toFixed(*food.Price, 2)})
+			updatedFood = append(updatedFood, bson.E{Key: "price", Value: helpers.ToFixed(*food.Price, 2)})
 		}
 
 		if food.Image != nil {
@@ -200,10 +188,8 @@ func UpdateFood() gin.HandlerFunc{
 			err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_ID}).Decode(&menu)
 			defer cancel()
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"message": "There was an error while querying the menu collection. Menu ID not found",
-					"error": err.Error(),
-				})
+				apperrors.Respond(c, apperrors.Reference(err, "menu_id", "menu"))
+				return
 			}
 			updatedFood = append(updatedFood, bson.E{Key: "menu_id", Value: food.Menu_ID})
 		}
@@ -212,41 +198,59 @@ func UpdateFood() gin.HandlerFunc{
 		updatedFood = append(updatedFood, bson.E{Key: "updated_at", Value: food.Updated_At})
 
 		
-		filter := bson.M{"food_id": foodId}
-		opt := options.Update().SetUpsert(true)
-		update := bson.D{{Key: "$set", Value: updatedFood}}
+		filter := bson.M{"food_id": foodId, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: updatedFood}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
 
-		results, err := foodCollection.UpdateOne(ctx, filter, update, opt )
+		results, err := foodCollection.UpdateOne(ctx, filter, update)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while updating object in food collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "food"))
 			return
 		}
-		
+
+		if results.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, foodCollection, bson.M{"food_id": foodId}, "food")
+			return
+		}
+
+		change := services.MenuChange{Food_id: foodId, Change: services.MenuChangeUpdated, Changed_At: food.Updated_At}
+		if food.Menu_ID != nil {
+			change.Menu_id = *food.Menu_ID
+		}
+		services.PublishMenuChange(ctx, change)
+
+		helpers.SetETag(c, version+1)
 		c.JSON(http.StatusOK, results)
 	}
 }
 
 func DeleteFood() gin.HandlerFunc{
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		foodId := c.Param("food_id")
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
 		
-		res, err := foodCollection.DeleteOne(ctx, bson.D{{Key: "food_id", Value: foodId}})
+		res, err := foodCollection.DeleteOne(ctx, bson.D{{Key: "food_id", Value: foodId}, {Key: "version", Value: helpers.VersionFilter(version)}})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while attempting to delete an object from the food collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "food"))
 			return
 		}
 		defer cancel()
 
+		if res.DeletedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, foodCollection, bson.M{"food_id": foodId}, "food")
+			return
+		}
+
+		services.PublishMenuChange(ctx, services.MenuChange{Food_id: foodId, Change: services.MenuChangeDeleted})
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Deletion Successful",
 			"number_of_objects_deleted": res.DeletedCount,
@@ -254,13 +258,3 @@ func DeleteFood() gin.HandlerFunc{
 	}
 }
 
-
-
-func round(num float64) int {
-	return int(num + math.Copysign(0.5, num))
-}
-
-func toFixed(num float64, precision int) float64{
-	output := math.Pow(10, float64(precision))
-	return float64(round(num * output)) / output
-}
\ No newline at end of file
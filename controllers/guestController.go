@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/qrcode"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+type TableQrRequest struct {
+	Format string `form:"format" validate:"omitempty,eq=png|eq=svg|eq=json"`
+	Scale  int    `form:"scale" validate:"omitempty,min=1,max=40"`
+}
+
+// TableQr is the link printed in a table's QR code.
+type TableQr struct {
+	Table_id string `json:"table_id"`
+	Token    string `json:"token"`
+	Url      string `json:"url"`
+}
+
+// GetTableQr returns the QR code guests scan to order from the table, as a
+// PNG or SVG to print or as the link it holds.
+func GetTableQr() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req TableQrRequest
+
+		if err := c.ShouldBindQuery(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		tableId := c.Param("table_id")
+
+		token, err := guestOrdering.QrToken(ctx, tableId, false)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		respondTableQr(c, tableId, token, req)
+	}
+}
+
+// RotateTableQr gives the table a new QR code, for when the printed one has
+// been copied or photographed. The old code and every guest session started
+// with it stop working.
+func RotateTableQr() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		tableId := c.Param("table_id")
+
+		token, err := guestOrdering.QrToken(ctx, tableId, true)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		respondTableQr(c, tableId, token, TableQrRequest{Format: "json"})
+	}
+}
+
+func respondTableQr(c *gin.Context, tableId string, token string, req TableQrRequest) {
+	qr := TableQr{Table_id: tableId, Token: token, Url: frontEndLink("/order", token)}
+
+	if req.Format == "json" {
+		c.JSON(http.StatusOK, qr)
+		return
+	}
+
+	code, err := qrcode.Encode(qr.Url, qrcode.M)
+	if err != nil {
+		apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "Could not draw the QR code", err))
+		return
+	}
+
+	scale := req.Scale
+	if scale == 0 {
+		scale = 10
+	}
+
+	if req.Format == "svg" {
+		c.Data(http.StatusOK, "image/svg+xml", code.SVG(scale))
+		return
+	}
+
+	image, err := code.PNG(scale)
+	if err != nil {
+		apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "Could not draw the QR code", err))
+		return
+	}
+	c.Data(http.StatusOK, "image/png", image)
+}
+
+// StartGuestSession is called by the ordering page a table's QR code links
+// to, with the token from the link.
+func StartGuestSession() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.GuestSessionRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		session, err := guestOrdering.StartSession(ctx, req.Token)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// GetGuestMenu returns the menus guests can order from right now, with
+// their foods.
+func GetGuestMenu() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		menus, err := guestOrdering.Menu(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "menu"))
+			return
+		}
+
+		c.JSON(http.StatusOK, menus)
+	}
+}
+
+// GetGuestOrder returns what has been ordered at the guest's table, and
+// whether their server has approved it yet.
+func GetGuestOrder() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		order, err := guestOrdering.Order(ctx, c.GetString("table_id"), c.GetString("qr_nonce"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
+			return
+		}
+
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// SubmitGuestOrder adds the guest's items to the open order at their table
+// for their server to approve.
+func SubmitGuestOrder() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.GuestOrderRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		req.Table_id = c.GetString("table_id")
+		req.Qr_Nonce = c.GetString("qr_nonce")
+		req.Actor = helpers.ActorFrom(c)
+
+		order, err := guestOrdering.Submit(ctx, req)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, order)
+	}
+}
+
+// GetPendingOrderItems lists the items guests ordered from their table that
+// are waiting for a server to approve them.
+func GetPendingOrderItems() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		orderItems, err := guestOrdering.Pending(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order item"))
+			return
+		}
+
+		c.JSON(http.StatusOK, orderItems)
+	}
+}
+
+// ApproveOrderItem fires an item a guest ordered.
+func ApproveOrderItem() gin.HandlerFunc{
+	return reviewOrderItem(true)
+}
+
+// RejectOrderItem turns down an item a guest ordered, optionally saying why.
+// It stays on the order for the guest to see but isn't charged for.
+func RejectOrderItem() gin.HandlerFunc{
+	return reviewOrderItem(false)
+}
+
+func reviewOrderItem(approve bool) gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.ReviewOrderItemRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		req.Order_item_id = c.Param("order_item_id")
+		req.Approve = approve
+		req.Actor = helpers.ActorFrom(c)
+
+		orderItem, err := guestOrdering.Review(ctx, req)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order item"))
+			return
+		}
+
+		helpers.SetETag(c, orderItem.Version)
+		c.JSON(http.StatusOK, orderItem)
+	}
+}
@@ -6,17 +6,31 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
 	"github.com/teandresmith/restaurant-project/helpers"
 	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var errOrderInvoiced = apperrors.New(apperrors.Conflict, "Order already has an invoice")
+
+// paymentStatusError refuses a payment_status the client isn't allowed to
+// set. It only changes through checkout and refunds, which record the
+// payment and settle the order along with it.
+func paymentStatusError(rule string, message string) *apperrors.Error {
+	return &apperrors.Error{
+		Code:    apperrors.ValidationFailed,
+		Message: "The request body is not valid",
+		Fields:  []apperrors.FieldError{{Field: "payment_status", Rule: rule, Message: message}},
+	}
+}
 
 func GetInvoices() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 
 		// Beginning of Pagination ( Will return to it later )
@@ -34,23 +48,17 @@ func GetInvoices() gin.HandlerFunc{
 		// startIndex := (pages - 1) * recordPerPage
 		// startIndex, err = strconv.Atoi(c.Query("startIndex"))
 
-		results, err := invoiceCollection.Find(context.TODO(), bson.M{})
+		results, err := invoiceCollection.Find(ctx, bson.M{})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while searching the invoice collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
 			return
 		}
 
 		var allInvoices []bson.M
 		
 		if err := results.All(ctx, &allInvoices); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while iterating through all invoices",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
 			return
 		}
 		defer cancel()
@@ -61,47 +69,51 @@ func GetInvoices() gin.HandlerFunc{
 
 func GetInvoice() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		invoiceId := c.Param("invoice_id")
-		var invoice models.Food
+		var invoice models.Invoice
 
 		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while searching the invoice collection.",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
 			return
 		}
 
+		helpers.SetETag(c, invoice.Version)
 		c.JSON(http.StatusOK, invoice)
 	}
 }
 
 func CreateInvoice() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var invoice models.Invoice
 		var order models.Order
 
-		if err := c.BindJSON(&invoice); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error when attempting to bind request body data",
-				"error": err.Error(),
-			})
+		if err := c.ShouldBindJSON(&invoice); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			defer cancel()
+			return
+		}
+
+		// Invoices are created to be paid at checkout, which records the
+		// payment and settles the order along with it.
+		if invoice.Payment_status == nil {
+			pending := services.PaymentStatusPending
+			invoice.Payment_status = &pending
+		}
+		if *invoice.Payment_status != services.PaymentStatusPending {
+			apperrors.Respond(c, paymentStatusError("eq", "must be PENDING, invoices are paid at checkout"))
 			defer cancel()
 			return
 		}
 
 		validationErr := validate.Struct(invoice)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while validating request body data",
-				"error": validationErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.Validation(validationErr))
 			defer cancel()
 			return
 		}
@@ -109,43 +121,68 @@ func CreateInvoice() gin.HandlerFunc{
 		err := orderCollection.FindOne(ctx, bson.M{"order_id": invoice.Order_id}).Decode(&order)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the order collection. Order with that order_id not found",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.Reference(err, "order_id", "order"))
+			return
+		}
+
+		if order.Order_status != nil && *order.Order_status == services.OrderStatusPaid {
+			apperrors.Respond(c, services.ErrOrderAlreadyPaid)
+			return
+		}
+
+		// The unique index on order_id settles two requests racing past
+		// this check.
+		invoiced, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": invoice.Order_id})
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
+			return
+		}
+		if invoiced > 0 {
+			apperrors.Respond(c, errOrderInvoiced)
 			return
 		}
 
 		invoice.ID = primitive.NewObjectID()
 		invoice.Invoice_id = invoice.ID.Hex()
+		invoice.Version = 1
+		invoice.Created_By = helpers.ActorFrom(c)
 		invoice.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		invoice.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
 		result, err := invoiceCollection.InsertOne(ctx, invoice)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while inserting an object in the invoice collection.",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
 			return
 		}
 
+		events.Publish(ctx, events.Event{Type: events.InvoiceCreated, Subject: invoice.Invoice_id, Data: invoice})
+
+		helpers.SetETag(c, invoice.Version)
 		c.JSON(http.StatusOK, result)
 	}
 }
 
 func UpdateInvoice() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var reqInvoiceData models.Invoice
 
-		if err := c.BindJSON(&reqInvoiceData); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while attempting to bind request body data",
-				"error": err.Error(),
-			})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		if err := c.ShouldBindJSON(&reqInvoiceData); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			defer cancel()
+			return
+		}
+
+		if reqInvoiceData.Payment_status != nil {
+			apperrors.Respond(c, paymentStatusError("excluded", "cannot be changed, invoices are paid at checkout and refunded through their refund endpoint"))
 			defer cancel()
 			return
 		}
@@ -154,16 +191,13 @@ func UpdateInvoice() gin.HandlerFunc{
 		invoiceID := c.Param("invoice_id")
 
 		
-		var menu models.Menu
+		var order models.Order
 		
 		if reqInvoiceData.Order_id != "" {
-			err := invoiceCollection.FindOne(ctx, bson.M{"order_id": reqInvoiceData.Order_id}).Decode(&menu)
+			err := orderCollection.FindOne(ctx, bson.M{"order_id": reqInvoiceData.Order_id}).Decode(&order)
 			defer cancel()
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"message": "There was an error while querying the order collection. Please check the correct inputted Order ID",
-					"error": err.Error(),
-				})
+				apperrors.Respond(c, apperrors.Reference(err, "order_id", "order"))
 				return
 			}
 
@@ -174,31 +208,31 @@ func UpdateInvoice() gin.HandlerFunc{
 			updatedInvoice = append(updatedInvoice, bson.E{Key: "payment_method", Value: reqInvoiceData.Payment_Method})
 		}
 
-		if reqInvoiceData.Payment_status != nil {
-			updatedInvoice = append(updatedInvoice, bson.E{Key: "payment_status", Value: reqInvoiceData.Payment_status})
-		}
-
 		if reqInvoiceData.Payment_due_date.String() != "" {
 			updatedInvoice = append(updatedInvoice, bson.E{Key: "payment_due_date", Value: reqInvoiceData.Payment_due_date})
 		}
 
 		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updatedInvoice = append(updatedInvoice, bson.E{Key: "updated_at", Value: updatedAt})
+		updatedInvoice = append(updatedInvoice, bson.E{Key: "updated_by", Value: helpers.ActorFrom(c)})
 
-		filter := bson.M{"invoice_id": invoiceID}
-		update := bson.D{{Key: "$set", Value: updatedInvoice}}
-		opt := options.Update().SetUpsert(true)
+		filter := bson.M{"invoice_id": invoiceID, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: updatedInvoice}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
 
-		results, err := invoiceCollection.UpdateOne(ctx, filter, update, opt)
+		results, err := invoiceCollection.UpdateOne(ctx, filter, update)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while updating an object in the invoice collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
 			return
 		}
 
+		if results.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, invoiceCollection, bson.M{"invoice_id": invoiceID}, "invoice")
+			return
+		}
+
+		helpers.SetETag(c, version+1)
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Updated Successful",
 			"updated_object": results,
@@ -209,7 +243,7 @@ func UpdateInvoice() gin.HandlerFunc{
 
 func DeleteInvoice() gin.HandlerFunc{
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		if admin := helpers.IsAdmin(c); !admin{
 			defer cancel()
@@ -218,14 +252,21 @@ func DeleteInvoice() gin.HandlerFunc{
 		
 		invoiceId := c.Param("invoice_id")
 
-		deleteCount, err := invoiceCollection.DeleteOne(ctx, bson.M{"invoice_id": invoiceId})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		deleteCount, err := invoiceCollection.DeleteOne(ctx, bson.M{"invoice_id": invoiceId, "version": helpers.VersionFilter(version)})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while attempting to delete an object from collection invoice",
-				"error": err.Error(),
-				
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "invoice"))
+			return
+		}
+
+		if deleteCount.DeletedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, invoiceCollection, bson.M{"invoice_id": invoiceId}, "invoice")
 			return
 		}
 
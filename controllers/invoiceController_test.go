@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestInvoicePaymentStatus checks clients can't mark invoices paid or
+// refunded themselves. Both requests are refused before any lookup.
+func TestInvoicePaymentStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/invoices", CreateInvoice())
+	router.PATCH("/invoices/:invoice_id", UpdateInvoice())
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"create paid", http.MethodPost, "/invoices", `{"order_id":"o1","payment_method":"CARD","payment_status":"PAID"}`},
+		{"create refunded", http.MethodPost, "/invoices", `{"order_id":"o1","payment_method":"CARD","payment_status":"REFUNDED"}`},
+		{"mark paid", http.MethodPatch, "/invoices/inv1", `{"payment_status":"PAID"}`},
+		{"mark pending", http.MethodPatch, "/invoices/inv1", `{"payment_status":"PENDING"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.Header.Set("If-Match", `"1"`)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"field":"payment_status"`) {
+				t.Errorf("%s %s: %d %s, want payment_status refused", test.method, test.path, w.Code, w.Body)
+			}
+		})
+	}
+}
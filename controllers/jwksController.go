@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/helpers"
+)
+
+// JWKS publishes the public keys tokens are signed with, so other services
+// such as the kitchen display tablets can verify tokens without calling us.
+func JWKS() gin.HandlerFunc{
+	return func(c *gin.Context){
+		// Short enough that verifiers pick up a new key well before it
+		// starts signing, as long as it is published a few minutes ahead.
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, helpers.SigningKeys().JWKS())
+	}
+}
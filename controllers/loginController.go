@@ -6,67 +6,87 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
 	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/logging"
 	"github.com/teandresmith/restaurant-project/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+var errEmailTaken = apperrors.New(apperrors.Conflict, "A user with that email already exists")
 
 func SignUp() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
 
 		var user models.User
 
 
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": err.Error(),
-			})
+		if err := c.ShouldBindJSON(&user); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
 		}
 
 		validationErr := validate.Struct(user)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error when validating the request body data",
-				"error": validationErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.Validation(validationErr))
+			return
+		}
+
+		count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if count > 0 {
+			apperrors.Respond(c, errEmailTaken)
+			return
 		}
 
 		user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_id = user.ID.Hex()
+		user.Version = 1
+		user.Email_Verified = false
+		user.Email_Verified_At = nil
+		user.Mfa_Enabled = false
 
 		token, refreshToken, tokenErr := helpers.GenerateTokens(*user.First_Name, *user.Last_Name, *user.User_Type, *user.Email, user.User_id)
 		if tokenErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while creating a new token",
-				"error": tokenErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while creating a new token", tokenErr))
+			return
 		}
 		user.Token = &token
 		user.Refresh_Token = &refreshToken
 
 		hashPassword, hashErr := HashPassword(*user.Password)
 		if hashErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while hashinig the password",
-				"error": hashErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while hashing the password", hashErr))
+			return
 		}
 		user.Password = &hashPassword
 
+		// The unique index on email settles two sign ups racing past the
+		// count.
 		result, insertErr := userCollection.InsertOne(ctx, user)
+		if mongo.IsDuplicateKeyError(insertErr) {
+			apperrors.Respond(c, errEmailTaken)
+			return
+		}
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while inserting an object in the user collection",
-				"error": insertErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(insertErr, "user"))
+			return
+		}
+
+		// The account exists either way; the user can ask for another link.
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			logging.FromContext(ctx).Error("could not send verification email", "user_id", user.User_id, "error", err)
 		}
-		defer cancel()
 
 		c.JSON(http.StatusOK, result)
 
@@ -75,59 +95,92 @@ func SignUp() gin.HandlerFunc{
 
 func Login() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
 
 		var user models.User
 		var userInput models.User
 
-		if err := c.BindJSON(&userInput); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": err.Error(),
-			})
-			defer cancel()
+		if err := c.ShouldBindJSON(&userInput); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			return 
 		}
-		
 
-		err := userCollection.FindOne(ctx, bson.M{"email": userInput.Email}).Decode(&user)
-		defer cancel()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the user collection",
-				"error": err.Error(),
+		var missing []apperrors.FieldError
+		if userInput.Email == nil {
+			missing = append(missing, apperrors.FieldError{Field: "email", Rule: "required", Message: "is required"})
+		}
+		if userInput.Password == nil {
+			missing = append(missing, apperrors.FieldError{Field: "password", Rule: "required", Message: "is required"})
+		}
+		if len(missing) > 0 {
+			apperrors.Respond(c, &apperrors.Error{
+				Code: apperrors.ValidationFailed,
+				Message: "Email and password are required",
+				Fields: missing,
 			})
 			return
 		}
 
-		if userInput.Password == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "No Password provided"})
+		// The attempt counts as a failure until the login succeeds.
+		if err := loginGuard.Attempt(ctx, *userInput.Email); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "login attempt"))
 			return
-		} else {
-			if err := VerifyPassword(*user.Password,*userInput.Password); err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-				return
-			}
 		}
 
-		// Create new tokens everytime user login
-		newToken, newRefreshToken, err := helpers.GenerateTokens(*user.First_Name, *user.Last_Name, *user.User_Type, *user.Email, user.User_id)
+		// An unknown email and a wrong password get the same answer, and have
+		// counted against the email alike, so the response can't be used to
+		// find out which emails have accounts.
+		invalidCredentials := apperrors.New(apperrors.Unauthorized, "Invalid email or password")
+
+		err := userCollection.FindOne(ctx, bson.M{"email": userInput.Email}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			apperrors.Respond(c, invalidCredentials)
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while generating new tokens",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
 		}
 
-		updateErr := helpers.UpdateTokens(newToken, newRefreshToken, user.User_id)
-		if updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while updating user tokens",
-				"error": updateErr.Error(),
-			})
+		if err := VerifyPassword(*user.Password,*userInput.Password); err != nil {
+			apperrors.Respond(c, invalidCredentials)
+			return
 		}
-		
-		
-		c.JSON(http.StatusOK, user)
+
+		// The password is right, but tokens are only handed out once the
+		// second factor has been checked by LoginMFA.
+		if user.Mfa_Enabled {
+			respondMfaChallenge(c, ctx, user)
+			return
+		}
+
+		if err := loginGuard.RecordSuccess(ctx, *userInput.Email); err != nil {
+			logging.FromContext(ctx).Warn("could not clear failed logins", "error", err)
+		}
+
+		startSession(c, ctx, user)
 	}
-}
\ No newline at end of file
+}
+
+// startSession issues and stores new tokens for user and responds with the
+// user.
+func startSession(c *gin.Context, ctx context.Context, user models.User) {
+	// Create new tokens everytime user login
+	newToken, newRefreshToken, err := helpers.GenerateTokens(*user.First_Name, *user.Last_Name, *user.User_Type, *user.Email, user.User_id)
+	if err != nil {
+		apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while generating new tokens", err))
+		return
+	}
+
+	updateErr := helpers.UpdateTokens(ctx, newToken, newRefreshToken, user.User_id)
+	if updateErr != nil {
+		apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while updating user tokens", updateErr))
+		return
+	}
+
+	user.Token = &newToken
+	user.Refresh_Token = &newRefreshToken
+
+	c.JSON(http.StatusOK, user)
+}
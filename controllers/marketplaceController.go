@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxWebhookBody is the largest marketplace webhook read. Bigger ones are
+// cut short and fail their signature check.
+const maxWebhookBody = 1 << 20
+
+// ReceiveMarketplaceOrder takes an order a delivery marketplace sent to its
+// webhook. It answers 201 for a new order and 200 when the marketplace sent
+// one it already took again.
+func ReceiveMarketplaceOrder() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		provider := c.Param("provider")
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.BadRequest, "The webhook could not be read", err))
+			return
+		}
+
+		incoming, err := marketplaceOrders.Receive(provider, c.Request.Header, body)
+		if err != nil {
+			apperrors.Respond(c, err)
+			return
+		}
+
+		if err := validate.Struct(incoming); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		order, created, err := marketplaceOrders.Ingest(ctx, provider, incoming)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		c.JSON(status, order)
+	}
+}
+
+func GetMenuMappings() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		mappings, err := marketplaceOrders.Mappings(ctx, c.Param("provider"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "menu mapping"))
+			return
+		}
+
+		c.JSON(http.StatusOK, mappings)
+	}
+}
+
+// MapMenuItem says which food a marketplace's menu item is, replacing what
+// it was mapped to before.
+func MapMenuItem() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var mapping models.MenuMapping
+
+		if err := c.ShouldBindJSON(&mapping); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(mapping); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		var food models.Food
+		if err := foodCollection.FindOne(ctx, bson.M{"food_id": mapping.Food_id}).Decode(&food); err != nil {
+			apperrors.Respond(c, apperrors.Reference(err, "food_id", "food"))
+			return
+		}
+
+		mapping.Provider = c.Param("provider")
+		mapping.External_item_id = c.Param("external_item_id")
+		mapping.Updated_By = helpers.ActorFrom(c)
+
+		mapping, err := marketplaceOrders.MapItem(ctx, mapping)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "menu mapping"))
+			return
+		}
+
+		c.JSON(http.StatusOK, mapping)
+	}
+}
+
+func UnmapMenuItem() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		if err := marketplaceOrders.UnmapItem(ctx, c.Param("provider"), c.Param("external_item_id")); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "menu mapping"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Deletion Successful"})
+	}
+}
@@ -2,22 +2,23 @@ package controllers
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
 	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 
 
 func GetMenus() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		// Beginning of Pagination Logic (will return to it later)
 
@@ -36,16 +37,17 @@ func GetMenus() gin.HandlerFunc{
 		// 	startingIndex = (pages - 1) * recordsPerPage
 		// }
 
-		results, err := menuCollection.Find(context.TODO(), bson.M{})
+		results, err := menuCollection.Find(ctx, bson.M{})
 		defer cancel()
 		if err != nil{
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the menu items"})
+			apperrors.Respond(c, apperrors.FromDB(err, "menu"))
 			return
 		}
 
 		var allMenus []bson.M
 		if err = results.All(ctx, &allMenus); err != nil {
-			log.Fatal(err)
+			apperrors.Respond(c, apperrors.FromDB(err, "menu"))
+			return
 		}
 		
 		c.JSON(http.StatusOK, allMenus)
@@ -54,35 +56,36 @@ func GetMenus() gin.HandlerFunc{
 
 func GetMenu() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 		menu_Id := c.Param("menu_id")
 		var menu models.Menu
 
 		err := menuCollection.FindOne(ctx, bson.M{"menu_id": menu_Id}).Decode(&menu)
 		defer cancel()
 		if err != nil{
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apperrors.Respond(c, apperrors.FromDB(err, "menu"))
 			return
 		}
 
+		helpers.SetETag(c, menu.Version)
 		c.JSON(http.StatusOK, menu)
 	}
 }
 
 func CreateMenu() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 		var menu models.Menu
 
-		if err := c.BindJSON(&menu); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := c.ShouldBindJSON(&menu); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
 
 		validationErr := validate.Struct(menu)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			apperrors.Respond(c, apperrors.Validation(validationErr))
 			defer cancel()
 			return
 		}
@@ -91,30 +94,37 @@ func CreateMenu() gin.HandlerFunc{
 		menu.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.ID = primitive.NewObjectID()
 		menu.Menu_Id = menu.ID.Hex()
+		menu.Version = 1
 		
 		result, insertErr := menuCollection.InsertOne(ctx, menu)
 		defer cancel()
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": insertErr.Error()})
+			apperrors.Respond(c, apperrors.FromDB(insertErr, "menu"))
 			return
 		}
 
+		services.PublishMenuChange(ctx, services.MenuChange{Menu_id: menu.Menu_Id, Change: services.MenuChangeCreated, Changed_At: menu.Created_At})
+
 		defer cancel()
+		helpers.SetETag(c, menu.Version)
 		c.JSON(http.StatusOK, result)
 	}
 }
 
 func UpdateMenu() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var reqMenuData models.Menu
 
-		if err := c.BindJSON(&reqMenuData); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding request body data",
-				"error": err.Error(),
-			})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		if err := c.ShouldBindJSON(&reqMenuData); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
@@ -141,20 +151,25 @@ func UpdateMenu() gin.HandlerFunc{
 		updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updatedMenu = append(updatedMenu, bson.E{Key: "updated_at", Value: updated_at})
 
-		filter := bson.M{"menu_id": menu_id}
-		opt := options.Update().SetUpsert(true)
-		update := bson.D{{Key: "$set", Value: updatedMenu}}
+		filter := bson.M{"menu_id": menu_id, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: updatedMenu}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
 
-		results, err := menuCollection.UpdateOne(ctx, filter, update, opt)
+		results, err := menuCollection.UpdateOne(ctx, filter, update)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while updating an object in the menu collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "menu"))
 			return
 		}
 
+		if results.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, menuCollection, bson.M{"menu_id": menu_id}, "menu")
+			return
+		}
+
+		services.PublishMenuChange(ctx, services.MenuChange{Menu_id: menu_id, Change: services.MenuChangeUpdated, Changed_At: updated_at})
+
+		helpers.SetETag(c, version+1)
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Update Successful",
 			"updated_object": results,
@@ -165,19 +180,30 @@ func UpdateMenu() gin.HandlerFunc{
 
 func DeleteMenu() gin.HandlerFunc{
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		menuId := c.Param("menu_id")
 
-		deleteResult, err := menuCollection.DeleteOne(ctx, bson.M{"menu_id": menuId})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		deleteResult, err := menuCollection.DeleteOne(ctx, bson.M{"menu_id": menuId, "version": helpers.VersionFilter(version)})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while deleting an object in the menu collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "menu"))
+			return
 		}
 
+		if deleteResult.DeletedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, menuCollection, bson.M{"menu_id": menuId}, "menu")
+			return
+		}
+
+		services.PublishMenuChange(ctx, services.MenuChange{Menu_id: menuId, Change: services.MenuChangeDeleted})
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Deletion Successful",
 			"number_of_deleted_object": deleteResult.DeletedCount,
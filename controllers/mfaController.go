@@ -0,0 +1,484 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+	"github.com/teandresmith/restaurant-project/totp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+	// Codes from one period either side of now are accepted to allow for
+	// clock drift between the server and the user's phone.
+	totpSkew = 1
+)
+
+type MfaChallenge struct {
+	Mfa_Required bool   `json:"mfa_required"`
+	Mfa_Token    string `json:"mfa_token"`
+	Expires_In   int    `json:"expires_in"`
+}
+
+type MfaLoginRequest struct {
+	Mfa_Token     string `json:"mfa_token" validate:"required"`
+	Code          string `json:"code"`
+	Recovery_Code string `json:"recovery_code"`
+}
+
+type MfaCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MfaEnrollment struct {
+	Secret           string `json:"secret"`
+	Provisioning_Uri string `json:"provisioning_uri"`
+}
+
+type MfaRecoveryCodes struct {
+	Recovery_Codes []string `json:"recovery_codes"`
+}
+
+var invalidMfaCode = apperrors.New(apperrors.Unauthorized, "Invalid authentication code")
+
+// respondMfaChallenge answers a login whose password was right with a
+// short-lived token to be sent back to LoginMFA with a code.
+func respondMfaChallenge(c *gin.Context, ctx context.Context, user models.User) {
+	token, err := accountTokens.Issue(ctx, user.User_id, services.TokenPurposeMfaChallenge, mfaChallengeTTL)
+	if err != nil {
+		apperrors.Respond(c, apperrors.FromDB(err, "account token"))
+		return
+	}
+
+	c.JSON(http.StatusOK, MfaChallenge{
+		Mfa_Required: true,
+		Mfa_Token:    token,
+		Expires_In:   int(mfaChallengeTTL / time.Second),
+	})
+}
+
+// LoginMFA finishes a login that Login answered with an MFA challenge. It
+// takes either a code from the user's authenticator app or one of their
+// recovery codes. A challenge can only be tried once; after a wrong code the
+// user has to log in again.
+func LoginMFA() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req MfaLoginRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if req.Code == "" && req.Recovery_Code == "" {
+			apperrors.Respond(c, &apperrors.Error{
+				Code:    apperrors.ValidationFailed,
+				Message: "Either code or recovery_code is required",
+				Fields:  []apperrors.FieldError{{Field: "code", Rule: "required_without", Message: "is required when recovery_code is not sent"}},
+			})
+			return
+		}
+
+		userId, err := accountTokens.Redeem(ctx, req.Mfa_Token, services.TokenPurposeMfaChallenge)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "account token"))
+			return
+		}
+
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if err := loginGuard.Attempt(ctx, *user.Email); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "login attempt"))
+			return
+		}
+
+		var ok bool
+		if req.Code != "" {
+			ok, err = useTotpCode(ctx, user, req.Code)
+		} else {
+			ok, err = useRecoveryCode(ctx, user, req.Recovery_Code)
+		}
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if !ok {
+			apperrors.Respond(c, invalidMfaCode)
+			return
+		}
+
+		if err := loginGuard.RecordSuccess(ctx, *user.Email); err != nil {
+			logging.FromContext(ctx).Warn("could not clear failed logins", "error", err)
+		}
+
+		startSession(c, ctx, user)
+	}
+}
+
+// EnrollMFA starts two-factor enrollment for the signed in user. The secret
+// only takes effect once ConfirmMFA has seen a code generated from it.
+func EnrollMFA() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		user, ok := currentUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if user.Mfa_Enabled {
+			apperrors.Respond(c, apperrors.New(apperrors.Conflict, "Two-factor authentication is already enabled"))
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while generating a secret", err))
+			return
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "mfa_pending_secret", Value: secret}}}}
+		if _, err := userCollection.UpdateOne(ctx, bson.M{"user_id": user.User_id}, update); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		c.JSON(http.StatusOK, MfaEnrollment{
+			Secret:           secret,
+			Provisioning_Uri: totp.ProvisioningURI(secret, mfaIssuer(), *user.Email),
+		})
+	}
+}
+
+// ConfirmMFA turns two-factor authentication on once the user proves their
+// app generates the right codes, and returns their recovery codes. This is
+// the only time the recovery codes are shown.
+func ConfirmMFA() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req MfaCodeRequest
+		if !bindMfaCode(c, &req) {
+			return
+		}
+
+		user, ok := currentUser(c, ctx)
+		if !ok {
+			return
+		}
+
+		if user.Mfa_Pending_Secret == nil {
+			apperrors.Respond(c, apperrors.New(apperrors.Conflict, "Start enrollment with POST /mfa/enroll first"))
+			return
+		}
+
+		step, valid := totp.Validate(*user.Mfa_Pending_Secret, req.Code, time.Now(), totpSkew)
+		if !valid {
+			apperrors.Respond(c, invalidMfaCode)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while generating recovery codes", err))
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		update := bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "mfa_enabled", Value: true},
+				{Key: "mfa_secret", Value: *user.Mfa_Pending_Secret},
+				{Key: "mfa_last_step", Value: step},
+				{Key: "mfa_recovery_codes", Value: hashes},
+				{Key: "updated_at", Value: updatedAt},
+			}},
+			{Key: "$unset", Value: bson.D{{Key: "mfa_pending_secret", Value: ""}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+
+		// Matching on the pending secret stops two confirmations racing.
+		filter := bson.M{"user_id": user.User_id, "mfa_pending_secret": *user.Mfa_Pending_Secret}
+		result, err := userCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			apperrors.Respond(c, apperrors.New(apperrors.Conflict, "Enrollment was restarted. Scan the new secret and try again"))
+			return
+		}
+
+		c.JSON(http.StatusOK, MfaRecoveryCodes{Recovery_Codes: codes})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the signed in user's recovery codes.
+func RegenerateRecoveryCodes() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req MfaCodeRequest
+		if !bindMfaCode(c, &req) {
+			return
+		}
+
+		user, ok := currentUser(c, ctx)
+		if !ok || !requireMfaCode(c, ctx, user, req.Code) {
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while generating recovery codes", err))
+			return
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "mfa_recovery_codes", Value: hashes}}}}
+		if _, err := userCollection.UpdateOne(ctx, bson.M{"user_id": user.User_id}, update); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		c.JSON(http.StatusOK, MfaRecoveryCodes{Recovery_Codes: codes})
+	}
+}
+
+// DisableMFA turns two-factor authentication off for the signed in user,
+// unless their role requires it.
+func DisableMFA() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req MfaCodeRequest
+		if !bindMfaCode(c, &req) {
+			return
+		}
+
+		user, ok := currentUser(c, ctx)
+		if !ok || !requireMfaCode(c, ctx, user, req.Code) {
+			return
+		}
+
+		if user.User_Type != nil && helpers.MfaRequired(*user.User_Type) {
+			apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "Two-factor authentication is required for your role"))
+			return
+		}
+
+		if err := clearMfa(ctx, user.User_id); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Two-factor authentication disabled",
+		})
+	}
+}
+
+// ResetUserMFA lets an admin clear the two-factor enrollment of a user who
+// lost both their device and their recovery codes.
+func ResetUserMFA() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		if err := clearMfa(ctx, c.Param("user_id")); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Two-factor authentication reset",
+		})
+	}
+}
+
+func clearMfa(ctx context.Context, userId string) error {
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "mfa_enabled", Value: false}, {Key: "updated_at", Value: updatedAt}}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "mfa_secret", Value: ""},
+			{Key: "mfa_pending_secret", Value: ""},
+			{Key: "mfa_last_step", Value: ""},
+			{Key: "mfa_recovery_codes", Value: ""},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userId}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func bindMfaCode(c *gin.Context, req *MfaCodeRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		apperrors.Respond(c, apperrors.Validation(err))
+		return false
+	}
+
+	if err := validate.Struct(req); err != nil {
+		apperrors.Respond(c, apperrors.Validation(err))
+		return false
+	}
+
+	return true
+}
+
+func currentUser(c *gin.Context, ctx context.Context) (models.User, bool) {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+		apperrors.Respond(c, apperrors.FromDB(err, "user"))
+		return user, false
+	}
+	return user, true
+}
+
+// requireMfaCode checks a code from the user's app before a change to their
+// two-factor settings.
+func requireMfaCode(c *gin.Context, ctx context.Context, user models.User, code string) bool {
+	if !user.Mfa_Enabled {
+		apperrors.Respond(c, apperrors.New(apperrors.Conflict, "Two-factor authentication is not enabled"))
+		return false
+	}
+
+	ok, err := useTotpCode(ctx, user, code)
+	if err != nil {
+		apperrors.Respond(c, apperrors.FromDB(err, "user"))
+		return false
+	}
+
+	if !ok {
+		apperrors.Respond(c, invalidMfaCode)
+		return false
+	}
+
+	return true
+}
+
+// useTotpCode checks code against the user's secret. Each code is accepted
+// once: the step it belongs to is recorded and codes from that step or
+// earlier are refused afterwards.
+func useTotpCode(ctx context.Context, user models.User, code string) (bool, error) {
+	if !user.Mfa_Enabled || user.Mfa_Secret == nil {
+		return false, nil
+	}
+
+	step, ok := totp.ValidateAfter(*user.Mfa_Secret, code, time.Now(), totpSkew, user.Mfa_Last_Step)
+	if !ok {
+		return false, nil
+	}
+
+	// Two requests with the same code can both get this far, so only the
+	// first to record its step counts.
+	filter := bson.M{
+		"user_id": user.User_id,
+		"$or": bson.A{
+			bson.M{"mfa_last_step": bson.M{"$lt": step}},
+			bson.M{"mfa_last_step": bson.M{"$exists": false}},
+		},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "mfa_last_step", Value: step}}}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// useRecoveryCode removes code from the user's recovery codes, reporting
+// whether it was there.
+func useRecoveryCode(ctx context.Context, user models.User, code string) (bool, error) {
+	if !user.Mfa_Enabled {
+		return false, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	filter := bson.M{"user_id": user.User_id, "mfa_recovery_codes": hash}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "mfa_recovery_codes", Value: hash}}}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// newRecoveryCodes returns codes to show the user and the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case and dashes so codes can be typed loosely.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Restaurant Management"
+}
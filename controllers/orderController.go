@@ -2,37 +2,38 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/geo"
 	"github.com/teandresmith/restaurant-project/helpers"
 	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func GetOrders() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
-		results, err := orderCollection.Find(context.TODO(), bson.M{})
+		results, err := orderCollection.Find(ctx, bson.M{})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while searching the invoice collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
 			return
 		}
 
 		var allOrders []bson.M
 		
 		if err := results.All(ctx, &allOrders); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while iterating through all invoices",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
 			return
 		}
 		defer cancel()
@@ -44,7 +45,7 @@ func GetOrders() gin.HandlerFunc{
 
 func GetOrder() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		orderId := c.Param("order_id")
 		var order models.Order
@@ -52,37 +53,40 @@ func GetOrder() gin.HandlerFunc{
 		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while searching the invoice collection.",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
 			return
 		}
 
+		helpers.SetETag(c, order.Version)
 		c.JSON(http.StatusOK, order)
 	}
 }
 
 func CreateOrder() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var order models.Order
 
-		if err := c.BindJSON(&order); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": err.Error(),
-			})
+		if err := c.ShouldBindJSON(&order); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
 
 		if err := validate.Struct(order); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while validating the request body data",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.Validation(err))
+			defer cancel()
+			return
+		}
+
+		if order.Order_type == nil {
+			orderType := services.OrderTypeDineIn
+			order.Order_type = &orderType
+		}
+
+		if err := services.ValidateOrderType(order); err != nil {
+			apperrors.Respond(c, err)
 			defer cancel()
 			return
 		}
@@ -91,17 +95,70 @@ func CreateOrder() gin.HandlerFunc{
 		order.Order_id = order.ID.Hex()
 		order.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		order.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		order.Version = 1
+		order.Created_By = helpers.ActorFrom(c)
+
+		orderStatus := services.OrderStatusOpen
+		order.Order_status = &orderStatus
+
+		if order.Promised_At != nil && !order.Promised_At.After(order.Created_At) {
+			apperrors.Respond(c, &apperrors.Error{
+				Code:    apperrors.ValidationFailed,
+				Message: "The request body is not valid",
+				Fields:  []apperrors.FieldError{{Field: "promised_at", Rule: "future", Message: "must be in the future"}},
+			})
+			defer cancel()
+			return
+		}
+
+		if services.OrderType(order) == services.OrderTypeDelivery {
+			quote, err := quoteDelivery(ctx, *order.Delivery_Address)
+			if err != nil {
+				apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+				defer cancel()
+				return
+			}
+			order.Delivery_Quote = &quote
+		}
 
-		insertResults, insertErr := orderCollection.InsertOne(ctx, bson.M{"order_id": order.Order_id})
+		// Without a time asked for, takeout is promised as soon as the kitchen
+		// can make it and delivery by the end of its zone's ETA.
+		if order.Promised_At == nil {
+			var promisedAt time.Time
+			switch services.OrderType(order) {
+			case services.OrderTypeTakeout:
+				promisedAt = order.Created_At.Add(pickupScheduler.LeadTime())
+			case services.OrderTypeDelivery:
+				promisedAt = order.Created_At.Add(time.Duration(order.Delivery_Quote.Eta_Max_Minutes) * time.Minute)
+			}
+			if !promisedAt.IsZero() {
+				order.Promised_At = &promisedAt
+			}
+		}
+
+		var insertResults *mongo.InsertOneResult
+		var insertErr error
+
+		if services.OrderType(order) == services.OrderTypePickup {
+			// The order is inserted in the transaction that takes its place
+			// in the slot, so no other order can take it.
+			_, insertErr = pickupScheduler.Book(ctx, order.Promised_At, func(ctx context.Context, slot time.Time) error {
+				order.Promised_At = &slot
+				insertResults, insertErr = orderCollection.InsertOne(ctx, order)
+				return insertErr
+			})
+		} else {
+			insertResults, insertErr = orderCollection.InsertOne(ctx, order)
+		}
 		defer cancel()
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while inserting an object in the order collection",
-				"error": insertErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(insertErr, "order"))
 			return
 		}
 
+		events.Publish(ctx, events.Event{Type: events.OrderCreated, Subject: order.Order_id, Data: order})
+
+		helpers.SetETag(c, order.Version)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Insertion Successful",
 			"results": insertResults,
@@ -110,16 +167,117 @@ func CreateOrder() gin.HandlerFunc{
 }
 
 
-// Need to complete logic
 func UpdateOrder() gin.HandlerFunc{
 	return func(c *gin.Context){
-		
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+
+		var order models.Order
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		if err := c.ShouldBindJSON(&order); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			defer cancel()
+			return
+		}
+
+		// Moving an order changes two tables' statuses and is audited, so it
+		// only happens through MoveOrder.
+		if order.Table_id != nil {
+			apperrors.Respond(c, &apperrors.Error{
+				Code:    apperrors.ValidationFailed,
+				Message: "The request body is not valid",
+				Fields:  []apperrors.FieldError{{Field: "table_id", Rule: "excluded", Message: "cannot be changed here, move the order with POST /orders/{order_id}/move"}},
+			})
+			defer cancel()
+			return
+		}
+
+		var orderUpdate primitive.D
+		orderId := c.Param("order_id")
+
+		if order.Customer != nil || order.Delivery_Address != nil {
+			var current models.Order
+
+			err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&current)
+			defer cancel()
+			if err != nil {
+				apperrors.Respond(c, apperrors.FromDB(err, "order"))
+				return
+			}
+
+			if order.Customer != nil {
+				if err := validate.Struct(order.Customer); err != nil {
+					apperrors.Respond(c, apperrors.Validation(err))
+					return
+				}
+				current.Customer = order.Customer
+				orderUpdate = append(orderUpdate, bson.E{Key: "customer", Value: order.Customer})
+			}
+
+			if order.Delivery_Address != nil {
+				if err := validate.Struct(order.Delivery_Address); err != nil {
+					apperrors.Respond(c, apperrors.Validation(err))
+					return
+				}
+				current.Delivery_Address = order.Delivery_Address
+				orderUpdate = append(orderUpdate, bson.E{Key: "delivery_address", Value: order.Delivery_Address})
+			}
+
+			if err := services.ValidateOrderType(current); err != nil {
+				apperrors.Respond(c, err)
+				return
+			}
+
+			// A new address can be in another zone, with another fee.
+			if order.Delivery_Address != nil && services.OrderType(current) == services.OrderTypeDelivery {
+				quote, err := quoteDelivery(ctx, *order.Delivery_Address)
+				if err != nil {
+					apperrors.Respond(c, apperrors.FromDB(err, "delivery zone"))
+					return
+				}
+				orderUpdate = append(orderUpdate, bson.E{Key: "delivery_quote", Value: quote})
+			}
+		}
+
+		if !order.Order_Date.IsZero() {
+			orderUpdate = append(orderUpdate, bson.E{Key: "order_date", Value: order.Order_Date})
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		orderUpdate = append(orderUpdate, bson.E{Key: "updated_at", Value: updatedAt})
+		orderUpdate = append(orderUpdate, bson.E{Key: "updated_by", Value: helpers.ActorFrom(c)})
+
+		filter := bson.M{"order_id": orderId, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: orderUpdate}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+
+		updateResults, updateErr := orderCollection.UpdateOne(ctx, filter, update)
+		defer cancel()
+		if updateErr != nil {
+			apperrors.Respond(c, apperrors.FromDB(updateErr, "order"))
+			return
+		}
+
+		if updateResults.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, orderCollection, bson.M{"order_id": orderId}, "order")
+			return
+		}
+
+		helpers.SetETag(c, version+1)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Update Successful",
+			"results": updateResults,
+		})
 	}
 }
 
 func DeleteOrder() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		if admin := helpers.IsAdmin(c); !admin {
 			defer cancel()
@@ -128,19 +286,174 @@ func DeleteOrder() gin.HandlerFunc{
 
 		orderId := c.Param("order_id")
 
-		deleteResult, deleteErr := orderCollection.DeleteOne(ctx, bson.M{"order_id": orderId})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		var deleted models.Order
+
+		deleteErr := orderCollection.FindOneAndDelete(ctx, bson.M{"order_id": orderId, "version": helpers.VersionFilter(version)}).Decode(&deleted)
 		defer cancel()
+		if errors.Is(deleteErr, mongo.ErrNoDocuments) {
+			helpers.RespondVersionMismatch(ctx, c, orderCollection, bson.M{"order_id": orderId}, "order")
+			return
+		}
 		if deleteErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while deleting an object in the order collection",
-				"error": deleteErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(deleteErr, "order"))
 			return
 		}
+		deleteResult := &mongo.DeleteResult{DeletedCount: 1}
+
+		if services.OrderType(deleted) == services.OrderTypePickup && deleted.Promised_At != nil {
+			if err := pickupScheduler.Release(ctx, *deleted.Promised_At); err != nil {
+				slog.Warn("could not give back a pickup slot", "order_id", orderId, "error", err)
+			}
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Deletion Successful",
 			"results": deleteResult,
 		})
 	}
-}
\ No newline at end of file
+}
+
+// MoveOrder moves an open order to another table when guests change tables.
+func MoveOrder() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.MoveOrderRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		req.Order_id = c.Param("order_id")
+		req.Actor = helpers.ActorFrom(c)
+
+		order, err := tableBoard.MoveOrder(ctx, req)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
+			return
+		}
+
+		helpers.SetETag(c, order.Version)
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// TransferOrder hands an open order over to another server.
+func TransferOrder() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.TransferOrderRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if ok := checkServerReference(ctx, c, req.Server_id); !ok {
+			return
+		}
+
+		req.Order_id = c.Param("order_id")
+		req.Actor = helpers.ActorFrom(c)
+
+		order, err := tableBoard.TransferOrder(ctx, req)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "order"))
+			return
+		}
+
+		helpers.SetETag(c, order.Version)
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// GetOrderAudit lists the moves and transfers of an order.
+func GetOrderAudit() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		entries, err := tableBoard.Audit(ctx, "", c.Param("order_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table audit"))
+			return
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// quoteDelivery finds the delivery zone of an address. Addresses outside
+// every zone are reported against the address.
+func quoteDelivery(ctx context.Context, address models.Address) (models.DeliveryQuote, error) {
+	quote, err := deliveryZones.Quote(ctx, geo.Point{Lat: *address.Latitude, Lng: *address.Longitude})
+	if errors.Is(err, services.ErrNotDeliverable) {
+		return quote, &apperrors.Error{
+			Code:    apperrors.ValidationFailed,
+			Message: "The request body is not valid",
+			Fields:  []apperrors.FieldError{{Field: "delivery_address", Rule: "delivery_zone", Message: "is outside every delivery zone"}},
+			Cause:   err,
+		}
+	}
+	return quote, err
+}
+
+type PickupSlotsRequest struct {
+	From *time.Time `form:"from"`
+	To   *time.Time `form:"to"`
+}
+
+// GetPickupSlots lists the pickup slots from the first the kitchen can make
+// until to, six hours ahead by default, with how many orders each can still
+// take.
+func GetPickupSlots() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req PickupSlotsRequest
+
+		if err := c.ShouldBindQuery(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		from := time.Now()
+		if req.From != nil {
+			from = *req.From
+		}
+
+		to := from.Add(6*time.Hour)
+		if req.To != nil {
+			to = *req.To
+		}
+
+		slots, err := pickupScheduler.Slots(ctx, from, to)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "pickup slot"))
+			return
+		}
+
+		c.JSON(http.StatusOK, slots)
+	}
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestUpdateOrderTable checks orders can't be moved to another table with
+// PATCH, which would leave both tables' statuses behind and the move out of
+// the audit trail. It is refused before the order is looked up.
+func TestUpdateOrderTable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PATCH("/orders/:order_id", UpdateOrder())
+
+	req := httptest.NewRequest(http.MethodPatch, "/orders/o1", strings.NewReader(`{"table_id":"t2"}`))
+	req.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"field":"table_id"`) {
+		t.Errorf("PATCH table_id: %d %s, want table_id refused", w.Code, w.Body)
+	}
+}
@@ -6,34 +6,29 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
 	"github.com/teandresmith/restaurant-project/helpers"
 	"github.com/teandresmith/restaurant-project/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func GetOrderItems() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
-		results, err := orderItemCollection.Find(context.TODO(), bson.M{})
+		results, err := orderItemCollection.Find(ctx, bson.M{})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the order item collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order item"))
 			return
 		}
 
 		var allOrderItems []bson.M
 
 		if err := results.All(ctx, &allOrderItems); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while iterating througuh all order items",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order item"))
 			return
 		}
 		defer cancel()
@@ -45,21 +40,19 @@ func GetOrderItems() gin.HandlerFunc{
 
 func GetOrderItem() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
-		var orderItem models.Food
+		var orderItem models.OrderItem
 		orderItemId := c.Param("order_item_id")
 
 		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemId}).Decode(&orderItem)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the order item collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order item"))
 			return
 		}
 
+		helpers.SetETag(c, orderItem.Version)
 		c.JSON(http.StatusOK, orderItem)
 
 
@@ -69,26 +62,22 @@ func GetOrderItem() gin.HandlerFunc{
 
 func GetOrderItemsByOrder() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		orderId := c.Param("order_id")
 
 		results, err := orderItemCollection.Find(ctx, bson.M{"order_id": orderId})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the order item collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order item"))
+			return
 		}
 
 		var allOrderItems []bson.M
 
 		if err := results.All(ctx, &allOrderItems); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while iterating through all the results",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "order item"))
+			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -104,24 +93,18 @@ func GetOrderItemsByOrder() gin.HandlerFunc{
 
 func CreateOrderItem() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var orderItem models.OrderItem
 
-		if err := c.BindJSON(&orderItem); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": err.Error(),
-			})
+		if err := c.ShouldBindJSON(&orderItem); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
 
 		if err := validate.Struct(orderItem); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while validating request body data",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
@@ -130,17 +113,19 @@ func CreateOrderItem() gin.HandlerFunc{
 		orderItem.Order_item_id = orderItem.ID.Hex()
 		orderItem.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		orderItem.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		orderItem.Version = 1
+		orderItem.Created_By = helpers.ActorFrom(c)
 
-		insertResults, insertErr := orderItemCollection.InsertOne(ctx, bson.M{"order_item_id": orderItem.Order_item_id})
+		insertResults, insertErr := orderItemCollection.InsertOne(ctx, orderItem)
 		defer cancel()
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while inserting an object in the order items collection",
-				"error": insertErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(insertErr, "order item"))
 			return
 		}
 
+		events.Publish(ctx, events.Event{Type: events.OrderItemFired, Subject: orderItem.Order_item_id, Data: orderItem})
+
+		helpers.SetETag(c, orderItem.Version)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Insertion Successful",
 			"results": insertResults,
@@ -150,15 +135,18 @@ func CreateOrderItem() gin.HandlerFunc{
 
 func UpdateOrderItem() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var orderItem models.OrderItem
 
-		if err := c.BindJSON(&orderItem); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": err.Error(),
-			})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		if err := c.ShouldBindJSON(&orderItem); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
@@ -176,21 +164,25 @@ func UpdateOrderItem() gin.HandlerFunc{
 
 		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		orderItemUpdate = append(orderItemUpdate, bson.E{Key: "updated_at", Value: updatedAt})
+		orderItemUpdate = append(orderItemUpdate, bson.E{Key: "updated_by", Value: helpers.ActorFrom(c)})
 
-		filter := bson.M{"order_item_id": orderItemId}
-		opt := options.Update().SetUpsert(true)
-		update := bson.D{{Key: "$set", Value: orderItemUpdate}}
+		filter := bson.M{"order_item_id": orderItemId, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: orderItemUpdate}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
 
-		updateResults, updateErr := orderItemCollection.UpdateOne(ctx, filter, update, opt)
+		updateResults, updateErr := orderItemCollection.UpdateOne(ctx, filter, update)
 		defer cancel()
 		if updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while updating an object in the order item collection",
-				"error": updateErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(updateErr, "order item"))
 			return
 		}
 
+		if updateResults.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, orderItemCollection, bson.M{"order_item_id": orderItemId}, "order item")
+			return
+		}
+
+		helpers.SetETag(c, version+1)
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Update Successful",
 			"results": updateResults,
@@ -201,7 +193,7 @@ func UpdateOrderItem() gin.HandlerFunc{
 
 func DeleteOrderItem() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		if admin := helpers.IsAdmin(c); !admin {
 			defer cancel()
@@ -210,13 +202,21 @@ func DeleteOrderItem() gin.HandlerFunc{
 
 		orderItemId := c.Param("order_item_id")
 
-		deleteResult, deleteErr := orderItemCollection.DeleteOne(ctx, bson.M{"order_item_id": orderItemId})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		deleteResult, deleteErr := orderItemCollection.DeleteOne(ctx, bson.M{"order_item_id": orderItemId, "version": helpers.VersionFilter(version)})
 		defer cancel()
 		if deleteErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while deleting an object in orderitems collection",
-				"error": deleteErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(deleteErr, "order item"))
+			return
+		}
+
+		if deleteResult.DeletedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, orderItemCollection, bson.M{"order_item_id": orderItemId}, "order item")
 			return
 		}
 
@@ -1,17 +1,430 @@
 package controllers
 
 import (
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/go-playground/validator"
 	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/eventsource"
+	"github.com/teandresmith/restaurant-project/geo"
+	"github.com/teandresmith/restaurant-project/health"
+	"github.com/teandresmith/restaurant-project/mailer"
+	"github.com/teandresmith/restaurant-project/marketplace"
+	"github.com/teandresmith/restaurant-project/notifier"
+	"github.com/teandresmith/restaurant-project/services"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var foodCollection *mongo.Collection = database.OpenCollection(database.Client, "food")
-var menuCollection *mongo.Collection = database.OpenCollection(database.Client, "menu")
-var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
-var invoiceCollection *mongo.Collection = database.OpenCollection(database.Client, "invoice")
-var orderCollection *mongo.Collection = database.OpenCollection(database.Client, "order")
-var orderItemCollection *mongo.Collection = database.OpenCollection(database.Client, "orderitems")
-var tableCollection *mongo.Collection = database.OpenCollection(database.Client, "table")
-var validate = validator.New()
+var foodCollection *mongo.Collection
+var menuCollection *mongo.Collection
+var userCollection *mongo.Collection
+var invoiceCollection *mongo.Collection
+var orderCollection *mongo.Collection
+var orderItemCollection *mongo.Collection
+var tableCollection *mongo.Collection
+var sectionCollection *mongo.Collection
+var terminalCollection *mongo.Collection
+var apiKeyCollection *mongo.Collection
+var validate = newValidator()
+
+var checkoutService *services.CheckoutService
+var tableBoard *services.TableBoard
+var waitlist *services.Waitlist
+var guestOrdering *services.GuestOrdering
+var pickupScheduler *services.PickupScheduler
+var deliveryZones *services.DeliveryZones
+var marketplaceOrders *services.MarketplaceOrders
+var webhooks *services.Webhooks
+var kitchen *services.Kitchen
+var changeStream *eventsource.ChangeStream
+var loginGuard *services.LoginGuard
+var pinGuard *services.LoginGuard
+var accountTokens *services.AccountTokens
+var mail mailer.Mailer
+
+// Setup opens the collections and services the handlers use. It must be
+// called with a connected client before the routes are served.
+func Setup(client *mongo.Client) error {
+	foodCollection = database.OpenCollection(client, "food")
+	menuCollection = database.OpenCollection(client, "menu")
+	userCollection = database.OpenCollection(client, "user")
+	ensureUserIndexes()
+	invoiceCollection = database.OpenCollection(client, "invoice")
+	orderCollection = database.OpenCollection(client, "order")
+	orderItemCollection = database.OpenCollection(client, "orderitems")
+	tableCollection = database.OpenCollection(client, "table")
+	sectionCollection = database.OpenCollection(client, "section")
+	terminalCollection = database.OpenCollection(client, "terminal")
+	apiKeyCollection = database.OpenCollection(client, "api_keys")
+	ensureApiKeyIndexes()
+
+	tableStore, waitlistStore, guestOrderStore, pickupSlotStore := newTableStores(client)
+	checkoutService = services.NewCheckoutService(newCheckoutStore(client, guestOrderStore))
+	tableBoard = services.NewTableBoard(tableStore)
+	tableBoard.Subscribe(events.Default)
+	guestOrdering = services.NewGuestOrdering(guestOrderStore, tableStore, maxPendingItems())
+	kitchen = services.NewKitchen(newKitchenStore(client, guestOrderStore))
+	kitchen.Subscribe(events.Default)
+	pickupScheduler = services.NewPickupScheduler(pickupSlotStore, pickupSlotCapacity(), durationFromEnv("PICKUP_LEAD_TIME", services.DefaultPickupLeadTime))
+	deliveryZones = services.NewDeliveryZones(newDeliveryZoneStore(client), restaurantLocation(), durationFromEnv("DELIVERY_TIME", services.DefaultDeliveryTime))
+	loginAttemptStore := newLoginAttemptStore(client)
+	loginGuard = services.NewLoginGuard(loginAttemptStore, loginPolicyFromEnv())
+	pinGuard = services.NewLoginGuard(loginAttemptStore, services.PinLoginPolicy)
+	accountTokens = services.NewAccountTokens(newAccountTokenStore(client))
+
+	var err error
+	mail, err = mailer.FromEnv()
+	if err != nil {
+		return err
+	}
+
+	marketplaces, err := marketplace.FromEnv()
+	if err != nil {
+		return err
+	}
+	marketplaceOrders = services.NewMarketplaceOrders(newMarketplaceStore(client, guestOrderStore), marketplaces)
+	marketplaceOrders.Subscribe(events.Default)
+
+	webhooks = services.NewWebhooks(newWebhookStore(client))
+	changeStream = newChangeStream(client)
+	if changeStream == nil {
+		webhooks.Subscribe(events.Default)
+	}
+
+	notify, err := notifier.FromEnv(mail)
+	if err != nil {
+		return err
+	}
+	waitlist = services.NewWaitlist(waitlistStore, tableBoard, notify, restaurantName())
+
+	return nil
+}
+
+// Start runs the work the services set up by Setup do in the background,
+// until ctx is cancelled, and registers readiness checks for it. It fails if
+// the change stream can't be opened, since with MongoDB webhooks are only
+// recorded from it.
+func Start(ctx context.Context) error {
+	if changeStream != nil {
+		if err := changeStream.Open(ctx); err != nil {
+			return err
+		}
+		go changeStream.Run(ctx)
+		health.Register("change_stream", changeStream.Check)
+	}
+
+	go webhooks.Run(ctx)
+	health.Register("webhooks", webhooks.Check)
+	return nil
+}
+
+// newChangeStream returns the change stream that feeds the webhook outbox
+// with MongoDB, so an event is recorded if and only if its change was
+// committed. It also publishes events on the bus when EVENT_SOURCE asks for
+// it. The memory backend has no database to watch, so it returns nil and
+// everything is published in process.
+func newChangeStream(client *mongo.Client) *eventsource.ChangeStream {
+	if database.Backend() == database.MemoryBackend {
+		if eventsource.Enabled() {
+			slog.Warn("EVENT_SOURCE is ignored with the memory backend, publishing events in process")
+		}
+		return nil
+	}
+
+	var bus *events.Bus
+	if eventsource.Enabled() {
+		bus = events.Default
+	}
+	return eventsource.NewChangeStream(client, webhooks.Record, bus)
+}
+
+func newAccountTokenStore(client *mongo.Client) services.AccountTokenStore {
+	if database.Backend() == database.MemoryBackend {
+		return services.NewMemoryAccountTokenStore()
+	}
+
+	store := services.NewMongoAccountTokenStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create account_tokens indexes", "error", err)
+	}
+
+	return store
+}
+
+// ensureUserIndexes allows one account per email, which SignUp and EditUser
+// check for but can't settle alone when two requests race.
+func ensureUserIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := userCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		slog.Warn("could not create user indexes", "error", err)
+	}
+}
+
+// ensureApiKeyIndexes indexes the key hash, which every request made with an
+// API key is looked up by.
+func ensureApiKeyIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := apiKeyCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		slog.Warn("could not create api_keys indexes", "error", err)
+	}
+}
+
+// newLoginAttemptStore returns the store failed logins and PIN logins are
+// counted in. PIN logins are kept apart by their "pin:" keys.
+func newLoginAttemptStore(client *mongo.Client) services.LoginAttemptStore {
+	if database.Backend() == database.MemoryBackend {
+		return services.NewMemoryLoginAttemptStore()
+	}
+
+	store := services.NewMongoLoginAttemptStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create login_attempts indexes", "error", err)
+	}
+
+	return store
+}
+
+// loginPolicyFromEnv overrides the default lockout with LOGIN_MAX_FAILURES
+// and LOGIN_LOCKOUT when they are set.
+func loginPolicyFromEnv() services.LoginPolicy {
+	policy := services.DefaultLoginPolicy
+
+	if maxFailures, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil && maxFailures > 0 {
+		policy.MaxFailures = maxFailures
+	}
+
+	if lockout, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT")); err == nil && lockout > 0 {
+		policy.Lockout = lockout
+	}
+
+	return policy
+}
+
+// newCheckoutStore checks out the orders and items the guest ordering store
+// keeps when running in memory.
+func newCheckoutStore(client *mongo.Client, guestOrderStore services.GuestOrderStore) services.CheckoutStore {
+	if guest, ok := guestOrderStore.(*services.MemoryGuestOrderStore); ok {
+		return services.NewMemoryCheckoutStore(guest)
+	}
+
+	store := services.NewMongoCheckoutStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create invoice indexes", "error", err)
+	}
+
+	return store
+}
+
+// newTableStores returns the stores for the table board, the waitlist, guest
+// ordering and pickup slots, which read the floor and orders the board keeps.
+func newTableStores(client *mongo.Client) (services.TableStore, services.WaitlistStore, services.GuestOrderStore, services.PickupSlotStore) {
+	if database.Backend() == database.MemoryBackend {
+		tables := services.NewMemoryTableStore()
+		return tables, services.NewMemoryWaitlistStore(tables), services.NewMemoryGuestOrderStore(tables), services.NewMemoryPickupSlotStore(tables)
+	}
+
+	store := services.NewMongoTableStore(client)
+	waitlistStore := services.NewMongoWaitlistStore(client)
+	guestOrderStore := services.NewMongoGuestOrderStore(client)
+	pickupSlotStore := services.NewMongoPickupSlotStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.Migrate(ctx); err != nil {
+		slog.Warn("could not migrate table statuses", "error", err)
+	}
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create table_groups and table_audit indexes", "error", err)
+	}
+
+	if err := waitlistStore.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create waitlist indexes", "error", err)
+	}
+
+	if err := guestOrderStore.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create guest ordering indexes", "error", err)
+	}
+
+	if err := pickupSlotStore.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create pickup slot indexes", "error", err)
+	}
+
+	return store, waitlistStore, guestOrderStore, pickupSlotStore
+}
+
+func newDeliveryZoneStore(client *mongo.Client) services.DeliveryZoneStore {
+	if database.Backend() == database.MemoryBackend {
+		return services.NewMemoryDeliveryZoneStore()
+	}
+
+	store := services.NewMongoDeliveryZoneStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create delivery_zones indexes", "error", err)
+	}
+
+	return store
+}
+
+// newMarketplaceStore puts orders from marketplaces and their items in the
+// guest ordering store when running in memory.
+func newMarketplaceStore(client *mongo.Client, guestOrderStore services.GuestOrderStore) services.MarketplaceStore {
+	if guest, ok := guestOrderStore.(*services.MemoryGuestOrderStore); ok {
+		return services.NewMemoryMarketplaceStore(guest)
+	}
+
+	store := services.NewMongoMarketplaceStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create marketplace indexes", "error", err)
+	}
+
+	return store
+}
+
+// newKitchenStore reads foods, menus and orders from the guest ordering
+// store when running in memory.
+func newKitchenStore(client *mongo.Client, guestOrderStore services.GuestOrderStore) services.KitchenStore {
+	if guest, ok := guestOrderStore.(*services.MemoryGuestOrderStore); ok {
+		return services.NewMemoryKitchenStore(guest)
+	}
+
+	store := services.NewMongoKitchenStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create station indexes", "error", err)
+	}
+
+	return store
+}
+
+func newWebhookStore(client *mongo.Client) services.WebhookStore {
+	if database.Backend() == database.MemoryBackend {
+		return services.NewMemoryWebhookStore()
+	}
+
+	store := services.NewMongoWebhookStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create webhook indexes", "error", err)
+	}
+
+	return store
+}
+
+// restaurantLocation reads RESTAURANT_LOCATION, the restaurant's
+// "latitude,longitude", which delivery distances are measured from.
+func restaurantLocation() *geo.Point {
+	value := os.Getenv("RESTAURANT_LOCATION")
+	if value == "" {
+		return nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) == 2 {
+		lat, latErr := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lng, lngErr := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if point := (geo.Point{Lat: lat, Lng: lng}); latErr == nil && lngErr == nil && point.Valid() {
+			return &point
+		}
+	}
+
+	slog.Warn("RESTAURANT_LOCATION is not a latitude,longitude pair, ignoring it", "value", value)
+	return nil
+}
+
+// maxPendingItems reads GUEST_MAX_PENDING_ITEMS, how many items guests at a
+// table may have waiting for approval at once.
+func maxPendingItems() int {
+	if max, err := strconv.Atoi(os.Getenv("GUEST_MAX_PENDING_ITEMS")); err == nil && max > 0 {
+		return max
+	}
+	return services.DefaultMaxPendingItems
+}
+
+// pickupSlotCapacity reads PICKUP_SLOT_CAPACITY, how many pickup orders the
+// kitchen takes for each 15 minute slot.
+func pickupSlotCapacity() int {
+	if capacity, err := strconv.Atoi(os.Getenv("PICKUP_SLOT_CAPACITY")); err == nil && capacity > 0 {
+		return capacity
+	}
+	return services.DefaultPickupSlotCapacity
+}
+
+// durationFromEnv reads a duration such as "20m" from the environment.
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// restaurantName is how guests are told where their table is ready.
+func restaurantName() string {
+	if name := os.Getenv("RESTAURANT_NAME"); name != "" {
+		return name
+	}
+	return "the restaurant"
+}
 
+// newValidator reports fields by their json name so validation errors match
+// what the client sent.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
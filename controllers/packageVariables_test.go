@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+// TestMemoryCheckout checks out orders put on the memory backend by guest
+// ordering and by a marketplace, through the stores Setup builds.
+func TestMemoryCheckout(t *testing.T) {
+	t.Setenv("DB_BACKEND", "memory")
+	ctx := context.Background()
+
+	tableStore, _, guestOrderStore, _ := newTableStores(nil)
+	checkout := services.NewCheckoutService(newCheckoutStore(nil, guestOrderStore))
+	marketplaceStore := newMarketplaceStore(nil, guestOrderStore)
+
+	open, price := services.OrderStatusOpen, 14.0
+	guestOrder, marketplaceOrder := "guest-order", "marketplace-order"
+
+	if err := guestOrderStore.InsertOrder(ctx, models.Order{Order_id: guestOrder, Order_status: &open}); err != nil {
+		t.Fatal(err)
+	}
+	if err := guestOrderStore.InsertOrderItems(ctx, []models.OrderItem{{Order_item_id: "i1", Order_id: &guestOrder, Unit_Price: &price}}); err != nil {
+		t.Fatal(err)
+	}
+
+	order := models.Order{Order_id: marketplaceOrder, Order_status: &open, External_Ref: &models.ExternalRef{Provider: "standard", External_id: "e1"}}
+	if err := marketplaceStore.InsertOrder(ctx, order, []models.OrderItem{{Order_item_id: "i2", Order_id: &marketplaceOrder, Unit_Price: &price}}); err != nil {
+		t.Fatal(err)
+	}
+
+	method := "CASH"
+	for _, orderId := range []string{guestOrder, marketplaceOrder} {
+		result, err := checkout.Checkout(ctx, services.CheckoutRequest{Order_id: orderId, Payment_Method: &method})
+		if err != nil {
+			t.Fatalf("Checkout(%s): %v", orderId, err)
+		}
+		if result.Invoice.Total_amount != price {
+			t.Errorf("Checkout(%s) invoiced %v, want %v", orderId, result.Invoice.Total_amount, price)
+		}
+
+		paid, err := tableStore.FindOrder(ctx, orderId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paid.Order_status == nil || *paid.Order_status != services.OrderStatusPaid {
+			t.Errorf("order %s is %v after checkout, want %s", orderId, paid.Order_status, services.OrderStatusPaid)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func GetSections() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "room", Value: 1}, {Key: "sort_order", Value: 1}, {Key: "name", Value: 1}})
+		results, err := sectionCollection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "section"))
+			return
+		}
+
+		sections := []models.Section{}
+		if err := results.All(ctx, &sections); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "section"))
+			return
+		}
+
+		c.JSON(http.StatusOK, sections)
+	}
+}
+
+func GetSection() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var section models.Section
+
+		err := sectionCollection.FindOne(ctx, bson.M{"section_id": c.Param("section_id")}).Decode(&section)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "section"))
+			return
+		}
+
+		helpers.SetETag(c, section.Version)
+		c.JSON(http.StatusOK, section)
+	}
+}
+
+func CreateSection() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var section models.Section
+
+		if err := c.ShouldBindJSON(&section); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(section); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if ok := checkServerReference(ctx, c, section.Server_id); !ok {
+			return
+		}
+
+		section.ID = primitive.NewObjectID()
+		section.Section_id = section.ID.Hex()
+		section.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		section.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		section.Version = 1
+
+		if section.Sort_Order == nil {
+			sortOrder := 0
+			section.Sort_Order = &sortOrder
+		}
+
+		if _, err := sectionCollection.InsertOne(ctx, section); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "section"))
+			return
+		}
+
+		helpers.SetETag(c, section.Version)
+		c.JSON(http.StatusCreated, section)
+	}
+}
+
+func UpdateSection() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		var section models.Section
+
+		if err := c.ShouldBindJSON(&section); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if ok := checkServerReference(ctx, c, section.Server_id); !ok {
+			return
+		}
+
+		var sectionUpdates primitive.D
+		sectionId := c.Param("section_id")
+
+		if section.Name != nil {
+			sectionUpdates = append(sectionUpdates, bson.E{Key: "name", Value: section.Name})
+		}
+
+		if section.Room != nil {
+			sectionUpdates = append(sectionUpdates, bson.E{Key: "room", Value: section.Room})
+		}
+
+		if section.Server_id != nil {
+			sectionUpdates = append(sectionUpdates, bson.E{Key: "server_id", Value: section.Server_id})
+		}
+
+		if section.Sort_Order != nil {
+			sectionUpdates = append(sectionUpdates, bson.E{Key: "sort_order", Value: section.Sort_Order})
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		sectionUpdates = append(sectionUpdates, bson.E{Key: "updated_at", Value: updatedAt})
+
+		filter := bson.M{"section_id": sectionId, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: sectionUpdates}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+
+		updateResults, err := sectionCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "section"))
+			return
+		}
+
+		if updateResults.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, sectionCollection, bson.M{"section_id": sectionId}, "section")
+			return
+		}
+
+		helpers.SetETag(c, version+1)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Update Successful",
+			"results": updateResults,
+		})
+	}
+}
+
+// DeleteSection refuses to delete a section that still has tables, so no
+// table is left pointing at a section that is gone.
+func DeleteSection() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		sectionId := c.Param("section_id")
+
+		tables, err := tableCollection.CountDocuments(ctx, bson.M{"section_id": sectionId})
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		if tables > 0 {
+			apperrors.Respond(c, apperrors.New(apperrors.Conflict, "Move the tables out of the section before deleting it"))
+			return
+		}
+
+		deleteResults, err := sectionCollection.DeleteOne(ctx, bson.M{"section_id": sectionId, "version": helpers.VersionFilter(version)})
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "section"))
+			return
+		}
+
+		if deleteResults.DeletedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, sectionCollection, bson.M{"section_id": sectionId}, "section")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Deletion Successful",
+			"results": deleteResults,
+		})
+	}
+}
+
+func checkServerReference(ctx context.Context, c *gin.Context, serverId *string) bool {
+	if serverId == nil {
+		return true
+	}
+
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": *serverId}).Err(); err != nil {
+		apperrors.Respond(c, apperrors.Reference(err, "server_id", "user"))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+func GetStations() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		stations, err := kitchen.Stations(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station"))
+			return
+		}
+
+		c.JSON(http.StatusOK, stations)
+	}
+}
+
+func GetStation() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		station, err := kitchen.FindStation(ctx, c.Param("station_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station"))
+			return
+		}
+
+		helpers.SetETag(c, station.Version)
+		c.JSON(http.StatusOK, station)
+	}
+}
+
+func CreateStation() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var station models.Station
+
+		if err := c.ShouldBindJSON(&station); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(station); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		station, err := kitchen.CreateStation(ctx, station, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station"))
+			return
+		}
+
+		helpers.SetETag(c, station.Version)
+		c.JSON(http.StatusCreated, station)
+	}
+}
+
+func UpdateStation() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		var change services.StationUpdate
+
+		if err := c.ShouldBindJSON(&change); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(change); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		station, err := kitchen.UpdateStation(ctx, c.Param("station_id"), version, change, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station"))
+			return
+		}
+
+		helpers.SetETag(c, station.Version)
+		c.JSON(http.StatusOK, station)
+	}
+}
+
+// DeleteStation removes a station once no routing rule sends items to it and
+// its queue is clear.
+func DeleteStation() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		if err := kitchen.DeleteStation(ctx, c.Param("station_id"), version); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Deletion Successful"})
+	}
+}
+
+// GetStationQueue lists what a station's screen shows, or its items in one
+// status.
+func GetStationQueue() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var filter services.StationQueueFilter
+
+		if err := c.ShouldBindQuery(&filter); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(filter); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		items, err := kitchen.Queue(ctx, c.Param("station_id"), filter)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station"))
+			return
+		}
+
+		c.JSON(http.StatusOK, items)
+	}
+}
+
+func StartStationItem() gin.HandlerFunc{
+	return moveStationItem((*services.Kitchen).Start)
+}
+
+func ReadyStationItem() gin.HandlerFunc{
+	return moveStationItem((*services.Kitchen).Ready)
+}
+
+func BumpStationItem() gin.HandlerFunc{
+	return moveStationItem((*services.Kitchen).Bump)
+}
+
+func RecallStationItem() gin.HandlerFunc{
+	return moveStationItem((*services.Kitchen).Recall)
+}
+
+// moveStationItem builds the handler for one of the actions that move an
+// item along a station's queue.
+func moveStationItem(move func(k *services.Kitchen, ctx context.Context, stationId string, orderItemId string, by *models.Actor) (models.StationItem, error)) gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		item, err := move(kitchen, ctx, c.Param("station_id"), c.Param("order_item_id"), helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station item"))
+			return
+		}
+
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+func GetTicketTimes() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.TicketTimesRequest
+
+		if err := c.ShouldBindQuery(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		times, err := kitchen.TicketTimes(ctx, c.Param("station_id"), req)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "station"))
+			return
+		}
+
+		c.JSON(http.StatusOK, times)
+	}
+}
+
+func GetRoutingRules() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		rules, err := kitchen.Rules(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "routing rule"))
+			return
+		}
+
+		c.JSON(http.StatusOK, rules)
+	}
+}
+
+// CreateRoutingRule sends the items of a food, or of the foods on menus of a
+// category, to a station. A rule with neither takes every other item.
+func CreateRoutingRule() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var rule models.RoutingRule
+
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(rule); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		rule, err := kitchen.CreateRule(ctx, rule, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "routing rule"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, rule)
+	}
+}
+
+func DeleteRoutingRule() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		if err := kitchen.DeleteRule(ctx, c.Param("rule_id")); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "routing rule"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Deletion Successful"})
+	}
+}
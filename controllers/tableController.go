@@ -6,34 +6,29 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
 	"github.com/teandresmith/restaurant-project/helpers"
 	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func GetTables() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
-		results, err := tableCollection.Find(context.TODO(), bson.M{})
+		results, err := tableCollection.Find(ctx, bson.M{})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the table collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
 			return
 		}
 
 		var allTables []bson.M
 
 		if err := results.All(ctx, &allTables); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while iterating through all table results",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
 			return
 		}
 		defer cancel()
@@ -45,7 +40,7 @@ func GetTables() gin.HandlerFunc{
 
 func GetTable() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		tableId := c.Param("table_id")
 		var table models.Table
@@ -53,13 +48,11 @@ func GetTable() gin.HandlerFunc{
 		err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the table collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
 			return
 		}
 
+		helpers.SetETag(c, table.Version)
 		c.JSON(http.StatusOK, table)
 
 	}
@@ -67,25 +60,24 @@ func GetTable() gin.HandlerFunc{
 
 func CreateTable() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var table models.Table
 
-		if err := c.BindJSON(&table); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": err.Error(),
-			})
+		if err := c.ShouldBindJSON(&table); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
 			defer cancel()
 			return
 		}
 
 
 		if err := validate.Struct(table); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There wasn an error while validating the request body data",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.Validation(err))
+			defer cancel()
+			return
+		}
+
+		if ok := checkTableReferences(ctx, c, table); !ok {
 			defer cancel()
 			return
 		}
@@ -94,18 +86,20 @@ func CreateTable() gin.HandlerFunc{
 		table.Table_id = table.ID.Hex()
 		table.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		table.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		
+		table.Status_Changed_At = &table.Created_At
+		table.Version = 1
 
-		results, insertErr := tableCollection.InsertOne(ctx, bson.M{"table_id": table.Table_id})
+		tableStatus := services.TableStatusAvailable
+		table.Table_status = &tableStatus
+
+		results, insertErr := tableCollection.InsertOne(ctx, table)
 		defer cancel()
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while inserting an object in the table collection",
-				"error": insertErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(insertErr, "table"))
 			return
 		}
 
+		helpers.SetETag(c, table.Version)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Insertion Successful",
 			"results": results,
@@ -115,15 +109,23 @@ func CreateTable() gin.HandlerFunc{
 
 func UpdateTable() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var table models.Table
 
-		if err := c.BindJSON(&table); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": err.Error(),
-			})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		if err := c.ShouldBindJSON(&table); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			defer cancel()
+			return
+		}
+
+		if ok := checkTableReferences(ctx, c, table); !ok {
 			defer cancel()
 			return
 		}
@@ -131,7 +133,6 @@ func UpdateTable() gin.HandlerFunc{
 		var tableUpdates primitive.D
 		tableId := c.Param("table_id")
 
-
 		if table.Number_of_guests != nil {
 			tableUpdates = append(tableUpdates, bson.E{Key: "number_of_guests", Value: table.Number_of_guests})
 		}
@@ -140,24 +141,47 @@ func UpdateTable() gin.HandlerFunc{
 			tableUpdates = append(tableUpdates, bson.E{Key: "table_number", Value: table.Table_number})
 		}
 
+		if table.Section_id != nil {
+			tableUpdates = append(tableUpdates, bson.E{Key: "section_id", Value: table.Section_id})
+		}
+
+		if table.Position != nil {
+			tableUpdates = append(tableUpdates, bson.E{Key: "position", Value: table.Position})
+		}
+
+		if table.Shape != nil {
+			tableUpdates = append(tableUpdates, bson.E{Key: "shape", Value: table.Shape})
+		}
+
+		if table.Seats != nil {
+			tableUpdates = append(tableUpdates, bson.E{Key: "seats", Value: table.Seats})
+		}
+
+		if table.Server_id != nil {
+			tableUpdates = append(tableUpdates, bson.E{Key: "server_id", Value: table.Server_id})
+		}
+
 		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		tableUpdates = append(tableUpdates, bson.E{Key: "updated_at", Value: updatedAt})
 
-		filter := bson.M{"table_id": tableId}
-		opt := options.Update().SetUpsert(true)
-		update := bson.D{{Key: "$set", Value: tableUpdates}}
+		filter := bson.M{"table_id": tableId, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: tableUpdates}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
 
 		
-		updateResults, updateErr := tableCollection.UpdateOne(ctx, filter, update, opt)
+		updateResults, updateErr := tableCollection.UpdateOne(ctx, filter, update)
 		defer cancel()
 		if updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while updating an object in the table collection",
-				"error": updateErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(updateErr, "table"))
 			return
 		}
 
+		if updateResults.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, tableCollection, bson.M{"table_id": tableId}, "table")
+			return
+		}
+
+		helpers.SetETag(c, version+1)
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Update Successful",
 			"results": updateResults,
@@ -167,7 +191,7 @@ func UpdateTable() gin.HandlerFunc{
 
 func DeleteTable() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		if admin := helpers.IsAdmin(c); !admin {
 			defer cancel()
@@ -176,13 +200,22 @@ func DeleteTable() gin.HandlerFunc{
 
 		tableId := c.Param("table_id")
 
-		deleteResults, err := tableCollection.DeleteOne(ctx, bson.M{"table_id": tableId})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		deleteResults, err := tableCollection.DeleteOne(ctx, bson.M{"table_id": tableId, "version": helpers.VersionFilter(version)})
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an issue while deleting an object in the table collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		if deleteResults.DeletedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, tableCollection, bson.M{"table_id": tableId}, "table")
+			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -190,4 +223,61 @@ func DeleteTable() gin.HandlerFunc{
 			"results": deleteResults,
 		})
 	}
-}
\ No newline at end of file
+}
+
+// TableStatusRequest is the body of PATCH /tables/:table_id/status.
+type TableStatusRequest struct{
+	Status			*string			`json:"status" validate:"required,eq=AVAILABLE|eq=SEATED|eq=ORDERING|eq=AWAITING_PAYMENT|eq=DIRTY|eq=RESERVED"`
+}
+
+// UpdateTableStatus lets hosts seat guests, mark tables bussed or reserved,
+// and correct the board when it is out of step with the room.
+func UpdateTableStatus() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req TableStatusRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		tableId := c.Param("table_id")
+
+		if _, err := tableBoard.SetStatus(ctx, tableId, *req.Status, "manual"); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		// A missing table is left alone by SetStatus and reported here.
+		var table models.Table
+		if err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		helpers.SetETag(c, table.Version)
+		c.JSON(http.StatusOK, table)
+	}
+}
+
+// checkTableReferences makes sure the section and server a table is assigned
+// to exist.
+func checkTableReferences(ctx context.Context, c *gin.Context, table models.Table) bool {
+	if table.Section_id != nil {
+		err := sectionCollection.FindOne(ctx, bson.M{"section_id": *table.Section_id}).Err()
+		if err != nil {
+			apperrors.Respond(c, apperrors.Reference(err, "section_id", "section"))
+			return false
+		}
+	}
+
+	return checkServerReference(ctx, c, table.Server_id)
+}
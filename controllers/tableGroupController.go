@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+// MergeTables pushes tables together into a group for a large party.
+func MergeTables() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.MergeTablesRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		req.Actor = helpers.ActorFrom(c)
+
+		group, err := tableBoard.Merge(ctx, req)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, group)
+	}
+}
+
+func GetTableGroups() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		groups, err := tableBoard.ActiveGroups(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table group"))
+			return
+		}
+
+		c.JSON(http.StatusOK, groups)
+	}
+}
+
+func GetTableGroup() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		group, err := tableBoard.Group(ctx, c.Param("group_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table group"))
+			return
+		}
+
+		c.JSON(http.StatusOK, group)
+	}
+}
+
+// SplitTables breaks a group back up into its tables. The body, which only
+// carries a reason for the audit trail, is optional.
+func SplitTables() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.SplitTablesRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		req.Group_id = c.Param("group_id")
+		req.Actor = helpers.ActorFrom(c)
+
+		group, err := tableBoard.Split(ctx, req)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table group"))
+			return
+		}
+
+		c.JSON(http.StatusOK, group)
+	}
+}
+
+// GetTableAudit lists the merges, splits and order moves a table was part of.
+func GetTableAudit() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		entries, err := tableBoard.Audit(ctx, c.Param("table_id"), "")
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "table audit"))
+			return
+		}
+
+		c.JSON(http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,417 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TerminalRegistration struct {
+	Terminal     models.Terminal `json:"terminal"`
+	Device_Token string          `json:"device_token"`
+}
+
+type PinLoginRequest struct {
+	User_id string `json:"user_id" validate:"required"`
+	Pin     string `json:"pin" validate:"required,numeric,min=4,max=6"`
+}
+
+type SetPinRequest struct {
+	Pin string `json:"pin" validate:"required,numeric,min=4,max=6"`
+}
+
+type PinSession struct {
+	Token        string     `json:"token"`
+	Expires_At   time.Time  `json:"expires_at"`
+	Idle_Timeout int        `json:"idle_timeout"`
+	User         StaffEntry `json:"user"`
+}
+
+// StaffEntry is what a terminal shows on its sign in screen.
+type StaffEntry struct {
+	User_id    string  `json:"user_id"`
+	First_Name *string `json:"first_name"`
+	Last_Name  *string `json:"last_name"`
+}
+
+var invalidPin = apperrors.New(apperrors.Unauthorized, "Invalid PIN")
+
+var pinLoginNotAllowed = apperrors.New(apperrors.Forbidden, "Admins and users with two-factor authentication sign in with their password, not a PIN")
+
+// RegisterTerminal registers a shared device and returns its device token.
+// The token is only shown here; a lost token means registering again.
+func RegisterTerminal() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var terminal models.Terminal
+
+		if err := c.ShouldBindJSON(&terminal); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(terminal); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while generating a device token", err))
+			return
+		}
+		deviceToken := base64.RawURLEncoding.EncodeToString(raw)
+
+		terminal.ID = primitive.NewObjectID()
+		terminal.Terminal_id = terminal.ID.Hex()
+		terminal.Device_Token_Hash = helpers.HashDeviceToken(deviceToken)
+		terminal.Registered_By = c.GetString("uid")
+		terminal.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		terminal.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		terminal.Version = 1
+
+		if _, err := terminalCollection.InsertOne(ctx, terminal); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, TerminalRegistration{Terminal: terminal, Device_Token: deviceToken})
+	}
+}
+
+func GetTerminals() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		results, err := terminalCollection.Find(ctx, bson.M{})
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+			return
+		}
+
+		allTerminals := []models.Terminal{}
+
+		if err := results.All(ctx, &allTerminals); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+			return
+		}
+
+		c.JSON(http.StatusOK, allTerminals)
+	}
+}
+
+// RevokeTerminal stops a terminal's device token from working and ends its
+// PIN session. The terminal is kept so past actions still resolve.
+func RevokeTerminal() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		update := bson.D{
+			{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: now}, {Key: "updated_at", Value: now}}},
+			{Key: "$unset", Value: endedSession()},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+
+		result, err := terminalCollection.UpdateOne(ctx, bson.M{"terminal_id": c.Param("terminal_id"), "revoked_at": nil}, update)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			apperrors.Respond(c, apperrors.New(apperrors.NotFound, "Terminal with that terminal_id not found or already revoked"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Terminal revoked",
+		})
+	}
+}
+
+// TerminalStaff lists the staff who can sign in to the calling terminal with
+// a PIN.
+func TerminalStaff() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if _, ok := callingTerminal(c, ctx); !ok {
+			return
+		}
+
+		projection := bson.M{"user_id": 1, "first_name": 1, "last_name": 1, "user_type": 1, "mfa_enabled": 1}
+		opts := options.Find().SetProjection(projection).SetSort(bson.D{{Key: "first_name", Value: 1}, {Key: "last_name", Value: 1}})
+
+		results, err := userCollection.Find(ctx, bson.M{"pin_hash": bson.M{"$ne": nil}}, opts)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		var users []models.User
+
+		if err := results.All(ctx, &users); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		staff := []StaffEntry{}
+		for _, user := range users {
+			if user.User_Type != nil && helpers.PinLoginAllowed(*user.User_Type, user.Mfa_Enabled) {
+				staff = append(staff, StaffEntry{User_id: user.User_id, First_Name: user.First_Name, Last_Name: user.Last_Name})
+			}
+		}
+
+		c.JSON(http.StatusOK, staff)
+	}
+}
+
+// PinLogin signs a staff member in to the calling terminal with their PIN.
+// Whoever was signed in there before is signed out.
+func PinLogin() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		terminal, ok := callingTerminal(c, ctx)
+		if !ok {
+			return
+		}
+
+		var req PinLoginRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		// PINs are short, so guesses are counted per user and locked out
+		// sooner than passwords.
+		guardKey := pinGuardKey(req.User_id)
+
+		if err := pinGuard.Attempt(ctx, guardKey); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "login attempt"))
+			return
+		}
+
+		var user models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": req.User_id}).Decode(&user)
+		if err == nil && (user.User_Type == nil || !helpers.PinLoginAllowed(*user.User_Type, user.Mfa_Enabled)) {
+			apperrors.Respond(c, pinLoginNotAllowed)
+			return
+		}
+		if err != nil || user.Pin_Hash == nil || VerifyPassword(*user.Pin_Hash, req.Pin) != nil {
+			apperrors.Respond(c, invalidPin)
+			return
+		}
+
+		if err := pinGuard.RecordSuccess(ctx, guardKey); err != nil {
+			logging.FromContext(ctx).Warn("could not clear failed PIN logins", "error", err)
+		}
+
+		sessionId := primitive.NewObjectID().Hex()
+		now := time.Now()
+		expiresAt := now.Add(helpers.PinSessionTTL)
+
+		token, err := helpers.GeneratePinToken(*user.First_Name, *user.Last_Name, *user.User_Type, *user.Email, user.User_id, terminal.Terminal_id, sessionId, now)
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an error while generating a token", err))
+			return
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "active_user_id", Value: user.User_id},
+			{Key: "session_id", Value: sessionId},
+			{Key: "session_started_at", Value: now},
+			{Key: "session_expires_at", Value: expiresAt},
+			{Key: "last_active_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}}}
+
+		if _, err := terminalCollection.UpdateOne(ctx, bson.M{"terminal_id": terminal.Terminal_id}, update); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+			return
+		}
+
+		c.JSON(http.StatusOK, PinSession{
+			Token:        token,
+			Expires_At:   expiresAt,
+			Idle_Timeout: int(helpers.PinIdleTimeout / time.Second),
+			User:         StaffEntry{User_id: user.User_id, First_Name: user.First_Name, Last_Name: user.Last_Name},
+		})
+	}
+}
+
+// LockTerminal ends the calling terminal's PIN session, e.g. when a waiter
+// walks away. It needs only the device token, so it works even after the
+// session has locked on its own.
+func LockTerminal() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		terminal, ok := callingTerminal(c, ctx)
+		if !ok {
+			return
+		}
+
+		update := bson.D{
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+			{Key: "$unset", Value: endedSession()},
+		}
+
+		if _, err := terminalCollection.UpdateOne(ctx, bson.M{"terminal_id": terminal.Terminal_id}, update); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Terminal locked",
+		})
+	}
+}
+
+// SetPin sets the PIN a user signs in to terminals with. Users set their own;
+// admins can set anyone's but their own and other admins', who can't sign in
+// with a PIN.
+func SetPin() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		userID := c.Param("user_id")
+
+		if c.GetString("uid") != userID && c.GetString("user_type") != "ADMIN" {
+			apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "User not authorized."))
+			return
+		}
+
+		var req SetPinRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		var user models.User
+		opts := options.FindOne().SetProjection(bson.M{"user_type": 1, "mfa_enabled": 1})
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}, opts).Decode(&user); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if user.User_Type == nil || !helpers.PinLoginAllowed(*user.User_Type, user.Mfa_Enabled) {
+			apperrors.Respond(c, pinLoginNotAllowed)
+			return
+		}
+
+		pinHash, err := HashPassword(req.Pin)
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an issue while hashing the PIN", err))
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		update := bson.D{
+			{Key: "$set", Value: bson.D{{Key: "pin_hash", Value: pinHash}, {Key: "updated_at", Value: updatedAt}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		}
+
+		result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			apperrors.Respond(c, apperrors.New(apperrors.NotFound, "User with that user_id not found"))
+			return
+		}
+
+		if err := pinGuard.Unlock(ctx, pinGuardKey(userID)); err != nil {
+			logging.FromContext(ctx).Warn("could not clear failed PIN logins", "error", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "PIN set",
+		})
+	}
+}
+
+// callingTerminal finds the registered, unrevoked terminal whose device token
+// the request carries.
+func callingTerminal(c *gin.Context, ctx context.Context) (models.Terminal, bool) {
+	var terminal models.Terminal
+
+	deviceToken := c.GetHeader(helpers.DeviceTokenHeader)
+	if deviceToken == "" {
+		apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "No "+helpers.DeviceTokenHeader+" header provided"))
+		return terminal, false
+	}
+
+	filter := bson.M{"device_token_hash": helpers.HashDeviceToken(deviceToken), "revoked_at": nil}
+	if err := terminalCollection.FindOne(ctx, filter).Decode(&terminal); err != nil {
+		if err == mongo.ErrNoDocuments {
+			apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "Unknown or revoked terminal"))
+			return terminal, false
+		}
+		apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+		return terminal, false
+	}
+
+	return terminal, true
+}
+
+func endedSession() bson.D {
+	return bson.D{
+		{Key: "active_user_id", Value: ""},
+		{Key: "session_id", Value: ""},
+		{Key: "session_started_at", Value: ""},
+		{Key: "session_expires_at", Value: ""},
+		{Key: "last_active_at", Value: ""},
+	}
+}
+
+func pinGuardKey(userId string) string {
+	return "pin:" + userId
+}
@@ -6,32 +6,36 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
 	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/logging"
 	"github.com/teandresmith/restaurant-project/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 
 
+// hiddenUserFields leaves out what the User model's json tags hide, for
+// GetUsers, which returns the documents as they are stored.
+var hiddenUserFields = bson.M{"mfa_secret": 0, "mfa_pending_secret": 0, "mfa_last_step": 0, "mfa_recovery_codes": 0, "pin_hash": 0}
+
 func GetUsers() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		if admin := helpers.IsAdmin(c); !admin {
 			defer cancel()
 			return
 		}
 
-		results, err := userCollection.Find(context.TODO(), bson.M{})
+		results, err := userCollection.Find(ctx, bson.M{}, options.Find().SetProjection(hiddenUserFields))
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while querying the user collection",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
 			return
 		}
 		
@@ -39,10 +43,7 @@ func GetUsers() gin.HandlerFunc{
 		var allUsers []bson.M
 
 		if err := results.All(ctx, &allUsers); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error during the iteration of all users",
-				"error": err.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
 			return
 		}
 		defer cancel()
@@ -54,7 +55,7 @@ func GetUsers() gin.HandlerFunc{
 
 func GetUser() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		var user models.User
 		userId := c.Param("user_id")
@@ -62,10 +63,11 @@ func GetUser() gin.HandlerFunc{
 		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user); 
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
 			return
 		}
 
+		helpers.SetETag(c, user.Version)
 		c.JSON(http.StatusOK, user)
 
 	}
@@ -75,14 +77,12 @@ func GetUser() gin.HandlerFunc{
 
 func EditUser() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 
 		userType, err := c.Get("uid")
 		if !err {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "User_type key value was not sent. Cannot determined privileges.",
-			})
+			apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "User_type key value was not sent. Cannot determined privileges."))
 			defer cancel()
 			return
 		}
@@ -90,20 +90,21 @@ func EditUser() gin.HandlerFunc{
 		userID := c.Param("user_id")
 
 		if userType != userID {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "User not authorized.",
-			})
+			apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "User not authorized."))
+			defer cancel()
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
 			defer cancel()
 			return
 		}
 
 		var userEdits models.User
 
-		if bindErr := c.BindJSON(&userEdits); bindErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "There was an error while binding the request body data",
-				"error": bindErr.Error(),
-			})
+		if bindErr := c.ShouldBindJSON(&userEdits); bindErr != nil {
+			apperrors.Respond(c, apperrors.Validation(bindErr))
 			defer cancel()
 			return
 		}
@@ -111,9 +112,7 @@ func EditUser() gin.HandlerFunc{
 		var userUpdateObject primitive.D
 
 		if userEdits.User_Type != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "User not authorized to change roles",
-			})
+			apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "User not authorized to change roles"))
 			defer cancel()
 			return
 		}
@@ -129,18 +128,30 @@ func EditUser() gin.HandlerFunc{
 		if userEdits.Password != nil {
 			newPassword, hashErr := HashPassword(*userEdits.Password)
 			if hashErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"message": "There was an issue while hashing the new password",
-					"error": hashErr.Error(),
-				})
+				apperrors.Respond(c, apperrors.Wrap(apperrors.Internal, "There was an issue while hashing the new password", hashErr))
 				defer cancel()
 				return
 			}
-			userUpdateObject = append(userUpdateObject, bson.E{Key: "Password", Value: newPassword})
+			userUpdateObject = append(userUpdateObject, bson.E{Key: "password", Value: newPassword})
 		}
 
+		// A new address has to be verified again.
 		if userEdits.Email != nil {
+			count, err := userCollection.CountDocuments(ctx, bson.M{"email": userEdits.Email, "user_id": bson.M{"$ne": userID}})
+			if err != nil {
+				apperrors.Respond(c, apperrors.FromDB(err, "user"))
+				defer cancel()
+				return
+			}
+			if count > 0 {
+				apperrors.Respond(c, errEmailTaken)
+				defer cancel()
+				return
+			}
+
 			userUpdateObject = append(userUpdateObject, bson.E{Key: "email", Value: userEdits.Email})
+			userUpdateObject = append(userUpdateObject, bson.E{Key: "email_verified", Value: false})
+			userUpdateObject = append(userUpdateObject, bson.E{Key: "email_verified_at", Value: nil})
 		}
 
 		if userEdits.Avatar != nil {
@@ -152,19 +163,33 @@ func EditUser() gin.HandlerFunc{
 		userUpdateObject = append(userUpdateObject, bson.E{Key: "updated_at", Value: updatedAt})
 
 
-		filter := bson.M{"user_id": userID}
-		opt := options.Update().SetUpsert(true)
-		update := bson.D{{Key: "$set", Value: userUpdateObject}}
+		filter := bson.M{"user_id": userID, "version": helpers.VersionFilter(version)}
+		update := bson.D{{Key: "$set", Value: userUpdateObject}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
 
-		results, updateErr := userCollection.UpdateOne(ctx, filter, update, opt)
+		results, updateErr := userCollection.UpdateOne(ctx, filter, update)
 		defer cancel()
+		if mongo.IsDuplicateKeyError(updateErr) {
+			apperrors.Respond(c, errEmailTaken)
+			return
+		}
 		if updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while updating an object in the user collection",
-				"error": updateErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(updateErr, "user"))
+			return
+		}
+
+		if results.MatchedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, userCollection, bson.M{"user_id": userID}, "user")
+			return
 		}
 
+		if userEdits.Email != nil {
+			user := models.User{User_id: userID, Email: userEdits.Email}
+			if err := sendVerificationEmail(ctx, user); err != nil {
+				logging.FromContext(ctx).Error("could not send verification email", "user_id", userID, "error", err)
+			}
+		}
+
+		helpers.SetETag(c, version+1)
 		c.JSON(http.StatusOK, results)
 		
 	}
@@ -172,7 +197,7 @@ func EditUser() gin.HandlerFunc{
 
 func DeleteUser() gin.HandlerFunc{
 	return func(c *gin.Context){
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 
 		if IsAdmin := helpers.IsAdmin(c); !IsAdmin {
 			defer cancel()
@@ -181,13 +206,21 @@ func DeleteUser() gin.HandlerFunc{
 
 		userID := c.Param("user_id")
 
-		result, deleteErr := userCollection.DeleteOne(ctx, bson.D{{Key: "user_id", Value: userID}})
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			defer cancel()
+			return
+		}
+
+		result, deleteErr := userCollection.DeleteOne(ctx, bson.D{{Key: "user_id", Value: userID}, {Key: "version", Value: helpers.VersionFilter(version)}})
 		defer cancel()
 		if deleteErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "There was an error while deleting an object in the user collection",
-				"error": deleteErr.Error(),
-			})
+			apperrors.Respond(c, apperrors.FromDB(deleteErr, "user"))
+			return
+		}
+
+		if result.DeletedCount == 0 {
+			helpers.RespondVersionMismatch(ctx, c, userCollection, bson.M{"user_id": userID}, "user")
 			return
 		}
 
@@ -198,6 +231,43 @@ func DeleteUser() gin.HandlerFunc{
 	}
 }
 
+// UnlockUser lifts a login lockout early and clears the user's failed login
+// history.
+func UnlockUser() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var user models.User
+		userID := c.Param("user_id")
+
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if user.Email != nil {
+			if err := loginGuard.Unlock(ctx, *user.Email); err != nil {
+				apperrors.Respond(c, apperrors.FromDB(err, "login attempt"))
+				return
+			}
+		}
+
+		if err := pinGuard.Unlock(ctx, pinGuardKey(userID)); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "login attempt"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User unlocked",
+		})
+	}
+}
+
 func HashPassword(password string) (string, error) {
 	passwordInBytes := []byte(password)
 	salt := 10
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// TestHiddenUserFields checks GetUsers leaves out every field the User model
+// keeps out of JSON, since it returns the stored documents.
+func TestHiddenUserFields(t *testing.T) {
+	userType := reflect.TypeOf(models.User{})
+
+	for i := 0; i < userType.NumField(); i++ {
+		field := userType.Field(i)
+		if field.Tag.Get("json") != "-" {
+			continue
+		}
+
+		// The driver stores fields without a bson tag under their
+		// lowercased name.
+		name := strings.ToLower(field.Name)
+		if _, ok := hiddenUserFields[name]; !ok {
+			t.Errorf("GetUsers returns %s, which the User model hides", name)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+type WaitQuoteRequest struct {
+	Party_size *int `form:"party_size" json:"party_size" validate:"required,min=1,max=100"`
+}
+
+// GetWaitlist returns the parties still waiting, in the order they'll be
+// seated, with their current estimated wait.
+func GetWaitlist() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		parties, err := waitlist.Active(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "waitlist"))
+			return
+		}
+
+		c.JSON(http.StatusOK, parties)
+	}
+}
+
+// QuoteWait estimates the wait for a party without putting it on the list,
+// for when guests ask before deciding to stay.
+func QuoteWait() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req WaitQuoteRequest
+
+		if err := c.ShouldBindQuery(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		quote, err := waitlist.Quote(ctx, *req.Party_size)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "waitlist"))
+			return
+		}
+
+		c.JSON(http.StatusOK, quote)
+	}
+}
+
+func AddToWaitlist() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var entry models.WaitlistEntry
+
+		if err := c.ShouldBindJSON(&entry); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(entry); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		entry.Created_By = helpers.ActorFrom(c)
+
+		entry, err := waitlist.Add(ctx, entry)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "waitlist"))
+			return
+		}
+
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusCreated, entry)
+	}
+}
+
+func GetWaitlistEntry() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		entry, err := waitlist.Find(ctx, c.Param("entry_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "waitlist entry"))
+			return
+		}
+
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// NotifyParty texts or emails the party that their table is ready.
+func NotifyParty() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		entry, err := waitlist.Notify(ctx, c.Param("entry_id"), helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "waitlist entry"))
+			return
+		}
+
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// SeatParty takes the party off the list, seating it at table_id when one is
+// given.
+func SeatParty() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.SeatWaitlistRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		entry, err := waitlist.Seat(ctx, c.Param("entry_id"), req.Table_id, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "waitlist entry"))
+			return
+		}
+
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// RemoveFromWaitlist takes off a party that cancelled, left or didn't show up
+// when notified. The entry is kept for the record.
+func RemoveFromWaitlist() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		var req services.RemoveFromWaitlistRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		entry, err := waitlist.Remove(ctx, c.Param("entry_id"), req.Reason, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "waitlist entry"))
+			return
+		}
+
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusOK, entry)
+	}
+}
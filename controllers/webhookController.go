@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+func GetWebhookSubscriptions() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		subscriptions, err := webhooks.ListSubscriptions(ctx)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook subscription"))
+			return
+		}
+
+		c.JSON(http.StatusOK, subscriptions)
+	}
+}
+
+func GetWebhookSubscription() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		subscription, err := webhooks.FindSubscription(ctx, c.Param("subscription_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook subscription"))
+			return
+		}
+
+		helpers.SetETag(c, subscription.Version)
+		c.JSON(http.StatusOK, subscription)
+	}
+}
+
+// CreateWebhookSubscription starts sending events to a URL. The secret the
+// deliveries are signed with is chosen by the subscriber and never sent back.
+func CreateWebhookSubscription() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var req services.WebhookSubscriptionRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		subscription, err := webhooks.CreateSubscription(ctx, req, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook subscription"))
+			return
+		}
+
+		helpers.SetETag(c, subscription.Version)
+		c.JSON(http.StatusCreated, subscription)
+	}
+}
+
+func UpdateWebhookSubscription() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		var change services.WebhookSubscriptionUpdate
+
+		if err := c.ShouldBindJSON(&change); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(change); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		subscription, err := webhooks.UpdateSubscription(ctx, c.Param("subscription_id"), version, change, helpers.ActorFrom(c))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook subscription"))
+			return
+		}
+
+		helpers.SetETag(c, subscription.Version)
+		c.JSON(http.StatusOK, subscription)
+	}
+}
+
+func DeleteWebhookSubscription() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		version, ok := helpers.RequireIfMatch(c)
+		if !ok {
+			return
+		}
+
+		if err := webhooks.DeleteSubscription(ctx, c.Param("subscription_id"), version); err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook subscription"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Deletion Successful"})
+	}
+}
+
+// GetWebhookDeliveries lists the newest deliveries. Filtering by status=DEAD
+// gives the dead-letter list.
+func GetWebhookDeliveries() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		var filter services.WebhookDeliveryFilter
+
+		if err := c.ShouldBindQuery(&filter); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		if err := validate.Struct(filter); err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+
+		deliveries, err := webhooks.ListDeliveries(ctx, filter)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook delivery"))
+			return
+		}
+
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+func GetWebhookDelivery() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		delivery, err := webhooks.FindDelivery(ctx, c.Param("delivery_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook delivery"))
+			return
+		}
+
+		c.JSON(http.StatusOK, delivery)
+	}
+}
+
+// RedeliverWebhook queues a dead-lettered or delivered event to be sent to
+// its subscription again.
+func RedeliverWebhook() gin.HandlerFunc{
+	return func(c *gin.Context){
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+		defer cancel()
+
+		if admin := helpers.IsAdmin(c); !admin {
+			return
+		}
+
+		delivery, err := webhooks.Redeliver(ctx, c.Param("delivery_id"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "webhook delivery"))
+			return
+		}
+
+		c.JSON(http.StatusAccepted, delivery)
+	}
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+)
+
+// TestWebhookSubscriptionETags checks the version a subscription is at is
+// sent as its ETag, and that writes need an If-Match with the current one.
+// Subscriptions are kept in memory, so the whole exchange runs without a
+// database.
+func TestWebhookSubscriptionETags(t *testing.T) {
+	previous := webhooks
+	webhooks = services.NewWebhooks(services.NewMemoryWebhookStore())
+	t.Cleanup(func() { webhooks = previous })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_type", "ADMIN") })
+	router.POST("/webhooks", CreateWebhookSubscription())
+	router.GET("/webhooks/:subscription_id", GetWebhookSubscription())
+	router.PATCH("/webhooks/:subscription_id", UpdateWebhookSubscription())
+	router.DELETE("/webhooks/:subscription_id", DeleteWebhookSubscription())
+
+	send := func(method string, path string, ifMatch string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	created := send(http.MethodPost, "/webhooks", "", `{"url":"https://example.com/hook","secret":"0123456789abcdef","event_types":["order.created"]}`)
+	if created.Code != http.StatusCreated || created.Header().Get("ETag") != `"1"` {
+		t.Fatalf("create: %d with ETag %q, want %d with \"1\"", created.Code, created.Header().Get("ETag"), http.StatusCreated)
+	}
+	var subscription models.WebhookSubscription
+	if err := json.Unmarshal(created.Body.Bytes(), &subscription); err != nil {
+		t.Fatal(err)
+	}
+	path := "/webhooks/" + subscription.Subscription_id
+
+	if w := send(http.MethodGet, path, "", ""); w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("get: %d with ETag %q, want %d with \"1\"", w.Code, w.Header().Get("ETag"), http.StatusOK)
+	}
+
+	change := `{"active":false}`
+	tests := []struct {
+		name    string
+		method  string
+		ifMatch string
+		status  int
+		etag    string
+	}{
+		{"update without If-Match", http.MethodPatch, "", http.StatusPreconditionRequired, ""},
+		{"update with a malformed If-Match", http.MethodPatch, "v1", http.StatusPreconditionFailed, ""},
+		{"update", http.MethodPatch, `"1"`, http.StatusOK, `"2"`},
+		{"update with a stale If-Match", http.MethodPatch, `"1"`, http.StatusPreconditionFailed, ""},
+		{"update with a weak ETag", http.MethodPatch, `W/"2"`, http.StatusOK, `"3"`},
+		{"delete without If-Match", http.MethodDelete, "", http.StatusPreconditionRequired, ""},
+		{"delete with a stale If-Match", http.MethodDelete, `"2"`, http.StatusPreconditionFailed, ""},
+		{"delete", http.MethodDelete, `"3"`, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		w := send(test.method, path, test.ifMatch, change)
+		if w.Code != test.status || w.Header().Get("ETag") != test.etag {
+			t.Errorf("%s: %d with ETag %q, want %d with %q (%s)", test.name, w.Code, w.Header().Get("ETag"), test.status, test.etag, w.Body)
+		}
+	}
+}
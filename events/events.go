@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Type names a domain event. The names are the ones external consumers see,
+// so they should not be changed once published.
+type Type string
+
+const (
+	OrderCreated       Type = "order.created"
+	OrderItemFired     Type = "order_item.fired"
+	InvoiceCreated     Type = "invoice.created"
+	InvoicePaid        Type = "invoice.paid"
+	InvoiceRefunded    Type = "invoice.refunded"
+	TableStatusChanged Type = "table.status_changed"
+	MenuUpdated        Type = "menu.updated"
+)
+
+// Event is something that happened to a restaurant object. Subject is the id
+// of the object and Data is the object itself after the change. Id is the
+// same wherever the event is delivered, so consumers can drop repeats.
+type Event struct {
+	Id          string
+	Type        Type
+	Subject     string
+	Occurred_At time.Time
+	Data        interface{}
+}
+
+// Handler consumes events. Handlers run synchronously on the publishing
+// goroutine and must not block.
+type Handler func(ctx context.Context, event Event)
+
+// Bus delivers each published event to every subscribed handler.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+	claimed  map[Type]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Claim hands the event types over to a source outside the handlers, such as
+// a MongoDB change stream, which publishes them with PublishFromSource.
+// Publish drops them from then on so they aren't delivered twice.
+func (b *Bus) Claim(types ...Type) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.claimed == nil {
+		b.claimed = map[Type]bool{}
+	}
+	for _, t := range types {
+		b.claimed[t] = true
+	}
+}
+
+// Publish delivers event to the subscribers. It should only be called once the
+// change the event describes has been committed.
+func (b *Bus) Publish(ctx context.Context, event Event) {
+	b.mu.RLock()
+	claimed := b.claimed[event.Type]
+	b.mu.RUnlock()
+
+	if !claimed {
+		b.deliver(ctx, event)
+	}
+}
+
+// PublishFromSource delivers an event of a type the source has claimed.
+func (b *Bus) PublishFromSource(ctx context.Context, event Event) {
+	b.deliver(ctx, event)
+}
+
+func (b *Bus) deliver(ctx context.Context, event Event) {
+	if event.Id == "" {
+		event.Id = newId()
+	}
+	if event.Occurred_At.IsZero() {
+		event.Occurred_At = time.Now()
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(ctx, event)
+	}
+}
+
+func newId() string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Default is the bus the application publishes on.
+var Default = NewBus()
+
+func Publish(ctx context.Context, event Event) {
+	Default.Publish(ctx, event)
+}
+
+func Subscribe(handler Handler) {
+	Default.Subscribe(handler)
+}
@@ -0,0 +1,382 @@
+// Package eventsource reads domain events from a MongoDB change stream
+// instead of from the requests that made the changes. Orders, order items,
+// invoices, table statuses and menus written by any instance, or straight to
+// the database, are recorded in the webhook outbox and, when asked for,
+// published on the event bus, and events a crashed instance never handled
+// are picked up where the stream left off.
+//
+// One instance at a time tails the stream, holding a lease on the document
+// its resume token is kept in. When the stream publishes on the bus, every
+// instance hands the event types over to it, so only the leader delivers
+// them. The token is saved every few seconds, and never past an event that
+// could not be recorded, so a new leader may handle the last few events
+// again; they keep their ids, which the outbox and consumers drop repeats
+// by.
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/events"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChangeStreamSource is the EVENT_SOURCE value that publishes events on the
+// bus from the change stream. Anything else keeps publishing them in process,
+// and the stream only records them.
+const ChangeStreamSource = "changestream"
+
+// Types are the events the change stream publishes.
+var Types = []events.Type{
+	events.OrderCreated,
+	events.OrderItemFired,
+	events.InvoiceCreated,
+	events.InvoicePaid,
+	events.InvoiceRefunded,
+	events.TableStatusChanged,
+	events.MenuUpdated,
+}
+
+// collections are the collections watched for the changes behind Types.
+var collections = bson.A{"order", "orderitems", "invoice", "table", "menu", "food"}
+
+const (
+	// streamId is the _id of the document the resume token and lease are
+	// kept in.
+	streamId = "domain-events"
+
+	leaseTime  = 30 * time.Second
+	renewEvery = 5 * time.Second
+	maxAwait   = time.Second
+	minRetry   = time.Second
+	maxRetry   = 30 * time.Second
+
+	// errChangeStreamHistoryLost is the server error for a resume token
+	// older than the oplog.
+	errChangeStreamHistoryLost = 286
+)
+
+var (
+	errNotLeader  = errors.New("eventsource: another instance holds the lease")
+	errLeaseLost  = errors.New("eventsource: lease was taken over")
+	errNotStarted = errors.New("eventsource: the change stream has not started")
+	errNoLeader   = errors.New("eventsource: no instance holds the lease")
+)
+
+// Enabled reports whether EVENT_SOURCE asks for events to be published from
+// the change stream.
+func Enabled() bool {
+	return os.Getenv("EVENT_SOURCE") == ChangeStreamSource
+}
+
+// Recorder keeps an event where it can't be lost, such as the webhook
+// outbox. It must not record an event with the same id twice.
+type Recorder func(ctx context.Context, event events.Event) error
+
+// ChangeStream tails the watched collections, records what changes in them
+// and, if it has a bus, publishes it there.
+type ChangeStream struct {
+	db       *mongo.Database
+	tokens   *mongo.Collection
+	record   Recorder
+	bus      *events.Bus
+	instance string
+
+	mu       sync.Mutex
+	started  bool
+	startErr error
+	// leaseUntil is when this instance's lease runs out, zero unless it
+	// leads.
+	leaseUntil time.Time
+}
+
+// NewChangeStream returns a stream that records each event with record
+// before publishing it on bus. bus may be nil to leave publishing in process.
+func NewChangeStream(client *mongo.Client, record Recorder, bus *events.Bus) *ChangeStream {
+	tokens := database.OpenCollection(client, "event_source_tokens")
+	hostname, _ := os.Hostname()
+
+	return &ChangeStream{
+		db:       tokens.Database(),
+		tokens:   tokens,
+		record:   record,
+		bus:      bus,
+		instance: hostname + "/" + primitive.NewObjectID().Hex(),
+	}
+}
+
+// Open checks that the stream can be opened and, the first time, saves
+// where it starts. Change streams need a replica set, so it fails on a
+// standalone server. Check reports the result.
+func (s *ChangeStream) Open(ctx context.Context) error {
+	err := s.start(ctx)
+
+	s.mu.Lock()
+	s.started, s.startErr = err == nil, err
+	s.mu.Unlock()
+
+	return err
+}
+
+// Run records and publishes changes until ctx is cancelled, opening the
+// stream first unless Open already has. If it can't be opened Run logs why
+// and returns, and nothing is recorded.
+func (s *ChangeStream) Run(ctx context.Context) {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+
+	if !started {
+		if err := s.Open(ctx); err != nil {
+			slog.Error("change stream is not available, webhooks are not recorded", "error", err)
+			return
+		}
+	}
+	if s.bus != nil {
+		s.bus.Claim(Types...)
+	}
+
+	wait := minRetry
+	for {
+		err := s.lead(ctx)
+		s.setLease(time.Time{})
+		if ctx.Err() != nil {
+			s.release()
+			return
+		}
+
+		switch {
+		case errors.Is(err, errNotLeader), errors.Is(err, errLeaseLost):
+			wait = renewEvery
+		case err != nil:
+			slog.Error("change stream stopped", "error", err)
+			wait = min(wait*2, maxRetry)
+		default:
+			wait = minRetry
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Check is the readiness check for the stream. It fails until Run has
+// started the stream, if the stream can't be opened, and when no instance
+// holds a live lease, so changes are going unrecorded.
+func (s *ChangeStream) Check(ctx context.Context) error {
+	s.mu.Lock()
+	started, startErr, leaseUntil := s.started, s.startErr, s.leaseUntil
+	s.mu.Unlock()
+
+	switch {
+	case startErr != nil:
+		return fmt.Errorf("eventsource: the change stream is not available: %w", startErr)
+	case !started:
+		return errNotStarted
+	case time.Now().Before(leaseUntil):
+		return nil
+	}
+
+	// Another instance leads, or should.
+	var lease struct {
+		Lease_Until *time.Time `bson:"lease_until"`
+	}
+	if err := s.tokens.FindOne(ctx, bson.M{"_id": streamId}).Decode(&lease); err != nil {
+		return err
+	}
+	if lease.Lease_Until == nil || !time.Now().Before(*lease.Lease_Until) {
+		return errNoLeader
+	}
+	return nil
+}
+
+func (s *ChangeStream) setLease(until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leaseUntil = until
+}
+
+// start checks that a change stream can be opened and, the first time the
+// stream is used, saves where it starts. Changes made after the events are
+// claimed are then published even if no instance leads yet.
+func (s *ChangeStream) start(ctx context.Context) error {
+	stream, err := s.watch(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(ctx)
+
+	_, err = s.tokens.UpdateOne(ctx,
+		bson.M{"_id": streamId},
+		bson.M{"$setOnInsert": bson.M{"token": stream.ResumeToken(), "updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *ChangeStream) watch(ctx context.Context, token bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"ns.coll":       bson.M{"$in": collections},
+			"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+		}}},
+	}
+
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(maxAwait)
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+	return s.db.Watch(ctx, pipeline, opts)
+}
+
+// lead takes the lease and tails the stream from the saved resume token
+// until ctx is cancelled, the lease is lost or the stream fails.
+func (s *ChangeStream) lead(ctx context.Context) error {
+	token, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	stream, err := s.watch(ctx, token)
+	if err != nil && isHistoryLost(err) {
+		slog.Error("change stream resume token is too old, changes since it were not published", "error", err)
+		stream, err = s.watch(ctx, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.WithoutCancel(ctx))
+
+	saved := time.Now()
+	for {
+		for stream.TryNext(ctx) {
+			var change Change
+			if err := stream.Decode(&change); err != nil {
+				return err
+			}
+
+			if err := s.handle(ctx, change); err != nil {
+				return err
+			}
+		}
+		if err := stream.Err(); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// The token moves on while nothing changes too, so it is saved,
+		// and the lease renewed, whether or not there were changes.
+		if time.Since(saved) >= renewEvery {
+			if err := s.renew(ctx, stream.ResumeToken()); err != nil {
+				return err
+			}
+			saved = time.Now()
+		}
+	}
+}
+
+// handle records the events a change stands for and publishes them. An error
+// stops the stream before its token is saved, so the change is read again.
+func (s *ChangeStream) handle(ctx context.Context, change Change) error {
+	published, err := Normalize(change)
+	if err != nil {
+		slog.Error("could not read change", "collection", change.Ns.Coll, "operation", change.OperationType, "error", err)
+	}
+
+	for _, event := range published {
+		if err := s.record(ctx, event); err != nil {
+			return fmt.Errorf("eventsource: could not record %s %s: %w", event.Type, event.Subject, err)
+		}
+		if s.bus != nil {
+			s.bus.PublishFromSource(ctx, event)
+		}
+	}
+	return nil
+}
+
+// acquire takes the lease if it is free, expired or already this
+// instance's, and returns the saved resume token.
+func (s *ChangeStream) acquire(ctx context.Context) (bson.Raw, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": streamId,
+		"$or": bson.A{
+			bson.M{"owner": s.instance},
+			bson.M{"lease_until": bson.M{"$lt": now}},
+			bson.M{"lease_until": nil},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": s.instance, "lease_until": now.Add(leaseTime)}}
+
+	var lease struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := s.tokens.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&lease)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errNotLeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.setLease(now.Add(leaseTime))
+	return lease.Token, nil
+}
+
+// renew saves token and extends the lease, as long as this instance still
+// holds it.
+func (s *ChangeStream) renew(ctx context.Context, token bson.Raw) error {
+	now := time.Now()
+	set := bson.M{"lease_until": now.Add(leaseTime), "updated_at": now}
+	if token != nil {
+		set["token"] = token
+	}
+
+	result, err := s.tokens.UpdateOne(ctx, bson.M{"_id": streamId, "owner": s.instance}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errLeaseLost
+	}
+	s.setLease(now.Add(leaseTime))
+	return nil
+}
+
+// release gives the lease up so another instance can take over without
+// waiting for it to expire.
+func (s *ChangeStream) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.tokens.UpdateOne(ctx, bson.M{"_id": streamId, "owner": s.instance}, bson.M{"$set": bson.M{"lease_until": nil}})
+	if err != nil {
+		slog.Warn("could not release change stream lease", "error", err)
+	}
+}
+
+func isHistoryLost(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(errChangeStreamHistoryLost)
+}
@@ -0,0 +1,204 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// change builds a change stream document for coll.
+func change(t *testing.T, operation string, coll string, id primitive.ObjectID, document bson.M) Change {
+	t.Helper()
+
+	raw := func(v interface{}) bson.Raw {
+		b, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	c := Change{
+		ID:            raw(bson.M{"_data": operation + coll + id.Hex()}),
+		OperationType: operation,
+		ClusterTime:   primitive.Timestamp{T: 1714564800},
+		DocumentKey:   raw(bson.M{"_id": id}),
+	}
+	c.Ns.Coll = coll
+	if document != nil {
+		document["_id"] = id
+		c.FullDocument = raw(document)
+	}
+	return c
+}
+
+func TestNormalizeMenuChanges(t *testing.T) {
+	menuId, foodId := primitive.NewObjectID(), primitive.NewObjectID()
+
+	tests := []struct {
+		name    string
+		change  Change
+		subject string
+		want    services.MenuChange
+	}{
+		{
+			name:    "menu created",
+			change:  change(t, "insert", "menu", menuId, bson.M{"menu_id": menuId.Hex(), "name": "Lunch"}),
+			subject: menuId.Hex(),
+			want:    services.MenuChange{Menu_id: menuId.Hex(), Change: services.MenuChangeCreated},
+		},
+		{
+			name:    "food updated",
+			change:  change(t, "update", "food", foodId, bson.M{"food_id": foodId.Hex(), "menu_id": menuId.Hex()}),
+			subject: foodId.Hex(),
+			want:    services.MenuChange{Menu_id: menuId.Hex(), Food_id: foodId.Hex(), Change: services.MenuChangeUpdated},
+		},
+		{
+			name:    "menu deleted",
+			change:  change(t, "delete", "menu", menuId, nil),
+			subject: menuId.Hex(),
+			want:    services.MenuChange{Menu_id: menuId.Hex(), Change: services.MenuChangeDeleted},
+		},
+		{
+			name:    "food deleted",
+			change:  change(t, "delete", "food", foodId, nil),
+			subject: foodId.Hex(),
+			want:    services.MenuChange{Food_id: foodId.Hex(), Change: services.MenuChangeDeleted},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			published, err := Normalize(test.change)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(published) != 1 {
+				t.Fatalf("%d events, want 1", len(published))
+			}
+
+			event := published[0]
+			if event.Type != events.MenuUpdated || event.Subject != test.subject {
+				t.Errorf("event is %s about %s, want %s about %s", event.Type, event.Subject, events.MenuUpdated, test.subject)
+			}
+			got := event.Data.(services.MenuChange)
+			test.want.Changed_At = event.Occurred_At
+			if got != test.want {
+				t.Errorf("data = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIgnoresOtherDeletes(t *testing.T) {
+	published, err := Normalize(change(t, "delete", "order", primitive.NewObjectID(), nil))
+	if err != nil || len(published) != 0 {
+		t.Errorf("deleting an order published %v, %v, want nothing", published, err)
+	}
+}
+
+func TestNormalizeKeepsIds(t *testing.T) {
+	id := primitive.NewObjectID()
+	order := change(t, "insert", "order", id, bson.M{"order_id": id.Hex()})
+
+	first, _ := Normalize(order)
+	again, _ := Normalize(order)
+	if len(first) != 1 || len(again) != 1 || first[0].Id != again[0].Id {
+		t.Fatalf("the same change read twice published %v and %v, want one event with the same id", first, again)
+	}
+}
+
+// TestHandleRecordsBeforePublishing checks that an event the outbox refuses
+// is not published and stops the stream, so its change is read again.
+func TestHandleRecordsBeforePublishing(t *testing.T) {
+	id := primitive.NewObjectID()
+	order := change(t, "insert", "order", id, bson.M{"order_id": id.Hex()})
+
+	var recorded, published []string
+	failed := errors.New("outbox unavailable")
+	fail := true
+
+	bus := events.NewBus()
+	bus.Subscribe(func(ctx context.Context, event events.Event) { published = append(published, event.Id) })
+	stream := &ChangeStream{
+		bus: bus,
+		record: func(ctx context.Context, event events.Event) error {
+			if fail {
+				return failed
+			}
+			recorded = append(recorded, event.Id)
+			return nil
+		},
+	}
+
+	if err := stream.handle(context.Background(), order); !errors.Is(err, failed) {
+		t.Fatalf("handle: err = %v, want %v", err, failed)
+	}
+	if len(published) != 0 {
+		t.Fatalf("published %v before it was recorded", published)
+	}
+
+	fail = false
+	if err := stream.handle(context.Background(), order); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if len(recorded) != 1 || len(published) != 1 || recorded[0] != published[0] {
+		t.Errorf("recorded %v and published %v, want the same event once each", recorded, published)
+	}
+
+	// Without a bus the stream only records.
+	stream.bus = nil
+	if err := stream.handle(context.Background(), order); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if len(recorded) != 2 || len(published) != 1 {
+		t.Errorf("recorded %d and published %d events, want 2 and 1", len(recorded), len(published))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	// A client that was never connected can't open a stream or read the
+	// lease.
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := NewChangeStream(client, func(context.Context, events.Event) error { return nil }, nil)
+
+	if err := stream.Check(ctx); !errors.Is(err, errNotStarted) {
+		t.Errorf("before Run: err = %v, want %v", err, errNotStarted)
+	}
+
+	if err := stream.Open(ctx); !errors.Is(err, mongo.ErrClientDisconnected) {
+		t.Errorf("Open: err = %v, want %v", err, mongo.ErrClientDisconnected)
+	}
+
+	// Run gives up the same way when it opens the stream itself.
+	stream.Run(ctx)
+	if err := stream.Check(ctx); err == nil || !errors.Is(err, mongo.ErrClientDisconnected) {
+		t.Errorf("stream that could not start: err = %v, want why it could not", err)
+	}
+
+	// Started, and this instance leads.
+	stream.startErr, stream.started = nil, true
+	stream.setLease(time.Now().Add(leaseTime))
+	if err := stream.Check(ctx); err != nil {
+		t.Errorf("while leading: %v", err)
+	}
+
+	// Once the lease has run out, whoever holds it is looked up.
+	stream.setLease(time.Now().Add(-time.Second))
+	if err := stream.Check(ctx); err == nil {
+		t.Error("check passed without a lease it could read")
+	}
+}
@@ -0,0 +1,192 @@
+package eventsource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Change is a change stream document for one of the watched collections.
+type Change struct {
+	ID            bson.Raw            `bson:"_id"`
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	Ns            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	// DocumentKey holds the _id of the changed document, which is all a
+	// delete leaves.
+	DocumentKey bson.Raw `bson:"documentKey"`
+	// FullDocument is the document as it is now, which may be after later
+	// changes, or nil if it has since been deleted.
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+}
+
+// Normalize turns a change into the domain events it stands for, which is
+// none for most updates. The events are the ones the code making the change
+// would have published in process, with an id derived from the change so it
+// is the same each time the change is read.
+func Normalize(change Change) ([]events.Event, error) {
+	event := events.Event{Id: changeId(change.ID), Occurred_At: time.Unix(int64(change.ClusterTime.T), 0)}
+	inserted := change.OperationType == "insert"
+
+	if change.Ns.Coll == "menu" || change.Ns.Coll == "food" {
+		return menuChange(change, event)
+	}
+	if change.FullDocument == nil {
+		return nil, nil
+	}
+
+	switch change.Ns.Coll {
+	case "order":
+		if !inserted {
+			return nil, nil
+		}
+		var order models.Order
+		if err := bson.Unmarshal(change.FullDocument, &order); err != nil {
+			return nil, err
+		}
+		event.Type, event.Subject, event.Data = events.OrderCreated, order.Order_id, order
+
+	case "orderitems":
+		// Items guests send are fired once a waiter approves them.
+		approval, approved := updatedString(change, "approval_status")
+		if !inserted && !(approved && approval == services.ApprovalApproved) {
+			return nil, nil
+		}
+		var orderItem models.OrderItem
+		if err := bson.Unmarshal(change.FullDocument, &orderItem); err != nil {
+			return nil, err
+		}
+		if inserted && orderItem.Approval_Status != nil && *orderItem.Approval_Status != services.ApprovalApproved {
+			return nil, nil
+		}
+		event.Type, event.Subject, event.Data = events.OrderItemFired, orderItem.Order_item_id, orderItem
+
+	case "invoice":
+		var invoice models.Invoice
+		if err := bson.Unmarshal(change.FullDocument, &invoice); err != nil {
+			return nil, err
+		}
+
+		if inserted {
+			event.Type = events.InvoiceCreated
+			// Checkout inserts invoices already paid.
+			if invoice.Payment_status != nil && *invoice.Payment_status == services.PaymentStatusPaid {
+				event.Type = events.InvoicePaid
+			}
+		} else {
+			status, _ := updatedString(change, "payment_status")
+			switch status {
+			case services.PaymentStatusPaid:
+				event.Type = events.InvoicePaid
+			case services.PaymentStatusRefunded:
+				event.Type = events.InvoiceRefunded
+			default:
+				return nil, nil
+			}
+		}
+		event.Subject, event.Data = invoice.Invoice_id, invoice
+
+	case "table":
+		status, changed := updatedString(change, "table_status")
+		if !changed {
+			return nil, nil
+		}
+		var table models.Table
+		if err := bson.Unmarshal(change.FullDocument, &table); err != nil {
+			return nil, err
+		}
+
+		// The full document may already be past this change, so what the
+		// change itself set is preferred.
+		statusChange := services.TableStatusChange{Table_id: table.Table_id, To: status, Changed_At: event.Occurred_At}
+		if from, ok := updatedString(change, "previous_status"); ok {
+			statusChange.From = from
+		} else if table.Previous_Status != nil {
+			statusChange.From = *table.Previous_Status
+		}
+		if reason, ok := updatedString(change, "status_reason"); ok {
+			statusChange.Reason = reason
+		} else if table.Status_Reason != nil {
+			statusChange.Reason = *table.Status_Reason
+		}
+		if changedAt, ok := change.UpdateDescription.UpdatedFields.Lookup("status_changed_at").TimeOK(); ok {
+			statusChange.Changed_At = changedAt
+			event.Occurred_At = changedAt
+		}
+		event.Type, event.Subject, event.Data = events.TableStatusChanged, table.Table_id, statusChange
+
+	default:
+		return nil, nil
+	}
+
+	return []events.Event{event}, nil
+}
+
+// menuChange turns a change to a menu or food into a menu.updated event.
+// Menus and foods are identified by the hex of their _id, so a deleted one
+// can still be named.
+func menuChange(change Change, event events.Event) ([]events.Event, error) {
+	data := services.MenuChange{Changed_At: event.Occurred_At}
+
+	switch change.OperationType {
+	case "insert":
+		data.Change = services.MenuChangeCreated
+	case "delete":
+		data.Change = services.MenuChangeDeleted
+	default:
+		data.Change = services.MenuChangeUpdated
+	}
+
+	if change.FullDocument != nil {
+		var document struct {
+			Menu_id string `bson:"menu_id"`
+			Food_id string `bson:"food_id"`
+		}
+		if err := bson.Unmarshal(change.FullDocument, &document); err != nil {
+			return nil, err
+		}
+		data.Menu_id, data.Food_id = document.Menu_id, document.Food_id
+	} else {
+		id, ok := change.DocumentKey.Lookup("_id").ObjectIDOK()
+		if !ok {
+			return nil, nil
+		}
+		if change.Ns.Coll == "menu" {
+			data.Menu_id = id.Hex()
+		} else {
+			data.Food_id = id.Hex()
+		}
+	}
+
+	event.Type, event.Subject, event.Data = events.MenuUpdated, data.Menu_id, data
+	if change.Ns.Coll == "food" {
+		event.Subject = data.Food_id
+	}
+	return []events.Event{event}, nil
+}
+
+// updatedString returns the string an update set field to.
+func updatedString(change Change, field string) (string, bool) {
+	if change.UpdateDescription.UpdatedFields == nil {
+		return "", false
+	}
+	return change.UpdateDescription.UpdatedFields.Lookup(field).StringValueOK()
+}
+
+// changeId shortens the change's resume token, which is unique to it, to
+// the length of the ids events published in process get.
+func changeId(id bson.Raw) string {
+	sum := sha256.Sum256(id)
+	return hex.EncodeToString(sum[:12])
+}
@@ -0,0 +1,124 @@
+// Package geo has the bits of geometry delivery zones need: the distance
+// between two points on the earth and whether a point is inside a GeoJSON
+// polygon. It answers the same questions MongoDB's 2dsphere queries do, for
+// the memory backend, treating polygon edges as straight lines on the map
+// rather than great circles, which makes no difference at the size of a
+// delivery area.
+package geo
+
+import (
+	"errors"
+	"math"
+)
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371008.8
+
+var (
+	ErrTooFewPositions = errors.New("each ring needs at least four positions")
+	ErrRingNotClosed   = errors.New("each ring must end where it starts")
+	ErrBadPosition     = errors.New("positions are [longitude, latitude] within range")
+)
+
+// Point is a position on the earth in degrees.
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// Valid reports whether the point is within the range of latitudes and
+// longitudes.
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180
+}
+
+// Distance returns the great circle distance between a and b in meters.
+func Distance(a Point, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(b.Lng - a.Lng)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// ValidatePolygon checks the coordinates of a GeoJSON polygon: an outer ring
+// and any holes, each a closed list of [longitude, latitude] positions.
+func ValidatePolygon(rings [][][]float64) error {
+	if len(rings) == 0 {
+		return ErrTooFewPositions
+	}
+
+	for _, ring := range rings {
+		if len(ring) < 4 {
+			return ErrTooFewPositions
+		}
+		for _, position := range ring {
+			if len(position) != 2 || !(Point{Lat: position[1], Lng: position[0]}).Valid() {
+				return ErrBadPosition
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return ErrRingNotClosed
+		}
+	}
+	return nil
+}
+
+// InPolygon reports whether p is inside the GeoJSON polygon with the given
+// coordinates: inside its outer ring and outside all of its holes. Points on
+// an edge count as inside, as they do for $geoIntersects.
+func InPolygon(p Point, rings [][][]float64) bool {
+	if len(rings) == 0 || !inRing(p, rings[0]) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if inRing(p, hole) && !onRing(p, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRing casts a ray from p towards increasing longitude and counts the
+// edges it crosses.
+func inRing(p Point, ring [][]float64) bool {
+	if onRing(p, ring) {
+		return true
+	}
+
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > p.Lat) != (yj > p.Lat) && p.Lng < (xj-xi)*(p.Lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func onRing(p Point, ring [][]float64) bool {
+	for i := 1; i < len(ring); i++ {
+		if onSegment(p, ring[i-1], ring[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func onSegment(p Point, a []float64, b []float64) bool {
+	const epsilon = 1e-12
+
+	cross := (b[0]-a[0])*(p.Lat-a[1]) - (b[1]-a[1])*(p.Lng-a[0])
+	if math.Abs(cross) > epsilon {
+		return false
+	}
+	return p.Lng >= math.Min(a[0], b[0])-epsilon && p.Lng <= math.Max(a[0], b[0])+epsilon &&
+		p.Lat >= math.Min(a[1], b[1])-epsilon && p.Lat <= math.Max(a[1], b[1])+epsilon
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
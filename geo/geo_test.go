@@ -0,0 +1,108 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// at is the point at a GeoJSON [longitude, latitude] position.
+func at(lng float64, lat float64) Point {
+	return Point{Lat: lat, Lng: lng}
+}
+
+func TestInPolygon(t *testing.T) {
+	// A U with a notch cut down from the top, between x 1 and 2, to y 1.
+	concave := [][][]float64{{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3}, {0, 3}, {0, 0}}}
+	// A square with a square hole in the middle.
+	holed := [][][]float64{
+		{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		{{1, 1}, {3, 1}, {3, 3}, {1, 3}, {1, 1}},
+	}
+	// A few blocks west of the meridian and south of the equator, drawn
+	// clockwise.
+	southWest := [][][]float64{{{-58.40, -34.60}, {-58.40, -34.62}, {-58.38, -34.62}, {-58.38, -34.60}, {-58.40, -34.60}}}
+
+	tests := []struct {
+		name    string
+		point   Point
+		polygon [][][]float64
+		want    bool
+	}{
+		{"left arm", at(0.5, 2), concave, true},
+		{"right arm", at(2.5, 2.5), concave, true},
+		{"base", at(1.5, 0.5), concave, true},
+		{"in the notch", at(1.5, 2), concave, false},
+		{"across the mouth of the notch", at(1.5, 3), concave, false},
+		{"bottom of the notch", at(1.5, 1), concave, true},
+		{"side of the notch", at(2, 2), concave, true},
+		{"outer edge", at(3, 1.5), concave, true},
+		{"corner", at(0, 0), concave, true},
+		{"reflex corner", at(1, 1), concave, true},
+		{"tip of an arm", at(2, 3), concave, true},
+		// The ray east runs along the bottom of the notch, through two
+		// corners.
+		{"level with the notch", at(0.5, 1), concave, true},
+		{"level with the notch outside", at(-0.5, 1), concave, false},
+		{"level with the tips outside", at(-0.5, 3), concave, false},
+		{"just past an edge", at(3+1e-9, 1.5), concave, false},
+		{"just inside an edge", at(3-1e-9, 1.5), concave, true},
+		{"beyond the corner", at(3.5, 3.5), concave, false},
+		{"in the hole", at(2, 2), holed, false},
+		{"edge of the hole", at(1, 2), holed, true},
+		{"corner of the hole", at(3, 3), holed, true},
+		{"around the hole", at(0.5, 0.5), holed, true},
+		{"level with the hole", at(3.5, 2), holed, true},
+		{"south west inside", at(-58.39, -34.61), southWest, true},
+		{"south west on the edge", at(-58.39, -34.60), southWest, true},
+		{"south west outside", at(-58.37, -34.61), southWest, false},
+		{"no rings", at(0, 0), nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := InPolygon(test.point, test.polygon); got != test.want {
+				t.Errorf("InPolygon(%+v) = %v, want %v", test.point, got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidatePolygon(t *testing.T) {
+	tests := []struct {
+		name  string
+		rings [][][]float64
+		want  error
+	}{
+		{"square", [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, nil},
+		{"no rings", nil, ErrTooFewPositions},
+		{"three positions", [][][]float64{{{0, 0}, {1, 0}, {0, 0}}}, ErrTooFewPositions},
+		{"open ring", [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}}, ErrRingNotClosed},
+		{"latitude out of range", [][][]float64{{{0, 0}, {1, 91}, {1, 1}, {0, 0}}}, ErrBadPosition},
+		{"longitude out of range", [][][]float64{{{0, 0}, {181, 0}, {1, 1}, {0, 0}}}, ErrBadPosition},
+		{"three coordinates", [][][]float64{{{0, 0, 0}, {1, 0}, {1, 1}, {0, 0}}}, ErrBadPosition},
+		{"open hole", [][][]float64{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}, {{1, 1}, {2, 1}, {2, 2}, {1, 2}}}, ErrRingNotClosed},
+	}
+	for _, test := range tests {
+		if err := ValidatePolygon(test.rings); err != test.want {
+			t.Errorf("%s: ValidatePolygon = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		want float64
+	}{
+		{"same point", at(-58.38, -34.60), at(-58.38, -34.60), 0},
+		{"a degree of latitude", at(0, 0), at(0, 1), math.Pi * earthRadius / 180},
+		{"a quarter of the equator", at(0, 0), at(90, 0), math.Pi * earthRadius / 2},
+		{"across the antimeridian", at(179.5, 0), at(-179.5, 0), math.Pi * earthRadius / 180},
+		{"pole to pole", at(0, 90), at(0, -90), math.Pi * earthRadius},
+	}
+	for _, test := range tests {
+		if got := Distance(test.a, test.b); math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("%s: Distance = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
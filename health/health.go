@@ -0,0 +1,99 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/logging"
+)
+
+// Check reports whether a dependency the server needs is usable.
+type Check func(ctx context.Context) error
+
+var (
+	mu           sync.RWMutex
+	checks       = map[string]Check{}
+	shuttingDown int32
+)
+
+const checkTimeout = 2 * time.Second
+
+// Register adds a readiness check. Subsystems that the API cannot serve
+// without, such as the database, register one at startup.
+func Register(name string, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks[name] = check
+}
+
+// SetShuttingDown makes /readyz fail so load balancers stop routing new
+// requests while in-flight ones drain.
+func SetShuttingDown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+func Routes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/healthz", Liveness())
+	incomingRoutes.GET("/readyz", Readiness())
+}
+
+// Liveness only reports that the process is serving requests. It does not
+// look at dependencies, so a database outage does not get the process
+// restarted.
+func Liveness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// Readiness runs every registered check and answers 503 if any fails or the
+// server is shutting down. Failures are logged; the response only names the
+// failing check.
+func Readiness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if atomic.LoadInt32(&shuttingDown) == 1 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting_down", "checks": gin.H{}})
+			return
+		}
+
+		mu.RLock()
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		mu.RUnlock()
+		sort.Strings(names)
+
+		results := gin.H{}
+		ready := true
+		for _, name := range names {
+			mu.RLock()
+			check := checks[name]
+			mu.RUnlock()
+
+			ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+			err := check(ctx)
+			cancel()
+
+			if err != nil {
+				logging.FromContext(c.Request.Context()).WarnContext(c.Request.Context(), "readiness check failed", "check", name, "error", err)
+				results[name] = "unavailable"
+				ready = false
+				continue
+			}
+			results[name] = "ok"
+		}
+
+		if !ready {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": results})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": results})
+	}
+}
@@ -1,27 +1,91 @@
 package helpers
 
 import (
-	"net/http"
+	"context"
+	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 
 func IsAdmin(c *gin.Context) (isAdmin bool) {
+		// API keys aren't users, and none of their scopes make them admins.
+		if _, apiKey := c.Get("api_key_id"); apiKey {
+			apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "This endpoint can't be called with an API key"))
+			return false
+		}
+
 		userType, exists := c.Get("user_type")
 		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "User_type key value could not be found. Cannot determine user previliges",
-			})
+			apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "User_type key value could not be found. Cannot determine user previliges"))
 			return false
 		}
 
 		if userType != "ADMIN" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "User not authorized.",
-			})
+			apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "User not authorized."))
 			return false
 		}
 
 		return true
-}
\ No newline at end of file
+}
+
+// IsEmailVerified reports whether the user has confirmed their email address.
+// Accounts created before verification existed have no email_verified field
+// and are treated as verified.
+func IsEmailVerified(ctx context.Context, uid string) (bool, error) {
+	var user struct {
+		Email_Verified *bool `bson:"email_verified"`
+	}
+
+	err := userCollection.FindOne(ctx, bson.M{"user_id": uid}, options.FindOne().SetProjection(bson.M{"email_verified": 1})).Decode(&user)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Email_Verified == nil || *user.Email_Verified, nil
+}
+
+var mfaRequiredRoles = map[string]bool{}
+
+// MfaRequired reports whether users of userType must use two-factor
+// authentication. The roles are listed in MFA_REQUIRED_ROLES, e.g. "ADMIN".
+func MfaRequired(userType string) bool {
+	return mfaRequiredRoles[userType]
+}
+
+// PinLoginAllowed reports whether a user may sign in to shared terminals with
+// a PIN. A 4 to 6 digit PIN can't stand in for a password and a second
+// factor, so admins and users who have or need two-factor authentication
+// sign in with their password instead.
+func PinLoginAllowed(userType string, mfaEnabled bool) bool {
+	return userType != "ADMIN" && !mfaEnabled && !MfaRequired(userType)
+}
+
+// IsMfaEnabled reports whether the user has finished enrolling in two-factor
+// authentication.
+func IsMfaEnabled(ctx context.Context, uid string) (bool, error) {
+	var user struct {
+		Mfa_Enabled bool `bson:"mfa_enabled"`
+	}
+
+	err := userCollection.FindOne(ctx, bson.M{"user_id": uid}, options.FindOne().SetProjection(bson.M{"mfa_enabled": 1})).Decode(&user)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Mfa_Enabled, nil
+}
+
+func rolesFromEnv(name string) map[string]bool {
+	roles := map[string]bool{}
+	for _, role := range strings.Split(os.Getenv(name), ",") {
+		if role = strings.ToUpper(strings.TrimSpace(role)); role != "" {
+			roles[role] = true
+		}
+	}
+	return roles
+}
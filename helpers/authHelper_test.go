@@ -0,0 +1,34 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPinLoginAllowed(t *testing.T) {
+	mfaRequiredRoles = map[string]bool{"MANAGER": true}
+	defer func() { mfaRequiredRoles = map[string]bool{} }()
+
+	tests := []struct {
+		userType   string
+		mfaEnabled bool
+		want       bool
+	}{
+		{"USER", false, true},
+		{"USER", true, false},
+		{"ADMIN", false, false},
+		{"MANAGER", false, false},
+	}
+
+	for _, test := range tests {
+		if got := PinLoginAllowed(test.userType, test.mfaEnabled); got != test.want {
+			t.Errorf("PinLoginAllowed(%q, %v) = %v, want %v", test.userType, test.mfaEnabled, got, test.want)
+		}
+	}
+
+	for _, userType := range []string{"ADMIN", "MANAGER"} {
+		if _, err := GeneratePinToken("Ada", "Lovelace", userType, "ada@example.com", "u1", "t1", "s1", time.Now()); err != errPinLoginNotAllowed {
+			t.Errorf("GeneratePinToken for %s: err = %v, want %v", userType, err, errPinLoginNotAllowed)
+		}
+	}
+}
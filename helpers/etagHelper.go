@@ -0,0 +1,68 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+
+func ETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+func SetETag(c *gin.Context, version int64) {
+	c.Header("ETag", ETag(version))
+}
+
+// RequireIfMatch reads the version the client last saw from the If-Match
+// header. Writes are refused when the header is missing or malformed.
+func RequireIfMatch(c *gin.Context) (version int64, ok bool) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" {
+		apperrors.Respond(c, apperrors.New(apperrors.PreconditionRequired, "An If-Match header with the current ETag is required to modify this object"))
+		return 0, false
+	}
+
+	ifMatch = strings.TrimPrefix(ifMatch, "W/")
+	version, err := strconv.ParseInt(strings.Trim(ifMatch, "\""), 10, 64)
+	if err != nil || version < 0 {
+		apperrors.Respond(c, apperrors.New(apperrors.PreconditionFailed, "The If-Match header does not contain a valid ETag"))
+		return 0, false
+	}
+
+	return version, true
+}
+
+// VersionFilter matches a document at the given version. Documents written
+// before versioning was introduced have no version field and count as 0.
+func VersionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// RespondVersionMismatch is used after a versioned write matched nothing. It
+// tells a missing object (404) apart from a stale If-Match (412). resource
+// names the kind of object, e.g. "food".
+func RespondVersionMismatch(ctx context.Context, c *gin.Context, collection *mongo.Collection, idFilter bson.M, resource string) {
+	count, err := collection.CountDocuments(ctx, idFilter)
+	if err != nil {
+		apperrors.Respond(c, apperrors.FromDB(err, resource))
+		return
+	}
+
+	if count == 0 {
+		apperrors.Respond(c, apperrors.FromDB(mongo.ErrNoDocuments, resource))
+		return
+	}
+
+	apperrors.Respond(c, apperrors.New(apperrors.PreconditionFailed, "The "+resource+" was modified after the provided ETag. Fetch it again and retry"))
+}
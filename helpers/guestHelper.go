@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// GuestSessionTTL is how long guests can order after scanning a table's QR
+// code before they have to scan it again. It is set by Setup.
+var GuestSessionTTL time.Duration
+
+var tableTokenSecret []byte
+
+var ErrTableTokenInvalid = errors.New("table token is not valid")
+
+// tableTokenSecretFromEnv reads the key table tokens are signed with from
+// QR_TOKEN_SECRET. Without one a key is generated, which is fine for
+// development but makes every printed QR code stop working on restart.
+func tableTokenSecretFromEnv() ([]byte, error) {
+	if secret := os.Getenv("QR_TOKEN_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+
+	slog.Warn("QR_TOKEN_SECRET is not set, generating a key; table QR codes stop working on restart")
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// NewQrNonce returns a random value to bind a table's QR code to. Giving the
+// table a new one invalidates its printed codes and the guest sessions they
+// started.
+func NewQrNonce() (string, error) {
+	nonce := make([]byte, 6)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// TableToken signs the table and nonce into the token printed in a table's QR
+// code. It is kept short, rather than being a JWT, so the code stays small
+// enough to scan from across a table.
+func TableToken(tableId string, nonce string) string {
+	payload := tableId + "." + nonce
+	return payload + "." + tableTokenMac(payload)
+}
+
+// ParseTableToken checks a table token's signature and returns the table and
+// nonce it was made for.
+func ParseTableToken(token string) (tableId string, nonce string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrTableTokenInvalid
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(tableTokenMac(payload))) {
+		return "", "", ErrTableTokenInvalid
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func tableTokenMac(payload string) string {
+	mac := hmac.New(sha256.New, tableTokenSecret)
+	mac.Write([]byte(payload))
+	// 128 bits is plenty for a token that can only be checked online.
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// GenerateGuestToken issues the token a guest orders with after scanning a
+// table's QR code. It only works for that table, and only until the table
+// gets a new QR code.
+func GenerateGuestToken(tableId string, nonce string, sessionId string, now time.Time) (string, error) {
+	claims := &SignedTokenDetails{
+		Token_Use:        TokenUseGuest,
+		Table_id:         tableId,
+		Qr_Nonce:         nonce,
+		Session_id:       sessionId,
+		RegisteredClaims: registeredClaims("guest:"+sessionId, now, GuestSessionTTL),
+	}
+
+	return signingKeys.Sign(claims)
+}
+
+// VerifyGuestToken checks a guest session token. Staff tokens are rejected,
+// as guest tokens are by VerifyToken.
+func VerifyGuestToken(tokenString string) (*SignedTokenDetails, error) {
+	return verifyToken(tokenString, TokenUseGuest)
+}
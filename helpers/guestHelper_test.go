@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// useTableTokenSecret signs table tokens with secret for the test.
+func useTableTokenSecret(t *testing.T, secret string) {
+	t.Helper()
+
+	previous := tableTokenSecret
+	tableTokenSecret = []byte(secret)
+	t.Cleanup(func() { tableTokenSecret = previous })
+}
+
+func TestTableToken(t *testing.T) {
+	useTableTokenSecret(t, "secret")
+
+	token := TableToken("t1", "n1")
+	tableId, nonce, err := ParseTableToken(token)
+	if err != nil || tableId != "t1" || nonce != "n1" {
+		t.Fatalf("ParseTableToken(%q) = %q, %q, %v, want t1, n1", token, tableId, nonce, err)
+	}
+
+	parts := strings.Split(token, ".")
+	mac := parts[2]
+	flipped := "A"
+	if mac[0] == 'A' {
+		flipped = "B"
+	}
+
+	invalid := map[string]string{
+		"other table":  "t2." + parts[1] + "." + mac,
+		"other nonce":  parts[0] + ".n2." + mac,
+		"tampered mac": parts[0] + "." + parts[1] + "." + flipped + mac[1:],
+		"short mac":    parts[0] + "." + parts[1] + "." + mac[:len(mac)-1],
+		"no mac":       parts[0] + "." + parts[1],
+		"extra part":   token + ".x",
+		"empty table":  "." + parts[1] + "." + mac,
+		"empty nonce":  parts[0] + ".." + mac,
+		"empty":        "",
+	}
+	for name, token := range invalid {
+		if _, _, err := ParseTableToken(token); !errors.Is(err, ErrTableTokenInvalid) {
+			t.Errorf("%s: ParseTableToken(%q) err = %v, want %v", name, token, err, ErrTableTokenInvalid)
+		}
+	}
+
+	// Tokens signed with another secret, such as before QR_TOKEN_SECRET was
+	// changed, don't verify.
+	useTableTokenSecret(t, "other secret")
+	if _, _, err := ParseTableToken(token); !errors.Is(err, ErrTableTokenInvalid) {
+		t.Errorf("token from another secret: err = %v, want %v", err, ErrTableTokenInvalid)
+	}
+}
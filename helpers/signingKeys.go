@@ -0,0 +1,294 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a key tokens are signed or verified with. Private is nil for
+// keys that are only kept to verify tokens signed before a rotation.
+type SigningKey struct {
+	Kid     string
+	Method  jwt.SigningMethod
+	Public  crypto.PublicKey
+	Private crypto.Signer
+}
+
+// KeySet holds every key tokens may be signed with. The first key with a
+// private half signs new tokens; all of them verify.
+type KeySet struct {
+	keys    []SigningKey
+	signing *SigningKey
+}
+
+var signingKeys *KeySet
+
+// SigningKeys returns the keys loaded by Setup.
+func SigningKeys() *KeySet {
+	return signingKeys
+}
+
+// CheckSigningKeys is the readiness check for the keys loaded by Setup.
+func CheckSigningKeys(ctx context.Context) error {
+	if signingKeys == nil {
+		return errors.New("no signing keys are loaded")
+	}
+	return signingKeys.Check()
+}
+
+// LoadKeySet reads PEM encoded RSA or Ed25519 keys from files. Private keys
+// can sign and verify. Public keys only verify, which is how a retired key is
+// kept around until the tokens it signed have expired.
+func LoadKeySet(paths []string) (*KeySet, error) {
+	set := &KeySet{}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := parseSigningKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if err := set.add(key); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if set.signing == nil {
+		return nil, errors.New("no private key to sign tokens with")
+	}
+
+	return set, nil
+}
+
+// GenerateKeySet returns a set holding one new Ed25519 key.
+func GenerateKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{}
+	if err := set.add(newSigningKey(private.Public(), private)); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func (s *KeySet) add(key SigningKey) error {
+	for _, existing := range s.keys {
+		if existing.Kid == key.Kid {
+			return fmt.Errorf("key %s is listed twice", key.Kid)
+		}
+	}
+
+	s.keys = append(s.keys, key)
+	if s.signing == nil && key.Private != nil {
+		s.signing = &key
+	}
+	return nil
+}
+
+// Sign signs claims with the current signing key and sets the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.Kid
+	return token.SignedString(s.signing.Private)
+}
+
+// Keyfunc finds the key a token was signed with by its kid header.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range s.keys {
+		if key.Kid == kid {
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, fmt.Errorf("key %s does not sign with %s", kid, token.Method.Alg())
+			}
+			return key.Public, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Check signs a token with the signing key and verifies it, so a key that
+// can no longer sign, such as one held by a signer that has gone away, is
+// noticed before users are refused.
+func (s *KeySet) Check() error {
+	token, err := s.Sign(&jwt.RegisteredClaims{Subject: "readiness"})
+	if err != nil {
+		return fmt.Errorf("key %s could not sign: %w", s.signing.Kid, err)
+	}
+
+	_, err = jwt.NewParser(jwt.WithValidMethods(s.Methods())).Parse(token, s.Keyfunc)
+	if err != nil {
+		return fmt.Errorf("key %s signed a token it can't verify: %w", s.signing.Kid, err)
+	}
+	return nil
+}
+
+// Methods lists the algorithms tokens signed with these keys use.
+func (s *KeySet) Methods() []string {
+	var methods []string
+	seen := map[string]bool{}
+	for _, key := range s.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key, for services that verify our
+// tokens themselves.
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk := publicJWK(key.Public)
+		jwk.Kid = key.Kid
+		jwk.Alg = key.Method.Alg()
+		jwk.Use = "sig"
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func parseSigningKey(data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return SigningKey{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return newSigningKey(&key.PublicKey, key), nil
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return SigningKey{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return newSigningKey(key, nil), nil
+	case ed25519.PrivateKey:
+		return newSigningKey(key.Public(), key), nil
+	case ed25519.PublicKey:
+		return newSigningKey(key, nil), nil
+	}
+
+	return SigningKey{}, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+}
+
+// newSigningKey names the key by its RFC 7638 thumbprint, so the kid stays
+// the same across restarts and hosts without being configured.
+func newSigningKey(public crypto.PublicKey, private crypto.Signer) SigningKey {
+	key := SigningKey{Public: public, Private: private}
+
+	var thumbprint []byte
+	jwk := publicJWK(public)
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+		thumbprint, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N})
+	default:
+		key.Method = jwt.SigningMethodEdDSA
+		thumbprint, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X})
+	}
+
+	sum := sha256.Sum256(thumbprint)
+	key.Kid = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key
+}
+
+func publicJWK(public crypto.PublicKey) JWK {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(key)}
+	}
+	return JWK{}
+}
+
+// keySetFromEnv loads the comma separated key files in JWT_KEYS. Without
+// them a key is generated, which is fine for development but logs everyone
+// out on restart and can't be shared between instances.
+func keySetFromEnv() (*KeySet, error) {
+	var paths []string
+	for _, path := range strings.Split(os.Getenv("JWT_KEYS"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		slog.Warn("JWT_KEYS is not set, signing tokens with a generated key")
+		return GenerateKeySet()
+	}
+
+	return LoadKeySet(paths)
+}
@@ -0,0 +1,329 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	testRSAKeyOnce sync.Once
+	testRSAKey     *rsa.PrivateKey
+)
+
+// rsaKey returns a 2048 bit RSA key, generated once as it takes a while.
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	testRSAKeyOnce.Do(func() {
+		testRSAKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	return testRSAKey
+}
+
+func ed25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+// writePEM writes key to a file in a temporary directory, as a private key
+// or, for public keys, a public one, and returns its path.
+func writePEM(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	var block *pem.Block
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	file, err := os.CreateTemp(t.TempDir(), "*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := pem.Encode(file, block); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+// useKeys signs and verifies tokens with set for the rest of the test.
+func useKeys(t *testing.T, set *KeySet) {
+	t.Helper()
+
+	keys, issuer, audience := signingKeys, tokenIssuer, tokenAudience
+	accessTTL, refreshTTL := accessTokenTTL, refreshTokenTTL
+	t.Cleanup(func() {
+		signingKeys, tokenIssuer, tokenAudience = keys, issuer, audience
+		accessTokenTTL, refreshTokenTTL = accessTTL, refreshTTL
+	})
+
+	signingKeys = set
+	tokenIssuer, tokenAudience = "issuer", "audience"
+	accessTokenTTL, refreshTokenTTL = 15*time.Minute, time.Hour
+}
+
+func loadKeySet(t *testing.T, paths ...string) *KeySet {
+	t.Helper()
+
+	set, err := LoadKeySet(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func headerOf(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header map[string]interface{}
+	json.Unmarshal(raw, &header)
+	return header
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, newKey := rsaKey(t), ed25519Key(t)
+	oldPrivate, oldPublic := writePEM(t, oldKey), writePEM(t, &oldKey.PublicKey)
+	newPrivate := writePEM(t, newKey)
+
+	before := loadKeySet(t, oldPrivate)
+	useKeys(t, before)
+	oldToken, _, err := GenerateTokens("Ada", "Lovelace", "USER", "ada@example.com", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key is listed first to sign with, and the old one is kept,
+	// public half only, until its tokens have expired.
+	during := loadKeySet(t, newPrivate, oldPublic)
+	useKeys(t, during)
+	newToken, _, err := GenerateTokens("Ada", "Lovelace", "USER", "ada@example.com", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := headerOf(t, newToken)
+	if header["alg"] != "EdDSA" || header["kid"] != newSigningKey(newKey.Public(), nil).Kid {
+		t.Errorf("new tokens have header %v, want them signed with the new key", header)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if claims, err := VerifyToken(token); err != nil || claims.Uid != "u1" {
+			t.Errorf("%s token during the rotation: %v", name, err)
+		}
+	}
+	if methods := during.Methods(); len(methods) != 2 {
+		t.Errorf("Methods() = %v, want EdDSA and RS256", methods)
+	}
+
+	// Once the old key is dropped its tokens stop working.
+	useKeys(t, loadKeySet(t, newPrivate))
+	if _, err := VerifyToken(oldToken); err == nil {
+		t.Error("a token signed with a retired key was accepted")
+	}
+	if _, err := VerifyToken(newToken); err != nil {
+		t.Errorf("new token after the rotation: %v", err)
+	}
+}
+
+func TestKeySetRefusesForgedTokens(t *testing.T) {
+	key := rsaKey(t)
+	set := loadKeySet(t, writePEM(t, key))
+	useKeys(t, set)
+	kid := set.signing.Kid
+
+	claims := &SignedTokenDetails{Uid: "u1", Token_Use: TokenUseAccess, RegisteredClaims: registeredClaims("u1", time.Now(), time.Minute)}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	publicDER, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for name, token := range map[string]string{
+		// HMAC keyed with the published public key.
+		"algorithm confusion": sign(jwt.SigningMethodHS256, kid, publicDER),
+		"no signature":        sign(jwt.SigningMethodNone, kid, jwt.UnsafeAllowNoneSignatureType),
+		"unknown key":         sign(jwt.SigningMethodRS256, "unknown", other),
+		"another key's kid":   sign(jwt.SigningMethodRS256, kid, other),
+	} {
+		if _, err := VerifyToken(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	if _, err := VerifyToken(sign(jwt.SigningMethodRS256, kid, key)); err != nil {
+		t.Errorf("genuine token: %v", err)
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	key := ed25519Key(t)
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	os.WriteFile(garbage, []byte("not a key"), 0o600)
+
+	for name, paths := range map[string][]string{
+		"only public keys":   {writePEM(t, key.Public())},
+		"the same key twice": {writePEM(t, key), writePEM(t, key.Public())},
+		"a small RSA key":    {writePEM(t, small)},
+		"not PEM":            {garbage},
+		"missing file":       {filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := LoadKeySet(paths); err == nil {
+			t.Errorf("%s: LoadKeySet succeeded", name)
+		}
+	}
+}
+
+// TestKeyIds checks kids are RFC 7638 thumbprints, against the examples in
+// RFC 7638 and RFC 8037.
+func TestKeyIds(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	rsaPublic := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(decode("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")),
+		E: 65537,
+	}
+	if kid := newSigningKey(rsaPublic, nil).Kid; kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("RSA kid = %s, want the RFC 7638 thumbprint", kid)
+	}
+
+	edPublic := ed25519.PublicKey(decode("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"))
+	if kid := newSigningKey(edPublic, nil).Kid; kid != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("Ed25519 kid = %s, want the RFC 8037 thumbprint", kid)
+	}
+}
+
+// TestJWKS checks the published keys are enough for another service to
+// verify our tokens.
+func TestJWKS(t *testing.T) {
+	edKey, rsaPrivate := ed25519Key(t), rsaKey(t)
+	set := loadKeySet(t, writePEM(t, edKey), writePEM(t, &rsaPrivate.PublicKey))
+	useKeys(t, set)
+
+	body, err := json.Marshal(set.JWKS())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	json.Unmarshal(body, &published)
+	if len(published.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2: %s", len(published.Keys), body)
+	}
+	for _, jwk := range published.Keys {
+		if jwk["use"] != "sig" || jwk["kid"] == "" {
+			t.Errorf("key %v, want a kid and use sig", jwk)
+		}
+		if _, private := jwk["d"]; private {
+			t.Errorf("key %s publishes its private half", jwk["kid"])
+		}
+	}
+
+	rsaJWK, edJWK := published.Keys[1], published.Keys[0]
+	if rsaJWK["kty"] != "RSA" || rsaJWK["alg"] != "RS256" || rsaJWK["e"] != "AQAB" {
+		t.Errorf("RSA key = %v", rsaJWK)
+	}
+	if edJWK["kty"] != "OKP" || edJWK["crv"] != "Ed25519" || edJWK["alg"] != "EdDSA" {
+		t.Errorf("Ed25519 key = %v", edJWK)
+	}
+
+	// Rebuild the signing key from the JWKS and check a token with it.
+	token, _, err := GenerateTokens("Ada", "Lovelace", "USER", "ada@example.com", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(edJWK["x"])
+	_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != edJWK["kid"] {
+			t.Errorf("token kid = %v, want %s", token.Header["kid"], edJWK["kid"])
+		}
+		return crypto.PublicKey(ed25519.PublicKey(x)), nil
+	})
+	if err != nil {
+		t.Errorf("token does not verify with the published key: %v", err)
+	}
+}
+
+// unavailableSigner is a key whose signer has gone away.
+type unavailableSigner struct {
+	public crypto.PublicKey
+}
+
+func (s unavailableSigner) Public() crypto.PublicKey { return s.public }
+
+func (s unavailableSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("signer unavailable")
+}
+
+func TestCheckSigningKeys(t *testing.T) {
+	useKeys(t, nil)
+	if err := CheckSigningKeys(context.Background()); err == nil {
+		t.Error("check passed with no keys loaded")
+	}
+
+	key := ed25519Key(t)
+	useKeys(t, loadKeySet(t, writePEM(t, key)))
+	if err := CheckSigningKeys(context.Background()); err != nil {
+		t.Errorf("check with a usable key: %v", err)
+	}
+
+	broken := &KeySet{}
+	broken.add(newSigningKey(key.Public(), unavailableSigner{key.Public()}))
+	useKeys(t, broken)
+	if err := CheckSigningKeys(context.Background()); err == nil {
+		t.Error("check passed with a key that can't sign")
+	}
+}
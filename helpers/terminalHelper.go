@@ -0,0 +1,70 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DeviceTokenHeader carries a registered terminal's device token.
+const DeviceTokenHeader = "X-Device-Token"
+
+var terminalCollection *mongo.Collection
+
+// PinSessionTTL is how long a PIN session lasts at most, and PinIdleTimeout
+// how long it may go unused before it locks. Both are set by Setup.
+var (
+	PinSessionTTL  time.Duration
+	PinIdleTimeout time.Duration
+)
+
+// HashDeviceToken returns the form a device token is stored in.
+func HashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TouchTerminalSession reports whether the PIN session is still the one open
+// on the terminal, presented with the terminal's device token, and neither
+// expired nor idle for longer than PinIdleTimeout. If so it counts as
+// activity and pushes the idle lock back.
+func TouchTerminalSession(ctx context.Context, terminalId string, sessionId string, deviceToken string) (bool, error) {
+	if deviceToken == "" {
+		return false, nil
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		"terminal_id":        terminalId,
+		"device_token_hash":  HashDeviceToken(deviceToken),
+		"revoked_at":         nil,
+		"session_id":         sessionId,
+		"session_expires_at": bson.M{"$gt": now},
+		"last_active_at":     bson.M{"$gt": now.Add(-PinIdleTimeout)},
+	}
+	update := bson.M{"$set": bson.M{"last_active_at": now}}
+
+	result, err := terminalCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// ActorFrom returns the signed in staff member, the API key or the guest
+// session to record against a change.
+func ActorFrom(c *gin.Context) *models.Actor {
+	return &models.Actor{
+		User_id:          c.GetString("uid"),
+		Terminal_id:      c.GetString("terminal_id"),
+		Api_key_id:       c.GetString("api_key_id"),
+		Guest_session_id: c.GetString("guest_session_id"),
+	}
+}
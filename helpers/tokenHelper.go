@@ -2,7 +2,8 @@ package helpers
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
 	"os"
 	"time"
 
@@ -11,11 +12,60 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var SECRET_KEY  = []byte(os.Getenv("SECRET_KEY")) 
-var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
+var userCollection *mongo.Collection
+
+// Setup opens the collections the helpers use and loads the token signing
+// keys. It must be called with a connected client before any token is
+// issued or verified.
+func Setup(client *mongo.Client) error {
+	userCollection = database.OpenCollection(client, "user")
+	terminalCollection = database.OpenCollection(client, "terminal")
+	apiKeyCollection = database.OpenCollection(client, "api_keys")
+	mfaRequiredRoles = rolesFromEnv("MFA_REQUIRED_ROLES")
+
+	keys, err := keySetFromEnv()
+	if err != nil {
+		return err
+	}
+	signingKeys = keys
+
+	tokenIssuer = envOrDefault("JWT_ISSUER", "Restaurant-Management-Backend-Project")
+	tokenAudience = envOrDefault("JWT_AUDIENCE", "restaurant-api")
+	accessTokenTTL = durationFromEnv("JWT_ACCESS_TTL", 15*time.Minute)
+	refreshTokenTTL = durationFromEnv("JWT_REFRESH_TTL", 24*time.Hour)
+	PinSessionTTL = durationFromEnv("PIN_SESSION_TTL", 30*time.Minute)
+	PinIdleTimeout = durationFromEnv("PIN_IDLE_TIMEOUT", 2*time.Minute)
+	GuestSessionTTL = durationFromEnv("GUEST_SESSION_TTL", 3*time.Hour)
+
+	secret, err := tableTokenSecretFromEnv()
+	if err != nil {
+		return err
+	}
+	tableTokenSecret = secret
+	return nil
+}
+
+const (
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+	TokenUsePin     = "pin"
+	TokenUseGuest   = "guest"
+
+	// Allowed difference between our clock and that of whoever signed or is
+	// checking a token.
+	clockLeeway = 30 * time.Second
+)
+
+var errPinLoginNotAllowed = errors.New("PIN sessions are not issued to admins or users who must use two-factor authentication")
+
+var (
+	tokenIssuer     string
+	tokenAudience   string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+)
 
 
 type SignedTokenDetails struct {
@@ -24,12 +74,18 @@ type SignedTokenDetails struct {
 	User_Type	string
 	Email		string
 	Uid			string
+	Token_Use	string
+	Terminal_id	string	`json:",omitempty"`
+	Session_id	string	`json:",omitempty"`
+	Table_id	string	`json:",omitempty"`
+	Qr_Nonce	string	`json:",omitempty"`
 	*jwt.RegisteredClaims
 }
 
 
 func GenerateTokens(firstName string, lastName, userType string, email string, uid string) (signedToken string, signedRefreshToken string, err error) {
 	
+	now := time.Now()
 
 	claims := &SignedTokenDetails{
 		First_Name: firstName,
@@ -37,11 +93,8 @@ func GenerateTokens(firstName string, lastName, userType string, email string, u
 		User_Type: 	userType,
 		Email: 		email,
 		Uid: 		uid,
-		RegisteredClaims: &jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Second*10)),
-			Issuer: "Restaurant-Management-Backend-Project",
-		},
-		
+		Token_Use:	TokenUseAccess,
+		RegisteredClaims: registeredClaims(uid, now, accessTokenTTL),
 	}
 
 	refreshTokenClaims := &SignedTokenDetails{
@@ -50,22 +103,19 @@ func GenerateTokens(firstName string, lastName, userType string, email string, u
 		User_Type: 	userType,
 		Email: 		email,
 		Uid: 		uid,
-		RegisteredClaims: &jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Hour*24)),
-		},
+		Token_Use:	TokenUseRefresh,
+		RegisteredClaims: registeredClaims(uid, now, refreshTokenTTL),
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := signingKeys.Sign(claims)
 	if err != nil {
-		log.Panic(err)
-		return
+		return "", "", err
 	}
 
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims).SignedString([]byte(SECRET_KEY))
+	refreshToken, err := signingKeys.Sign(refreshTokenClaims)
 
 	if err != nil {
-		log.Panic(err)
-		return
+		return "", "", err
 	}
 
 
@@ -73,9 +123,45 @@ func GenerateTokens(firstName string, lastName, userType string, email string, u
 	
 }
 
-func UpdateTokens(token string, refreshToken string, userId string) (error) {
+// GeneratePinToken issues the access token for a PIN session on a shared
+// terminal. It only works together with the terminal's device token and
+// stops working when the session locks, see TouchTerminalSession.
+// Callers check PinLoginAllowed first; roles it rules out are refused here as
+// well.
+func GeneratePinToken(firstName string, lastName string, userType string, email string, uid string, terminalId string, sessionId string, now time.Time) (string, error) {
+	if !PinLoginAllowed(userType, false) {
+		return "", errPinLoginNotAllowed
+	}
 
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	claims := &SignedTokenDetails{
+		First_Name:  firstName,
+		Last_Name:   lastName,
+		User_Type:   userType,
+		Email:       email,
+		Uid:         uid,
+		Token_Use:   TokenUsePin,
+		Terminal_id: terminalId,
+		Session_id:  sessionId,
+		RegisteredClaims: registeredClaims(uid, now, PinSessionTTL),
+	}
+
+	return signingKeys.Sign(claims)
+}
+
+func registeredClaims(subject string, now time.Time, ttl time.Duration) *jwt.RegisteredClaims {
+	return &jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{tokenAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+func UpdateTokens(ctx context.Context, token string, refreshToken string, userId string) (error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Second)
 
 	var updateUserToken primitive.D
 
@@ -85,40 +171,78 @@ func UpdateTokens(token string, refreshToken string, userId string) (error) {
 	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	updateUserToken = append(updateUserToken, bson.E{Key: "updated_at", Value: updated_at})
 
+	// Token rotation is bookkeeping rather than an edit to the user, so the
+	// version is left alone and outstanding ETags stay valid.
 	filter := bson.M{"user_id": userId}
-	opt := options.Update().SetUpsert(true)
 
-	_, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: updateUserToken}}, opt)
+	result, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: updateUserToken}})
 	defer cancel()
 	if err != nil {
-		log.Panic(err)
 		return err
 	}
 
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
 	return nil
 }
 
-func VerifyToken(tokenString string) (claims *SignedTokenDetails, message string) {
+// VerifyToken checks an access or PIN session token's signature, issuer,
+// audience and validity period and returns its claims. Refresh tokens are
+// rejected.
+func VerifyToken(tokenString string) (*SignedTokenDetails, error) {
+	return verifyToken(tokenString, TokenUseAccess, TokenUsePin)
+}
 
+func verifyToken(tokenString string, uses ...string) (*SignedTokenDetails, error) {
+	claims := &SignedTokenDetails{RegisteredClaims: &jwt.RegisteredClaims{}}
 
-	token, err := jwt.ParseWithClaims(tokenString, &SignedTokenDetails{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_KEY), nil
-	})
+	// The registered claims are checked below instead, so they can be
+	// required and given some leeway.
+	parser := jwt.NewParser(jwt.WithValidMethods(signingKeys.Methods()), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(tokenString, claims, signingKeys.Keyfunc); err != nil {
+		return nil, err
+	}
 
-	
+	now := time.Now()
+
+	if claims.ExpiresAt == nil || !now.Before(claims.ExpiresAt.Add(clockLeeway)) {
+		return nil, errors.New("token is expired")
+	}
+
+	if claims.NotBefore != nil && now.Add(clockLeeway).Before(claims.NotBefore.Time) {
+		return nil, errors.New("token is not valid yet")
+	}
 
-	claims, ok := token.Claims.(*SignedTokenDetails) 
-	if !ok {
-		message = err.Error()
-		return
+	if claims.Issuer != tokenIssuer {
+		return nil, errors.New("token has the wrong issuer")
 	}
 
-	//the token is expired
-	if claims.VerifyExpiresAt(time.Now().Local(), true) {
-		message = err.Error()
-		return
+	if !claims.VerifyAudience(tokenAudience, true) {
+		return nil, errors.New("token has the wrong audience")
 	}
 
-	return claims, message
+	for _, use := range uses {
+		if claims.Token_Use == use {
+			return claims, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%q tokens are not accepted here", claims.Token_Use)
+}
 
-}
\ No newline at end of file
+func envOrDefault(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Mailer delivers messages to users.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// FromEnv builds the mailer selected by MAILER:
+//
+//   - "outbox" or unset: messages are appended to MAIL_OUTBOX_FILE (default
+//     outbox.jsonl) instead of being sent, for local runs and tests.
+//   - "smtp": messages are sent through SMTP_HOST:SMTP_PORT as MAIL_FROM,
+//     authenticating with SMTP_USERNAME and SMTP_PASSWORD when set.
+func FromEnv() (Mailer, error) {
+	switch kind := os.Getenv("MAILER"); kind {
+	case "", "outbox":
+		path := os.Getenv("MAIL_OUTBOX_FILE")
+		if path == "" {
+			path = "outbox.jsonl"
+		}
+		return NewOutboxMailer(path), nil
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		})
+	default:
+		return nil, fmt.Errorf("mailer: unknown MAILER %q", kind)
+	}
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// OutboxMailer appends every message to a JSON lines file instead of sending
+// it, so the links in it can be read back during local runs and tests.
+type OutboxMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewOutboxMailer(path string) *OutboxMailer {
+	return &OutboxMailer{path: path}
+}
+
+type outboxEntry struct {
+	Message
+	Sent_At time.Time `json:"sent_at"`
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, message Message) error {
+	line, err := json.Marshal(outboxEntry{Message: message, Sent_At: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
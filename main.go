@@ -1,39 +1,137 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/teandresmith/restaurant-project/controllers"
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/health"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/metrics"
 	"github.com/teandresmith/restaurant-project/middleware"
+	"github.com/teandresmith/restaurant-project/openapi"
 	"github.com/teandresmith/restaurant-project/routes"
+	"github.com/teandresmith/restaurant-project/tracing"
 
-	"github.com/gin-gonic/gin"
-) 
-
-
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
 
 func main() {
+	logging.Setup()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8000"
 	}
 
-	router := gin.New()
+	// Cancelled on SIGINT/SIGTERM, which starts a graceful shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		fatal("could not set up tracing", err)
+	}
+
+	metrics.RecordEvents(events.Default)
+
+	monitor := database.CombineMonitors(metrics.MongoMonitor(), tracing.MongoMonitor())
+	client, err := database.Connect(ctx, options.Client().SetMonitor(monitor))
+	if err != nil {
+		fatal("could not connect to MongoDB", err)
+	}
+
+	if err := helpers.Setup(client); err != nil {
+		fatal("could not load token signing keys", err)
+	}
+	middleware.Setup(client)
+	if err := controllers.Setup(client); err != nil {
+		fatal("could not set up controllers", err)
+	}
+	if err := controllers.Start(ctx); err != nil {
+		fatal("could not open the change stream, which needs a replica set", err)
+	}
+
+	health.Register("mongo", database.Ping)
+	health.Register("signing_keys", helpers.CheckSigningKeys)
+
+	router, err := routes.NewRouter(trustedProxies())
+	if err != nil {
+		fatal("TRUSTED_PROXIES is not a list of addresses or CIDR ranges", err)
+	}
+
+	if err := openapi.CheckRoutes(router.Routes()); err != nil {
+		fatal("routes do not match openapi.json", err)
+	}
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("server stopped", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("shutting down, waiting for in-flight requests to finish")
 
-	// Default Middleware
-	router.Use(gin.Logger())
-	routes.LoginRoutes(router)
+	health.SetShuttingDown()
 
-	// Custom Authentication Middleware
-	router.Use(middleware.Authentication())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
 
-	routes.UserRoutes(router)
-	routes.FoodRoutes(router)
-	routes.MenuRoutes(router)
-	routes.TableRoutes(router)
-	routes.OrderRoutes(router)
-	routes.OrderItemRoutes(router)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("could not shut down cleanly", "error", err)
+	}
 
-	log.Fatal(router.Run(":" + port))
-	
-}
\ No newline at end of file
+	if err := database.Disconnect(shutdownCtx); err != nil {
+		slog.Error("could not disconnect from MongoDB", "error", err)
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("could not flush traces", "error", err)
+	}
+}
+
+// fatal logs err and exits without running deferred calls, so it is only
+// used when the server never got as far as serving requests.
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
+}
+
+func shutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 15 * time.Second
+	}
+	return timeout
+}
+
+// trustedProxies reads TRUSTED_PROXIES, the comma separated addresses or CIDR
+// ranges of the load balancers in front of the server. Without it the client
+// address is the one the connection came from.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
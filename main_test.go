@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	if proxies := trustedProxies(); proxies != nil {
+		t.Errorf("trustedProxies() = %v with TRUSTED_PROXIES unset, want nil", proxies)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", " 10.0.0.0/8, 192.168.1.1 ,")
+	if proxies, want := trustedProxies(), []string{"10.0.0.0/8", "192.168.1.1"}; !reflect.DeepEqual(proxies, want) {
+		t.Errorf("trustedProxies() = %v, want %v", proxies, want)
+	}
+}
+
+// TestClientAddress checks X-Forwarded-For only picks the client address,
+// which requests are rate limited by, when a trusted proxy set it.
+func TestClientAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		proxies    []string
+		remoteAddr string
+		want       string
+	}{
+		{nil, "203.0.113.7:5000", "203.0.113.7"},
+		{[]string{"10.0.0.0/8"}, "203.0.113.7:5000", "203.0.113.7"},
+		{[]string{"10.0.0.0/8"}, "10.1.2.3:5000", "198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		router := gin.New()
+		if err := router.SetTrustedProxies(test.proxies); err != nil {
+			t.Fatal(err)
+		}
+		router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if got := w.Body.String(); got != test.want {
+			t.Errorf("with proxies %v and a request from %s, client address = %s, want %s", test.proxies, test.remoteAddr, got, test.want)
+		}
+	}
+}
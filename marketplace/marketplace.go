@@ -0,0 +1,102 @@
+// Package marketplace turns orders from delivery marketplaces into one shape
+// the restaurant understands, and reports back to the marketplace as those
+// orders progress. Each marketplace has an Adapter that knows how it signs
+// its webhooks, what its orders look like and where status updates go.
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// Statuses reported back to marketplaces.
+const (
+	StatusAccepted  = "ACCEPTED"
+	StatusCompleted = "COMPLETED"
+)
+
+var (
+	ErrSignature = errors.New("marketplace: webhook signature is not valid")
+	ErrPayload   = errors.New("marketplace: webhook payload is not valid")
+)
+
+// Order is a marketplace order in the restaurant's terms, apart from its
+// items still being named by the marketplace's menu ids.
+type Order struct {
+	External_id      string           `json:"external_id" validate:"required,max=100"`
+	Display_id       string           `json:"display_id" validate:"max=50"`
+	Order_type       string           `json:"order_type" validate:"eq=DELIVERY|eq=PICKUP"`
+	Placed_At        time.Time        `json:"placed_at"`
+	Promised_At      *time.Time       `json:"promised_at"`
+	Customer         *models.Customer `json:"customer" validate:"required"`
+	Delivery_Address *models.Address  `json:"delivery_address"`
+	Items            []Item           `json:"items" validate:"required,min=1,max=100,dive"`
+}
+
+// Item is a line of a marketplace order.
+type Item struct {
+	External_item_id string  `json:"external_item_id" validate:"required,max=100"`
+	Count            int     `json:"count" validate:"min=1,max=50"`
+	Size             string  `json:"size" validate:"omitempty,eq=S|eq=M|eq=L"`
+	Unit_Price       float64 `json:"unit_price" validate:"min=0"`
+}
+
+// StatusUpdate tells a marketplace how one of its orders is getting on.
+type StatusUpdate struct {
+	External_id string    `json:"external_id"`
+	Order_id    string    `json:"order_id"`
+	Status      string    `json:"status"`
+	Occurred_At time.Time `json:"occurred_at"`
+}
+
+// Adapter speaks one marketplace's webhook dialect.
+type Adapter interface {
+	// Verify checks that a webhook was signed by the marketplace.
+	Verify(header http.Header, body []byte, now time.Time) error
+	// Parse reads the order out of a verified webhook body.
+	Parse(body []byte) (Order, error)
+	// StatusRequest builds the request reporting update to the marketplace,
+	// or returns nil when it doesn't take status updates.
+	StatusRequest(ctx context.Context, update StatusUpdate) (*http.Request, error)
+}
+
+// FromEnv builds the adapters for the marketplaces named in MARKETPLACES, a
+// comma separated list such as "ubereats,doordash". Each is configured with
+// MARKETPLACE_<NAME>_SECRET, which its webhooks are signed with,
+// MARKETPLACE_<NAME>_CALLBACK_URL, where status updates are posted, and
+// MARKETPLACE_<NAME>_ADAPTER:
+//
+//   - "standard" or unset: the payload and signature described in the
+//     README, for marketplaces that can be set up to send it.
+func FromEnv() (map[string]Adapter, error) {
+	adapters := map[string]Adapter{}
+
+	for _, name := range strings.Split(os.Getenv("MARKETPLACES"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "MARKETPLACE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		secret := os.Getenv(prefix + "SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("marketplace: %sSECRET is required for %q", prefix, name)
+		}
+
+		switch kind := os.Getenv(prefix + "ADAPTER"); kind {
+		case "", "standard":
+			adapters[name] = NewStandardAdapter([]byte(secret), os.Getenv(prefix+"CALLBACK_URL"))
+		default:
+			return nil, fmt.Errorf("marketplace: unknown %sADAPTER %q", prefix, kind)
+		}
+	}
+
+	return adapters, nil
+}
@@ -0,0 +1,106 @@
+package marketplace
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the signature of standard webhooks and status
+// updates, as "t=<unix seconds>,v1=<hex HMAC-SHA256 of t.body>".
+const SignatureHeader = "X-Marketplace-Signature"
+
+// signatureTolerance is how old a signed webhook may be, so a captured one
+// can't be replayed later.
+const signatureTolerance = 5 * time.Minute
+
+// StandardAdapter takes orders already in the restaurant's Order shape,
+// signed with a shared secret, and posts status updates signed the same way.
+type StandardAdapter struct {
+	secret      []byte
+	callbackUrl string
+}
+
+func NewStandardAdapter(secret []byte, callbackUrl string) *StandardAdapter {
+	return &StandardAdapter{secret: secret, callbackUrl: callbackUrl}
+}
+
+func (a *StandardAdapter) Verify(header http.Header, body []byte, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header.Get(SignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return ErrSignature
+	}
+
+	// More than one signature is sent while the secret is being changed.
+	expected := a.sign(timestamp, body)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrSignature
+}
+
+func (a *StandardAdapter) Parse(body []byte) (Order, error) {
+	var order Order
+	if err := json.Unmarshal(body, &order); err != nil {
+		return order, fmt.Errorf("%w: %v", ErrPayload, err)
+	}
+	return order, nil
+}
+
+func (a *StandardAdapter) StatusRequest(ctx context.Context, update StatusUpdate) (*http.Request, error) {
+	if a.callbackUrl == "" {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, a.SignatureHeader(time.Now(), body))
+	return req, nil
+}
+
+// SignatureHeader returns the SignatureHeader value for body sent at t.
+func (a *StandardAdapter) SignatureHeader(t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + a.sign(timestamp, body)
+}
+
+func (a *StandardAdapter) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,135 @@
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("whsec_test_0123456789")
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestStandardAdapterVerify(t *testing.T) {
+	adapter := NewStandardAdapter(testSecret, "")
+	other := NewStandardAdapter([]byte("whsec_other_0123456789"), "")
+	body := readFixture(t, "standard_order.json")
+	now := time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC)
+
+	header := func(value string) http.Header {
+		return http.Header{SignatureHeader: []string{value}}
+	}
+	signed := adapter.SignatureHeader(now, body)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		now    time.Time
+		valid  bool
+	}{
+		{"signed", header(signed), body, now, true},
+		{"a little old", header(signed), body, now.Add(signatureTolerance), true},
+		{"too old", header(signed), body, now.Add(signatureTolerance + time.Second), false},
+		{"from the future", header(signed), body, now.Add(-signatureTolerance - time.Second), false},
+		{"body changed", header(signed), append([]byte(" "), body...), now, false},
+		{"other secret", header(other.SignatureHeader(now, body)), body, now, false},
+		// While the secret changes both signatures are sent.
+		{"rotating secret", header(other.SignatureHeader(now, body) + ",v1=" + adapter.sign(unix(now), body)), body, now, true},
+		{"no timestamp", header("v1=" + adapter.sign(unix(now), body)), body, now, false},
+		{"no signature", header("t=" + unix(now)), body, now, false},
+		{"no header", http.Header{}, body, now, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := adapter.Verify(test.header, test.body, test.now)
+			if test.valid && err != nil {
+				t.Errorf("Verify: %v, want it valid", err)
+			}
+			if !test.valid && !errors.Is(err, ErrSignature) {
+				t.Errorf("Verify: err = %v, want %v", err, ErrSignature)
+			}
+		})
+	}
+}
+
+func TestStandardAdapterParse(t *testing.T) {
+	adapter := NewStandardAdapter(testSecret, "")
+
+	order, err := adapter.Parse(readFixture(t, "standard_order.json"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if order.External_id != "ue-7f3a9c" || order.Display_id != "7F3A" || order.Order_type != "DELIVERY" {
+		t.Errorf("order = %s (%s) for %s, want ue-7f3a9c (7F3A) for DELIVERY", order.External_id, order.Display_id, order.Order_type)
+	}
+	if want := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC); !order.Placed_At.Equal(want) {
+		t.Errorf("placed_at = %v, want %v", order.Placed_At, want)
+	}
+	if order.Customer == nil || order.Customer.Name != "Ada Lovelace" {
+		t.Errorf("customer = %+v, want Ada Lovelace", order.Customer)
+	}
+	if order.Delivery_Address == nil || order.Delivery_Address.City != "London" {
+		t.Errorf("delivery_address = %+v, want one in London", order.Delivery_Address)
+	}
+
+	want := []Item{
+		{External_item_id: "burger-classic", Count: 2, Size: "L", Unit_Price: 11.5},
+		{External_item_id: "fries", Count: 1, Unit_Price: 3.25},
+	}
+	if len(order.Items) != len(want) {
+		t.Fatalf("%d items, want %d", len(order.Items), len(want))
+	}
+	for i := range want {
+		if order.Items[i] != want[i] {
+			t.Errorf("item %d = %+v, want %+v", i, order.Items[i], want[i])
+		}
+	}
+
+	if _, err := adapter.Parse(readFixture(t, "malformed_order.json")); !errors.Is(err, ErrPayload) {
+		t.Errorf("Parse of a malformed order: err = %v, want %v", err, ErrPayload)
+	}
+}
+
+func TestStandardAdapterStatusRequest(t *testing.T) {
+	update := StatusUpdate{External_id: "ue-7f3a9c", Order_id: "o1", Status: StatusAccepted, Occurred_At: time.Now()}
+
+	req, err := NewStandardAdapter(testSecret, "").StatusRequest(context.Background(), update)
+	if err != nil || req != nil {
+		t.Errorf("StatusRequest without a callback URL = %v, %v, want no request", req, err)
+	}
+
+	adapter := NewStandardAdapter(testSecret, "https://marketplace.example/status")
+	req, err = adapter.StatusRequest(context.Background(), update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPost || req.URL.String() != "https://marketplace.example/status" {
+		t.Errorf("request is %s %s, want a POST to the callback URL", req.Method, req.URL)
+	}
+
+	// The marketplace checks the update the way webhooks are checked here.
+	body, _ := io.ReadAll(req.Body)
+	if err := adapter.Verify(req.Header, body, time.Now()); err != nil {
+		t.Errorf("status update signature: %v", err)
+	}
+}
+
+func unix(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
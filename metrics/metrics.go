@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+const namespace = "restaurant"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Requests rejected as unauthenticated, by route.",
+	}, []string{"route"})
+
+	ordersCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_created_total",
+		Help:      "Orders created.",
+	})
+
+	itemsFired = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "order_items_fired_total",
+		Help:      "Order items sent to the kitchen.",
+	})
+
+	invoicesPaid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invoices_paid_total",
+		Help:      "Invoices paid, by payment method.",
+	}, []string{"payment_method"})
+
+	revenue = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revenue_total",
+		Help:      "Total amount of paid invoices, excluding tips, by payment method.",
+	}, []string{"payment_method"})
+
+	refunds = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refunded_total",
+		Help:      "Total amount of refunded invoices.",
+	})
+)
+
+func Routes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/metrics", Handler())
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() gin.HandlerFunc {
+	handler := promhttp.Handler()
+	return func(c *gin.Context) {
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Middleware records the count and latency of every request, labelled by the
+// route pattern rather than the raw path so ids don't explode the series.
+// It should be installed before any middleware that can abort the request.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := c.Writer.Status()
+
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+
+		if status == 401 {
+			authFailures.WithLabelValues(route).Inc()
+		}
+	}
+}
+
+// RecordEvents keeps the business counters in step with the domain events
+// published on bus.
+func RecordEvents(bus *events.Bus) {
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		switch event.Type {
+		case events.OrderCreated:
+			ordersCreated.Inc()
+		case events.OrderItemFired:
+			itemsFired.Inc()
+		case events.InvoicePaid:
+			invoice, ok := event.Data.(models.Invoice)
+			if !ok {
+				return
+			}
+			method := paymentMethod(invoice)
+			invoicesPaid.WithLabelValues(method).Inc()
+			revenue.WithLabelValues(method).Add(invoice.Total_amount)
+		case events.InvoiceRefunded:
+			invoice, ok := event.Data.(models.Invoice)
+			if !ok {
+				return
+			}
+			refunds.Add(invoice.Total_amount)
+		}
+	})
+}
+
+func paymentMethod(invoice models.Invoice) string {
+	if invoice.Payment_Method == nil {
+		return "unknown"
+	}
+	return *invoice.Payment_Method
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+func TestMiddlewareLabels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	httpRequests.Reset()
+	httpDuration.Reset()
+	authFailures.Reset()
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/orders/:order_id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/users/me", func(c *gin.Context) { c.AbortWithStatus(http.StatusUnauthorized) })
+
+	get := func(path string) {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	for i := 0; i < 50; i++ {
+		get("/orders/o" + strconv.Itoa(i))
+		get("/scan/" + strconv.Itoa(i))
+	}
+	get("/users/me")
+
+	tests := []struct {
+		route  string
+		status string
+		want   float64
+	}{
+		{"/orders/:order_id", "200", 50},
+		{"unmatched", "404", 50},
+		{"/users/me", "401", 1},
+	}
+	for _, test := range tests {
+		if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, test.route, test.status)); got != test.want {
+			t.Errorf("requests to %s with %s = %v, want %v", test.route, test.status, got, test.want)
+		}
+	}
+
+	// A hundred distinct paths make one series per route, not per path.
+	if series := testutil.CollectAndCount(httpRequests); series != len(tests) {
+		t.Errorf("http_requests_total has %d series, want %d", series, len(tests))
+	}
+	if series := testutil.CollectAndCount(httpDuration); series != len(tests) {
+		t.Errorf("http_request_duration_seconds has %d series, want %d", series, len(tests))
+	}
+
+	if got := testutil.ToFloat64(authFailures.WithLabelValues("/users/me")); got != 1 {
+		t.Errorf("auth failures on /users/me = %v, want 1", got)
+	}
+	if series := testutil.CollectAndCount(authFailures); series != 1 {
+		t.Errorf("auth_failures_total has %d series, want only the 401", series)
+	}
+}
+
+func TestRecordEvents(t *testing.T) {
+	invoicesPaid.Reset()
+	revenue.Reset()
+
+	bus := events.NewBus()
+	RecordEvents(bus)
+
+	card := "CARD"
+	ctx := context.Background()
+	bus.Publish(ctx, events.Event{Type: events.InvoicePaid, Data: models.Invoice{Payment_Method: &card, Total_amount: 20}})
+	bus.Publish(ctx, events.Event{Type: events.InvoicePaid, Data: models.Invoice{Payment_Method: &card, Total_amount: 5.5}})
+	bus.Publish(ctx, events.Event{Type: events.InvoicePaid, Data: models.Invoice{Total_amount: 3}})
+	// Events carrying something other than an invoice are skipped.
+	bus.Publish(ctx, events.Event{Type: events.InvoicePaid, Data: "inv1"})
+
+	tests := []struct {
+		method  string
+		paid    float64
+		revenue float64
+	}{
+		{"CARD", 2, 25.5},
+		{"unknown", 1, 3},
+	}
+	for _, test := range tests {
+		if got := testutil.ToFloat64(invoicesPaid.WithLabelValues(test.method)); got != test.paid {
+			t.Errorf("invoices paid by %s = %v, want %v", test.method, got, test.paid)
+		}
+		if got := testutil.ToFloat64(revenue.WithLabelValues(test.method)); got != test.revenue {
+			t.Errorf("revenue by %s = %v, want %v", test.method, got, test.revenue)
+		}
+	}
+	if series := testutil.CollectAndCount(invoicesPaid); series != len(tests) {
+		t.Errorf("invoices_paid_total has %d series, want %d", series, len(tests))
+	}
+}
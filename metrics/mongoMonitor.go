@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "MongoDB command latency by collection and command.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"collection", "command"})
+
+	mongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_errors_total",
+		Help:      "Failed MongoDB commands by collection and command.",
+	}, []string{"collection", "command"})
+)
+
+// MongoMonitor returns a command monitor that times every command the driver
+// sends, so every collection is covered without touching the code that uses
+// it. Commands that aren't about a collection, such as ping or
+// commitTransaction, are labelled with an empty collection.
+func MongoMonitor() *event.CommandMonitor {
+	// The finished events don't carry the command, so the collection is
+	// remembered from the started event until the command finishes.
+	var inFlight sync.Map
+
+	collectionOf := func(connectionID string, requestID int64) string {
+		key := commandKey{connectionID, requestID}
+		collection, _ := inFlight.LoadAndDelete(key)
+		name, _ := collection.(string)
+		return name
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			collection, _ := evt.Command.Lookup(evt.CommandName).StringValueOK()
+			inFlight.Store(commandKey{evt.ConnectionID, evt.RequestID}, collection)
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			collection := collectionOf(evt.ConnectionID, evt.RequestID)
+			mongoDuration.WithLabelValues(collection, evt.CommandName).Observe(seconds(evt.DurationNanos))
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			collection := collectionOf(evt.ConnectionID, evt.RequestID)
+			mongoDuration.WithLabelValues(collection, evt.CommandName).Observe(seconds(evt.DurationNanos))
+			mongoErrors.WithLabelValues(collection, evt.CommandName).Inc()
+		},
+	}
+}
+
+type commandKey struct {
+	connectionID string
+	requestID    int64
+}
+
+func seconds(nanos int64) float64 {
+	return time.Duration(nanos).Seconds()
+}
@@ -1,33 +1,63 @@
 package middleware
 
 import (
-	"net/http"
+	"errors"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
 	"github.com/teandresmith/restaurant-project/helpers"
 )
 
-
+// Authentication accepts either "Bearer <jwt>" for users or "ApiKey <key>"
+// for machine clients in the Authorization header. A bare JWT is still
+// accepted for clients written before the schemes were introduced.
 func Authentication() gin.HandlerFunc{
 	return func(c *gin.Context) {
-		token := c.Request.Header.Get("Authorization")
+		header := c.Request.Header.Get("Authorization")
 
-		if token == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"message": "No Authorization header provided",
-			})
+		if header == "" {
+			apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "No Authorization header provided"))
 			return
 		}
 
-		claims, message :=  helpers.VerifyToken(token); 
-		if message != "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"message": "Token not valid",
-				"error": message,
-			})
+		token := header
+		if scheme, credentials, found := strings.Cut(header, " "); found {
+			token = strings.TrimSpace(credentials)
+
+			switch strings.ToLower(scheme) {
+			case "bearer":
+			case "apikey":
+				authenticateApiKey(c, token)
+				return
+			default:
+				apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "Unsupported Authorization scheme. Use Bearer or ApiKey"))
+				return
+			}
+		}
+
+		claims, err := helpers.VerifyToken(token)
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Unauthorized, "Token not valid", err))
 			return
 		}
 
+		// PIN sessions only work from their terminal and lock when left idle.
+		if claims.Token_Use == helpers.TokenUsePin {
+			active, err := helpers.TouchTerminalSession(c.Request.Context(), claims.Terminal_id, claims.Session_id, c.GetHeader(helpers.DeviceTokenHeader))
+			if err != nil {
+				apperrors.Respond(c, apperrors.FromDB(err, "terminal"))
+				return
+			}
+
+			if !active {
+				apperrors.Respond(c, apperrors.New(apperrors.SessionLocked, "Terminal session is locked. Enter your PIN again"))
+				return
+			}
+
+			c.Set("terminal_id", claims.Terminal_id)
+		}
+
 		c.Set("first_name", claims.First_Name)
 		c.Set("last_name", claims.Last_Name)
 		c.Set("user_type", claims.User_Type)
@@ -38,3 +68,34 @@ func Authentication() gin.HandlerFunc{
 			
 	}
 }
+
+// verifyApiKey looks up API keys. Tests stand in for the database with it.
+var verifyApiKey = helpers.VerifyApiKey
+
+// authenticateApiKey lets the request through if key is valid and has the
+// scope the route needs.
+func authenticateApiKey(c *gin.Context, key string) {
+	apiKey, err := verifyApiKey(c.Request.Context(), key)
+	if errors.Is(err, helpers.ErrApiKeyInvalid) {
+		apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "API key not valid"))
+		return
+	}
+	if err != nil {
+		apperrors.Respond(c, apperrors.FromDB(err, "API key"))
+		return
+	}
+
+	scope, ok := helpers.RequiredScope(c.Request.Method, c.FullPath())
+	if !ok {
+		apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "This endpoint can't be called with an API key"))
+		return
+	}
+
+	if !helpers.HasScope(apiKey.Scopes, scope) {
+		apperrors.Respond(c, apperrors.New(apperrors.Forbidden, "API key is missing the "+scope+" scope"))
+		return
+	}
+
+	c.Set("api_key_id", apiKey.Api_key_id)
+	c.Next()
+}
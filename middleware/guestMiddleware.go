@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+)
+
+// GuestSession lets through guests with the session token they got by
+// scanning a table's QR code, sent as "Bearer <token>". Staff tokens and API
+// keys are not accepted, so guests only ever reach the guest routes.
+func GuestSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, found := strings.Cut(c.Request.Header.Get("Authorization"), " ")
+		if !found || !strings.EqualFold(scheme, "bearer") {
+			apperrors.Respond(c, apperrors.New(apperrors.Unauthorized, "Scan the QR code on your table to start ordering"))
+			return
+		}
+
+		claims, err := helpers.VerifyGuestToken(strings.TrimSpace(token))
+		if err != nil {
+			apperrors.Respond(c, apperrors.Wrap(apperrors.Unauthorized, "Guest session not valid. Scan the QR code on your table again", err))
+			return
+		}
+
+		c.Set("table_id", claims.Table_id)
+		c.Set("qr_nonce", claims.Qr_Nonce)
+		c.Set("guest_session_id", claims.Session_id)
+		c.Next()
+	}
+}
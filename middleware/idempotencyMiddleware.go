@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var idempotencyStore IdempotencyStore
+var idempotencyTTL = idempotencyTTLFromEnv()
+
+// Setup creates the stores the middleware keeps state in. It must be called
+// with a connected client before the routes are served.
+func Setup(client *mongo.Client) {
+	idempotencyStore = newIdempotencyStore(client)
+	rateLimitStore = newRateLimitStore(client)
+	setupRateLimits()
+}
+
+func newIdempotencyStore(client *mongo.Client) IdempotencyStore {
+	if database.Backend() == database.MemoryBackend {
+		return NewMemoryIdempotencyStore()
+	}
+
+	store := NewMongoIdempotencyStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create idempotency_keys indexes", "error", err)
+	}
+
+	return store
+}
+
+func idempotencyTTLFromEnv() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL"))
+	if err != nil || ttl <= 0 {
+		return 24 * time.Hour
+	}
+	return ttl
+}
+
+// Idempotency makes a create endpoint safe to retry. A request carrying an
+// Idempotency-Key runs once; retries with the same key and body get the
+// stored response replayed, and retries with a different body are refused.
+// Requests without the header are passed through untouched.
+func Idempotency() gin.HandlerFunc {
+	return idempotency(false)
+}
+
+// RequireIdempotency is Idempotency for endpoints that move money. Requests
+// without an Idempotency-Key are rejected.
+func RequireIdempotency() gin.HandlerFunc {
+	return idempotency(true)
+}
+
+func idempotency(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			if required {
+				apperrors.Respond(c, &apperrors.Error{
+					Code:    apperrors.ValidationFailed,
+					Message: "An Idempotency-Key header is required for this endpoint",
+					Fields:  []apperrors.FieldError{{Field: IdempotencyKeyHeader, Rule: "required", Message: "is required"}},
+				})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if len(key) > 255 {
+			apperrors.Respond(c, &apperrors.Error{
+				Code:    apperrors.ValidationFailed,
+				Message: "The Idempotency-Key header is not valid",
+				Fields:  []apperrors.FieldError{{Field: IdempotencyKeyHeader, Rule: "max", Message: "must be at most 255"}},
+			})
+			return
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			apperrors.Respond(c, apperrors.Validation(err))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		// Keys are scoped to the caller so two clients can't collide on, or
+		// read back, each other's responses.
+		scopedKey := c.Request.Method + " " + c.FullPath() + " " + ByUser(c) + " " + key
+
+		now := time.Now()
+		record := IdempotencyRecord{
+			Key:         scopedKey,
+			Fingerprint: fingerprint(c.Request.Method, c.Request.URL.Path, body),
+			Created_At:  now,
+			Expires_At:  now.Add(idempotencyTTL),
+		}
+
+		existing, err := idempotencyStore.Reserve(ctx, record)
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "idempotency key"))
+			return
+		}
+
+		if existing != nil {
+			replayIdempotentResponse(c, existing, record.Fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		c.Next()
+
+		// The outcome is recorded even if the client has gone away in the
+		// meantime, so it is not tied to the request context.
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Server errors are not remembered so the client can retry them.
+		if recorder.Status() >= http.StatusInternalServerError {
+			if err := idempotencyStore.Release(ctx, scopedKey); err != nil {
+				logging.FromContext(c.Request.Context()).Warn("could not release idempotency key", "error", err)
+			}
+			return
+		}
+
+		if err := idempotencyStore.Complete(ctx, scopedKey, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.Header().Get("ETag"), recorder.body.Bytes()); err != nil {
+			logging.FromContext(c.Request.Context()).Warn("could not store idempotent response", "error", err)
+		}
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, existing *IdempotencyRecord, fingerprint string) {
+	if existing.Fingerprint != fingerprint {
+		apperrors.Respond(c, apperrors.New(apperrors.Conflict, "This Idempotency-Key was already used with a different request body"))
+		return
+	}
+
+	if !existing.Completed {
+		apperrors.Respond(c, apperrors.New(apperrors.Conflict, "A request with this Idempotency-Key is still being processed"))
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	// Without it the client couldn't send the If-Match its next write needs.
+	if existing.ETag != "" {
+		c.Header("ETag", existing.ETag)
+	}
+	c.Data(existing.Status, existing.Content_Type, existing.Body)
+	c.Abort()
+}
+
+func fingerprint(method string, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder copies everything the handler writes so it can be stored.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useIdempotencyStore keeps Idempotency-Keys in memory for the test.
+func useIdempotencyStore(t *testing.T) {
+	t.Helper()
+
+	previous := idempotencyStore
+	idempotencyStore = NewMemoryIdempotencyStore()
+	t.Cleanup(func() { idempotencyStore = previous })
+}
+
+// newIdempotentRouter serves POST /orders behind Idempotency with handler,
+// signed in as the user named in the X-User header.
+func newIdempotentRouter(middleware gin.HandlerFunc, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", c.GetHeader("X-User"))
+	})
+	router.POST("/orders", middleware, handler)
+	return router
+}
+
+func postOrder(router *gin.Engine, user string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set("X-User", user)
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// countingHandler creates an order numbered by how many times it has run, at
+// version 1.
+func countingHandler(calls *int32) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n := atomic.AddInt32(calls, 1)
+		c.Header("ETag", `"1"`)
+		c.JSON(http.StatusCreated, gin.H{"order_id": "o" + strconv.Itoa(int(n))})
+	}
+}
+
+func TestIdempotencyReplays(t *testing.T) {
+	useIdempotencyStore(t)
+	var calls int32
+	router := newIdempotentRouter(Idempotency(), countingHandler(&calls))
+
+	first := postOrder(router, "u1", "key-1", `{"table_id":"t1"}`)
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first request: %d %s, want it run", first.Code, first.Body)
+	}
+
+	retry := postOrder(router, "u1", "key-1", `{"table_id":"t1"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: %d %s, want the first response replayed", retry.Code, retry.Body)
+	}
+	if retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Errorf("retry Content-Type = %q, want %q", retry.Header().Get("Content-Type"), first.Header().Get("Content-Type"))
+	}
+	if retry.Header().Get("ETag") != `"1"` {
+		t.Errorf("retry ETag = %q, want %q", retry.Header().Get("ETag"), `"1"`)
+	}
+
+	if changed := postOrder(router, "u1", "key-1", `{"table_id":"t2"}`); changed.Code != http.StatusConflict {
+		t.Errorf("same key with another body: %d, want %d", changed.Code, http.StatusConflict)
+	}
+
+	// Keys belong to the caller that sent them.
+	if other := postOrder(router, "u2", "key-1", `{"table_id":"t1"}`); other.Code != http.StatusCreated || other.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("another user's request: %d, want it run", other.Code)
+	}
+
+	// Without a key every request runs.
+	postOrder(router, "u1", "", `{"table_id":"t1"}`)
+	postOrder(router, "u1", "", `{"table_id":"t1"}`)
+
+	if calls != 4 {
+		t.Errorf("the handler ran %d times, want 4", calls)
+	}
+}
+
+func TestIdempotencyConcurrentRetries(t *testing.T) {
+	useIdempotencyStore(t)
+
+	var calls int32
+	started, finish := make(chan struct{}), make(chan struct{})
+	router := newIdempotentRouter(Idempotency(), func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-finish
+		c.JSON(http.StatusCreated, gin.H{"order_id": "o1"})
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postOrder(router, "u1", "key-1", `{}`) }()
+	<-started
+
+	// Retries sent while the first request is running are turned away.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := postOrder(router, "u1", "key-1", `{}`)
+			mu.Lock()
+			statuses[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	close(finish)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Errorf("first request: %d, want %d", first.Code, http.StatusCreated)
+	}
+	if statuses[http.StatusConflict] != 8 {
+		t.Errorf("statuses while in progress = %v, want 8 Conflict", statuses)
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want once", calls)
+	}
+
+	if after := postOrder(router, "u1", "key-1", `{}`); after.Code != http.StatusCreated || after.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry once finished: %d, want the response replayed", after.Code)
+	}
+}
+
+func TestIdempotencyRetriesServerErrors(t *testing.T) {
+	useIdempotencyStore(t)
+
+	var calls int32
+	router := newIdempotentRouter(Idempotency(), func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"order_id": "o1"})
+	})
+
+	if w := postOrder(router, "u1", "key-1", `{}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first request: %d, want it to fail", w.Code)
+	}
+	if w := postOrder(router, "u1", "key-1", `{}`); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry after a server error: %d, want it run again", w.Code)
+	}
+}
+
+func TestIdempotencyKeysExpire(t *testing.T) {
+	useIdempotencyStore(t)
+	previous := idempotencyTTL
+	idempotencyTTL = time.Millisecond
+	defer func() { idempotencyTTL = previous }()
+
+	var calls int32
+	router := newIdempotentRouter(Idempotency(), countingHandler(&calls))
+
+	postOrder(router, "u1", "key-1", `{}`)
+	time.Sleep(5 * time.Millisecond)
+	if w := postOrder(router, "u1", "key-1", `{}`); w.Header().Get("Idempotent-Replayed") != "" || calls != 2 {
+		t.Errorf("reused expired key: replayed %q after %d calls, want it run again", w.Header().Get("Idempotent-Replayed"), calls)
+	}
+}
+
+func TestRequireIdempotency(t *testing.T) {
+	useIdempotencyStore(t)
+	var calls int32
+	router := newIdempotentRouter(RequireIdempotency(), countingHandler(&calls))
+
+	if w := postOrder(router, "u1", "", `{}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), IdempotencyKeyHeader) {
+		t.Errorf("no key: %d %s, want a validation error about the header", w.Code, w.Body)
+	}
+	if w := postOrder(router, "u1", strings.Repeat("k", 256), `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("long key: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := postOrder(router, "u1", "key-1", `{}`); w.Code != http.StatusCreated {
+		t.Errorf("with a key: %d, want %d", w.Code, http.StatusCreated)
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want once", calls)
+	}
+}
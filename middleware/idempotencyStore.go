@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+type IdempotencyRecord struct {
+	Key          string    `bson:"key"`
+	Fingerprint  string    `bson:"fingerprint"`
+	Completed    bool      `bson:"completed"`
+	Status       int       `bson:"status"`
+	Content_Type string    `bson:"content_type"`
+	ETag         string    `bson:"etag,omitempty"`
+	Body         []byte    `bson:"body"`
+	Created_At   time.Time `bson:"created_at"`
+	Expires_At   time.Time `bson:"expires_at"`
+}
+
+// IdempotencyStore remembers which Idempotency-Keys have been seen and the
+// response each one produced.
+type IdempotencyStore interface {
+	// Reserve claims record.Key for a request that is about to run. When the key
+	// is already held by an unexpired record that record is returned instead
+	// and nothing is written.
+	Reserve(ctx context.Context, record IdempotencyRecord) (existing *IdempotencyRecord, err error)
+	// Complete stores the response for a reserved key so retries can replay it.
+	// etag is the version the response was written at, empty if it has none.
+	Complete(ctx context.Context, key string, status int, contentType string, etag string, body []byte) error
+	// Release forgets a reserved key so the request can be tried again.
+	Release(ctx context.Context, key string) error
+}
+
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: map[string]IdempotencyRecord{}}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[record.Key]; ok && time.Now().Before(existing.Expires_At) {
+		return &existing, nil
+	}
+
+	s.records[record.Key] = record
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, status int, contentType string, etag string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	record.Completed = true
+	record.Status = status
+	record.Content_Type = contentType
+	record.ETag = etag
+	record.Body = body
+	s.records[key] = record
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// MongoIdempotencyStore keeps records in the idempotency_keys collection. A
+// unique index on key makes Reserve safe across server instances and a TTL
+// index lets Mongo reap expired records.
+type MongoIdempotencyStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoIdempotencyStore(client *mongo.Client) *MongoIdempotencyStore {
+	return &MongoIdempotencyStore{collection: database.OpenCollection(client, "idempotency_keys")}
+}
+
+func (s *MongoIdempotencyStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (s *MongoIdempotencyStore) Reserve(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	// The TTL monitor only runs about once a minute, so an expired record may
+	// still be present. Clear it first so the key can be reused.
+	_, err := s.collection.DeleteOne(ctx, bson.M{"key": record.Key, "expires_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.collection.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var existing IdempotencyRecord
+	if err := s.collection.FindOne(ctx, bson.M{"key": record.Key}).Decode(&existing); err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+func (s *MongoIdempotencyStore) Complete(ctx context.Context, key string, status int, contentType string, etag string, body []byte) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "completed", Value: true},
+		{Key: "status", Value: status},
+		{Key: "content_type", Value: contentType},
+		{Key: "etag", Value: etag},
+		{Key: "body", Value: body},
+	}}}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"key": key}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *MongoIdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"key": key, "completed": false})
+	return err
+}
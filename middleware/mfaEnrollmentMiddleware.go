@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+)
+
+// mfaEnrollmentRoutes are all a user whose role requires two-factor
+// authentication can reach until they have set it up.
+var mfaEnrollmentRoutes = map[string]bool{
+	"POST /mfa/enroll":          true,
+	"POST /mfa/confirm":         true,
+	"POST /email/verify/resend": true,
+}
+
+// RequireMfaEnrollment keeps users whose role is listed in MFA_REQUIRED_ROLES
+// out of everything but enrollment until they have turned on two-factor
+// authentication. Asking for a new verification email is allowed as well,
+// since enrolling is a write and needs a verified address.
+// It must run after Authentication.
+func RequireMfaEnrollment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helpers.MfaRequired(c.GetString("user_type")) || mfaEnrollmentRoutes[c.Request.Method+" "+c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		enabled, err := helpers.IsMfaEnabled(c.Request.Context(), c.GetString("uid"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if !enabled {
+			apperrors.Respond(c, apperrors.New(apperrors.MfaEnrollmentRequired, "Set up two-factor authentication with POST /mfa/enroll to continue"))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+
+func newRateLimitStore(client *mongo.Client) RateLimitStore {
+	if database.Backend() == database.MemoryBackend {
+		return NewMemoryRateLimitStore()
+	}
+
+	store := NewMongoRateLimitStore(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := store.EnsureIndexes(ctx); err != nil {
+		slog.Warn("could not create rate_limits indexes", "error", err)
+	}
+
+	return store
+}
+
+var (
+	ipRateLimit    = RateLimit{Requests: 300, Per: time.Minute}
+	userRateLimit  = RateLimit{Requests: 600, Per: time.Minute}
+	loginRateLimit = RateLimit{Requests: 10, Per: time.Minute}
+	tableRateLimit = RateLimit{Requests: 10, Per: 10 * time.Minute}
+)
+
+// setupRateLimits reads the configured limits. Limits that are not set keep
+// their defaults.
+func setupRateLimits() {
+	ipRateLimit = rateLimitFromEnv("RATE_LIMIT_IP", ipRateLimit)
+	userRateLimit = rateLimitFromEnv("RATE_LIMIT_USER", userRateLimit)
+	loginRateLimit = rateLimitFromEnv("RATE_LIMIT_LOGIN", loginRateLimit)
+	tableRateLimit = rateLimitFromEnv("RATE_LIMIT_TABLE", tableRateLimit)
+}
+
+// KeyFunc picks the bucket a request is counted against.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client address.
+func ByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// ByUser counts requests per authenticated user, API key or guest session,
+// falling back to the client address for anonymous requests. It must run
+// after Authentication or GuestSession.
+func ByUser(c *gin.Context) string {
+	if uid := c.GetString("uid"); uid != "" {
+		return "user:" + uid
+	}
+	if apiKeyId := c.GetString("api_key_id"); apiKeyId != "" {
+		return "api_key:" + apiKeyId
+	}
+	if guestSessionId := c.GetString("guest_session_id"); guestSessionId != "" {
+		return "guest:" + guestSessionId
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// ByTable counts requests per table for guests ordering from their table,
+// however many phones they use. It must run after GuestSession.
+func ByTable(c *gin.Context) string {
+	return "table:" + c.GetString("table_id")
+}
+
+// ByRoute counts every request for the route pattern together, whoever
+// sends it.
+func ByRoute(c *gin.Context) string {
+	return c.Request.Method + " " + c.FullPath()
+}
+
+// RateLimitBy rejects requests once the bucket chosen by key is empty, with
+// a 429 and a Retry-After header. name keeps the buckets of different
+// limiters apart.
+func RateLimitBy(name string, limit RateLimit, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter, err := rateLimitStore.Take(c.Request.Context(), name+":"+key(c), limit, time.Now())
+		if err != nil {
+			// A broken limiter shouldn't take the API down with it.
+			logging.FromContext(c.Request.Context()).Warn("could not check rate limit", "limiter", name, "error", err)
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			apperrors.Respond(c, &apperrors.Error{
+				Code:       apperrors.RateLimited,
+				Message:    "Too many requests. Please try again later",
+				RetryAfter: retryAfter,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// LimitByIP applies RATE_LIMIT_IP to every request from a client address.
+func LimitByIP() gin.HandlerFunc {
+	return RateLimitBy("ip", ipRateLimit, ByIP)
+}
+
+// LimitByUser applies RATE_LIMIT_USER to every request from a signed in user.
+func LimitByUser() gin.HandlerFunc {
+	return RateLimitBy("user", userRateLimit, ByUser)
+}
+
+// LimitLogin applies the stricter RATE_LIMIT_LOGIN to login attempts from a
+// client address.
+func LimitLogin() gin.HandlerFunc {
+	return RateLimitBy("login", loginRateLimit, ByIP)
+}
+
+// LimitByTable applies RATE_LIMIT_TABLE to the orders guests send from a
+// table.
+func LimitByTable() gin.HandlerFunc {
+	return RateLimitBy("table", tableRateLimit, ByTable)
+}
+
+// rateLimitFromEnv reads a limit written as "<requests>/<duration>", such as
+// "100/1m".
+func rateLimitFromEnv(name string, fallback RateLimit) RateLimit {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	limit, err := ParseRateLimit(value)
+	if err != nil {
+		slog.Warn("ignoring invalid rate limit", "variable", name, "error", err)
+		return fallback
+	}
+	return limit
+}
+
+func ParseRateLimit(value string) (RateLimit, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not <requests>/<duration>", value)
+	}
+
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests < 1 {
+		return RateLimit{}, fmt.Errorf("rate limit %q needs a positive request count", value)
+	}
+
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q needs a positive duration", value)
+	}
+
+	return RateLimit{Requests: requests, Per: per}, nil
+}
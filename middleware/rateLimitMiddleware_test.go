@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 3, Per: 3 * time.Second}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := store.Take(context.Background(), "k", limit, now); !allowed {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+
+	allowed, retryAfter, _ := store.Take(context.Background(), "k", limit, now)
+	if allowed || retryAfter != time.Second {
+		t.Fatalf("over the limit: allowed %v, retry after %v, want refused for 1s", allowed, retryAfter)
+	}
+
+	if allowed, _, _ := store.Take(context.Background(), "other", limit, now); !allowed {
+		t.Error("another key shares the bucket")
+	}
+
+	if allowed, _, _ := store.Take(context.Background(), "k", limit, now.Add(time.Second)); !allowed {
+		t.Error("refused once a token had refilled")
+	}
+}
+
+func TestRateLimitBy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := rateLimitStore
+	rateLimitStore = NewMemoryRateLimitStore()
+	defer func() { rateLimitStore = previous }()
+
+	router := gin.New()
+	router.Use(RateLimitBy("test", RateLimit{Requests: 5, Per: time.Minute}, ByIP))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// Requests sent at once take from the bucket one at a time.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "203.0.113.7:5000"
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			mu.Lock()
+			statuses[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if statuses[http.StatusOK] != 5 || statuses[http.StatusTooManyRequests] != 15 {
+		t.Errorf("statuses = %v, want 5 OK and 15 Too Many Requests", statuses)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Retry-After") != "12" {
+		t.Errorf("Retry-After = %q, want 12", w.Header().Get("Retry-After"))
+	}
+}
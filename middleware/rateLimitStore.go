@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimit allows Requests requests per Per, in bursts of up to Requests.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// RateLimitStore holds one token bucket per key.
+type RateLimitStore interface {
+	// Take spends a token from key's bucket. When the bucket is empty it
+	// reports false and how long until a token will be available.
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	refill  time.Duration
+}
+
+// MemoryRateLimitStore keeps buckets in process, for the memory backend.
+// Each server instance enforces its own limits, so the effective limit behind
+// a load balancer would be the configured one times the number of instances.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	burst := float64(limit.Requests)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, updated: now, refill: limit.Per}
+		s.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.updated).Seconds() * limit.rate()
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / limit.rate() * float64(time.Second))
+		return false, wait, nil
+	}
+
+	bucket.tokens--
+	return true, 0, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again, so
+// callers that went away don't hold memory forever.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.updated) > bucket.refill {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// MongoRateLimitStore keeps buckets in MongoDB, so every instance takes from
+// the same ones.
+type MongoRateLimitStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoRateLimitStore(client *mongo.Client) *MongoRateLimitStore {
+	return &MongoRateLimitStore{collection: database.OpenCollection(client, "rate_limits")}
+}
+
+// EnsureIndexes expires buckets once they have been idle long enough to be
+// full again.
+func (s *MongoRateLimitStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Take refills and spends from the bucket with a single pipeline update, so
+// two requests can't both spend the last token.
+func (s *MongoRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	burst := float64(limit.Requests)
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated", now}}}}, 1000}}
+	refilled := bson.M{"$min": bson.A{burst, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", burst}},
+		bson.M{"$multiply": bson.A{elapsed, limit.rate()}},
+	}}}}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"refilled": refilled}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed":    bson.M{"$gte": bson.A{"$refilled", 1}},
+			"tokens":     bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$refilled", 1}}, bson.M{"$subtract": bson.A{"$refilled", 1}}, "$refilled"}},
+			"updated":    now,
+			"expires_at": now.Add(limit.Per),
+		}}},
+		{{Key: "$unset", Value: "refilled"}},
+	}
+
+	var bucket struct {
+		Allowed bool    `bson:"allowed"`
+		Tokens  float64 `bson:"tokens"`
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&bucket); err != nil {
+		return false, 0, err
+	}
+
+	if !bucket.Allowed {
+		return false, time.Duration((1 - bucket.Tokens) / limit.rate() * float64(time.Second)), nil
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/logging"
+)
+
+// Recovery turns a panic in a later handler into an internal error, logged
+// with its stack and answered as problem+json, so one bad request doesn't
+// take the server down or leave the client without an answer.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Handlers panic with this on purpose to drop the connection.
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			err := apperrors.Wrap(apperrors.Internal, "Handler panicked", fmt.Errorf("panic: %v\n%s", recovered, debug.Stack()))
+
+			// Part of the response has gone out, so all that is left is to
+			// log it and stop.
+			if c.Writer.Written() {
+				ctx := c.Request.Context()
+				logging.FromContext(ctx).ErrorContext(ctx, "internal error", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+				c.Abort()
+				return
+			}
+			apperrors.Respond(c, err)
+		}()
+
+		c.Next()
+	}
+}
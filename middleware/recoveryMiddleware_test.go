@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+)
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Recovery())
+	router.GET("/panic", func(c *gin.Context) { panic("token rk_secret leaked") })
+	router.GET("/abort", func(c *gin.Context) { panic(http.ErrAbortHandler) })
+	router.GET("/written", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("too late")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != apperrors.ProblemContentType {
+		t.Fatalf("panic answered %d %s, want %d %s", w.Code, w.Header().Get("Content-Type"), http.StatusInternalServerError, apperrors.ProblemContentType)
+	}
+	var problem apperrors.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Detail != "An internal error occurred. Please try again later" {
+		t.Errorf("detail = %q, want the generic internal error", problem.Detail)
+	}
+	if body := w.Body.String(); strings.Contains(body, "rk_secret") || strings.Contains(body, "goroutine") {
+		t.Errorf("the panic or its stack reached the client: %s", body)
+	}
+
+	// Once the response has started it is left as it is.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/written", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("panic after writing answered %d %q, want the partial response", w.Code, w.Body)
+	}
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want %v passed on", recovered, http.ErrAbortHandler)
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+	}()
+}
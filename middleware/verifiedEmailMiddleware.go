@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/helpers"
+)
+
+// unverifiedWrites are the only changes a user who hasn't verified their
+// email address may make: asking for a new link and fixing a mistyped
+// address.
+var unverifiedWrites = map[string]bool{
+	"POST /email/verify/resend": true,
+	"PATCH /users/:user_id":     true,
+}
+
+// RequireVerifiedEmail limits users who haven't verified their email address
+// to reading. It must run after Authentication.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		// API keys belong to no user; their scopes decide what they may do.
+		if unverifiedWrites[c.Request.Method+" "+c.FullPath()] || c.GetString("api_key_id") != "" {
+			c.Next()
+			return
+		}
+
+		verified, err := helpers.IsEmailVerified(c.Request.Context(), c.GetString("uid"))
+		if err != nil {
+			apperrors.Respond(c, apperrors.FromDB(err, "user"))
+			return
+		}
+
+		if !verified {
+			apperrors.Respond(c, apperrors.New(apperrors.EmailNotVerified, "Verify your email address before making changes"))
+			return
+		}
+
+		c.Next()
+	}
+}
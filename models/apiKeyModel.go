@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ApiKey lets a machine client such as a delivery aggregator call the API
+// without a user. Only the SHA-256 of the key is stored.
+type ApiKey struct{
+	ID				primitive.ObjectID		`bson:"_id"`
+	Api_key_id		string					`json:"api_key_id"`
+	Name			*string					`json:"name" validate:"required,min=2,max=100"`
+	Scopes			[]string				`json:"scopes" validate:"required,min=1"`
+	Prefix			string					`json:"prefix"`
+	Key_Hash		string					`json:"-"`
+	Expires_At		*time.Time				`json:"expires_at"`
+	Last_Used_At	*time.Time				`json:"last_used_at"`
+	Revoked_At		*time.Time				`json:"revoked_at"`
+	Replaced_By		*string					`json:"replaced_by"`
+	Created_By		string					`json:"created_by"`
+	Created_At		time.Time				`json:"created_at"`
+	Updated_At		time.Time				`json:"updated_at"`
+	Version			int64					`json:"version"`
+}
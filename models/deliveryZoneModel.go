@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeliveryZone is an area the restaurant delivers to, either a polygon drawn
+// on the map or everywhere within a radius of the restaurant.
+type DeliveryZone struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Zone_id				string					`json:"zone_id"`
+	Name				*string					`json:"name" validate:"required,min=1,max=100"`
+	// Area is left out rather than stored as null so radius zones stay out of
+	// the 2dsphere index.
+	Area				*GeoPolygon				`json:"area" bson:"area,omitempty"`
+	Radius_Meters		*float64				`json:"radius_meters" validate:"omitempty,gt=0,max=100000"`
+	Min_Order			*float64				`json:"min_order" validate:"omitempty,min=0"`
+	Delivery_Fee		*float64				`json:"delivery_fee" validate:"required,min=0"`
+	Eta_Bands			[]EtaBand				`json:"eta_bands" validate:"omitempty,max=10,dive"`
+	Created_At			time.Time				`json:"created_at"`
+	Updated_At			time.Time				`json:"updated_at"`
+	Version				int64					`json:"version"`
+	Created_By			*Actor					`json:"created_by"`
+	Updated_By			*Actor					`json:"updated_by"`
+}
+
+// GeoPolygon is a GeoJSON polygon. Coordinates are an outer ring followed by
+// any holes, each a closed list of [longitude, latitude] positions.
+type GeoPolygon struct{
+	Type				string					`json:"type" validate:"eq=Polygon"`
+	Coordinates			[][][]float64			`json:"coordinates" validate:"required,min=1"`
+}
+
+// EtaBand is how long deliveries take to addresses up to a distance from the
+// restaurant.
+type EtaBand struct{
+	Up_To_Meters		float64					`json:"up_to_meters" validate:"gt=0"`
+	Min_Minutes			int						`json:"min_minutes" validate:"min=1"`
+	Max_Minutes			int						`json:"max_minutes" validate:"gtefield=Min_Minutes"`
+}
+
+// DeliveryQuote is what delivering an order costs and how long it takes, as
+// worked out from the zone its address is in when the order was placed.
+type DeliveryQuote struct{
+	Zone_id				string					`json:"zone_id"`
+	Zone_Name			string					`json:"zone_name"`
+	Delivery_Fee		float64					`json:"delivery_fee"`
+	Min_Order			float64					`json:"min_order"`
+	Distance_Meters		*float64				`json:"distance_meters"`
+	Eta_Min_Minutes		int						`json:"eta_min_minutes"`
+	Eta_Max_Minutes		int						`json:"eta_max_minutes"`
+}
@@ -13,6 +13,7 @@ type Food struct{
 	Image			*string						`json:"image" validate:"required"`
 	Created_At		time.Time					`json:"created_at"`
 	Updated_At		time.Time					`json:"updated_at"`
+	Version			int64						`json:"version"`
 	Food_ID			string						`json:"food_id"`
 	Menu_ID			*string						`json:"menu_id" validate:"required"`
 }
\ No newline at end of file
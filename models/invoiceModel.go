@@ -11,9 +11,14 @@ type Invoice struct{
 	ID						primitive.ObjectID			`bson:"_id"`
 	Invoice_id				string						`json:"invoice_id"`
 	Order_id				string						`json:"order_id"`
-	Payment_Method			*string						`json:"payment_method" validate:"required,eq=CARD|eq=CASH|eq="""`
-	Payment_status			*string						`json:"payment_status" validate:"required,eq=PENDING|eq=PAID"`
+	Payment_Method			*string						`json:"payment_method" validate:"required,eq=CARD|eq=CASH"`
+	Payment_status			*string						`json:"payment_status" validate:"required,eq=PENDING|eq=PAID|eq=REFUNDED"`
 	Payment_due_date		time.Time					`json:"payment_due_date"`
+	Total_amount			float64						`json:"total_amount"`
+	Delivery_Fee			float64						`json:"delivery_fee"`
 	Created_At				time.Time					`json:"created_at"`
 	Updated_At				time.Time					`json:"updated_at"`
+	Version					int64						`json:"version"`
+	Created_By				*Actor						`json:"created_by"`
+	Updated_By				*Actor						`json:"updated_by"`
 }
\ No newline at end of file
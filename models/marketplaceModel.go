@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MenuMapping says which food a delivery marketplace's menu item is.
+type MenuMapping struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Provider			string					`json:"provider"`
+	External_item_id	string					`json:"external_item_id"`
+	Food_id				*string					`json:"food_id" validate:"required"`
+	Updated_At			time.Time				`json:"updated_at"`
+	Updated_By			*Actor					`json:"updated_by"`
+}
@@ -14,5 +14,6 @@ type Menu struct{
 	End_Date			*time.Time					`json:"end_date"`
 	Created_At			time.Time					`json:"created_at"`
 	Updated_At			time.Time					`json:"updated_at"`
+	Version				int64						`json:"version"`
 	Menu_Id				string						`json:"menu_id"`
 }
\ No newline at end of file
@@ -12,5 +12,6 @@ type Note struct{
 	Title			string						`json:"title" validate:"required"`
 	Created_At		time.Time					`json:"created_at"`
 	Updated_At		time.Time					`json:"updated_at"`
+	Version			int64						`json:"version"`
 	Note_id			string						`json:"note_id"`
 }
\ No newline at end of file
@@ -12,7 +12,14 @@ type OrderItem struct{
 	Unit_Price		*float64					`json:"unit_price" validate:"required"`
 	Created_At		time.Time					`json:"created_at"`
 	Updated_At		time.Time					`json:"updated_at"`
+	Version			int64						`json:"version"`
 	Food_id			*string						`json:"food_id" validate:"required"`
 	Order_item_id	 string						`json:"order_item_id"`
 	Order_id		*string						`json:"order_id"`
+	Created_By		*Actor						`json:"created_by"`
+	Updated_By		*Actor						`json:"updated_by"`
+	Approval_Status	*string						`json:"approval_status"`
+	Reviewed_At		*time.Time					`json:"reviewed_at"`
+	Reviewed_By		*Actor						`json:"reviewed_by"`
+	Reject_Reason	*string						`json:"reject_reason"`
 }
\ No newline at end of file
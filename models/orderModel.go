@@ -12,6 +12,47 @@ type Order struct{
 	Order_Date			time.Time				`json:"order_date" validate:"required"`
 	Created_At			time.Time				`json:"created_at"`
 	Updated_At			time.Time				`json:"updated_at"`
+	Version				int64					`json:"version"`
 	Order_id			string					`json:"order_id"`
-	Table_id			*string					`json:"table_id" validate:"required"`
-}
\ No newline at end of file
+	Table_id			*string					`json:"table_id"`
+	Order_type			*string					`json:"order_type" validate:"omitempty,eq=DINE_IN|eq=TAKEOUT|eq=DELIVERY|eq=PICKUP"`
+	Customer			*Customer				`json:"customer"`
+	Delivery_Address	*Address				`json:"delivery_address"`
+	Promised_At			*time.Time				`json:"promised_at"`
+	Delivery_Quote		*DeliveryQuote			`json:"delivery_quote"`
+	External_Ref		*ExternalRef			`json:"external_ref"`
+	Server_id			*string					`json:"server_id"`
+	Order_status		*string					`json:"order_status" validate:"omitempty,eq=OPEN|eq=PAID"`
+	Invoice_id			*string					`json:"invoice_id"`
+	Paid_At				*time.Time				`json:"paid_at"`
+	Created_By			*Actor					`json:"created_by"`
+	Updated_By			*Actor					`json:"updated_by"`
+}
+
+// ExternalRef is the order a delivery marketplace knows an order by.
+type ExternalRef struct{
+	Provider			string					`json:"provider"`
+	External_id			string					`json:"external_id"`
+	Display_id			string					`json:"display_id"`
+	Last_Status			string					`json:"last_status"`
+}
+
+// Customer is who a takeout, pickup or delivery order is for.
+type Customer struct{
+	Name				string					`json:"name" validate:"required,max=100"`
+	Phone				string					`json:"phone" validate:"omitempty,e164"`
+	Email				string					`json:"email" validate:"omitempty,email"`
+}
+
+// Address is where a delivery order goes.
+type Address struct{
+	Line1				string					`json:"line1" validate:"required,max=200"`
+	Line2				string					`json:"line2" validate:"max=200"`
+	City				string					`json:"city" validate:"required,max=100"`
+	Postal_Code			string					`json:"postal_code" validate:"max=20"`
+	Region				string					`json:"region" validate:"max=100"`
+	Country				string					`json:"country" validate:"max=100"`
+	Instructions		string					`json:"instructions" validate:"max=500"`
+	Latitude			*float64				`json:"latitude" validate:"omitempty,min=-90,max=90"`
+	Longitude			*float64				`json:"longitude" validate:"omitempty,min=-180,max=180"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+
+type Payment struct{
+	ID					primitive.ObjectID			`bson:"_id"`
+	Payment_id			string						`json:"payment_id"`
+	Invoice_id			string						`json:"invoice_id"`
+	Order_id			string						`json:"order_id"`
+	Payment_Method		*string						`json:"payment_method" validate:"required,eq=CARD|eq=CASH"`
+	Payment_type		string						`json:"payment_type"`
+	Amount				float64						`json:"amount"`
+	Tip					float64						`json:"tip"`
+	Created_At			time.Time					`json:"created_at"`
+	Version				int64						`json:"version"`
+	Created_By			*Actor						`json:"created_by"`
+}
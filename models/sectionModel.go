@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Section is an area of the floor plan, such as the patio or the bar side of
+// the main room, usually looked after by one server.
+type Section struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Section_id			string					`json:"section_id"`
+	Name				*string					`json:"name" validate:"required,min=1,max=100"`
+	Room				*string					`json:"room" validate:"required,min=1,max=100"`
+	Server_id			*string					`json:"server_id"`
+	Sort_Order			*int					`json:"sort_order"`
+	Created_At			time.Time				`json:"created_at"`
+	Updated_At			time.Time				`json:"updated_at"`
+	Version				int64					`json:"version"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Station is a part of the kitchen, such as the grill, the fryer or the bar,
+// that prepares the items routed to it from its own queue.
+type Station struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Station_id			string					`json:"station_id"`
+	Name				*string					`json:"name" validate:"required,min=1,max=50"`
+	Created_At			time.Time				`json:"created_at"`
+	Updated_At			time.Time				`json:"updated_at"`
+	Version				int64					`json:"version"`
+	Created_By			*Actor					`json:"created_by"`
+	Updated_By			*Actor					`json:"updated_by"`
+}
+
+// RoutingRule sends fired order items to a station. A rule is for one food,
+// for the foods on menus of a category, or, with neither, for every item no
+// other rule matches.
+type RoutingRule struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Rule_id				string					`json:"rule_id"`
+	Food_id				*string					`json:"food_id" validate:"omitempty,min=1"`
+	Category			*string					`json:"category" validate:"omitempty,min=1,max=50"`
+	Station_id			*string					`json:"station_id" validate:"required"`
+	// Match is what the rule applies to, which only one rule can have.
+	Match				string					`json:"-"`
+	Created_At			time.Time				`json:"created_at"`
+	Created_By			*Actor					`json:"created_by"`
+}
+
+// StationItem is an order item on a station's queue, with when it moved
+// through each status.
+type StationItem struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Order_item_id		string					`json:"order_item_id"`
+	Station_id			string					`json:"station_id"`
+	Order_id			*string					`json:"order_id"`
+	Table_id			*string					`json:"table_id"`
+	Food_id				*string					`json:"food_id"`
+	Food_Name			*string					`json:"food_name"`
+	Quantity			*string					`json:"quantity"`
+	Status				string					`json:"status"`
+	Queued_At			time.Time				`json:"queued_at"`
+	Started_At			*time.Time				`json:"started_at"`
+	Ready_At			*time.Time				`json:"ready_at"`
+	Bumped_At			*time.Time				`json:"bumped_at"`
+	Recalls				int						`json:"recalls"`
+	Updated_At			time.Time				`json:"updated_at"`
+	Version				int64					`json:"version"`
+	Updated_By			*Actor					`json:"updated_by"`
+}
+
+// TicketTimes is how long a station took over a period. A ticket is the
+// items of one order at the station, timed from the first being queued to
+// the last being ready.
+type TicketTimes struct{
+	Station_id				string				`json:"station_id"`
+	From					time.Time			`json:"from"`
+	To						time.Time			`json:"to"`
+	Tickets					int					`json:"tickets"`
+	Items					int					`json:"items"`
+	Open_Items				int					`json:"open_items"`
+	Average_Ticket_Seconds	float64				`json:"average_ticket_seconds"`
+	P90_Ticket_Seconds		float64				`json:"p90_ticket_seconds"`
+	Longest_Ticket_Seconds	float64				`json:"longest_ticket_seconds"`
+	Average_Wait_Seconds	float64				`json:"average_wait_seconds"`
+	Average_Cook_Seconds	float64				`json:"average_cook_seconds"`
+}
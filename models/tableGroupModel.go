@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TableGroup is a set of tables pushed together for a large party. The
+// tables share one status until the group is split.
+type TableGroup struct{
+	ID						primitive.ObjectID		`bson:"_id"`
+	Group_id				string					`json:"group_id"`
+	Table_ids				[]string				`json:"table_ids"`
+	Primary_table_id		string					`json:"primary_table_id"`
+	Capacity				int						`json:"capacity"`
+	Created_At				time.Time				`json:"created_at"`
+	Created_By				*Actor					`json:"created_by"`
+	Split_At				*time.Time				`json:"split_at"`
+	Split_By				*Actor					`json:"split_by"`
+}
+
+// TableAudit records a merge, split, move or transfer and who did it.
+type TableAudit struct{
+	ID						primitive.ObjectID		`bson:"_id"`
+	Audit_id				string					`json:"audit_id"`
+	Action					string					`json:"action"`
+	Table_ids				[]string				`json:"table_ids"`
+	Group_id				*string					`json:"group_id,omitempty"`
+	Order_id				*string					`json:"order_id,omitempty"`
+	From					*string					`json:"from,omitempty"`
+	To						*string					`json:"to,omitempty"`
+	Reason					*string					`json:"reason,omitempty"`
+	Actor					*Actor					`json:"actor"`
+	Created_At				time.Time				`json:"created_at"`
+}
@@ -13,5 +13,27 @@ type Table struct{
 	Table_number			*int						`json:"table_number" validate:"required"`
 	Created_At				time.Time					`json:"created_at"`
 	Updated_At				time.Time					`json:"updated_at"`
+	Version					int64						`json:"version"`
 	Table_id				string						`json:"table_id"`
-}
\ No newline at end of file
+	Table_status			*string						`json:"table_status" validate:"omitempty,eq=AVAILABLE|eq=SEATED|eq=ORDERING|eq=AWAITING_PAYMENT|eq=DIRTY|eq=RESERVED"`
+	Status_Changed_At		*time.Time					`json:"status_changed_at"`
+	// Previous_Status and Status_Reason describe the last status change for
+	// change stream consumers, which only see the table after it.
+	Previous_Status			*string						`json:"-"`
+	Status_Reason			*string						`json:"-"`
+	Section_id				*string						`json:"section_id"`
+	Position				*Position					`json:"position"`
+	Shape					*string						`json:"shape" validate:"omitempty,eq=ROUND|eq=SQUARE|eq=RECTANGLE|eq=BOOTH"`
+	Seats					*int						`json:"seats" validate:"omitempty,min=1,max=100"`
+	Server_id				*string						`json:"server_id"`
+	Group_id				*string						`json:"group_id"`
+	Qr_Nonce				*string						`json:"-"`
+}
+
+// Position places a table on the floor plan. Coordinates are in whatever
+// grid the floor plan editor uses; the API only stores them.
+type Position struct{
+	X						float64						`json:"x"`
+	Y						float64						`json:"y"`
+	Rotation				float64						`json:"rotation"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Terminal is a shared device, such as a POS tablet, that staff sign in to
+// with a PIN. It holds at most one PIN session at a time.
+type Terminal struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Terminal_id			string					`json:"terminal_id"`
+	Name				*string					`json:"name" validate:"required,min=2,max=100"`
+	Location			*string					`json:"location" validate:"omitempty,max=100"`
+	Device_Token_Hash	string					`json:"-"`
+	Registered_By		string					`json:"registered_by"`
+	Active_User_id		*string					`json:"active_user_id"`
+	Session_id			*string					`json:"-"`
+	Session_Started_At	*time.Time				`json:"session_started_at"`
+	Session_Expires_At	*time.Time				`json:"session_expires_at"`
+	Last_Active_At		*time.Time				`json:"last_active_at"`
+	Revoked_At			*time.Time				`json:"revoked_at"`
+	Created_At			time.Time				`json:"created_at"`
+	Updated_At			time.Time				`json:"updated_at"`
+	Version				int64					`json:"version"`
+}
+
+// Actor is the staff member behind a change and, when they were signed in
+// with a PIN, the terminal they used. Changes made with an API key carry the
+// key instead of a user, items guests order from their table carry the guest
+// session, and orders from delivery marketplaces the marketplace.
+type Actor struct{
+	User_id				string					`json:"user_id,omitempty" bson:",omitempty"`
+	Terminal_id			string					`json:"terminal_id,omitempty" bson:",omitempty"`
+	Api_key_id			string					`json:"api_key_id,omitempty" bson:",omitempty"`
+	Guest_session_id	string					`json:"guest_session_id,omitempty" bson:",omitempty"`
+	Marketplace			string					`json:"marketplace,omitempty" bson:",omitempty"`
+}
@@ -17,7 +17,16 @@ type User struct{
 	Phone			*string					`json:"phone" validate:"required"`
 	Token			*string					`json:"token"`
 	Refresh_Token	*string					`json:"refresh_token"`
+	Email_Verified	bool					`json:"email_verified"`
+	Email_Verified_At	*time.Time			`json:"email_verified_at"`
+	Mfa_Enabled		bool					`json:"mfa_enabled"`
+	Mfa_Secret		*string					`json:"-"`
+	Mfa_Pending_Secret	*string				`json:"-"`
+	Mfa_Last_Step	int64					`json:"-"`
+	Mfa_Recovery_Codes	[]string			`json:"-"`
+	Pin_Hash		*string					`json:"-"`
 	Created_At		time.Time				`json:"created_at"`
 	Updated_At		time.Time				`json:"updated_at"`
+	Version			int64					`json:"version"`
 	User_id			string					`json:"user_id"`
 }
\ No newline at end of file
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WaitlistEntry is a walk-in party waiting for a table.
+type WaitlistEntry struct{
+	ID						primitive.ObjectID		`bson:"_id"`
+	Entry_id				string					`json:"entry_id"`
+	Party_name				*string					`json:"party_name" validate:"required,min=1,max=100"`
+	Party_size				*int					`json:"party_size" validate:"required,min=1,max=100"`
+	Phone					*string					`json:"phone" validate:"omitempty,e164"`
+	Email					*string					`json:"email" validate:"omitempty,email"`
+	Notes					*string					`json:"notes" validate:"omitempty,max=500"`
+	Status					string					`json:"status"`
+	Quoted_Wait				int						`json:"quoted_wait"`
+	Quoted_At				time.Time				`json:"quoted_at"`
+	Notified_At				*time.Time				`json:"notified_at"`
+	Notify_Count			int						`json:"notify_count"`
+	Seated_At				*time.Time				`json:"seated_at"`
+	Table_id				*string					`json:"table_id"`
+	Removed_At				*time.Time				`json:"removed_at"`
+	Remove_Reason			*string					`json:"remove_reason"`
+	Created_At				time.Time				`json:"created_at"`
+	Updated_At				time.Time				`json:"updated_at"`
+	Created_By				*Actor					`json:"created_by"`
+	Updated_By				*Actor					`json:"updated_by"`
+	Version					int64					`json:"version"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookSubscription sends the events of the given types to a URL, signed
+// with the subscriber's secret.
+type WebhookSubscription struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Subscription_id		string					`json:"subscription_id"`
+	Url					string					`json:"url"`
+	// Secret is never sent back; the subscriber already has it.
+	Secret				string					`json:"-"`
+	Event_Types			[]string				`json:"event_types"`
+	Active				bool					`json:"active"`
+	Created_At			time.Time				`json:"created_at"`
+	Updated_At			time.Time				`json:"updated_at"`
+	Version				int64					`json:"version"`
+	Created_By			*Actor					`json:"created_by"`
+	Updated_By			*Actor					`json:"updated_by"`
+}
+
+// OutboxEvent is a published event waiting to be turned into webhook
+// deliveries. Payload is the exact body subscribers are sent.
+type OutboxEvent struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Event_id			string					`json:"event_id"`
+	Event_type			string					`json:"event_type"`
+	Payload				string					`json:"payload"`
+	Occurred_At			time.Time				`json:"occurred_at"`
+	Dispatched_At		*time.Time				`json:"dispatched_at"`
+}
+
+// WebhookDelivery is one event on its way to one subscription.
+type WebhookDelivery struct{
+	ID					primitive.ObjectID		`bson:"_id"`
+	Delivery_id			string					`json:"delivery_id"`
+	Subscription_id		string					`json:"subscription_id"`
+	Event_id			string					`json:"event_id"`
+	Event_type			string					`json:"event_type"`
+	Payload				string					`json:"payload"`
+	Status				string					`json:"status"`
+	Attempts			int						`json:"attempts"`
+	Next_Attempt_At		time.Time				`json:"next_attempt_at"`
+	Last_Attempt_At		*time.Time				`json:"last_attempt_at"`
+	Last_Status_Code	int						`json:"last_status_code"`
+	Last_Error			string					`json:"last_error"`
+	Delivered_At		*time.Time				`json:"delivered_at"`
+	Created_At			time.Time				`json:"created_at"`
+}
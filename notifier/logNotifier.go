@@ -0,0 +1,24 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/teandresmith/restaurant-project/logging"
+)
+
+// LogNotifier only logs notifications. The recipient is left out so guests'
+// phone numbers and addresses don't end up in the logs.
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	logging.FromContext(ctx).Info("notification not sent, notifier is log only",
+		"channel", notification.Channel,
+		"subject", notification.Subject,
+		"body", notification.Body,
+	)
+	return nil
+}
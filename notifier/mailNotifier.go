@@ -0,0 +1,24 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/teandresmith/restaurant-project/mailer"
+)
+
+// MailNotifier sends email notifications with the application's mailer.
+type MailNotifier struct {
+	mail mailer.Mailer
+}
+
+func NewMailNotifier(mail mailer.Mailer) *MailNotifier {
+	return &MailNotifier{mail: mail}
+}
+
+func (n *MailNotifier) Notify(ctx context.Context, notification Notification) error {
+	return n.mail.Send(ctx, mailer.Message{
+		To:      notification.To,
+		Subject: notification.Subject,
+		Body:    notification.Body,
+	})
+}
@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/teandresmith/restaurant-project/mailer"
+)
+
+const (
+	ChannelSMS   = "sms"
+	ChannelEmail = "email"
+)
+
+var ErrNoChannel = errors.New("notifier: no notifier for channel")
+
+// Notification is a short text message to a guest. To is a phone number in
+// E.164 form for SMS and an email address for email.
+type Notification struct {
+	Channel string `json:"channel"`
+	To      string `json:"to"`
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers notifications to guests.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// ByChannel hands each notification to the notifier for its channel.
+type ByChannel map[string]Notifier
+
+func (n ByChannel) Notify(ctx context.Context, notification Notification) error {
+	notifier, ok := n[notification.Channel]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrNoChannel, notification.Channel)
+	}
+	return notifier.Notify(ctx, notification)
+}
+
+// FromEnv builds the notifiers selected by NOTIFIER_SMS and NOTIFIER_EMAIL:
+//
+//   - "log" or unset: notifications are only logged, for local runs.
+//   - NOTIFIER_SMS=twilio: texts are sent through Twilio as TWILIO_FROM,
+//     with TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN.
+//   - NOTIFIER_EMAIL=mailer: emails are sent with mail.
+func FromEnv(mail mailer.Mailer) (Notifier, error) {
+	var sms, email Notifier = NewLogNotifier(), NewLogNotifier()
+
+	switch kind := os.Getenv("NOTIFIER_SMS"); kind {
+	case "", "log":
+	case "twilio":
+		twilio, err := NewTwilioNotifier(TwilioConfig{
+			Account_Sid: os.Getenv("TWILIO_ACCOUNT_SID"),
+			Auth_Token:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:        os.Getenv("TWILIO_FROM"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sms = twilio
+	default:
+		return nil, fmt.Errorf("notifier: unknown NOTIFIER_SMS %q", kind)
+	}
+
+	switch kind := os.Getenv("NOTIFIER_EMAIL"); kind {
+	case "", "log":
+	case "mailer":
+		email = NewMailNotifier(mail)
+	default:
+		return nil, fmt.Errorf("notifier: unknown NOTIFIER_EMAIL %q", kind)
+	}
+
+	return ByChannel{ChannelSMS: sms, ChannelEmail: email}, nil
+}
@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const twilioAPI = "https://api.twilio.com/2010-04-01"
+
+type TwilioConfig struct {
+	Account_Sid string
+	Auth_Token  string
+	From        string
+}
+
+// TwilioNotifier sends texts with Twilio's Messages API.
+type TwilioNotifier struct {
+	config TwilioConfig
+	client *http.Client
+}
+
+func NewTwilioNotifier(config TwilioConfig) (*TwilioNotifier, error) {
+	if config.Account_Sid == "" || config.Auth_Token == "" || config.From == "" {
+		return nil, errors.New("notifier: TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM are required")
+	}
+
+	return &TwilioNotifier{config: config, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (n *TwilioNotifier) Notify(ctx context.Context, notification Notification) error {
+	form := url.Values{}
+	form.Set("To", notification.To)
+	form.Set("From", n.config.From)
+	form.Set("Body", notification.Body)
+
+	endpoint := twilioAPI + "/Accounts/" + url.PathEscape(n.config.Account_Sid) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(n.config.Account_Sid, n.config.Auth_Token)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notifier: twilio answered %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/apperrors"
+)
+
+// openapi.json is maintained by hand next to routes/. Any route added there
+// must be described here too; CheckRoutes refuses to start the server if the
+// two disagree.
+//
+//go:embed openapi.json
+var specJSON []byte
+
+//go:embed docs.html
+var docsHTML []byte
+
+var doc *openapi3.T
+var router routers.Router
+
+func init() {
+	var err error
+
+	// Keep validation errors to the failing field instead of dumping the schema.
+	openapi3.SchemaErrorDetailsDisabled = true
+
+	doc, err = openapi3.NewLoader().LoadFromData(specJSON)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: could not load openapi.json: %v", err))
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		panic(fmt.Sprintf("openapi: openapi.json is not a valid OpenAPI 3 document: %v", err))
+	}
+
+	router, err = gorillamux.NewRouter(doc)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: could not build a router from openapi.json: %v", err))
+	}
+}
+
+func Routes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/openapi.json", ServeSpec())
+	incomingRoutes.GET("/docs", ServeDocs())
+}
+
+func ServeSpec() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", specJSON)
+	}
+}
+
+// ServeDocs serves a Swagger UI page that renders /openapi.json.
+func ServeDocs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", docsHTML)
+	}
+}
+
+// ValidateRequests checks path parameters, headers, query strings and JSON
+// bodies against the operation openapi.json declares for the route. Requests
+// for routes that are not in the document, such as /openapi.json itself, are
+// let through so gin can answer them.
+func ValidateRequests() gin.HandlerFunc {
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		MultiError:         true,
+	}
+
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		}
+
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			apperrors.Respond(c, &apperrors.Error{
+				Code:    apperrors.ValidationFailed,
+				Message: "The request does not match the API specification",
+				Fields:  fieldErrors(err),
+				Cause:   err,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// fieldErrors flattens the errors kin-openapi reports into one entry per
+// offending parameter or body field.
+func fieldErrors(err error) []apperrors.FieldError {
+	var fields []apperrors.FieldError
+
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		for _, inner := range e {
+			fields = append(fields, fieldErrors(inner)...)
+		}
+	case *openapi3filter.RequestError:
+		if e.Parameter != nil {
+			message := e.Reason
+			if message == "" && e.Err != nil {
+				message = e.Err.Error()
+			}
+			return []apperrors.FieldError{{Field: e.Parameter.Name, Rule: e.Parameter.In, Message: message}}
+		}
+
+		if e.Err != nil {
+			fields = fieldErrors(e.Err)
+		}
+		if len(fields) == 0 {
+			message := e.Reason
+			if message == "" && e.Err != nil {
+				message = e.Err.Error()
+			}
+			fields = append(fields, apperrors.FieldError{Field: "body", Message: message})
+		}
+	case *openapi3.SchemaError:
+		field := strings.Join(e.JSONPointer(), ".")
+		if field == "" {
+			field = "body"
+		}
+		fields = append(fields, apperrors.FieldError{Field: field, Rule: e.SchemaField, Message: e.Reason})
+	}
+
+	return fields
+}
+
+var ginParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// CheckRoutes compares the routes registered on gin with the operations in
+// openapi.json and reports every route that only one of them knows about.
+func CheckRoutes(registered gin.RoutesInfo) error {
+	ignored := map[string]bool{
+		"GET /openapi.json": true,
+		"GET /docs":         true,
+	}
+
+	inRouter := map[string]bool{}
+	for _, route := range registered {
+		key := route.Method + " " + ginParam.ReplaceAllString(route.Path, "{$1}")
+		if !ignored[key] {
+			inRouter[key] = true
+		}
+	}
+
+	inSpec := map[string]bool{}
+	for path, pathItem := range doc.Paths {
+		for method := range pathItem.Operations() {
+			inSpec[method+" "+path] = true
+		}
+	}
+
+	var problems []string
+	for key := range inRouter {
+		if !inSpec[key] {
+			problems = append(problems, "route missing from openapi.json: "+key)
+		}
+	}
+	for key := range inSpec {
+		if !inRouter[key] {
+			problems = append(problems, "operation in openapi.json has no route: "+key)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+	return fmt.Errorf("router and openapi.json disagree:\n  %s", strings.Join(problems, "\n  "))
+}
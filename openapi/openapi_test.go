@@ -0,0 +1,58 @@
+package openapi_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/openapi"
+	"github.com/teandresmith/restaurant-project/routes"
+)
+
+// TestRoutesMatchSpec builds the router the server runs and checks every
+// route is documented in openapi.json and every operation has a route.
+func TestRoutesMatchSpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router, err := routes.NewRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := openapi.CheckRoutes(router.Routes()); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCheckRoutesReportsDrift checks a route missing from either side is
+// reported.
+func TestCheckRoutesReportsDrift(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router, err := routes.NewRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop a documented route and add one that isn't.
+	var registered gin.RoutesInfo
+	for _, route := range router.Routes() {
+		if route.Method+" "+route.Path != "GET /foods/:food_id" {
+			registered = append(registered, route)
+		}
+	}
+	registered = append(registered, gin.RouteInfo{Method: "GET", Path: "/undocumented/:id"})
+
+	err = openapi.CheckRoutes(registered)
+	if err == nil {
+		t.Fatal("CheckRoutes passed a router missing a documented route and with an undocumented one")
+	}
+	for _, want := range []string{
+		"route missing from openapi.json: GET /undocumented/{id}",
+		"operation in openapi.json has no route: GET /foods/{food_id}",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("CheckRoutes: %v\nwant it to report %q", err, want)
+		}
+	}
+}
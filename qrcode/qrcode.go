@@ -0,0 +1,513 @@
+// Package qrcode encodes short texts, such as URLs, as QR codes (ISO/IEC
+// 18004) and renders them as PNG or SVG.
+//
+// Only byte mode and versions 1 to 10 are supported, which is up to 213 bytes
+// at level M. That is plenty for links and keeps the tables small.
+package qrcode
+
+import (
+	"errors"
+)
+
+// Level is how much of the code can be damaged and still be read.
+type Level int
+
+const (
+	// L recovers about 7% of the codewords.
+	L Level = iota
+	// M recovers about 15%.
+	M
+	// Q recovers about 25%.
+	Q
+	// H recovers about 30%.
+	H
+)
+
+// formatBits are the two bits each level is written as in the format
+// information, which are not in the same order as the levels.
+var formatBits = [...]int{L: 1, M: 0, Q: 3, H: 2}
+
+const maxVersion = 10
+
+var ErrTooLong = errors.New("qrcode: text is too long for a version 10 code")
+
+// blockLayout is how a version's codewords are split into blocks at a level:
+// count1 blocks of data1 data codewords, then count2 blocks with one more,
+// each followed by ecc error correction codewords.
+type blockLayout struct {
+	ecc    int
+	count1 int
+	data1  int
+	count2 int
+}
+
+// layouts is indexed by version, then level.
+var layouts = [maxVersion + 1][4]blockLayout{
+	1:  {L: {7, 1, 19, 0}, M: {10, 1, 16, 0}, Q: {13, 1, 13, 0}, H: {17, 1, 9, 0}},
+	2:  {L: {10, 1, 34, 0}, M: {16, 1, 28, 0}, Q: {22, 1, 22, 0}, H: {28, 1, 16, 0}},
+	3:  {L: {15, 1, 55, 0}, M: {26, 1, 44, 0}, Q: {18, 2, 17, 0}, H: {22, 2, 13, 0}},
+	4:  {L: {20, 1, 80, 0}, M: {18, 2, 32, 0}, Q: {26, 2, 24, 0}, H: {16, 4, 9, 0}},
+	5:  {L: {26, 1, 108, 0}, M: {24, 2, 43, 0}, Q: {18, 2, 15, 2}, H: {22, 2, 11, 2}},
+	6:  {L: {18, 2, 68, 0}, M: {16, 4, 27, 0}, Q: {24, 4, 19, 0}, H: {28, 4, 15, 0}},
+	7:  {L: {20, 2, 78, 0}, M: {18, 4, 31, 0}, Q: {18, 2, 14, 4}, H: {26, 4, 13, 1}},
+	8:  {L: {24, 2, 97, 0}, M: {22, 2, 38, 2}, Q: {22, 4, 18, 2}, H: {26, 4, 14, 2}},
+	9:  {L: {30, 2, 116, 0}, M: {22, 3, 36, 2}, Q: {20, 4, 16, 4}, H: {24, 4, 12, 4}},
+	10: {L: {18, 2, 68, 2}, M: {26, 4, 43, 1}, Q: {24, 6, 19, 2}, H: {28, 6, 15, 2}},
+}
+
+func (b blockLayout) dataCodewords() int {
+	return b.count1*b.data1 + b.count2*(b.data1+1)
+}
+
+// alignmentCenters lists the rows and columns alignment patterns are centred
+// on, by version.
+var alignmentCenters = [maxVersion + 1][]int{
+	2:  {6, 18},
+	3:  {6, 22},
+	4:  {6, 26},
+	5:  {6, 30},
+	6:  {6, 34},
+	7:  {6, 22, 38},
+	8:  {6, 24, 42},
+	9:  {6, 26, 46},
+	10: {6, 28, 50},
+}
+
+// Code is an encoded QR code. Modules are true when dark.
+type Code struct {
+	Version int
+	Size    int
+	modules [][]bool
+	// function marks the finder, timing, alignment and format modules,
+	// which are never masked.
+	function [][]bool
+}
+
+// Dark reports whether the module at column x and row y is dark. Modules
+// outside the code, in the quiet zone, are light.
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y][x]
+}
+
+// Encode encodes text in byte mode in the smallest version that holds it at
+// the given level.
+func Encode(text string, level Level) (*Code, error) {
+	return encode(text, level, -1)
+}
+
+// encode is Encode with the given mask, or the one scoring the lowest
+// penalty when mask is -1.
+func encode(text string, level Level, mask int) (*Code, error) {
+	data := []byte(text)
+
+	version := 0
+	for v := 1; v <= maxVersion; v++ {
+		if len(data) <= capacity(v, level) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	layout := layouts[version][level]
+	codewords := interleave(layout, dataCodewords(data, version, layout.dataCodewords()))
+
+	code := newCode(version)
+	code.drawFunctionPatterns()
+	code.drawCodewords(codewords)
+
+	if mask < 0 {
+		bestPenalty := -1
+		for try := 0; try < 8; try++ {
+			code.applyMask(try)
+			code.drawFormat(level, try)
+			if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+				mask, bestPenalty = try, penalty
+			}
+			// Masking twice undoes it.
+			code.applyMask(try)
+		}
+	}
+
+	code.applyMask(mask)
+	code.drawFormat(level, mask)
+	return code, nil
+}
+
+// capacity is how many bytes a version holds at a level, after the mode
+// indicator and character count.
+func capacity(version int, level Level) int {
+	bits := layouts[version][level].dataCodewords()*8 - 4 - countBits(version)
+	return bits / 8
+}
+
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// dataCodewords writes the byte mode segment for data, the terminator and
+// the padding that fill the version's data codewords.
+func dataCodewords(data []byte, version int, total int) []byte {
+	var w bitWriter
+	w.write(0x4, 4)
+	w.write(len(data), countBits(version))
+	for _, b := range data {
+		w.write(int(b), 8)
+	}
+
+	capacityBits := total * 8
+	terminator := capacityBits - w.len()
+	if terminator > 4 {
+		terminator = 4
+	}
+	w.write(0, terminator)
+	if rem := w.len() % 8; rem != 0 {
+		w.write(0, 8-rem)
+	}
+
+	for pad := 0xec; w.len() < capacityBits; pad ^= 0xec ^ 0x11 {
+		w.write(pad, 8)
+	}
+	return w.bytes
+}
+
+// interleave splits data into the layout's blocks, adds each block's error
+// correction and interleaves the blocks codeword by codeword.
+func interleave(layout blockLayout, data []byte) []byte {
+	var blocks, eccs [][]byte
+
+	offset := 0
+	for i := 0; i < layout.count1+layout.count2; i++ {
+		n := layout.data1
+		if i >= layout.count1 {
+			n++
+		}
+		block := data[offset : offset+n]
+		offset += n
+
+		blocks = append(blocks, block)
+		eccs = append(eccs, errorCorrection(block, layout.ecc))
+	}
+
+	var out []byte
+	for i := 0; i <= layout.data1; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecc; i++ {
+		for _, ecc := range eccs {
+			out = append(out, ecc[i])
+		}
+	}
+	return out
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+
+	code := &Code{Version: version, Size: size}
+	code.modules = make([][]bool, size)
+	code.function = make([][]bool, size)
+	for y := range code.modules {
+		code.modules[y] = make([]bool, size)
+		code.function[y] = make([]bool, size)
+	}
+	return code
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	centers := alignmentCenters[c.Version]
+	last := len(centers) - 1
+	for i, x := range centers {
+		for j, y := range centers {
+			// Those corners are taken by the finder patterns.
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve the format areas, and the dark module next to them, before
+	// the data is placed. Their real values are drawn once the mask is
+	// chosen.
+	c.drawFormat(M, 0)
+	c.drawVersion()
+}
+
+// drawFinder draws a finder pattern and its light separator around the
+// centre x, y.
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat writes the level and mask, protected by a BCH code, next to the
+// finder patterns.
+func (c *Code) drawFormat(level Level, mask int) {
+	bits := formatInfo(level, mask)
+
+	// Around the top left finder.
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(bits, i))
+	}
+	c.setFunction(8, 7, bit(bits, 6))
+	c.setFunction(8, 8, bit(bits, 7))
+	c.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(bits, i))
+	}
+
+	// Split between the other two.
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(bits, i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawVersion writes the version, protected by a BCH code, next to the top
+// right and bottom left finders. Versions below 7 don't have it.
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+
+	bits := versionInfo(c.Version)
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, bit(bits, i))
+		c.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// formatInfo is the 15 bit format information for level and mask: five data
+// bits and ten BCH bits, masked so they are never all light.
+func formatInfo(level Level, mask int) int {
+	data := formatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionInfo is the 18 bit version information: six data bits and twelve
+// BCH bits.
+func versionInfo(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	return version<<12 | rem
+}
+
+// drawCodewords places the codewords in two module wide columns, zigzagging
+// up and down from the bottom right corner and skipping function modules.
+// Modules left over at the end stay light.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// The vertical timing pattern gets a column to itself.
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y][x] = bit(int(codewords[i>>3]), 7-i&7)
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.function[y][x] {
+				continue
+			}
+
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			c.modules[y][x] = c.modules[y][x] != invert
+		}
+	}
+}
+
+// penalty scores how hard the code is to read, following the four rules of
+// the standard: long runs, 2x2 blocks, finder lookalikes and an uneven
+// balance of dark and light.
+func (c *Code) penalty() int {
+	score := 0
+
+	for i := 0; i < c.Size; i++ {
+		score += linePenalty(c.Size, func(j int) bool { return c.modules[i][j] })
+		score += linePenalty(c.Size, func(j int) bool { return c.modules[j][i] })
+	}
+
+	for y := 0; y+1 < c.Size; y++ {
+		for x := 0; x+1 < c.Size; x++ {
+			dark := c.modules[y][x]
+			if c.modules[y][x+1] == dark && c.modules[y+1][x] == dark && c.modules[y+1][x+1] == dark {
+				score += 3
+			}
+		}
+	}
+
+	dark := 0
+	for _, row := range c.modules {
+		for _, module := range row {
+			if module {
+				dark++
+			}
+		}
+	}
+	total := c.Size * c.Size
+	// Ten points for every full 5% away from an even split.
+	score += abs(dark*20-total*10) / total * 10
+
+	return score
+}
+
+// finderLike is the 1:1:3:1:1 pattern with four light modules on one side.
+var finderLike = [2][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+func linePenalty(size int, at func(int) bool) int {
+	score := 0
+
+	run := 1
+	for j := 1; j <= size; j++ {
+		if j < size && at(j) == at(j-1) {
+			run++
+			continue
+		}
+		if run >= 5 {
+			score += run - 2
+		}
+		run = 1
+	}
+
+	for j := 0; j+11 <= size; j++ {
+		for _, pattern := range finderLike {
+			match := true
+			for k, dark := range pattern {
+				if at(j+k) != dark {
+					match = false
+					break
+				}
+			}
+			if match {
+				score += 40
+			}
+		}
+	}
+
+	return score
+}
+
+type bitWriter struct {
+	bytes []byte
+	n     int
+}
+
+func (w *bitWriter) write(value int, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		if value>>i&1 == 1 {
+			w.bytes[len(w.bytes)-1] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) len() int {
+	return w.n
+}
+
+func bit(value int, i int) bool {
+	return value>>i&1 == 1
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
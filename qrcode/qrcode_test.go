@@ -0,0 +1,198 @@
+package qrcode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestFormatInfo checks the format information against the table in
+// Annex C of ISO/IEC 18004.
+func TestFormatInfo(t *testing.T) {
+	want := map[Level][8]string{
+		L: {"111011111000100", "111001011110011", "111110110101010", "111100010011101", "110011000101111", "110001100011000", "110110001000001", "110100101110110"},
+		M: {"101010000010010", "101000100100101", "101111001111100", "101101101001011", "100010111111001", "100000011001110", "100111110010111", "100101010100000"},
+		Q: {"011010101011111", "011000001101000", "011111100110001", "011101000000110", "010010010110100", "010000110000011", "010111011011010", "010101111101101"},
+		H: {"001011010001001", "001001110111110", "001110011100111", "001100111010000", "000011101100010", "000001001010101", "000110100001100", "000100000111011"},
+	}
+	for level, masks := range want {
+		for mask, bits := range masks {
+			if got := binary(formatInfo(level, mask), 15); got != bits {
+				t.Errorf("formatInfo(%d, %d) = %s, want %s", level, mask, got, bits)
+			}
+		}
+	}
+}
+
+// TestVersionInfo checks the version information against the table in
+// Annex D of the standard.
+func TestVersionInfo(t *testing.T) {
+	want := map[int]string{
+		7:  "000111110010010100",
+		8:  "001000010110111100",
+		9:  "001001101010011001",
+		10: "001010010011010011",
+	}
+	for version, bits := range want {
+		if got := binary(versionInfo(version), 18); got != bits {
+			t.Errorf("versionInfo(%d) = %s, want %s", version, got, bits)
+		}
+	}
+}
+
+// referenceModules is what rsc.io/qr v0.2.0 draws for a version 1 code at
+// level M with mask 2, holding "rk.example/t/1".
+const referenceModules = `
+#######.....#.#######
+#.....#...###.#.....#
+#.###.#.##..#.#.###.#
+#.###.#.#.....#.###.#
+#.###.#.###.#.#.###.#
+#.....#.###.#.#.....#
+#######.#.#.#.#######
+........#####........
+#.#####..##.#.#####..
+.####...#..#....#...#
+#####.#.#...#....#.#.
+....#....###.#....#.#
+####.##...##..#.#....
+........#....#####.##
+#######.....#......#.
+#.....#.#.#.##...##.#
+#.###.#.#..##.##...##
+#.###.#.####.#.####..
+#.###.#.##.###...##..
+#.....#....#...#.##..
+#######.#.##..####.#.
+`
+
+func TestModules(t *testing.T) {
+	code, err := encode("rk.example/t/1", M, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := modules(code); got != strings.TrimPrefix(referenceModules, "\n") {
+		t.Errorf("modules =\n%s\nwant\n%s", got, referenceModules)
+	}
+}
+
+// TestModulesMatchReference compares the modules, drawn one character per
+// module and one line per row, with the SHA-256 of those rsc.io/qr v0.2.0
+// draws for each mask at level M. The texts need versions 1, 7, with its
+// version information, and 10, with a longer character count and blocks of
+// two sizes.
+func TestModulesMatchReference(t *testing.T) {
+	tests := []struct {
+		text    string
+		version int
+		masks   [8]string
+	}{
+		{
+			text:    "rk.example/t/1",
+			version: 1,
+			masks: [8]string{
+				"fefb6a35dc78e06660cb7504a6796a518068f5fe1f0da78d219a7a644baf8e31",
+				"1ae1b98a8f6cce3926835287f1505f3d0142b2da05c8e680bf0b807df8cf4e6c",
+				"863be211c3673ed279e3ae87463003556bfef46947e4cc3192728b70b654801e",
+				"50f6c1f021059821c2197b5cd6c9ba3d741ce8eb1b6337fc388d0573184877c6",
+				"5b677d0eb5399d680c86a5ff56b658a92f5cf1962106626a5517c9f55cb248ae",
+				"8ac5d804642b155b3c5ddeaccfa339572616bba09680f13cde43da4e2384690e",
+				"4db04cda8129dad138bfc664ab1f200f17975fa0cc63e847147abbdfb691d659",
+				"4dcab2db32075ac5b63c42cfa9fa46bebe757a15141c6c35b32ca644f18b18b1",
+			},
+		},
+		{
+			text:    "https://restaurant.example/guest?table=t-17&token=" + strings.Repeat("Ab3_", 15),
+			version: 7,
+			masks: [8]string{
+				"03d9d45f78aac427f62b232697773513ec892d2ad128330a9b60c4fe0e2273a9",
+				"973848962f891e6dcb00a0f56873f45feb010c50d53aa9a1af7aed96f0e77321",
+				"37f3efbfdf0e11867e7c809f5bd59d66159ce40a9222947fe136e7befb1f79ab",
+				"9d41e8290b8a669319b030994f88f8bea3ef0495ed65145c1118b2c8f9c4c98c",
+				"42dd9c96bb8cfcef82fbdb2a1d101d7f5ddbc39c12be69b84d62ff939d5019a3",
+				"933a6dc4f02d86283f6887cb24fe1e142280eaa640e73401fab5c5563ca907ed",
+				"48338ba45435bb6668c9e37c70646305d7355bc56ab48264ca85b78fd8c7e1d6",
+				"2c8e0547f0ea36d9c65bc417ddc41cb98eb3a9c9989eaf137a1122fdbfc662dd",
+			},
+		},
+		{
+			text:    "https://restaurant.example/guest?table=t-17&token=" + strings.Repeat("xY9-", 38),
+			version: 10,
+			masks: [8]string{
+				"308a9818d4d6f0587227b72715babd5a3cfb0cea1dd17f6b76812ec166b11def",
+				"c52589184087b37c9f5f454a350c8f10527571cbd54887b1dc0a634a28179dc8",
+				"a41db7d4616a766d9946cfdcb5631f565e23c352e3531550045088094f22e25c",
+				"3602b77d4d06b054ad4e15a88fc4194b74878513c3c1f367a47225907d4ed5a4",
+				"fd45718a7e8367cb574f1d929d5651772b1cd1e21b400e1207d357ceead9c522",
+				"33ade1ee81f9213b9366440d620a559bf2e3eea7e5e36a7de70993c15b105196",
+				"b29591bd24cf00da51c3c0feef543ba20ba9ccf5ffd0a5fa62844b84588957b1",
+				"8223777d241c7f40f8643f577541a52f78de96def8e1a36c21d407e6fa13e5d9",
+			},
+		},
+	}
+	for _, test := range tests {
+		for mask, want := range test.masks {
+			code, err := encode(test.text, M, mask)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code.Version != test.version {
+				t.Fatalf("%d bytes took version %d, want %d", len(test.text), code.Version, test.version)
+			}
+			if got := modulesHash(code); got != want {
+				t.Errorf("version %d with mask %d: modules hash to %s, want %s", test.version, mask, got, want)
+			}
+		}
+
+		// Whichever mask Encode picks, it draws the same code.
+		code, err := Encode(test.text, M)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, want := range test.masks {
+			found = found || modulesHash(code) == want
+		}
+		if !found {
+			t.Errorf("Encode drew a version %d code with none of the masks", test.version)
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(strings.Repeat("a", 214), M); err != ErrTooLong {
+		t.Errorf("214 bytes at level M: err = %v, want %v", err, ErrTooLong)
+	}
+	if _, err := Encode(strings.Repeat("a", 213), M); err != nil {
+		t.Errorf("213 bytes at level M: %v", err)
+	}
+}
+
+// modules draws the code one character per module, # for dark, and one
+// line per row.
+func modules(code *Code) string {
+	var b strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Dark(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func modulesHash(code *Code) string {
+	sum := sha256.Sum256([]byte(modules(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+func binary(value int, bits int) string {
+	s := strconv.FormatInt(int64(value), 2)
+	return strings.Repeat("0", bits-len(s)) + s
+}
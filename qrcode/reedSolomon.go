@@ -0,0 +1,67 @@
+package qrcode
+
+// Reed-Solomon error correction over GF(256) with the QR code polynomial
+// x^8 + x^4 + x^3 + x^2 + 1.
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	// Doubling the table saves reducing exponents mod 255 when multiplying.
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// generator returns the coefficients of (x - a^0)(x - a^1)...(x - a^(n-1)),
+// highest degree first, leaving out the leading 1.
+func generator(n int) []byte {
+	poly := make([]byte, n)
+	poly[n-1] = 1
+
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			poly[j] = gfMul(poly[j], root)
+			if j+1 < n {
+				poly[j] ^= poly[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return poly
+}
+
+// errorCorrection returns the n error correction codewords for data.
+func errorCorrection(data []byte, n int) []byte {
+	gen := generator(n)
+	rem := make([]byte, n)
+
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[n-1] = 0
+		for i := range rem {
+			rem[i] ^= gfMul(gen[i], factor)
+		}
+	}
+	return rem
+}
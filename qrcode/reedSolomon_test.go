@@ -0,0 +1,37 @@
+package qrcode
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestErrorCorrection checks the worked example from Annex I of ISO/IEC
+// 18004, "01234567" as a 1-M code.
+func TestErrorCorrection(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11}
+	want := []byte{0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55}
+
+	if got := errorCorrection(data, 10); !bytes.Equal(got, want) {
+		t.Errorf("errorCorrection = % x, want % x", got, want)
+	}
+}
+
+// TestGenerator checks generator polynomials against the exponents of
+// their coefficients listed in Annex A of the standard.
+func TestGenerator(t *testing.T) {
+	tests := []struct {
+		n         int
+		exponents []int
+	}{
+		{7, []int{87, 229, 146, 149, 238, 102, 21}},
+		{10, []int{251, 67, 46, 61, 118, 70, 64, 94, 32, 45}},
+	}
+	for _, test := range tests {
+		gen := generator(test.n)
+		for i, exponent := range test.exponents {
+			if gen[i] != gfExp[exponent] {
+				t.Errorf("generator(%d)[%d] = a^%d, want a^%d", test.n, i, gfLog[gen[i]], exponent)
+			}
+		}
+	}
+}
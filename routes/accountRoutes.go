@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func AccountRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/password/forgot", controllers.ForgotPassword())
+	incomingRoutes.POST("/password/reset", controllers.ResetPassword())
+	incomingRoutes.POST("/email/verify", controllers.VerifyEmail())
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func ApiKeyRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/api-keys", controllers.GetApiKeys())
+	incomingRoutes.POST("/api-keys", controllers.CreateApiKey())
+	incomingRoutes.POST("/api-keys/:api_key_id/rotate", controllers.RotateApiKey())
+	incomingRoutes.DELETE("/api-keys/:api_key_id", controllers.RevokeApiKey())
+}
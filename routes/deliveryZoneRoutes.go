@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func DeliveryZoneRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/delivery-zones", controllers.GetDeliveryZones())
+	incomingRoutes.GET("/delivery-zones/quote", controllers.QuoteDelivery())
+	incomingRoutes.GET("/delivery-zones/:zone_id", controllers.GetDeliveryZone())
+	incomingRoutes.POST("/delivery-zones", controllers.CreateDeliveryZone())
+	incomingRoutes.PATCH("/delivery-zones/:zone_id", controllers.UpdateDeliveryZone())
+	incomingRoutes.DELETE("/delivery-zones/:zone_id", controllers.DeleteDeliveryZone())
+}
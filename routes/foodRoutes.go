@@ -8,8 +8,8 @@ import (
 
 func FoodRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/foods", controllers.GetFoods())
-	incomingRoutes.GET("/foods/:foods_id", controllers.GetFood())
+	incomingRoutes.GET("/foods/:food_id", controllers.GetFood())
 	incomingRoutes.POST("/foods", controllers.CreateFood())
 	incomingRoutes.PATCH("/foods/:food_id", controllers.UpdateFood())
-	incomingRoutes.DELETE("/foods/:foods_id", controllers.DeleteFood())
+	incomingRoutes.DELETE("/foods/:food_id", controllers.DeleteFood())
 }
\ No newline at end of file
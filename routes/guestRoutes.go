@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+	"github.com/teandresmith/restaurant-project/middleware"
+)
+
+// GuestRoutes are called by guests ordering from their table, with the
+// session they started by scanning its QR code rather than a user's token.
+func GuestRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/guest/session", middleware.LimitLogin(), controllers.StartGuestSession())
+	incomingRoutes.GET("/guest/menu", middleware.GuestSession(), controllers.GetGuestMenu())
+	incomingRoutes.GET("/guest/order", middleware.GuestSession(), controllers.GetGuestOrder())
+	incomingRoutes.POST("/guest/order", middleware.GuestSession(), middleware.LimitByTable(), middleware.Idempotency(), controllers.SubmitGuestOrder())
+}
@@ -3,13 +3,15 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/teandresmith/restaurant-project/controllers"
+	"github.com/teandresmith/restaurant-project/middleware"
 )
 
 
 func InvoiceRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/invoices", controllers.GetInvoices())
-	incomingRoutes.GET("/invoice/:invoice_id", controllers.GetInvoice())
-	incomingRoutes.POST("/invoices", controllers.CreateInvoice())
-	incomingRoutes.PATCH("invoices/:invoice_id", controllers.UpdateInvoice())
-	incomingRoutes.DELETE("invoices/:invoice_id", controllers.DeleteInvoice())
+	incomingRoutes.GET("/invoices/:invoice_id", controllers.GetInvoice())
+	incomingRoutes.POST("/invoices", middleware.Idempotency(), controllers.CreateInvoice())
+	incomingRoutes.POST("/invoices/:invoice_id/refund", middleware.RequireIdempotency(), controllers.RefundInvoice())
+	incomingRoutes.PATCH("/invoices/:invoice_id", controllers.UpdateInvoice())
+	incomingRoutes.DELETE("/invoices/:invoice_id", controllers.DeleteInvoice())
 }
\ No newline at end of file
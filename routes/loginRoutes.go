@@ -3,9 +3,11 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/teandresmith/restaurant-project/controllers"
+	"github.com/teandresmith/restaurant-project/middleware"
 )
 
 func LoginRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.POST("/signup", controllers.SignUp())
-	incomingRoutes.POST("/login", controllers.Login())
+	incomingRoutes.POST("/login", middleware.LimitLogin(), controllers.Login())
+	incomingRoutes.POST("/login/mfa", middleware.LimitLogin(), controllers.LoginMFA())
 } 
\ No newline at end of file
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+// MarketplaceWebhookRoutes are called by delivery marketplaces, which sign
+// their webhooks rather than sending a user's token.
+func MarketplaceWebhookRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/webhooks/marketplaces/:provider", controllers.ReceiveMarketplaceOrder())
+}
+
+func MarketplaceRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/marketplaces/:provider/menu-map", controllers.GetMenuMappings())
+	incomingRoutes.PUT("/marketplaces/:provider/menu-map/:external_item_id", controllers.MapMenuItem())
+	incomingRoutes.DELETE("/marketplaces/:provider/menu-map/:external_item_id", controllers.UnmapMenuItem())
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func MfaRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/mfa/enroll", controllers.EnrollMFA())
+	incomingRoutes.POST("/mfa/confirm", controllers.ConfirmMFA())
+	incomingRoutes.POST("/mfa/recovery-codes", controllers.RegenerateRecoveryCodes())
+	incomingRoutes.POST("/mfa/disable", controllers.DisableMFA())
+}
@@ -3,14 +3,17 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/teandresmith/restaurant-project/controllers"
+	"github.com/teandresmith/restaurant-project/middleware"
 )
 
 
 func OrderItemRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/orderItems", controllers.GetOrderItems())
-	incomingRoutes.GET("/orderItems/:orderItem_id", controllers.GetOrderItem())
-	incomingRoutes.GET("orderItems-order/:order_id", controllers.GetOrderItemsByOrder())
-	incomingRoutes.POST("/orderItems", controllers.CreateOrderItem())
-	incomingRoutes.PATCH("/orderItems/:orderItem_id", controllers.UpdateOrderItem())
-	incomingRoutes.DELETE("/orderItems/:orderItem_id", controllers.DeleteOrderItem())
+	incomingRoutes.GET("/orderItems/pending", controllers.GetPendingOrderItems())
+	incomingRoutes.GET("/orderItems/:order_item_id", controllers.GetOrderItem())
+	incomingRoutes.POST("/orderItems", middleware.Idempotency(), controllers.CreateOrderItem())
+	incomingRoutes.PATCH("/orderItems/:order_item_id", controllers.UpdateOrderItem())
+	incomingRoutes.DELETE("/orderItems/:order_item_id", controllers.DeleteOrderItem())
+	incomingRoutes.POST("/orderItems/:order_item_id/approve", controllers.ApproveOrderItem())
+	incomingRoutes.POST("/orderItems/:order_item_id/reject", controllers.RejectOrderItem())
 }
\ No newline at end of file
@@ -3,13 +3,20 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/teandresmith/restaurant-project/controllers"
+	"github.com/teandresmith/restaurant-project/middleware"
 )
 
 
 func OrderRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/orders", controllers.GetOrders())
 	incomingRoutes.GET("/orders/:order_id", controllers.GetOrder())
-	incomingRoutes.POST("/orders", controllers.CreateOrder())
+	incomingRoutes.GET("/orders/:order_id/orderItems", controllers.GetOrderItemsByOrder())
+	incomingRoutes.POST("/orders", middleware.Idempotency(), controllers.CreateOrder())
+	incomingRoutes.POST("/orders/:order_id/checkout", middleware.RequireIdempotency(), controllers.CheckoutOrder())
+	incomingRoutes.POST("/orders/:order_id/move", controllers.MoveOrder())
+	incomingRoutes.POST("/orders/:order_id/transfer", controllers.TransferOrder())
+	incomingRoutes.GET("/orders/:order_id/audit", controllers.GetOrderAudit())
 	incomingRoutes.PATCH("/orders/:order_id", controllers.UpdateOrder())
 	incomingRoutes.DELETE("/orders/:order_id", controllers.DeleteOrder())
+	incomingRoutes.GET("/pickup-slots", controllers.GetPickupSlots())
 }
\ No newline at end of file
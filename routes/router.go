@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/health"
+	"github.com/teandresmith/restaurant-project/metrics"
+	"github.com/teandresmith/restaurant-project/middleware"
+	"github.com/teandresmith/restaurant-project/openapi"
+	"github.com/teandresmith/restaurant-project/tracing"
+)
+
+// NewRouter returns the router the server runs: every route, behind the
+// middleware in the order it applies. trustedProxies are the addresses or
+// CIDR ranges whose X-Forwarded-For is believed.
+func NewRouter(trustedProxies []string) (*gin.Engine, error) {
+	router := gin.New()
+
+	// Requests are limited by client address, so X-Forwarded-For is only
+	// believed when it was set by one of our own proxies.
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+
+	// Default Middleware
+	router.Use(middleware.RequestID())
+	router.Use(middleware.AccessLog())
+	router.Use(metrics.Middleware())
+	router.Use(tracing.Middleware())
+	router.Use(middleware.Recovery())
+	router.Use(middleware.LimitByIP())
+	openapi.Routes(router)
+	health.Routes(router)
+	metrics.Routes(router)
+
+	// Validate requests against openapi.json
+	router.Use(openapi.ValidateRequests())
+	JwksRoutes(router)
+	LoginRoutes(router)
+	AccountRoutes(router)
+	TerminalDeviceRoutes(router)
+	GuestRoutes(router)
+	MarketplaceWebhookRoutes(router)
+
+	// Custom Authentication Middleware
+	router.Use(middleware.Authentication())
+	router.Use(middleware.LimitByUser())
+	router.Use(middleware.RequireVerifiedEmail())
+	router.Use(middleware.RequireMfaEnrollment())
+
+	MfaRoutes(router)
+	UserRoutes(router)
+	TerminalRoutes(router)
+	ApiKeyRoutes(router)
+	FoodRoutes(router)
+	MenuRoutes(router)
+	SectionRoutes(router)
+	TableRoutes(router)
+	WaitlistRoutes(router)
+	OrderRoutes(router)
+	DeliveryZoneRoutes(router)
+	MarketplaceRoutes(router)
+	OrderItemRoutes(router)
+	StationRoutes(router)
+	InvoiceRoutes(router)
+	WebhookRoutes(router)
+
+	return router, nil
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func SectionRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/sections", controllers.GetSections())
+	incomingRoutes.GET("/sections/:section_id", controllers.GetSection())
+	incomingRoutes.POST("/sections", controllers.CreateSection())
+	incomingRoutes.PATCH("/sections/:section_id", controllers.UpdateSection())
+	incomingRoutes.DELETE("/sections/:section_id", controllers.DeleteSection())
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func StationRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/stations", controllers.GetStations())
+	incomingRoutes.GET("/stations/:station_id", controllers.GetStation())
+	incomingRoutes.POST("/stations", controllers.CreateStation())
+	incomingRoutes.PATCH("/stations/:station_id", controllers.UpdateStation())
+	incomingRoutes.DELETE("/stations/:station_id", controllers.DeleteStation())
+	incomingRoutes.GET("/stations/:station_id/queue", controllers.GetStationQueue())
+	incomingRoutes.GET("/stations/:station_id/ticket-times", controllers.GetTicketTimes())
+	incomingRoutes.POST("/stations/:station_id/items/:order_item_id/start", controllers.StartStationItem())
+	incomingRoutes.POST("/stations/:station_id/items/:order_item_id/ready", controllers.ReadyStationItem())
+	incomingRoutes.POST("/stations/:station_id/items/:order_item_id/bump", controllers.BumpStationItem())
+	incomingRoutes.POST("/stations/:station_id/items/:order_item_id/recall", controllers.RecallStationItem())
+	incomingRoutes.GET("/routing-rules", controllers.GetRoutingRules())
+	incomingRoutes.POST("/routing-rules", controllers.CreateRoutingRule())
+	incomingRoutes.DELETE("/routing-rules/:rule_id", controllers.DeleteRoutingRule())
+}
@@ -12,4 +12,13 @@ func TableRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.POST("/tables", controllers.CreateTable())
 	incomingRoutes.PATCH("/tables/:table_id", controllers.UpdateTable())
 	incomingRoutes.DELETE("/tables/:table_id", controllers.DeleteTable())
+	incomingRoutes.PATCH("/tables/:table_id/status", controllers.UpdateTableStatus())
+	incomingRoutes.GET("/tables/:table_id/audit", controllers.GetTableAudit())
+	incomingRoutes.GET("/tables/:table_id/qr", controllers.GetTableQr())
+	incomingRoutes.POST("/tables/:table_id/qr/rotate", controllers.RotateTableQr())
+	incomingRoutes.GET("/floor", controllers.GetFloor())
+	incomingRoutes.GET("/table-groups", controllers.GetTableGroups())
+	incomingRoutes.GET("/table-groups/:group_id", controllers.GetTableGroup())
+	incomingRoutes.POST("/table-groups", controllers.MergeTables())
+	incomingRoutes.POST("/table-groups/:group_id/split", controllers.SplitTables())
 }
\ No newline at end of file
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+	"github.com/teandresmith/restaurant-project/middleware"
+)
+
+// TerminalDeviceRoutes are called by registered terminals with their device
+// token rather than a user's token.
+func TerminalDeviceRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/terminal/staff", controllers.TerminalStaff())
+	incomingRoutes.POST("/terminal/login", middleware.LimitLogin(), controllers.PinLogin())
+	incomingRoutes.POST("/terminal/lock", controllers.LockTerminal())
+}
+
+func TerminalRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/terminals", controllers.GetTerminals())
+	incomingRoutes.POST("/terminals", controllers.RegisterTerminal())
+	incomingRoutes.DELETE("/terminals/:terminal_id", controllers.RevokeTerminal())
+}
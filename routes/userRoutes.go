@@ -8,6 +8,10 @@ import (
 func UserRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/users", controllers.GetUsers())
 	incomingRoutes.GET("/users/:user_id", controllers.GetUser())
-	incomingRoutes.DELETE("users/:user_id", controllers.DeleteUser())
-	incomingRoutes.PATCH("users/:user_id", controllers.EditUser())
+	incomingRoutes.DELETE("/users/:user_id", controllers.DeleteUser())
+	incomingRoutes.PATCH("/users/:user_id", controllers.EditUser())
+	incomingRoutes.POST("/users/:user_id/unlock", controllers.UnlockUser())
+	incomingRoutes.PUT("/users/:user_id/pin", controllers.SetPin())
+	incomingRoutes.DELETE("/users/:user_id/mfa", controllers.ResetUserMFA())
+	incomingRoutes.POST("/email/verify/resend", controllers.ResendVerification())
 }
\ No newline at end of file
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func WaitlistRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/waitlist", controllers.GetWaitlist())
+	incomingRoutes.GET("/waitlist/quote", controllers.QuoteWait())
+	incomingRoutes.POST("/waitlist", controllers.AddToWaitlist())
+	incomingRoutes.GET("/waitlist/:entry_id", controllers.GetWaitlistEntry())
+	incomingRoutes.POST("/waitlist/:entry_id/notify", controllers.NotifyParty())
+	incomingRoutes.POST("/waitlist/:entry_id/seat", controllers.SeatParty())
+	incomingRoutes.POST("/waitlist/:entry_id/remove", controllers.RemoveFromWaitlist())
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/teandresmith/restaurant-project/controllers"
+)
+
+func WebhookRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/webhook-subscriptions", controllers.GetWebhookSubscriptions())
+	incomingRoutes.GET("/webhook-subscriptions/:subscription_id", controllers.GetWebhookSubscription())
+	incomingRoutes.POST("/webhook-subscriptions", controllers.CreateWebhookSubscription())
+	incomingRoutes.PATCH("/webhook-subscriptions/:subscription_id", controllers.UpdateWebhookSubscription())
+	incomingRoutes.DELETE("/webhook-subscriptions/:subscription_id", controllers.DeleteWebhookSubscription())
+	incomingRoutes.GET("/webhook-deliveries", controllers.GetWebhookDeliveries())
+	incomingRoutes.GET("/webhook-deliveries/:delivery_id", controllers.GetWebhookDelivery())
+	incomingRoutes.POST("/webhook-deliveries/:delivery_id/redeliver", controllers.RedeliverWebhook())
+}
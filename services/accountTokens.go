@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeMfaChallenge      = "mfa_challenge"
+)
+
+var ErrAccountTokenInvalid = apperrors.New(apperrors.BadRequest, "The token is invalid, expired or already used")
+
+// AccountToken is a single-use token mailed to a user. Only the SHA-256 of
+// the token is stored, so a leaked collection can't be used to take over
+// accounts.
+type AccountToken struct {
+	Token_Hash string     `bson:"token_hash"`
+	User_id    string     `bson:"user_id"`
+	Purpose    string     `bson:"purpose"`
+	Created_At time.Time  `bson:"created_at"`
+	Expires_At time.Time  `bson:"expires_at"`
+	Used_At    *time.Time `bson:"used_at"`
+}
+
+type AccountTokenStore interface {
+	// Replace stores token and discards any unused token the user already has
+	// for the same purpose.
+	Replace(ctx context.Context, token AccountToken) error
+	// Use marks the unused, unexpired token with hash and purpose as used and
+	// returns it. It returns ErrAccountTokenInvalid if there is none.
+	Use(ctx context.Context, hash string, purpose string, now time.Time) (AccountToken, error)
+}
+
+// AccountTokens issues and redeems password reset and email verification
+// tokens.
+type AccountTokens struct {
+	store AccountTokenStore
+}
+
+func NewAccountTokens(store AccountTokenStore) *AccountTokens {
+	return &AccountTokens{store: store}
+}
+
+// Issue creates a token for userId that is valid for ttl and returns it. The
+// returned value is the only copy of the token.
+func (t *AccountTokens) Issue(ctx context.Context, userId string, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	err := t.store.Replace(ctx, AccountToken{
+		Token_Hash: hashAccountToken(token),
+		User_id:    userId,
+		Purpose:    purpose,
+		Created_At: now,
+		Expires_At: now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Redeem uses up token and returns the id of the user it was issued to.
+func (t *AccountTokens) Redeem(ctx context.Context, token string, purpose string) (string, error) {
+	if token == "" {
+		return "", ErrAccountTokenInvalid
+	}
+
+	used, err := t.store.Use(ctx, hashAccountToken(token), purpose, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return used.User_id, nil
+}
+
+func hashAccountToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type MemoryAccountTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]AccountToken
+}
+
+func NewMemoryAccountTokenStore() *MemoryAccountTokenStore {
+	return &MemoryAccountTokenStore{tokens: map[string]AccountToken{}}
+}
+
+func (s *MemoryAccountTokenStore) Replace(ctx context.Context, token AccountToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, existing := range s.tokens {
+		if (existing.User_id == token.User_id && existing.Purpose == token.Purpose && existing.Used_At == nil) || time.Now().After(existing.Expires_At) {
+			delete(s.tokens, hash)
+		}
+	}
+
+	s.tokens[token.Token_Hash] = token
+	return nil
+}
+
+func (s *MemoryAccountTokenStore) Use(ctx context.Context, hash string, purpose string, now time.Time) (AccountToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok || token.Purpose != purpose || token.Used_At != nil || !now.Before(token.Expires_At) {
+		return AccountToken{}, ErrAccountTokenInvalid
+	}
+
+	token.Used_At = &now
+	s.tokens[hash] = token
+	return token, nil
+}
+
+// MongoAccountTokenStore keeps tokens in the account_tokens collection. A TTL
+// index removes them once they expire.
+type MongoAccountTokenStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoAccountTokenStore(client *mongo.Client) *MongoAccountTokenStore {
+	return &MongoAccountTokenStore{collection: database.OpenCollection(client, "account_tokens")}
+}
+
+func (s *MongoAccountTokenStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (s *MongoAccountTokenStore) Replace(ctx context.Context, token AccountToken) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": token.User_id, "purpose": token.Purpose, "used_at": nil})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (s *MongoAccountTokenStore) Use(ctx context.Context, hash string, purpose string, now time.Time) (AccountToken, error) {
+	// Matching on used_at makes redeeming atomic: of two concurrent requests
+	// with the same token only one can flip it.
+	filter := bson.M{
+		"token_hash": hash,
+		"purpose":    purpose,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"used_at": now}}
+
+	var token AccountToken
+	err := s.collection.FindOneAndUpdate(ctx, filter, update).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return AccountToken{}, ErrAccountTokenInvalid
+	}
+	if err != nil {
+		return AccountToken{}, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// MemoryCheckoutStore keeps invoices and payments in process and checks out
+// the orders and order items of a MemoryGuestOrderStore, so it sees what
+// guests, servers and marketplaces put there.
+// A checkout is staged against a private copy of the affected records and is
+// only applied once the whole checkout has succeeded, so a failure part way
+// through leaves the store untouched.
+type MemoryCheckoutStore struct {
+	mu       sync.Mutex
+	invoices map[string]models.Invoice
+	payments map[string]models.Payment
+	guest    *MemoryGuestOrderStore
+}
+
+func NewMemoryCheckoutStore(guest *MemoryGuestOrderStore) *MemoryCheckoutStore {
+	return &MemoryCheckoutStore{
+		invoices: map[string]models.Invoice{},
+		payments: map[string]models.Payment{},
+		guest:    guest,
+	}
+}
+
+func (s *MemoryCheckoutStore) Invoices() []models.Invoice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoices := make([]models.Invoice, 0, len(s.invoices))
+	for _, invoice := range s.invoices {
+		invoices = append(invoices, invoice)
+	}
+	return invoices
+}
+
+func (s *MemoryCheckoutStore) Payments() []models.Payment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payments := make([]models.Payment, 0, len(s.payments))
+	for _, payment := range s.payments {
+		payments = append(payments, payment)
+	}
+	return payments
+}
+
+func (s *MemoryCheckoutStore) RunInTransaction(ctx context.Context, fn func(tx CheckoutTx) error) error {
+	// Holding the lock for the whole checkout serializes checkouts the same way
+	// a write conflict would abort one of two concurrent Mongo transactions.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The orders are the table store's, which is held as well so nothing
+	// moves or pays an order between the checkout reading and writing it.
+	tables := s.guest.tables
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tx := &memoryCheckoutTx{
+		store:    s,
+		orders:   map[string]models.Order{},
+		invoices: map[string]models.Invoice{},
+		payments: map[string]models.Payment{},
+		tables:   map[string]models.Table{},
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for id, order := range tx.orders {
+		tables.orders[id] = order
+	}
+	for id, table := range tx.tables {
+		tables.tables[id] = table
+	}
+	for id, invoice := range tx.invoices {
+		s.invoices[id] = invoice
+	}
+	for id, payment := range tx.payments {
+		s.payments[id] = payment
+	}
+
+	return nil
+}
+
+// memoryCheckoutTx buffers writes until the transaction commits. Reads see the
+// buffered writes first so the transaction observes its own changes.
+type memoryCheckoutTx struct {
+	store    *MemoryCheckoutStore
+	orders   map[string]models.Order
+	invoices map[string]models.Invoice
+	payments map[string]models.Payment
+	tables   map[string]models.Table
+}
+
+func (tx *memoryCheckoutTx) FindOrder(orderId string) (models.Order, error) {
+	if order, ok := tx.orders[orderId]; ok {
+		return order, nil
+	}
+
+	order, ok := tx.store.guest.tables.orders[orderId]
+	if !ok {
+		return order, ErrOrderNotFound
+	}
+
+	return order, nil
+}
+
+func (tx *memoryCheckoutTx) FindOrderItems(orderId string) ([]models.OrderItem, error) {
+	return tx.store.guest.OrderItems(context.Background(), orderId)
+}
+
+func (tx *memoryCheckoutTx) InsertInvoice(invoice models.Invoice) error {
+	tx.invoices[invoice.Invoice_id] = invoice
+	return nil
+}
+
+func (tx *memoryCheckoutTx) FindOrderInvoice(orderId string) (models.Invoice, error) {
+	for _, invoices := range []map[string]models.Invoice{tx.invoices, tx.store.invoices} {
+		for _, invoice := range invoices {
+			if invoice.Order_id == orderId {
+				return invoice, nil
+			}
+		}
+	}
+	return models.Invoice{}, ErrInvoiceNotFound
+}
+
+func (tx *memoryCheckoutTx) PayInvoice(invoice models.Invoice, version int64) error {
+	current, err := tx.FindInvoice(invoice.Invoice_id)
+	if err != nil {
+		return err
+	}
+	if current.Version != version || current.Payment_status == nil || *current.Payment_status != PaymentStatusPending {
+		return ErrOrderAlreadyPaid
+	}
+
+	tx.invoices[invoice.Invoice_id] = invoice
+	return nil
+}
+
+func (tx *memoryCheckoutTx) InsertPayment(payment models.Payment) error {
+	tx.payments[payment.Payment_id] = payment
+	return nil
+}
+
+func (tx *memoryCheckoutTx) MarkOrderPaid(orderId string, invoiceId string, paidAt time.Time, by *models.Actor) error {
+	order, err := tx.FindOrder(orderId)
+	if err != nil {
+		return err
+	}
+
+	if order.Order_status != nil && *order.Order_status == OrderStatusPaid {
+		return ErrOrderAlreadyPaid
+	}
+
+	status := OrderStatusPaid
+	order.Order_status = &status
+	order.Invoice_id = &invoiceId
+	order.Paid_At = &paidAt
+	order.Updated_At = paidAt
+	order.Updated_By = by
+	order.Version++
+
+	tx.orders[orderId] = order
+	return nil
+}
+
+func (tx *memoryCheckoutTx) FindInvoice(invoiceId string) (models.Invoice, error) {
+	if invoice, ok := tx.invoices[invoiceId]; ok {
+		return invoice, nil
+	}
+
+	invoice, ok := tx.store.invoices[invoiceId]
+	if !ok {
+		return invoice, ErrInvoiceNotFound
+	}
+
+	return invoice, nil
+}
+
+func (tx *memoryCheckoutTx) FindPayments(invoiceId string) ([]models.Payment, error) {
+	var payments []models.Payment
+
+	for id, payment := range tx.store.payments {
+		if _, staged := tx.payments[id]; !staged && payment.Invoice_id == invoiceId {
+			payments = append(payments, payment)
+		}
+	}
+	for _, payment := range tx.payments {
+		if payment.Invoice_id == invoiceId {
+			payments = append(payments, payment)
+		}
+	}
+
+	return payments, nil
+}
+
+func (tx *memoryCheckoutTx) MarkInvoiceRefunded(invoiceId string, refundedAt time.Time, by *models.Actor) error {
+	invoice, err := tx.FindInvoice(invoiceId)
+	if err != nil {
+		return err
+	}
+
+	if invoice.Payment_status == nil || *invoice.Payment_status != PaymentStatusPaid {
+		return ErrInvoiceNotPaid
+	}
+
+	status := PaymentStatusRefunded
+	invoice.Payment_status = &status
+	invoice.Updated_At = refundedAt
+	invoice.Updated_By = by
+	invoice.Version++
+
+	tx.invoices[invoiceId] = invoice
+	return nil
+}
+
+func (tx *memoryCheckoutTx) GroupTables(tableId string) ([]string, error) {
+	table, ok := tx.findTable(tableId)
+	if !ok || table.Group_id == nil {
+		return []string{tableId}, nil
+	}
+	return tx.store.guest.tables.groups[*table.Group_id].Table_ids, nil
+}
+
+func (tx *memoryCheckoutTx) SetTableStatus(tableId string, status string, from []string, reason string, at time.Time) (string, bool, error) {
+	table, ok := tx.findTable(tableId)
+	if !ok {
+		return "", false, nil
+	}
+
+	previous, changed := setStatus(&table, status, from, reason, at)
+	if changed {
+		tx.tables[tableId] = table
+	}
+	return previous, changed, nil
+}
+
+func (tx *memoryCheckoutTx) findTable(tableId string) (models.Table, bool) {
+	if table, ok := tx.tables[tableId]; ok {
+		return table, true
+	}
+	table, ok := tx.store.guest.tables.tables[tableId]
+	return table, ok
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// MongoCheckoutStore runs checkouts inside a MongoDB multi-document
+// transaction. Transactions require a replica set or sharded cluster.
+type MongoCheckoutStore struct {
+	client              *mongo.Client
+	orderCollection     *mongo.Collection
+	orderItemCollection *mongo.Collection
+	invoiceCollection   *mongo.Collection
+	paymentCollection   *mongo.Collection
+	tables              *MongoTableStore
+}
+
+func NewMongoCheckoutStore(client *mongo.Client) *MongoCheckoutStore {
+	return &MongoCheckoutStore{
+		client:              client,
+		orderCollection:     database.OpenCollection(client, "order"),
+		orderItemCollection: database.OpenCollection(client, "orderitems"),
+		invoiceCollection:   database.OpenCollection(client, "invoice"),
+		paymentCollection:   database.OpenCollection(client, "payment"),
+		tables:              NewMongoTableStore(client),
+	}
+}
+
+// EnsureIndexes allows one invoice per order, so an order can't be invoiced
+// again while it is being checked out.
+func (s *MongoCheckoutStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.invoiceCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "order_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (s *MongoCheckoutStore) RunInTransaction(ctx context.Context, fn func(tx CheckoutTx) error) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	txnOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(&mongoCheckoutTx{ctx: sessCtx, store: s})
+	}, txnOpts)
+
+	return err
+}
+
+type mongoCheckoutTx struct {
+	ctx   mongo.SessionContext
+	store *MongoCheckoutStore
+}
+
+func (tx *mongoCheckoutTx) FindOrder(orderId string) (models.Order, error) {
+	var order models.Order
+
+	err := tx.store.orderCollection.FindOne(tx.ctx, bson.M{"order_id": orderId}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return order, ErrOrderNotFound
+	}
+
+	return order, err
+}
+
+func (tx *mongoCheckoutTx) FindOrderItems(orderId string) ([]models.OrderItem, error) {
+	var orderItems []models.OrderItem
+
+	results, err := tx.store.orderItemCollection.Find(tx.ctx, bson.M{"order_id": orderId})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := results.All(tx.ctx, &orderItems); err != nil {
+		return nil, err
+	}
+
+	return orderItems, nil
+}
+
+func (tx *mongoCheckoutTx) InsertInvoice(invoice models.Invoice) error {
+	_, err := tx.store.invoiceCollection.InsertOne(tx.ctx, invoice)
+	return err
+}
+
+func (tx *mongoCheckoutTx) FindOrderInvoice(orderId string) (models.Invoice, error) {
+	var invoice models.Invoice
+
+	err := tx.store.invoiceCollection.FindOne(tx.ctx, bson.M{"order_id": orderId}).Decode(&invoice)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return invoice, ErrInvoiceNotFound
+	}
+	return invoice, err
+}
+
+func (tx *mongoCheckoutTx) PayInvoice(invoice models.Invoice, version int64) error {
+	filter := bson.M{"invoice_id": invoice.Invoice_id, "version": version, "payment_status": PaymentStatusPending}
+
+	result, err := tx.store.invoiceCollection.ReplaceOne(tx.ctx, filter, invoice)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrOrderAlreadyPaid
+	}
+
+	return nil
+}
+
+func (tx *mongoCheckoutTx) InsertPayment(payment models.Payment) error {
+	_, err := tx.store.paymentCollection.InsertOne(tx.ctx, payment)
+	return err
+}
+
+func (tx *mongoCheckoutTx) MarkOrderPaid(orderId string, invoiceId string, paidAt time.Time, by *models.Actor) error {
+	// Filtering on the status as well guards against a concurrent checkout of
+	// the same order that committed after our read.
+	filter := bson.M{"order_id": orderId, "order_status": bson.M{"$ne": OrderStatusPaid}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "order_status", Value: OrderStatusPaid},
+		{Key: "invoice_id", Value: invoiceId},
+		{Key: "paid_at", Value: paidAt},
+		{Key: "updated_at", Value: paidAt},
+		{Key: "updated_by", Value: by},
+	}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+
+	result, err := tx.store.orderCollection.UpdateOne(tx.ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrOrderAlreadyPaid
+	}
+
+	return nil
+}
+
+func (tx *mongoCheckoutTx) FindInvoice(invoiceId string) (models.Invoice, error) {
+	var invoice models.Invoice
+
+	err := tx.store.invoiceCollection.FindOne(tx.ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		return invoice, ErrInvoiceNotFound
+	}
+
+	return invoice, err
+}
+
+func (tx *mongoCheckoutTx) FindPayments(invoiceId string) ([]models.Payment, error) {
+	var payments []models.Payment
+
+	results, err := tx.store.paymentCollection.Find(tx.ctx, bson.M{"invoice_id": invoiceId})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := results.All(tx.ctx, &payments); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+func (tx *mongoCheckoutTx) MarkInvoiceRefunded(invoiceId string, refundedAt time.Time, by *models.Actor) error {
+	filter := bson.M{"invoice_id": invoiceId, "payment_status": PaymentStatusPaid}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "payment_status", Value: PaymentStatusRefunded},
+		{Key: "updated_at", Value: refundedAt},
+		{Key: "updated_by", Value: by},
+	}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+
+	result, err := tx.store.invoiceCollection.UpdateOne(tx.ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrInvoiceNotPaid
+	}
+
+	return nil
+}
+
+// The table store's writes join the transaction through the session context.
+
+func (tx *mongoCheckoutTx) GroupTables(tableId string) ([]string, error) {
+	return tx.store.tables.GroupTables(tx.ctx, tableId)
+}
+
+func (tx *mongoCheckoutTx) SetTableStatus(tableId string, status string, from []string, reason string, at time.Time) (string, bool, error) {
+	return tx.store.tables.SetStatus(tx.ctx, tableId, status, from, reason, at)
+}
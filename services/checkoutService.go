@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OrderStatusOpen = "OPEN"
+	OrderStatusPaid = "PAID"
+
+	PaymentStatusPending  = "PENDING"
+	PaymentStatusPaid     = "PAID"
+	PaymentStatusRefunded = "REFUNDED"
+
+	PaymentTypeCharge = "CHARGE"
+	PaymentTypeRefund = "REFUND"
+)
+
+var (
+	ErrOrderNotFound    = apperrors.New(apperrors.NotFound, "Order with that order_id not found")
+	ErrOrderAlreadyPaid = apperrors.New(apperrors.Conflict, "Order has already been paid")
+	ErrEmptyOrder       = apperrors.New(apperrors.Conflict, "Order has no order items to invoice")
+	ErrInvoiceNotFound  = apperrors.New(apperrors.NotFound, "Invoice with that invoice_id not found")
+	ErrInvoiceNotPaid   = apperrors.New(apperrors.Conflict, "Only paid invoices can be refunded")
+)
+
+// CheckoutTx is the set of writes a checkout performs. Every call made through
+// a CheckoutTx either commits together or not at all.
+type CheckoutTx interface {
+	FindOrder(orderId string) (models.Order, error)
+	FindOrderItems(orderId string) ([]models.OrderItem, error)
+	InsertInvoice(invoice models.Invoice) error
+	// FindOrderInvoice returns the invoice of an order, or
+	// ErrInvoiceNotFound if it has none yet.
+	FindOrderInvoice(orderId string) (models.Invoice, error)
+	// PayInvoice overwrites an invoice that is still PENDING at version.
+	PayInvoice(invoice models.Invoice, version int64) error
+	InsertPayment(payment models.Payment) error
+	MarkOrderPaid(orderId string, invoiceId string, paidAt time.Time, by *models.Actor) error
+	FindInvoice(invoiceId string) (models.Invoice, error)
+	FindPayments(invoiceId string) ([]models.Payment, error)
+	MarkInvoiceRefunded(invoiceId string, refundedAt time.Time, by *models.Actor) error
+	// GroupTables and SetTableStatus are TableStore's, run as part of the
+	// checkout.
+	GroupTables(tableId string) ([]string, error)
+	SetTableStatus(tableId string, status string, from []string, reason string, at time.Time) (string, bool, error)
+}
+
+// CheckoutStore runs fn inside a single atomic unit of work. If fn returns an
+// error nothing it wrote is kept.
+type CheckoutStore interface {
+	RunInTransaction(ctx context.Context, fn func(tx CheckoutTx) error) error
+}
+
+type CheckoutRequest struct {
+	Order_id       string
+	Payment_Method *string       `json:"payment_method" validate:"required,eq=CARD|eq=CASH"`
+	Tip            *float64      `json:"tip" validate:"omitempty,min=0"`
+	Actor          *models.Actor `json:"-"`
+}
+
+type CheckoutResult struct {
+	Invoice models.Invoice `json:"invoice"`
+	Payment models.Payment `json:"payment"`
+}
+
+type CheckoutService struct {
+	store CheckoutStore
+}
+
+func NewCheckoutService(store CheckoutStore) *CheckoutService {
+	return &CheckoutService{store: store}
+}
+
+// Checkout invoices an order, records its payment, marks the order paid and
+// leaves its table, and any grouped with it, to be bussed as one transaction.
+func (s *CheckoutService) Checkout(ctx context.Context, req CheckoutRequest) (CheckoutResult, error) {
+	var result CheckoutResult
+	var tableChanges []TableStatusChange
+
+	err := s.store.RunInTransaction(ctx, func(tx CheckoutTx) error {
+		order, err := tx.FindOrder(req.Order_id)
+		if err != nil {
+			return err
+		}
+
+		if order.Order_status != nil && *order.Order_status == OrderStatusPaid {
+			return ErrOrderAlreadyPaid
+		}
+
+		allOrderItems, err := tx.FindOrderItems(req.Order_id)
+		if err != nil {
+			return err
+		}
+
+		// Items guests ordered that their server hasn't approved aren't
+		// charged for.
+		var orderItems []models.OrderItem
+		for _, orderItem := range allOrderItems {
+			if Billable(orderItem) {
+				orderItems = append(orderItems, orderItem)
+			}
+		}
+
+		if len(orderItems) == 0 {
+			return ErrEmptyOrder
+		}
+
+		var total float64
+		for _, orderItem := range orderItems {
+			if orderItem.Unit_Price != nil {
+				total += *orderItem.Unit_Price
+			}
+		}
+
+		// Delivery orders must reach their zone's minimum before the fee,
+		// which is then added on.
+		var deliveryFee float64
+		if order.Delivery_Quote != nil {
+			if total < order.Delivery_Quote.Min_Order {
+				return DeliveryMinimum(*order.Delivery_Quote)
+			}
+			deliveryFee = order.Delivery_Quote.Delivery_Fee
+			total += deliveryFee
+		}
+
+		var tip float64
+		if req.Tip != nil {
+			tip = *req.Tip
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		paymentStatus := PaymentStatusPaid
+
+		invoice := models.Invoice{
+			ID:               primitive.NewObjectID(),
+			Order_id:         req.Order_id,
+			Payment_Method:   req.Payment_Method,
+			Payment_status:   &paymentStatus,
+			Payment_due_date: now,
+			Total_amount:     helpers.ToFixed(total, 2),
+			Delivery_Fee:     helpers.ToFixed(deliveryFee, 2),
+			Created_At:       now,
+			Updated_At:       now,
+			Version:          1,
+			Created_By:       req.Actor,
+		}
+		invoice.Invoice_id = invoice.ID.Hex()
+
+		payment := models.Payment{
+			ID:             primitive.NewObjectID(),
+			Invoice_id:     invoice.Invoice_id,
+			Order_id:       req.Order_id,
+			Payment_Method: req.Payment_Method,
+			Payment_type:   PaymentTypeCharge,
+			Amount:         helpers.ToFixed(total+tip, 2),
+			Tip:            helpers.ToFixed(tip, 2),
+			Created_At:     now,
+			Version:        1,
+			Created_By:     req.Actor,
+		}
+		payment.Payment_id = payment.ID.Hex()
+
+		// An invoice already presented to the guests is the one paid, so
+		// the order is only ever invoiced once.
+		pending, err := tx.FindOrderInvoice(req.Order_id)
+		switch {
+		case err == nil:
+			if pending.Payment_status == nil || *pending.Payment_status != PaymentStatusPending {
+				return ErrOrderAlreadyPaid
+			}
+			invoice.ID, invoice.Invoice_id = pending.ID, pending.Invoice_id
+			invoice.Created_At, invoice.Created_By = pending.Created_At, pending.Created_By
+			invoice.Updated_By = req.Actor
+			invoice.Version = pending.Version + 1
+			payment.Invoice_id = invoice.Invoice_id
+
+			if err := tx.PayInvoice(invoice, pending.Version); err != nil {
+				return err
+			}
+		case errors.Is(err, ErrInvoiceNotFound):
+			if err := tx.InsertInvoice(invoice); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		if err := tx.InsertPayment(payment); err != nil {
+			return err
+		}
+
+		if err := tx.MarkOrderPaid(req.Order_id, invoice.Invoice_id, now, req.Actor); err != nil {
+			return err
+		}
+
+		// A retried transaction starts over, so only the last attempt's
+		// changes are kept.
+		tableChanges = nil
+		if order.Table_id != nil && *order.Table_id != "" {
+			tableChanges, err = closeTables(tx, *order.Table_id, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		result = CheckoutResult{Invoice: invoice, Payment: payment}
+		return nil
+	})
+	if err != nil {
+		return CheckoutResult{}, err
+	}
+
+	events.Publish(ctx, events.Event{Type: events.InvoicePaid, Subject: result.Invoice.Invoice_id, Data: result.Invoice})
+	for _, change := range tableChanges {
+		events.Publish(ctx, events.Event{Type: events.TableStatusChanged, Subject: change.Table_id, Occurred_At: change.Changed_At, Data: change})
+	}
+
+	return result, nil
+}
+
+// closeTables moves tableId, and every table grouped with it, from occupied
+// to DIRTY, the same way the TableBoard moves tables.
+func closeTables(tx CheckoutTx, tableId string, at time.Time) ([]TableStatusChange, error) {
+	tableIds, err := tx.GroupTables(tableId)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []TableStatusChange
+	for _, id := range tableIds {
+		reason := string(events.InvoicePaid)
+		previous, changed, err := tx.SetTableStatus(id, TableStatusDirty, occupiedStatuses, reason, at)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			changes = append(changes, TableStatusChange{Table_id: id, From: previous, To: TableStatusDirty, Reason: reason, Changed_At: at})
+		}
+	}
+	return changes, nil
+}
+
+// Refund reverses every charge taken against a paid invoice and marks the
+// invoice refunded as one transaction. by is recorded as having made the
+// refund.
+func (s *CheckoutService) Refund(ctx context.Context, invoiceId string, by *models.Actor) (models.Payment, error) {
+	var refund models.Payment
+	var refunded models.Invoice
+
+	err := s.store.RunInTransaction(ctx, func(tx CheckoutTx) error {
+		invoice, err := tx.FindInvoice(invoiceId)
+		if err != nil {
+			return err
+		}
+
+		if invoice.Payment_status == nil || *invoice.Payment_status != PaymentStatusPaid {
+			return ErrInvoiceNotPaid
+		}
+
+		payments, err := tx.FindPayments(invoiceId)
+		if err != nil {
+			return err
+		}
+
+		var charged, tip float64
+		for _, payment := range payments {
+			charged += payment.Amount
+			tip += payment.Tip
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		refund = models.Payment{
+			ID:             primitive.NewObjectID(),
+			Invoice_id:     invoiceId,
+			Order_id:       invoice.Order_id,
+			Payment_Method: invoice.Payment_Method,
+			Payment_type:   PaymentTypeRefund,
+			Amount:         helpers.ToFixed(-charged, 2),
+			Tip:            helpers.ToFixed(-tip, 2),
+			Created_At:     now,
+			Version:        1,
+			Created_By:     by,
+		}
+		refund.Payment_id = refund.ID.Hex()
+
+		if err := tx.InsertPayment(refund); err != nil {
+			return err
+		}
+
+		if err := tx.MarkInvoiceRefunded(invoiceId, now, by); err != nil {
+			return err
+		}
+
+		refundedStatus := PaymentStatusRefunded
+		refunded = invoice
+		refunded.Payment_status = &refundedStatus
+		refunded.Updated_At = now
+		refunded.Updated_By = by
+		refunded.Version++
+		return nil
+	})
+	if err != nil {
+		return models.Payment{}, err
+	}
+
+	events.Publish(ctx, events.Event{Type: events.InvoiceRefunded, Subject: invoiceId, Data: refunded})
+
+	return refund, nil
+}
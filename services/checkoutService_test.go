@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+var fixedTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestCheckout() (*CheckoutService, *MemoryCheckoutStore, *MemoryGuestOrderStore) {
+	guest := NewMemoryGuestOrderStore(NewMemoryTableStore())
+	store := NewMemoryCheckoutStore(guest)
+	return NewCheckoutService(store), store, guest
+}
+
+// seedOrder puts an open order with one item at each price in guest.
+func seedOrder(t *testing.T, guest *MemoryGuestOrderStore, order models.Order, prices ...float64) {
+	t.Helper()
+
+	status := OrderStatusOpen
+	order.Order_status = &status
+	if err := guest.InsertOrder(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+
+	var orderItems []models.OrderItem
+	for i, price := range prices {
+		price := price
+		orderId := order.Order_id
+		orderItems = append(orderItems, models.OrderItem{
+			Order_item_id: order.Order_id + "-" + string(rune('a'+i)),
+			Order_id:      &orderId,
+			Unit_Price:    &price,
+		})
+	}
+	if err := guest.InsertOrderItems(context.Background(), orderItems); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkoutRequest(orderId string) CheckoutRequest {
+	method := "CARD"
+	tip := 2.5
+	return CheckoutRequest{Order_id: orderId, Payment_Method: &method, Tip: &tip}
+}
+
+func TestCheckoutPaysOrder(t *testing.T) {
+	service, store, guest := newTestCheckout()
+	seedOrder(t, guest, models.Order{Order_id: "o1"}, 12, 8.5)
+
+	result, err := service.Checkout(context.Background(), checkoutRequest("o1"))
+	if err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+
+	if result.Invoice.Total_amount != 20.5 {
+		t.Errorf("invoice total = %v, want 20.5", result.Invoice.Total_amount)
+	}
+	if result.Payment.Amount != 23 || result.Payment.Tip != 2.5 {
+		t.Errorf("payment = %v with tip %v, want 23 with tip 2.5", result.Payment.Amount, result.Payment.Tip)
+	}
+
+	order, err := guest.tables.FindOrder(context.Background(), "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Order_status == nil || *order.Order_status != OrderStatusPaid {
+		t.Errorf("order status = %v, want %s", order.Order_status, OrderStatusPaid)
+	}
+	if order.Invoice_id == nil || *order.Invoice_id != result.Invoice.Invoice_id {
+		t.Errorf("order invoice_id = %v, want %s", order.Invoice_id, result.Invoice.Invoice_id)
+	}
+	if len(store.Invoices()) != 1 || len(store.Payments()) != 1 {
+		t.Errorf("stored %d invoices and %d payments, want 1 and 1", len(store.Invoices()), len(store.Payments()))
+	}
+}
+
+func TestCheckoutSkipsUnapprovedItems(t *testing.T) {
+	service, _, guest := newTestCheckout()
+	seedOrder(t, guest, models.Order{Order_id: "o1"}, 10)
+
+	orderId, pending := "o1", ApprovalPending
+	price := 99.0
+	guest.InsertOrderItems(context.Background(), []models.OrderItem{{Order_item_id: "guest", Order_id: &orderId, Unit_Price: &price, Approval_Status: &pending}})
+
+	result, err := service.Checkout(context.Background(), checkoutRequest("o1"))
+	if err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+	if result.Invoice.Total_amount != 10 {
+		t.Errorf("invoice total = %v, want 10", result.Invoice.Total_amount)
+	}
+}
+
+func TestCheckoutErrors(t *testing.T) {
+	service, _, guest := newTestCheckout()
+	seedOrder(t, guest, models.Order{Order_id: "empty"})
+
+	if _, err := service.Checkout(context.Background(), checkoutRequest("missing")); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("missing order: err = %v, want %v", err, ErrOrderNotFound)
+	}
+	if _, err := service.Checkout(context.Background(), checkoutRequest("empty")); !errors.Is(err, ErrEmptyOrder) {
+		t.Errorf("empty order: err = %v, want %v", err, ErrEmptyOrder)
+	}
+}
+
+func TestCheckoutPaysPendingInvoice(t *testing.T) {
+	service, store, guest := newTestCheckout()
+	seedOrder(t, guest, models.Order{Order_id: "o1"}, 12)
+
+	status := PaymentStatusPending
+	presented := models.Invoice{Invoice_id: "inv1", Order_id: "o1", Payment_status: &status, Created_At: fixedTime, Version: 1}
+	store.RunInTransaction(context.Background(), func(tx CheckoutTx) error { return tx.InsertInvoice(presented) })
+
+	result, err := service.Checkout(context.Background(), checkoutRequest("o1"))
+	if err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+	if result.Invoice.Invoice_id != "inv1" || result.Payment.Invoice_id != "inv1" {
+		t.Errorf("paid invoice %s with payment for %s, want the pending inv1", result.Invoice.Invoice_id, result.Payment.Invoice_id)
+	}
+
+	invoices := store.Invoices()
+	if len(invoices) != 1 {
+		t.Fatalf("stored %d invoices, want the pending one paid", len(invoices))
+	}
+	paid := invoices[0]
+	if paid.Payment_status == nil || *paid.Payment_status != PaymentStatusPaid || paid.Version != 2 || !paid.Created_At.Equal(fixedTime) {
+		t.Errorf("invoice = %v version %d created %v, want PAID version 2 created %v", paid.Payment_status, paid.Version, paid.Created_At, fixedTime)
+	}
+
+	if _, err := service.Checkout(context.Background(), checkoutRequest("o1")); !errors.Is(err, ErrOrderAlreadyPaid) {
+		t.Errorf("second checkout: err = %v, want %v", err, ErrOrderAlreadyPaid)
+	}
+}
+
+func TestCheckoutRollsBackOnFailure(t *testing.T) {
+	service, store, guest := newTestCheckout()
+	quote := models.DeliveryQuote{Min_Order: 30, Delivery_Fee: 5}
+	seedOrder(t, guest, models.Order{Order_id: "o1", Delivery_Quote: &quote}, 10)
+
+	if _, err := service.Checkout(context.Background(), checkoutRequest("o1")); err == nil {
+		t.Fatal("Checkout below the delivery minimum succeeded")
+	}
+
+	// A failure after every write has been made keeps none of them.
+	failed := errors.New("failed")
+	err := store.RunInTransaction(context.Background(), func(tx CheckoutTx) error {
+		tx.InsertInvoice(models.Invoice{Invoice_id: "i1", Order_id: "o1"})
+		tx.InsertPayment(models.Payment{Payment_id: "p1", Invoice_id: "i1"})
+		if err := tx.MarkOrderPaid("o1", "i1", fixedTime, nil); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("RunInTransaction: err = %v, want %v", err, failed)
+	}
+
+	if len(store.Invoices()) != 0 || len(store.Payments()) != 0 {
+		t.Errorf("stored %d invoices and %d payments after failing, want none", len(store.Invoices()), len(store.Payments()))
+	}
+	order, _ := guest.tables.FindOrder(context.Background(), "o1")
+	if *order.Order_status != OrderStatusOpen || order.Invoice_id != nil {
+		t.Errorf("order is %s with invoice %v after failing, want it left open", *order.Order_status, order.Invoice_id)
+	}
+}
+
+func TestCheckoutLeavesTablesDirty(t *testing.T) {
+	service, _, guest := newTestCheckout()
+	ctx := context.Background()
+
+	ordering, seated, free := TableStatusOrdering, TableStatusSeated, TableStatusAvailable
+	guest.tables.SaveTable(models.Table{Table_id: "t1", Table_status: &ordering})
+	guest.tables.SaveTable(models.Table{Table_id: "t2", Table_status: &seated})
+	guest.tables.SaveTable(models.Table{Table_id: "t3", Table_status: &free})
+	if err := guest.tables.CreateGroup(ctx, models.TableGroup{Group_id: "g1", Table_ids: []string{"t1", "t2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tableId := "t1"
+	seedOrder(t, guest, models.Order{Order_id: "o1", Table_id: &tableId}, 10)
+
+	if _, err := service.Checkout(ctx, checkoutRequest("o1")); err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+
+	for id, want := range map[string]string{"t1": TableStatusDirty, "t2": TableStatusDirty, "t3": TableStatusAvailable} {
+		table, _ := guest.tables.Table(id)
+		if got := tableStatus(table); got != want {
+			t.Errorf("table %s is %s after checkout, want %s", id, got, want)
+		}
+	}
+}
+
+func TestCheckoutKeepsTableOnFailure(t *testing.T) {
+	_, store, guest := newTestCheckout()
+
+	ordering := TableStatusOrdering
+	guest.tables.SaveTable(models.Table{Table_id: "t1", Table_status: &ordering})
+
+	failed := errors.New("failed")
+	err := store.RunInTransaction(context.Background(), func(tx CheckoutTx) error {
+		changes, err := closeTables(tx, "t1", fixedTime)
+		if err != nil {
+			return err
+		}
+		if len(changes) != 1 {
+			t.Errorf("closeTables made %d changes, want 1", len(changes))
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("RunInTransaction: err = %v, want %v", err, failed)
+	}
+
+	table, _ := guest.tables.Table("t1")
+	if got := tableStatus(table); got != TableStatusOrdering {
+		t.Errorf("table is %s after a failed checkout, want %s", got, TableStatusOrdering)
+	}
+}
+
+func TestCheckoutConcurrent(t *testing.T) {
+	service, store, guest := newTestCheckout()
+	seedOrder(t, guest, models.Order{Order_id: "o1"}, 10)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.Checkout(context.Background(), checkoutRequest("o1"))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var paid int
+	for err := range errs {
+		switch {
+		case err == nil:
+			paid++
+		case !errors.Is(err, ErrOrderAlreadyPaid):
+			t.Errorf("Checkout: %v", err)
+		}
+	}
+
+	if paid != 1 || len(store.Invoices()) != 1 {
+		t.Errorf("%d checkouts succeeded with %d invoices, want 1 and 1", paid, len(store.Invoices()))
+	}
+}
+
+func TestRefund(t *testing.T) {
+	service, store, guest := newTestCheckout()
+	seedOrder(t, guest, models.Order{Order_id: "o1"}, 10)
+
+	result, err := service.Checkout(context.Background(), checkoutRequest("o1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refund, err := service.Refund(context.Background(), result.Invoice.Invoice_id, nil)
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if refund.Amount != -12.5 || refund.Tip != -2.5 {
+		t.Errorf("refund = %v with tip %v, want -12.5 with tip -2.5", refund.Amount, refund.Tip)
+	}
+	if _, err := service.Refund(context.Background(), result.Invoice.Invoice_id, nil); !errors.Is(err, ErrInvoiceNotPaid) {
+		t.Errorf("second refund: err = %v, want %v", err, ErrInvoiceNotPaid)
+	}
+	if len(store.Payments()) != 2 {
+		t.Errorf("stored %d payments, want the charge and the refund", len(store.Payments()))
+	}
+}
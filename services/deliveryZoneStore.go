@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/geo"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoBadGeometry is the error MongoDB gives when a polygon can't be indexed,
+// for example because its edges cross.
+const mongoBadGeometry = 16755
+
+// MemoryDeliveryZoneStore keeps delivery zones in process and finds the ones
+// containing a point by checking each of them.
+type MemoryDeliveryZoneStore struct {
+	mu    sync.Mutex
+	zones map[string]models.DeliveryZone
+}
+
+func NewMemoryDeliveryZoneStore() *MemoryDeliveryZoneStore {
+	return &MemoryDeliveryZoneStore{zones: map[string]models.DeliveryZone{}}
+}
+
+func (s *MemoryDeliveryZoneStore) Insert(ctx context.Context, zone models.DeliveryZone) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones[zone.Zone_id] = zone
+	return nil
+}
+
+func (s *MemoryDeliveryZoneStore) Find(ctx context.Context, zoneId string) (models.DeliveryZone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone, ok := s.zones[zoneId]
+	if !ok {
+		return zone, ErrDeliveryZoneNotFound
+	}
+	return zone, nil
+}
+
+func (s *MemoryDeliveryZoneStore) List(ctx context.Context) ([]models.DeliveryZone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zones []models.DeliveryZone
+	for _, zone := range s.zones {
+		zones = append(zones, zone)
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return *zones[i].Name < *zones[j].Name })
+	return zones, nil
+}
+
+func (s *MemoryDeliveryZoneStore) Replace(ctx context.Context, zone models.DeliveryZone, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.zones[zone.Zone_id]
+	if !ok {
+		return ErrDeliveryZoneNotFound
+	}
+	if current.Version != version {
+		return ErrDeliveryZoneChanged
+	}
+	s.zones[zone.Zone_id] = zone
+	return nil
+}
+
+func (s *MemoryDeliveryZoneStore) Delete(ctx context.Context, zoneId string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.zones[zoneId]
+	if !ok {
+		return ErrDeliveryZoneNotFound
+	}
+	if current.Version != version {
+		return ErrDeliveryZoneChanged
+	}
+	delete(s.zones, zoneId)
+	return nil
+}
+
+func (s *MemoryDeliveryZoneStore) Containing(ctx context.Context, point geo.Point, distance *float64) ([]models.DeliveryZone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zones []models.DeliveryZone
+	for _, zone := range s.zones {
+		inArea := zone.Area != nil && geo.InPolygon(point, zone.Area.Coordinates)
+		inRadius := zone.Radius_Meters != nil && distance != nil && *distance <= *zone.Radius_Meters
+		if inArea || inRadius {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
+
+type MongoDeliveryZoneStore struct {
+	zoneCollection *mongo.Collection
+}
+
+func NewMongoDeliveryZoneStore(client *mongo.Client) *MongoDeliveryZoneStore {
+	return &MongoDeliveryZoneStore{zoneCollection: database.OpenCollection(client, "delivery_zones")}
+}
+
+func (s *MongoDeliveryZoneStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.zoneCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "zone_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "area", Value: "2dsphere"}}},
+	})
+	return err
+}
+
+func (s *MongoDeliveryZoneStore) Insert(ctx context.Context, zone models.DeliveryZone) error {
+	_, err := s.zoneCollection.InsertOne(ctx, zone)
+	return badGeometry(err)
+}
+
+func (s *MongoDeliveryZoneStore) Find(ctx context.Context, zoneId string) (models.DeliveryZone, error) {
+	var zone models.DeliveryZone
+
+	err := s.zoneCollection.FindOne(ctx, bson.M{"zone_id": zoneId}).Decode(&zone)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return zone, ErrDeliveryZoneNotFound
+	}
+	return zone, err
+}
+
+func (s *MongoDeliveryZoneStore) List(ctx context.Context) ([]models.DeliveryZone, error) {
+	return s.find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+}
+
+func (s *MongoDeliveryZoneStore) Replace(ctx context.Context, zone models.DeliveryZone, version int64) error {
+	result, err := s.zoneCollection.ReplaceOne(ctx, bson.M{"zone_id": zone.Zone_id, "version": version}, zone)
+	if err != nil {
+		return badGeometry(err)
+	}
+	if result.MatchedCount == 0 {
+		return s.mismatch(ctx, zone.Zone_id)
+	}
+	return nil
+}
+
+func (s *MongoDeliveryZoneStore) Delete(ctx context.Context, zoneId string, version int64) error {
+	result, err := s.zoneCollection.DeleteOne(ctx, bson.M{"zone_id": zoneId, "version": version})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return s.mismatch(ctx, zoneId)
+	}
+	return nil
+}
+
+// Containing finds polygon zones with a 2dsphere query on their area. Radius
+// zones all share the restaurant as their center, so only the distance to
+// the point has to be compared.
+func (s *MongoDeliveryZoneStore) Containing(ctx context.Context, point geo.Point, distance *float64) ([]models.DeliveryZone, error) {
+	geometry := bson.M{"type": "Point", "coordinates": bson.A{point.Lng, point.Lat}}
+
+	or := bson.A{bson.M{"area": bson.M{"$geoIntersects": bson.M{"$geometry": geometry}}}}
+	if distance != nil {
+		or = append(or, bson.M{"radius_meters": bson.M{"$gte": *distance}})
+	}
+
+	return s.find(ctx, bson.M{"$or": or})
+}
+
+func (s *MongoDeliveryZoneStore) find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]models.DeliveryZone, error) {
+	results, err := s.zoneCollection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []models.DeliveryZone
+	if err := results.All(ctx, &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// mismatch tells a missing zone apart from a stale version after a write
+// matched nothing.
+func (s *MongoDeliveryZoneStore) mismatch(ctx context.Context, zoneId string) error {
+	count, err := s.zoneCollection.CountDocuments(ctx, bson.M{"zone_id": zoneId})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrDeliveryZoneNotFound
+	}
+	return ErrDeliveryZoneChanged
+}
+
+// badGeometry reports a polygon MongoDB refused to index as the client's
+// mistake.
+func badGeometry(err error) error {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == mongoBadGeometry {
+				return ErrDeliveryZoneArea
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/geo"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrDeliveryZoneNotFound = apperrors.New(apperrors.NotFound, "Delivery zone with that zone_id not found")
+	ErrDeliveryZoneChanged  = apperrors.New(apperrors.PreconditionFailed, "The delivery zone was modified after the provided ETag. Fetch it again and retry")
+	ErrNotDeliverable       = apperrors.New(apperrors.Conflict, "That address is outside every delivery zone")
+	ErrDeliveryZoneArea     = apperrors.New(apperrors.BadRequest, "The area is not a valid polygon, check that its edges don't cross")
+)
+
+type DeliveryZoneStore interface {
+	Insert(ctx context.Context, zone models.DeliveryZone) error
+	Find(ctx context.Context, zoneId string) (models.DeliveryZone, error)
+	List(ctx context.Context) ([]models.DeliveryZone, error)
+	// Replace overwrites the zone if it is still at version.
+	Replace(ctx context.Context, zone models.DeliveryZone, version int64) error
+	// Delete removes the zone if it is still at version.
+	Delete(ctx context.Context, zoneId string, version int64) error
+	// Containing returns the zones whose area contains point, and, when
+	// distance is given, the zones whose radius reaches that far.
+	Containing(ctx context.Context, point geo.Point, distance *float64) ([]models.DeliveryZone, error)
+}
+
+type DeliveryQuoteRequest struct {
+	Latitude  *float64 `form:"latitude" json:"latitude" validate:"required,min=-90,max=90"`
+	Longitude *float64 `form:"longitude" json:"longitude" validate:"required,min=-180,max=180"`
+}
+
+// DeliveryZoneUpdate changes the fields of a delivery zone that are set.
+type DeliveryZoneUpdate struct {
+	Name          *string            `json:"name" validate:"omitempty,min=1,max=100"`
+	Area          *models.GeoPolygon `json:"area"`
+	Radius_Meters *float64           `json:"radius_meters" validate:"omitempty,gt=0,max=100000"`
+	Min_Order     *float64           `json:"min_order" validate:"omitempty,min=0"`
+	Delivery_Fee  *float64           `json:"delivery_fee" validate:"omitempty,min=0"`
+	Eta_Bands     []models.EtaBand   `json:"eta_bands" validate:"omitempty,max=10,dive"`
+}
+
+// DeliveryZones keeps the areas the restaurant delivers to and quotes the
+// fee, minimum order and delivery time for an address.
+type DeliveryZones struct {
+	store DeliveryZoneStore
+	// restaurant is where radius zones and ETA bands are measured from. Zones
+	// can only be drawn as polygons without it.
+	restaurant *geo.Point
+	// deliveryTime is promised for zones without ETA bands.
+	deliveryTime time.Duration
+}
+
+func NewDeliveryZones(store DeliveryZoneStore, restaurant *geo.Point, deliveryTime time.Duration) *DeliveryZones {
+	return &DeliveryZones{store: store, restaurant: restaurant, deliveryTime: deliveryTime}
+}
+
+func (z *DeliveryZones) List(ctx context.Context) ([]models.DeliveryZone, error) {
+	zones, err := z.store.List(ctx)
+	if zones == nil {
+		zones = []models.DeliveryZone{}
+	}
+	return zones, err
+}
+
+func (z *DeliveryZones) Find(ctx context.Context, zoneId string) (models.DeliveryZone, error) {
+	return z.store.Find(ctx, zoneId)
+}
+
+func (z *DeliveryZones) Create(ctx context.Context, zone models.DeliveryZone, by *models.Actor) (models.DeliveryZone, error) {
+	if err := z.check(&zone); err != nil {
+		return zone, err
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	zone.ID = primitive.NewObjectID()
+	zone.Zone_id = zone.ID.Hex()
+	zone.Created_At = now
+	zone.Updated_At = now
+	zone.Version = 1
+	zone.Created_By = by
+
+	if err := z.store.Insert(ctx, zone); err != nil {
+		return zone, err
+	}
+	return zone, nil
+}
+
+// Update applies change to the zone if it is still at version. Giving a zone
+// an area takes away its radius, and the other way round.
+func (z *DeliveryZones) Update(ctx context.Context, zoneId string, version int64, change DeliveryZoneUpdate, by *models.Actor) (models.DeliveryZone, error) {
+	zone, err := z.store.Find(ctx, zoneId)
+	if err != nil {
+		return zone, err
+	}
+	if zone.Version != version {
+		return zone, ErrDeliveryZoneChanged
+	}
+
+	if change.Name != nil {
+		zone.Name = change.Name
+	}
+	if change.Area != nil || change.Radius_Meters != nil {
+		zone.Area, zone.Radius_Meters = change.Area, change.Radius_Meters
+	}
+	if change.Min_Order != nil {
+		zone.Min_Order = change.Min_Order
+	}
+	if change.Delivery_Fee != nil {
+		zone.Delivery_Fee = change.Delivery_Fee
+	}
+	if change.Eta_Bands != nil {
+		zone.Eta_Bands = change.Eta_Bands
+	}
+
+	if err := z.check(&zone); err != nil {
+		return zone, err
+	}
+
+	zone.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	zone.Updated_By = by
+	zone.Version++
+
+	if err := z.store.Replace(ctx, zone, version); err != nil {
+		return zone, err
+	}
+	return zone, nil
+}
+
+func (z *DeliveryZones) Delete(ctx context.Context, zoneId string, version int64) error {
+	return z.store.Delete(ctx, zoneId, version)
+}
+
+// Quote finds the zone point is in and what delivering there costs. Where
+// zones overlap the one with the lowest fee is used.
+func (z *DeliveryZones) Quote(ctx context.Context, point geo.Point) (models.DeliveryQuote, error) {
+	var distance *float64
+	if z.restaurant != nil {
+		meters := geo.Distance(*z.restaurant, point)
+		distance = &meters
+	}
+
+	zones, err := z.store.Containing(ctx, point, distance)
+	if err != nil {
+		return models.DeliveryQuote{}, err
+	}
+	if len(zones) == 0 {
+		return models.DeliveryQuote{}, ErrNotDeliverable
+	}
+
+	sort.SliceStable(zones, func(i, j int) bool {
+		if amount(zones[i].Delivery_Fee) != amount(zones[j].Delivery_Fee) {
+			return amount(zones[i].Delivery_Fee) < amount(zones[j].Delivery_Fee)
+		}
+		return amount(zones[i].Min_Order) < amount(zones[j].Min_Order)
+	})
+	zone := zones[0]
+
+	quote := models.DeliveryQuote{
+		Zone_id:         zone.Zone_id,
+		Zone_Name:       *zone.Name,
+		Delivery_Fee:    amount(zone.Delivery_Fee),
+		Min_Order:       amount(zone.Min_Order),
+		Distance_Meters: distance,
+		Eta_Min_Minutes: int(z.deliveryTime / time.Minute),
+		Eta_Max_Minutes: int(z.deliveryTime / time.Minute),
+	}
+
+	if band, ok := etaBand(zone.Eta_Bands, distance); ok {
+		quote.Eta_Min_Minutes = band.Min_Minutes
+		quote.Eta_Max_Minutes = band.Max_Minutes
+	}
+
+	return quote, nil
+}
+
+// check validates what the struct tags can't: that a zone is either an area
+// or a radius, that the area is a proper polygon, and that a radius can be
+// measured from somewhere.
+func (z *DeliveryZones) check(zone *models.DeliveryZone) error {
+	invalid := func(field string, rule string, message string) error {
+		return &apperrors.Error{Code: apperrors.ValidationFailed, Message: "The request body is not valid", Fields: []apperrors.FieldError{{Field: field, Rule: rule, Message: message}}}
+	}
+
+	switch {
+	case zone.Area == nil && zone.Radius_Meters == nil:
+		return invalid("area", "required_without", "area or radius_meters is required")
+	case zone.Area != nil && zone.Radius_Meters != nil:
+		return invalid("radius_meters", "excluded_with", "cannot be set together with area")
+	case zone.Area != nil:
+		if err := geo.ValidatePolygon(zone.Area.Coordinates); err != nil {
+			return invalid("area.coordinates", "polygon", err.Error())
+		}
+	case z.restaurant == nil:
+		return invalid("radius_meters", "restaurant_location", "needs RESTAURANT_LOCATION to be set")
+	}
+
+	sort.Slice(zone.Eta_Bands, func(i, j int) bool { return zone.Eta_Bands[i].Up_To_Meters < zone.Eta_Bands[j].Up_To_Meters })
+	return nil
+}
+
+// etaBand returns the band for an address distance away. Addresses beyond
+// the last band, or when the distance isn't known, get the last band.
+func etaBand(bands []models.EtaBand, distance *float64) (models.EtaBand, bool) {
+	if len(bands) == 0 {
+		return models.EtaBand{}, false
+	}
+	if distance != nil {
+		for _, band := range bands {
+			if *distance <= band.Up_To_Meters {
+				return band, true
+			}
+		}
+	}
+	return bands[len(bands)-1], true
+}
+
+// DeliveryMinimum is the error for checking out a delivery order that comes
+// to less than its zone's minimum.
+func DeliveryMinimum(quote models.DeliveryQuote) error {
+	return apperrors.New(apperrors.Conflict, fmt.Sprintf("Delivery orders to %s must come to at least %.2f before the delivery fee", quote.Zone_Name, quote.Min_Order))
+}
+
+func amount(value *float64) float64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
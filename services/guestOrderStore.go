@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryGuestOrderStore keeps menus, foods and order items in process and
+// reads tables and orders from a MemoryTableStore.
+type MemoryGuestOrderStore struct {
+	mu         sync.Mutex
+	menus      map[string]models.Menu
+	foods      map[string]models.Food
+	orderItems map[string]models.OrderItem
+	tables     *MemoryTableStore
+}
+
+func NewMemoryGuestOrderStore(tables *MemoryTableStore) *MemoryGuestOrderStore {
+	return &MemoryGuestOrderStore{
+		menus:      map[string]models.Menu{},
+		foods:      map[string]models.Food{},
+		orderItems: map[string]models.OrderItem{},
+		tables:     tables,
+	}
+}
+
+func (s *MemoryGuestOrderStore) SaveMenu(menu models.Menu) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.menus[menu.Menu_Id] = menu
+}
+
+func (s *MemoryGuestOrderStore) SaveFood(food models.Food) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.foods[food.Food_ID] = food
+}
+
+func (s *MemoryGuestOrderStore) SetQrNonce(ctx context.Context, tableId string, nonce string, onlyIfUnset bool) (string, error) {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	table, ok := s.tables.tables[tableId]
+	if !ok {
+		return "", ErrTableNotFound
+	}
+	if onlyIfUnset && table.Qr_Nonce != nil {
+		return *table.Qr_Nonce, nil
+	}
+
+	table.Qr_Nonce = &nonce
+	s.tables.tables[tableId] = table
+	return nonce, nil
+}
+
+func (s *MemoryGuestOrderStore) ActiveMenus(ctx context.Context, at time.Time) ([]models.Menu, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var menus []models.Menu
+	for _, menu := range s.menus {
+		if (menu.Start_Date == nil || !menu.Start_Date.After(at)) && (menu.End_Date == nil || !menu.End_Date.Before(at)) {
+			menus = append(menus, menu)
+		}
+	}
+
+	sort.Slice(menus, func(i, j int) bool { return menus[i].Name < menus[j].Name })
+	return menus, nil
+}
+
+func (s *MemoryGuestOrderStore) MenuFoods(ctx context.Context, menuIds []string) ([]models.Food, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var foods []models.Food
+	for _, food := range s.foods {
+		if food.Menu_ID != nil && contains(menuIds, *food.Menu_ID) {
+			foods = append(foods, food)
+		}
+	}
+
+	sort.Slice(foods, func(i, j int) bool { return foodName(foods[i]) < foodName(foods[j]) })
+	return foods, nil
+}
+
+func (s *MemoryGuestOrderStore) OpenOrder(ctx context.Context, tableIds []string) (models.Order, error) {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	var open *models.Order
+	for _, order := range s.tables.orders {
+		if order.Table_id == nil || !contains(tableIds, *order.Table_id) || order.Order_status == nil || *order.Order_status != OrderStatusOpen {
+			continue
+		}
+		if open == nil || order.Created_At.After(open.Created_At) {
+			order := order
+			open = &order
+		}
+	}
+
+	if open == nil {
+		return models.Order{}, ErrOrderNotFound
+	}
+	return *open, nil
+}
+
+func (s *MemoryGuestOrderStore) InsertOrder(ctx context.Context, order models.Order) error {
+	s.tables.SaveOrder(order)
+	return nil
+}
+
+func (s *MemoryGuestOrderStore) InsertOrderItems(ctx context.Context, orderItems []models.OrderItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, orderItem := range orderItems {
+		s.orderItems[orderItem.Order_item_id] = orderItem
+	}
+	return nil
+}
+
+func (s *MemoryGuestOrderStore) OrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error) {
+	return s.findItems(func(orderItem models.OrderItem) bool {
+		return orderItem.Order_id != nil && *orderItem.Order_id == orderId
+	}), nil
+}
+
+func (s *MemoryGuestOrderStore) CountPending(ctx context.Context, orderId string) (int64, error) {
+	pending := s.findItems(func(orderItem models.OrderItem) bool {
+		return orderItem.Order_id != nil && *orderItem.Order_id == orderId && isPending(orderItem)
+	})
+	return int64(len(pending)), nil
+}
+
+func (s *MemoryGuestOrderStore) PendingItems(ctx context.Context) ([]models.OrderItem, error) {
+	return s.findItems(isPending), nil
+}
+
+func (s *MemoryGuestOrderStore) ReviewItem(ctx context.Context, orderItemId string, status string, reason *string, at time.Time, by *models.Actor) (models.OrderItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orderItem, ok := s.orderItems[orderItemId]
+	if !ok {
+		return orderItem, ErrOrderItemNotFound
+	}
+	if orderItem.Approval_Status == nil {
+		return orderItem, ErrOrderItemNotByGuest
+	}
+	if *orderItem.Approval_Status != ApprovalPending {
+		return orderItem, ErrOrderItemReviewed
+	}
+
+	orderItem.Approval_Status = &status
+	orderItem.Reviewed_At = &at
+	orderItem.Reviewed_By = by
+	orderItem.Reject_Reason = reason
+	orderItem.Updated_At = at
+	orderItem.Updated_By = by
+	orderItem.Version++
+
+	s.orderItems[orderItemId] = orderItem
+	return orderItem, nil
+}
+
+// findItems returns the order items match picks, oldest first.
+func (s *MemoryGuestOrderStore) findItems(match func(models.OrderItem) bool) []models.OrderItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orderItems []models.OrderItem
+	for _, orderItem := range s.orderItems {
+		if match(orderItem) {
+			orderItems = append(orderItems, orderItem)
+		}
+	}
+
+	sort.Slice(orderItems, func(i, j int) bool { return orderItems[i].Created_At.Before(orderItems[j].Created_At) })
+	return orderItems
+}
+
+func isPending(orderItem models.OrderItem) bool {
+	return orderItem.Approval_Status != nil && *orderItem.Approval_Status == ApprovalPending
+}
+
+func foodName(food models.Food) string {
+	if food.Name == nil {
+		return ""
+	}
+	return *food.Name
+}
+
+type MongoGuestOrderStore struct {
+	tableCollection     *mongo.Collection
+	menuCollection      *mongo.Collection
+	foodCollection      *mongo.Collection
+	orderCollection     *mongo.Collection
+	orderItemCollection *mongo.Collection
+}
+
+func NewMongoGuestOrderStore(client *mongo.Client) *MongoGuestOrderStore {
+	return &MongoGuestOrderStore{
+		tableCollection:     database.OpenCollection(client, "table"),
+		menuCollection:      database.OpenCollection(client, "menu"),
+		foodCollection:      database.OpenCollection(client, "food"),
+		orderCollection:     database.OpenCollection(client, "order"),
+		orderItemCollection: database.OpenCollection(client, "orderitems"),
+	}
+}
+
+func (s *MongoGuestOrderStore) EnsureIndexes(ctx context.Context) error {
+	// Servers poll for items waiting on them, and guests for their order.
+	_, err := s.orderItemCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "approval_status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "table_id", Value: 1}, {Key: "order_status", Value: 1}},
+	})
+	return err
+}
+
+func (s *MongoGuestOrderStore) SetQrNonce(ctx context.Context, tableId string, nonce string, onlyIfUnset bool) (string, error) {
+	filter := bson.M{"table_id": tableId}
+	if onlyIfUnset {
+		filter["qr_nonce"] = nil
+	}
+
+	result, err := s.tableCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"qr_nonce": nonce}})
+	if err != nil {
+		return "", err
+	}
+	if result.MatchedCount == 1 {
+		return nonce, nil
+	}
+
+	// Either the table is gone or it already has a nonce to keep.
+	var table models.Table
+	err = s.tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", ErrTableNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if table.Qr_Nonce == nil {
+		return "", ErrTableNotFound
+	}
+	return *table.Qr_Nonce, nil
+}
+
+func (s *MongoGuestOrderStore) ActiveMenus(ctx context.Context, at time.Time) ([]models.Menu, error) {
+	filter := bson.M{"$and": bson.A{
+		bson.M{"$or": bson.A{bson.M{"start_date": nil}, bson.M{"start_date": bson.M{"$lte": at}}}},
+		bson.M{"$or": bson.A{bson.M{"end_date": nil}, bson.M{"end_date": bson.M{"$gte": at}}}},
+	}}
+
+	results, err := s.menuCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var menus []models.Menu
+	if err := results.All(ctx, &menus); err != nil {
+		return nil, err
+	}
+	return menus, nil
+}
+
+func (s *MongoGuestOrderStore) MenuFoods(ctx context.Context, menuIds []string) ([]models.Food, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	results, err := s.foodCollection.Find(ctx, bson.M{"menu_id": bson.M{"$in": menuIds}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var foods []models.Food
+	if err := results.All(ctx, &foods); err != nil {
+		return nil, err
+	}
+	return foods, nil
+}
+
+func (s *MongoGuestOrderStore) OpenOrder(ctx context.Context, tableIds []string) (models.Order, error) {
+	var order models.Order
+
+	filter := bson.M{"table_id": bson.M{"$in": tableIds}, "order_status": OrderStatusOpen}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	err := s.orderCollection.FindOne(ctx, filter, opts).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrOrderNotFound
+	}
+	return order, err
+}
+
+func (s *MongoGuestOrderStore) InsertOrder(ctx context.Context, order models.Order) error {
+	_, err := s.orderCollection.InsertOne(ctx, order)
+	return err
+}
+
+func (s *MongoGuestOrderStore) InsertOrderItems(ctx context.Context, orderItems []models.OrderItem) error {
+	documents := make([]interface{}, len(orderItems))
+	for i, orderItem := range orderItems {
+		documents[i] = orderItem
+	}
+
+	_, err := s.orderItemCollection.InsertMany(ctx, documents)
+	return err
+}
+
+func (s *MongoGuestOrderStore) OrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error) {
+	return s.findItems(ctx, bson.M{"order_id": orderId})
+}
+
+func (s *MongoGuestOrderStore) CountPending(ctx context.Context, orderId string) (int64, error) {
+	return s.orderItemCollection.CountDocuments(ctx, bson.M{"order_id": orderId, "approval_status": ApprovalPending})
+}
+
+func (s *MongoGuestOrderStore) PendingItems(ctx context.Context) ([]models.OrderItem, error) {
+	return s.findItems(ctx, bson.M{"approval_status": ApprovalPending})
+}
+
+func (s *MongoGuestOrderStore) ReviewItem(ctx context.Context, orderItemId string, status string, reason *string, at time.Time, by *models.Actor) (models.OrderItem, error) {
+	var orderItem models.OrderItem
+
+	filter := bson.M{"order_item_id": orderItemId, "approval_status": ApprovalPending}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "approval_status", Value: status},
+		{Key: "reviewed_at", Value: at},
+		{Key: "reviewed_by", Value: by},
+		{Key: "reject_reason", Value: reason},
+		{Key: "updated_at", Value: at},
+		{Key: "updated_by", Value: by},
+	}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := s.orderItemCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&orderItem)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return orderItem, err
+	}
+
+	// Work out why nothing matched.
+	err = s.orderItemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemId}).Decode(&orderItem)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return orderItem, ErrOrderItemNotFound
+	case err != nil:
+		return orderItem, err
+	case orderItem.Approval_Status == nil:
+		return orderItem, ErrOrderItemNotByGuest
+	default:
+		return orderItem, ErrOrderItemReviewed
+	}
+}
+
+func (s *MongoGuestOrderStore) findItems(ctx context.Context, filter bson.M) ([]models.OrderItem, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	results, err := s.orderItemCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var orderItems []models.OrderItem
+	if err := results.All(ctx, &orderItems); err != nil {
+		return nil, err
+	}
+	return orderItems, nil
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Approval statuses of order items guests send from their table. Items
+// entered by staff have none and are fired straight away.
+const (
+	ApprovalPending  = "PENDING"
+	ApprovalApproved = "APPROVED"
+	ApprovalRejected = "REJECTED"
+)
+
+// DefaultMaxPendingItems is how many items a table may have waiting for its
+// server before guests have to wait for them to be approved.
+const DefaultMaxPendingItems = 30
+
+var (
+	ErrGuestSessionEnded   = apperrors.New(apperrors.Unauthorized, "This table's QR code has been replaced. Scan the code on your table again")
+	ErrTableTokenInvalid   = apperrors.New(apperrors.Unauthorized, "This QR code is not valid")
+	ErrFoodNotOnMenu       = apperrors.New(apperrors.BadRequest, "One of the items is not on the menu right now")
+	ErrTooManyPending      = apperrors.New(apperrors.RateLimited, "Too many items are waiting for your server. Please wait for them to be confirmed")
+	ErrOrderItemNotFound   = apperrors.New(apperrors.NotFound, "Order item with that order_item_id not found")
+	ErrOrderItemReviewed   = apperrors.New(apperrors.Conflict, "Order item has already been approved or rejected")
+	ErrOrderItemNotByGuest = apperrors.New(apperrors.Conflict, "Only items guests ordered from their table need approving")
+)
+
+type GuestOrderStore interface {
+	// SetQrNonce gives the table nonce, or only does so if it has none when
+	// onlyIfUnset is set. It returns the table's nonce afterwards.
+	SetQrNonce(ctx context.Context, tableId string, nonce string, onlyIfUnset bool) (string, error)
+	// ActiveMenus returns the menus whose dates, where they have them,
+	// include at.
+	ActiveMenus(ctx context.Context, at time.Time) ([]models.Menu, error)
+	MenuFoods(ctx context.Context, menuIds []string) ([]models.Food, error)
+	// OpenOrder returns the newest open order at any of the tables, or
+	// ErrOrderNotFound.
+	OpenOrder(ctx context.Context, tableIds []string) (models.Order, error)
+	InsertOrder(ctx context.Context, order models.Order) error
+	InsertOrderItems(ctx context.Context, orderItems []models.OrderItem) error
+	OrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error)
+	CountPending(ctx context.Context, orderId string) (int64, error)
+	// PendingItems returns every item waiting for approval, oldest first.
+	PendingItems(ctx context.Context) ([]models.OrderItem, error)
+	// ReviewItem approves or rejects a pending item and returns it after the
+	// change.
+	ReviewItem(ctx context.Context, orderItemId string, status string, reason *string, at time.Time, by *models.Actor) (models.OrderItem, error)
+}
+
+// GuestSession is what a guest gets for scanning a table's QR code.
+type GuestSession struct {
+	Session_Token string    `json:"session_token"`
+	Expires_At    time.Time `json:"expires_at"`
+	Table_id      string    `json:"table_id"`
+	Table_number  *int      `json:"table_number"`
+}
+
+// GuestMenu is an active menu with its foods.
+type GuestMenu struct {
+	models.Menu
+	Foods []models.Food `json:"foods"`
+}
+
+// GuestOrder is the open order at a guest's table.
+type GuestOrder struct {
+	Order_id string             `json:"order_id"`
+	Table_id string             `json:"table_id"`
+	Items    []models.OrderItem `json:"items"`
+}
+
+type GuestSessionRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type GuestItem struct {
+	Food_id  string `json:"food_id" validate:"required"`
+	Quantity string `json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
+}
+
+type GuestOrderRequest struct {
+	Items    []GuestItem   `json:"items" validate:"required,min=1,max=20,dive"`
+	Table_id string        `json:"-"`
+	Qr_Nonce string        `json:"-"`
+	Actor    *models.Actor `json:"-"`
+}
+
+type ReviewOrderItemRequest struct {
+	Reason        *string       `json:"reason" validate:"omitempty,max=200"`
+	Order_item_id string        `json:"-"`
+	Approve       bool          `json:"-"`
+	Actor         *models.Actor `json:"-"`
+}
+
+// GuestOrdering lets guests order from their table by scanning its QR code.
+// What they order waits for their server to approve it before it is fired.
+type GuestOrdering struct {
+	store      GuestOrderStore
+	tables     TableStore
+	maxPending int
+
+	// mu keeps two guests at a table without an open order from both
+	// opening one. It only holds within one instance.
+	mu sync.Mutex
+}
+
+func NewGuestOrdering(store GuestOrderStore, tables TableStore, maxPending int) *GuestOrdering {
+	return &GuestOrdering{store: store, tables: tables, maxPending: maxPending}
+}
+
+// QrToken returns the token for the table's QR code. rotate gives the table
+// a new one, which stops its old codes and every guest session they started
+// from working.
+func (g *GuestOrdering) QrToken(ctx context.Context, tableId string, rotate bool) (string, error) {
+	nonce, err := helpers.NewQrNonce()
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err = g.store.SetQrNonce(ctx, tableId, nonce, !rotate)
+	if err != nil {
+		return "", err
+	}
+
+	return helpers.TableToken(tableId, nonce), nil
+}
+
+// StartSession exchanges the token from a table's QR code for a guest
+// session at that table.
+func (g *GuestOrdering) StartSession(ctx context.Context, token string) (GuestSession, error) {
+	tableId, nonce, err := helpers.ParseTableToken(token)
+	if err != nil {
+		return GuestSession{}, ErrTableTokenInvalid
+	}
+
+	table, err := g.table(ctx, tableId, nonce)
+	if err != nil {
+		return GuestSession{}, err
+	}
+
+	now := time.Now()
+	sessionToken, err := helpers.GenerateGuestToken(tableId, nonce, primitive.NewObjectID().Hex(), now)
+	if err != nil {
+		return GuestSession{}, err
+	}
+
+	return GuestSession{
+		Session_Token: sessionToken,
+		Expires_At:    now.Add(helpers.GuestSessionTTL),
+		Table_id:      tableId,
+		Table_number:  table.Table_number,
+	}, nil
+}
+
+// Menu returns the menus guests can order from right now.
+func (g *GuestOrdering) Menu(ctx context.Context) ([]GuestMenu, error) {
+	menus, err := g.store.ActiveMenus(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	menuIds := make([]string, len(menus))
+	for i, menu := range menus {
+		menuIds[i] = menu.Menu_Id
+	}
+
+	foods, err := g.store.MenuFoods(ctx, menuIds)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]GuestMenu, len(menus))
+	for i, menu := range menus {
+		result[i] = GuestMenu{Menu: menu, Foods: []models.Food{}}
+		for _, food := range foods {
+			if food.Menu_ID != nil && *food.Menu_ID == menu.Menu_Id {
+				result[i].Foods = append(result[i].Foods, food)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Order returns the open order at the guest's table, with what everyone at
+// the table has ordered so far. Before anyone has ordered it has no items.
+func (g *GuestOrdering) Order(ctx context.Context, tableId string, nonce string) (GuestOrder, error) {
+	if _, err := g.table(ctx, tableId, nonce); err != nil {
+		return GuestOrder{}, err
+	}
+
+	order, err := g.openOrder(ctx, tableId)
+	if errors.Is(err, ErrOrderNotFound) {
+		return GuestOrder{Table_id: tableId, Items: []models.OrderItem{}}, nil
+	}
+	if err != nil {
+		return GuestOrder{}, err
+	}
+
+	return g.guestOrder(ctx, order)
+}
+
+// Submit adds what a guest ordered to the open order at their table, opening
+// one if there is none, for their server to approve. Prices are taken from
+// the menu rather than the guest.
+func (g *GuestOrdering) Submit(ctx context.Context, req GuestOrderRequest) (GuestOrder, error) {
+	table, err := g.table(ctx, req.Table_id, req.Qr_Nonce)
+	if err != nil {
+		return GuestOrder{}, err
+	}
+
+	prices, err := g.menuPrices(ctx)
+	if err != nil {
+		return GuestOrder{}, err
+	}
+	for _, item := range req.Items {
+		if _, ok := prices[item.Food_id]; !ok {
+			return GuestOrder{}, ErrFoodNotOnMenu
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	order, err := g.openOrder(ctx, table.Table_id)
+	opened := errors.Is(err, ErrOrderNotFound)
+	if opened {
+		order = g.newOrder(table, now, req.Actor)
+	} else if err != nil {
+		return GuestOrder{}, err
+	}
+
+	if !opened {
+		pending, err := g.store.CountPending(ctx, order.Order_id)
+		if err != nil {
+			return GuestOrder{}, err
+		}
+		if int(pending)+len(req.Items) > g.maxPending {
+			return GuestOrder{}, ErrTooManyPending
+		}
+	}
+
+	orderItems := make([]models.OrderItem, len(req.Items))
+	for i, item := range req.Items {
+		foodId, quantity, price := item.Food_id, item.Quantity, prices[item.Food_id]
+		status := ApprovalPending
+
+		orderItems[i] = models.OrderItem{
+			ID:              primitive.NewObjectID(),
+			Quantity:        &quantity,
+			Unit_Price:      &price,
+			Created_At:      now,
+			Updated_At:      now,
+			Version:         1,
+			Food_id:         &foodId,
+			Order_id:        &order.Order_id,
+			Created_By:      req.Actor,
+			Approval_Status: &status,
+		}
+		orderItems[i].Order_item_id = orderItems[i].ID.Hex()
+	}
+
+	if opened {
+		if err := g.store.InsertOrder(ctx, order); err != nil {
+			return GuestOrder{}, err
+		}
+		events.Publish(ctx, events.Event{Type: events.OrderCreated, Subject: order.Order_id, Data: order})
+	}
+
+	if err := g.store.InsertOrderItems(ctx, orderItems); err != nil {
+		return GuestOrder{}, err
+	}
+
+	return g.guestOrder(ctx, order)
+}
+
+// Pending returns the items guests are waiting on their servers to approve.
+func (g *GuestOrdering) Pending(ctx context.Context) ([]models.OrderItem, error) {
+	return g.store.PendingItems(ctx)
+}
+
+// Review approves or rejects an item a guest ordered. Approved items are
+// fired like any other.
+func (g *GuestOrdering) Review(ctx context.Context, req ReviewOrderItemRequest) (models.OrderItem, error) {
+	status := ApprovalRejected
+	if req.Approve {
+		status = ApprovalApproved
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	orderItem, err := g.store.ReviewItem(ctx, req.Order_item_id, status, req.Reason, now, req.Actor)
+	if err != nil {
+		return orderItem, err
+	}
+
+	if req.Approve {
+		events.Publish(ctx, events.Event{Type: events.OrderItemFired, Subject: orderItem.Order_item_id, Data: orderItem})
+	}
+
+	return orderItem, nil
+}
+
+// table returns the guest's table, as long as its QR code hasn't been
+// replaced since they scanned it.
+func (g *GuestOrdering) table(ctx context.Context, tableId string, nonce string) (models.Table, error) {
+	tables, err := g.tables.FindTables(ctx, []string{tableId})
+	if err != nil {
+		return models.Table{}, err
+	}
+	if len(tables) == 0 || tables[0].Qr_Nonce == nil || *tables[0].Qr_Nonce != nonce {
+		return models.Table{}, ErrGuestSessionEnded
+	}
+	return tables[0], nil
+}
+
+// openOrder finds the open order at the table or, when tables have been
+// pushed together, at any table of its group.
+func (g *GuestOrdering) openOrder(ctx context.Context, tableId string) (models.Order, error) {
+	tableIds, err := g.tables.GroupTables(ctx, tableId)
+	if err != nil {
+		return models.Order{}, err
+	}
+	return g.store.OpenOrder(ctx, tableIds)
+}
+
+func (g *GuestOrdering) newOrder(table models.Table, now time.Time, by *models.Actor) models.Order {
+	status := OrderStatusOpen
+	orderType := OrderTypeDineIn
+
+	order := models.Order{
+		ID:           primitive.NewObjectID(),
+		Order_Date:   now,
+		Created_At:   now,
+		Updated_At:   now,
+		Version:      1,
+		Table_id:     &table.Table_id,
+		Order_type:   &orderType,
+		Server_id:    table.Server_id,
+		Order_status: &status,
+		Created_By:   by,
+	}
+	order.Order_id = order.ID.Hex()
+	return order
+}
+
+func (g *GuestOrdering) guestOrder(ctx context.Context, order models.Order) (GuestOrder, error) {
+	orderItems, err := g.store.OrderItems(ctx, order.Order_id)
+	if err != nil {
+		return GuestOrder{}, err
+	}
+	if orderItems == nil {
+		orderItems = []models.OrderItem{}
+	}
+
+	result := GuestOrder{Order_id: order.Order_id, Items: orderItems}
+	if order.Table_id != nil {
+		result.Table_id = *order.Table_id
+	}
+	return result, nil
+}
+
+// menuPrices returns the price of every food on an active menu.
+func (g *GuestOrdering) menuPrices(ctx context.Context) (map[string]float64, error) {
+	menus, err := g.Menu(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := map[string]float64{}
+	for _, menu := range menus {
+		for _, food := range menu.Foods {
+			if food.Price != nil {
+				prices[food.Food_ID] = *food.Price
+			}
+		}
+	}
+	return prices, nil
+}
+
+// Billable reports whether an order item is charged for: it was entered by
+// staff, or a guest ordered it and their server approved it.
+func Billable(orderItem models.OrderItem) bool {
+	return orderItem.Approval_Status == nil || *orderItem.Approval_Status == ApprovalApproved
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/teandresmith/restaurant-project/helpers"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// TestQrNonceRotation checks rotating a table's QR code ends the guest
+// sessions started from the old one, which carry the old nonce.
+func TestQrNonceRotation(t *testing.T) {
+	ctx := context.Background()
+	tables := NewMemoryTableStore()
+	tables.SaveTable(models.Table{Table_id: "t1"})
+	tables.SaveTable(models.Table{Table_id: "t2"})
+	guest := NewGuestOrdering(NewMemoryGuestOrderStore(tables), tables, 10)
+
+	printed, err := guest.QrToken(ctx, "t1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := guest.QrToken(ctx, "t1", false); again != printed {
+		t.Errorf("QrToken without rotating = %q, want the printed %q", again, printed)
+	}
+
+	_, nonce, err := helpers.ParseTableToken(printed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := guest.Order(ctx, "t1", nonce); err != nil {
+		t.Fatalf("Order before rotating: %v", err)
+	}
+
+	rotated, err := guest.QrToken(ctx, "t1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == printed {
+		t.Fatal("rotating kept the printed token")
+	}
+
+	if _, err := guest.Order(ctx, "t1", nonce); !errors.Is(err, ErrGuestSessionEnded) {
+		t.Errorf("Order in a session from the old code: err = %v, want %v", err, ErrGuestSessionEnded)
+	}
+	if _, err := guest.StartSession(ctx, printed); !errors.Is(err, ErrGuestSessionEnded) {
+		t.Errorf("StartSession with the old code: err = %v, want %v", err, ErrGuestSessionEnded)
+	}
+
+	_, newNonce, _ := helpers.ParseTableToken(rotated)
+	if _, err := guest.Order(ctx, "t1", newNonce); err != nil {
+		t.Errorf("Order in a session from the new code: %v", err)
+	}
+
+	// A nonce only works at the table it was given to.
+	if _, err := guest.Order(ctx, "t2", newNonce); !errors.Is(err, ErrGuestSessionEnded) {
+		t.Errorf("Order at another table: err = %v, want %v", err, ErrGuestSessionEnded)
+	}
+	if _, err := guest.StartSession(ctx, strings.Replace(rotated, "t1.", "t2.", 1)); !errors.Is(err, ErrTableTokenInvalid) {
+		t.Errorf("StartSession with the table swapped: err = %v, want %v", err, ErrTableTokenInvalid)
+	}
+}
@@ -0,0 +1,508 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses of an item on a station's queue. Bumped items are done and off
+// the screen until they are recalled.
+const (
+	StationItemQueued  = "QUEUED"
+	StationItemCooking = "COOKING"
+	StationItemReady   = "READY"
+	StationItemBumped  = "BUMPED"
+)
+
+// activeStationItems are the statuses a station's screen shows.
+var activeStationItems = []string{StationItemQueued, StationItemCooking, StationItemReady}
+
+// DefaultTicketWindow is how far back ticket times go when no period is
+// asked for.
+const DefaultTicketWindow = 24 * time.Hour
+
+var (
+	ErrStationNotFound     = apperrors.New(apperrors.NotFound, "Station with that station_id not found")
+	ErrStationChanged      = apperrors.New(apperrors.PreconditionFailed, "The station was modified after the provided ETag. Fetch it again and retry")
+	ErrStationInUse        = apperrors.New(apperrors.Conflict, "Routing rules still send items to the station, or it has items that aren't bumped yet")
+	ErrRoutingRuleNotFound = apperrors.New(apperrors.NotFound, "Routing rule with that rule_id not found")
+	ErrRoutingRuleExists   = apperrors.New(apperrors.Conflict, "A routing rule for that food or category already exists, delete it first")
+	ErrStationItemNotFound = apperrors.New(apperrors.NotFound, "Order item with that order_item_id is not on the station's queue")
+	ErrStationItemStatus   = apperrors.New(apperrors.Conflict, "The item's status doesn't allow that, fetch the queue again")
+	ErrStationItemChanged  = apperrors.New(apperrors.Conflict, "The item was changed by someone else, try again")
+	ErrFoodNotFound        = apperrors.New(apperrors.NotFound, "Food with that food_id not found")
+)
+
+// StationUpdate changes the fields of a station that are set.
+type StationUpdate struct {
+	Name *string `json:"name" validate:"omitempty,min=1,max=50"`
+}
+
+// StationQueueFilter picks the items of a station's queue to list. Without
+// a status the items still on the screen are listed.
+type StationQueueFilter struct {
+	Status string `form:"status" json:"status" validate:"omitempty,eq=QUEUED|eq=COOKING|eq=READY|eq=BUMPED"`
+	Limit  int    `form:"limit" json:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+// TicketTimesRequest is the period ticket times are worked out over, by when
+// items were queued.
+type TicketTimesRequest struct {
+	From *time.Time `form:"from" json:"from"`
+	To   *time.Time `form:"to" json:"to"`
+}
+
+type KitchenStore interface {
+	InsertStation(ctx context.Context, station models.Station) error
+	FindStation(ctx context.Context, stationId string) (models.Station, error)
+	Stations(ctx context.Context) ([]models.Station, error)
+	// ReplaceStation overwrites the station if it is still at version.
+	ReplaceStation(ctx context.Context, station models.Station, version int64) error
+	// DeleteStation removes the station if it is still at version.
+	DeleteStation(ctx context.Context, stationId string, version int64) error
+	// InsertRule returns ErrRoutingRuleExists if a rule with the same match
+	// already is stored.
+	InsertRule(ctx context.Context, rule models.RoutingRule) error
+	Rules(ctx context.Context) ([]models.RoutingRule, error)
+	// MatchingRules returns the rules with any of the matches.
+	MatchingRules(ctx context.Context, matches []string) ([]models.RoutingRule, error)
+	DeleteRule(ctx context.Context, ruleId string) error
+	// FindFood returns the food and the category of the menu it is on.
+	FindFood(ctx context.Context, foodId string) (models.Food, string, error)
+	FindOrder(ctx context.Context, orderId string) (models.Order, error)
+	// InsertItem puts an item on a queue, unless the order item already is
+	// on one.
+	InsertItem(ctx context.Context, item models.StationItem) error
+	FindItem(ctx context.Context, orderItemId string) (models.StationItem, error)
+	// ReplaceItem overwrites the item if it is still at version.
+	ReplaceItem(ctx context.Context, item models.StationItem, version int64) error
+	// Queue returns up to limit of the station's items in the given
+	// statuses: bumped ones newest bumped first, the others oldest queued
+	// first.
+	Queue(ctx context.Context, stationId string, statuses []string, limit int) ([]models.StationItem, error)
+	// QueuedBetween returns the station's items queued in [from, to).
+	QueuedBetween(ctx context.Context, stationId string, from time.Time, to time.Time) ([]models.StationItem, error)
+}
+
+// Kitchen routes fired order items to the stations that prepare them and
+// keeps each station's queue.
+type Kitchen struct {
+	store KitchenStore
+}
+
+func NewKitchen(store KitchenStore) *Kitchen {
+	return &Kitchen{store: store}
+}
+
+// Subscribe makes the kitchen queue the order items fired on bus.
+func (k *Kitchen) Subscribe(bus *events.Bus) {
+	bus.Subscribe(k.handle)
+}
+
+func (k *Kitchen) Stations(ctx context.Context) ([]models.Station, error) {
+	stations, err := k.store.Stations(ctx)
+	if stations == nil {
+		stations = []models.Station{}
+	}
+	return stations, err
+}
+
+func (k *Kitchen) FindStation(ctx context.Context, stationId string) (models.Station, error) {
+	return k.store.FindStation(ctx, stationId)
+}
+
+func (k *Kitchen) CreateStation(ctx context.Context, station models.Station, by *models.Actor) (models.Station, error) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	station.ID = primitive.NewObjectID()
+	station.Station_id = station.ID.Hex()
+	station.Created_At = now
+	station.Updated_At = now
+	station.Version = 1
+	station.Created_By = by
+
+	if err := k.store.InsertStation(ctx, station); err != nil {
+		return station, err
+	}
+	return station, nil
+}
+
+// UpdateStation applies change to the station if it is still at version.
+func (k *Kitchen) UpdateStation(ctx context.Context, stationId string, version int64, change StationUpdate, by *models.Actor) (models.Station, error) {
+	station, err := k.store.FindStation(ctx, stationId)
+	if err != nil {
+		return station, err
+	}
+	if station.Version != version {
+		return station, ErrStationChanged
+	}
+
+	if change.Name != nil {
+		station.Name = change.Name
+	}
+	station.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	station.Updated_By = by
+	station.Version++
+
+	if err := k.store.ReplaceStation(ctx, station, version); err != nil {
+		return station, err
+	}
+	return station, nil
+}
+
+// DeleteStation removes a station nothing is routed to any more and whose
+// items have all been bumped.
+func (k *Kitchen) DeleteStation(ctx context.Context, stationId string, version int64) error {
+	rules, err := k.store.Rules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if *rule.Station_id == stationId {
+			return ErrStationInUse
+		}
+	}
+
+	items, err := k.store.Queue(ctx, stationId, activeStationItems, 1)
+	if err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		return ErrStationInUse
+	}
+
+	return k.store.DeleteStation(ctx, stationId, version)
+}
+
+func (k *Kitchen) Rules(ctx context.Context) ([]models.RoutingRule, error) {
+	rules, err := k.store.Rules(ctx)
+	if rules == nil {
+		rules = []models.RoutingRule{}
+	}
+	return rules, err
+}
+
+// CreateRule starts routing the items the rule matches to its station. Items
+// already queued stay where they are.
+func (k *Kitchen) CreateRule(ctx context.Context, rule models.RoutingRule, by *models.Actor) (models.RoutingRule, error) {
+	invalid := func(field string, rule string, message string) error {
+		return &apperrors.Error{Code: apperrors.ValidationFailed, Message: "The request body is not valid", Fields: []apperrors.FieldError{{Field: field, Rule: rule, Message: message}}}
+	}
+
+	if rule.Food_id != nil && rule.Category != nil {
+		return rule, invalid("category", "excluded_with", "cannot be set together with food_id")
+	}
+
+	if _, err := k.store.FindStation(ctx, *rule.Station_id); err != nil {
+		if errors.Is(err, ErrStationNotFound) {
+			return rule, invalid("station_id", "exists", "does not match an existing station")
+		}
+		return rule, err
+	}
+
+	if rule.Food_id != nil {
+		if _, _, err := k.store.FindFood(ctx, *rule.Food_id); err != nil {
+			if errors.Is(err, ErrFoodNotFound) {
+				return rule, invalid("food_id", "exists", "does not match an existing food")
+			}
+			return rule, err
+		}
+	}
+
+	rule.ID = primitive.NewObjectID()
+	rule.Rule_id = rule.ID.Hex()
+	rule.Match = ruleMatch(rule.Food_id, rule.Category)
+	rule.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	rule.Created_By = by
+
+	if err := k.store.InsertRule(ctx, rule); err != nil {
+		return rule, err
+	}
+	return rule, nil
+}
+
+func (k *Kitchen) DeleteRule(ctx context.Context, ruleId string) error {
+	return k.store.DeleteRule(ctx, ruleId)
+}
+
+// ruleMatch is what a rule for foodId or category is stored under. Rules
+// for neither match "*".
+func ruleMatch(foodId *string, category *string) string {
+	switch {
+	case foodId != nil:
+		return "food:" + *foodId
+	case category != nil:
+		return "category:" + *category
+	}
+	return "*"
+}
+
+func (k *Kitchen) handle(ctx context.Context, event events.Event) {
+	orderItem, ok := event.Data.(models.OrderItem)
+	if event.Type != events.OrderItemFired || !ok {
+		return
+	}
+
+	// The request that fired the item may be cancelled the moment it has
+	// answered, but the item is already committed.
+	if err := k.route(context.WithoutCancel(ctx), orderItem, event.Occurred_At); err != nil {
+		logging.FromContext(ctx).Error("could not queue order item", "order_item_id", orderItem.Order_item_id, "error", err)
+	}
+}
+
+// route queues an order item at the station the most specific rule for it
+// names: a rule for its food, then one for its menu's category, then the
+// rule for everything else. Items no rule matches aren't queued anywhere.
+func (k *Kitchen) route(ctx context.Context, orderItem models.OrderItem, at time.Time) error {
+	var food models.Food
+	var foodId, category string
+
+	if orderItem.Food_id != nil {
+		var err error
+		foodId = *orderItem.Food_id
+		food, category, err = k.store.FindFood(ctx, foodId)
+		if err != nil && !errors.Is(err, ErrFoodNotFound) {
+			return err
+		}
+	}
+
+	matches := []string{ruleMatch(nil, nil)}
+	if category != "" {
+		matches = append([]string{ruleMatch(nil, &category)}, matches...)
+	}
+	if foodId != "" {
+		matches = append([]string{ruleMatch(&foodId, nil)}, matches...)
+	}
+
+	rules, err := k.store.MatchingRules(ctx, matches)
+	if err != nil {
+		return err
+	}
+
+	var rule *models.RoutingRule
+	for _, match := range matches {
+		for i := range rules {
+			if rules[i].Match == match && rule == nil {
+				rule = &rules[i]
+			}
+		}
+	}
+	if rule == nil {
+		logging.FromContext(ctx).Warn("no routing rule matches order item, it is not on any station", "order_item_id", orderItem.Order_item_id, "food_id", foodId)
+		return nil
+	}
+
+	queuedAt, _ := time.Parse(time.RFC3339, at.Format(time.RFC3339))
+	item := models.StationItem{
+		ID:            primitive.NewObjectID(),
+		Order_item_id: orderItem.Order_item_id,
+		Station_id:    *rule.Station_id,
+		Order_id:      orderItem.Order_id,
+		Food_id:       orderItem.Food_id,
+		Food_Name:     food.Name,
+		Quantity:      orderItem.Quantity,
+		Status:        StationItemQueued,
+		Queued_At:     queuedAt,
+		Updated_At:    queuedAt,
+		Version:       1,
+	}
+
+	if orderItem.Order_id != nil {
+		order, err := k.store.FindOrder(ctx, *orderItem.Order_id)
+		if err != nil && !errors.Is(err, ErrOrderNotFound) {
+			return err
+		}
+		item.Table_id = order.Table_id
+	}
+
+	return k.store.InsertItem(ctx, item)
+}
+
+// Queue lists a station's items.
+func (k *Kitchen) Queue(ctx context.Context, stationId string, filter StationQueueFilter) ([]models.StationItem, error) {
+	if _, err := k.store.FindStation(ctx, stationId); err != nil {
+		return nil, err
+	}
+
+	statuses := activeStationItems
+	if filter.Status != "" {
+		statuses = []string{filter.Status}
+	}
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+
+	items, err := k.store.Queue(ctx, stationId, statuses, filter.Limit)
+	if items == nil {
+		items = []models.StationItem{}
+	}
+	return items, err
+}
+
+// Start marks a queued item as being cooked.
+func (k *Kitchen) Start(ctx context.Context, stationId string, orderItemId string, by *models.Actor) (models.StationItem, error) {
+	return k.move(ctx, stationId, orderItemId, []string{StationItemQueued}, by, func(item *models.StationItem, now time.Time) {
+		item.Status = StationItemCooking
+		item.Started_At = &now
+	})
+}
+
+// Ready marks an item as ready to be served.
+func (k *Kitchen) Ready(ctx context.Context, stationId string, orderItemId string, by *models.Actor) (models.StationItem, error) {
+	return k.move(ctx, stationId, orderItemId, []string{StationItemQueued, StationItemCooking}, by, func(item *models.StationItem, now time.Time) {
+		item.Status = StationItemReady
+		item.Ready_At = &now
+	})
+}
+
+// Bump takes an item off the station's screen. Items bumped before they
+// were marked ready are ready as of the bump.
+func (k *Kitchen) Bump(ctx context.Context, stationId string, orderItemId string, by *models.Actor) (models.StationItem, error) {
+	return k.move(ctx, stationId, orderItemId, activeStationItems, by, func(item *models.StationItem, now time.Time) {
+		item.Status = StationItemBumped
+		item.Bumped_At = &now
+		if item.Ready_At == nil {
+			item.Ready_At = &now
+		}
+	})
+}
+
+// Recall puts a bumped item back on the station's screen as ready. It keeps
+// the time it was first ready, so recalls don't change ticket times.
+func (k *Kitchen) Recall(ctx context.Context, stationId string, orderItemId string, by *models.Actor) (models.StationItem, error) {
+	return k.move(ctx, stationId, orderItemId, []string{StationItemBumped}, by, func(item *models.StationItem, now time.Time) {
+		item.Status = StationItemReady
+		item.Bumped_At = nil
+		item.Recalls++
+	})
+}
+
+func (k *Kitchen) move(ctx context.Context, stationId string, orderItemId string, from []string, by *models.Actor, apply func(item *models.StationItem, now time.Time)) (models.StationItem, error) {
+	item, err := k.store.FindItem(ctx, orderItemId)
+	if err != nil {
+		return item, err
+	}
+	if item.Station_id != stationId {
+		return item, ErrStationItemNotFound
+	}
+	if !contains(from, item.Status) {
+		return item, ErrStationItemStatus
+	}
+
+	version := item.Version
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	apply(&item, now)
+	item.Updated_At = now
+	item.Updated_By = by
+	item.Version++
+
+	if err := k.store.ReplaceItem(ctx, item, version); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// TicketTimes works out how long the station took over the items queued in
+// the period, the last DefaultTicketWindow unless one is given. Items not
+// ready yet are only counted as open, and tickets with such items are left
+// out.
+func (k *Kitchen) TicketTimes(ctx context.Context, stationId string, req TicketTimesRequest) (models.TicketTimes, error) {
+	to, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if req.To != nil {
+		to = *req.To
+	}
+	from := to.Add(-DefaultTicketWindow)
+	if req.From != nil {
+		from = *req.From
+	}
+
+	times := models.TicketTimes{Station_id: stationId, From: from, To: to}
+
+	if !from.Before(to) {
+		return times, &apperrors.Error{Code: apperrors.ValidationFailed, Message: "The request body is not valid", Fields: []apperrors.FieldError{{Field: "from", Rule: "ltfield", Message: "must be before to"}}}
+	}
+
+	if _, err := k.store.FindStation(ctx, stationId); err != nil {
+		return times, err
+	}
+
+	items, err := k.store.QueuedBetween(ctx, stationId, from, to)
+	if err != nil {
+		return times, err
+	}
+
+	type ticket struct {
+		queued time.Time
+		ready  time.Time
+		open   bool
+	}
+	tickets := map[string]*ticket{}
+	var waits, cooks []time.Duration
+
+	for _, item := range items {
+		key := item.Order_item_id
+		if item.Order_id != nil {
+			key = *item.Order_id
+		}
+		t, ok := tickets[key]
+		if !ok {
+			t = &ticket{queued: item.Queued_At}
+			tickets[key] = t
+		}
+		if item.Queued_At.Before(t.queued) {
+			t.queued = item.Queued_At
+		}
+
+		if item.Ready_At == nil {
+			t.open = true
+			times.Open_Items++
+			continue
+		}
+		times.Items++
+		if item.Ready_At.After(t.ready) {
+			t.ready = *item.Ready_At
+		}
+		if item.Started_At != nil {
+			waits = append(waits, item.Started_At.Sub(item.Queued_At))
+			cooks = append(cooks, item.Ready_At.Sub(*item.Started_At))
+		}
+	}
+
+	var ticketTimes []time.Duration
+	for _, t := range tickets {
+		if !t.open {
+			ticketTimes = append(ticketTimes, t.ready.Sub(t.queued))
+		}
+	}
+	sort.Slice(ticketTimes, func(i, j int) bool { return ticketTimes[i] < ticketTimes[j] })
+
+	times.Tickets = len(ticketTimes)
+	times.Average_Ticket_Seconds = averageSeconds(ticketTimes)
+	times.Average_Wait_Seconds = averageSeconds(waits)
+	times.Average_Cook_Seconds = averageSeconds(cooks)
+	if n := len(ticketTimes); n > 0 {
+		times.P90_Ticket_Seconds = ticketTimes[int(math.Ceil(0.9*float64(n)))-1].Seconds()
+		times.Longest_Ticket_Seconds = ticketTimes[n-1].Seconds()
+	}
+	return times, nil
+}
+
+func averageSeconds(durations []time.Duration) float64 {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return math.Round(total.Seconds() / float64(len(durations)))
+}
@@ -0,0 +1,473 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryKitchenStore keeps stations, routing rules and queues in process and
+// reads foods, menus and orders from a MemoryGuestOrderStore.
+type MemoryKitchenStore struct {
+	mu       sync.Mutex
+	stations map[string]models.Station
+	rules    map[string]models.RoutingRule
+	items    map[string]models.StationItem
+	guest    *MemoryGuestOrderStore
+}
+
+func NewMemoryKitchenStore(guest *MemoryGuestOrderStore) *MemoryKitchenStore {
+	return &MemoryKitchenStore{
+		stations: map[string]models.Station{},
+		rules:    map[string]models.RoutingRule{},
+		items:    map[string]models.StationItem{},
+		guest:    guest,
+	}
+}
+
+func (s *MemoryKitchenStore) InsertStation(ctx context.Context, station models.Station) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stations[station.Station_id] = station
+	return nil
+}
+
+func (s *MemoryKitchenStore) FindStation(ctx context.Context, stationId string) (models.Station, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	station, ok := s.stations[stationId]
+	if !ok {
+		return station, ErrStationNotFound
+	}
+	return station, nil
+}
+
+func (s *MemoryKitchenStore) Stations(ctx context.Context) ([]models.Station, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stations []models.Station
+	for _, station := range s.stations {
+		stations = append(stations, station)
+	}
+
+	sort.Slice(stations, func(i, j int) bool { return *stations[i].Name < *stations[j].Name })
+	return stations, nil
+}
+
+func (s *MemoryKitchenStore) ReplaceStation(ctx context.Context, station models.Station, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.stations[station.Station_id]
+	if !ok {
+		return ErrStationNotFound
+	}
+	if current.Version != version {
+		return ErrStationChanged
+	}
+	s.stations[station.Station_id] = station
+	return nil
+}
+
+func (s *MemoryKitchenStore) DeleteStation(ctx context.Context, stationId string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.stations[stationId]
+	if !ok {
+		return ErrStationNotFound
+	}
+	if current.Version != version {
+		return ErrStationChanged
+	}
+	delete(s.stations, stationId)
+	return nil
+}
+
+func (s *MemoryKitchenStore) InsertRule(ctx context.Context, rule models.RoutingRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.rules {
+		if existing.Match == rule.Match {
+			return ErrRoutingRuleExists
+		}
+	}
+	s.rules[rule.Rule_id] = rule
+	return nil
+}
+
+func (s *MemoryKitchenStore) Rules(ctx context.Context) ([]models.RoutingRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rules []models.RoutingRule
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Match < rules[j].Match })
+	return rules, nil
+}
+
+func (s *MemoryKitchenStore) MatchingRules(ctx context.Context, matches []string) ([]models.RoutingRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rules []models.RoutingRule
+	for _, rule := range s.rules {
+		if contains(matches, rule.Match) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (s *MemoryKitchenStore) DeleteRule(ctx context.Context, ruleId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rules[ruleId]; !ok {
+		return ErrRoutingRuleNotFound
+	}
+	delete(s.rules, ruleId)
+	return nil
+}
+
+func (s *MemoryKitchenStore) FindFood(ctx context.Context, foodId string) (models.Food, string, error) {
+	s.guest.mu.Lock()
+	defer s.guest.mu.Unlock()
+
+	food, ok := s.guest.foods[foodId]
+	if !ok {
+		return food, "", ErrFoodNotFound
+	}
+
+	var category string
+	if food.Menu_ID != nil {
+		category = s.guest.menus[*food.Menu_ID].Category
+	}
+	return food, category, nil
+}
+
+func (s *MemoryKitchenStore) FindOrder(ctx context.Context, orderId string) (models.Order, error) {
+	return s.guest.tables.FindOrder(ctx, orderId)
+}
+
+func (s *MemoryKitchenStore) InsertItem(ctx context.Context, item models.StationItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[item.Order_item_id]; !ok {
+		s.items[item.Order_item_id] = item
+	}
+	return nil
+}
+
+func (s *MemoryKitchenStore) FindItem(ctx context.Context, orderItemId string) (models.StationItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[orderItemId]
+	if !ok {
+		return item, ErrStationItemNotFound
+	}
+	return item, nil
+}
+
+func (s *MemoryKitchenStore) ReplaceItem(ctx context.Context, item models.StationItem, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.items[item.Order_item_id]
+	if !ok {
+		return ErrStationItemNotFound
+	}
+	if current.Version != version {
+		return ErrStationItemChanged
+	}
+	s.items[item.Order_item_id] = item
+	return nil
+}
+
+func (s *MemoryKitchenStore) Queue(ctx context.Context, stationId string, statuses []string, limit int) ([]models.StationItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []models.StationItem
+	for _, item := range s.items {
+		if item.Station_id == stationId && contains(statuses, item.Status) {
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Bumped_At != nil && items[j].Bumped_At != nil {
+			return items[i].Bumped_At.After(*items[j].Bumped_At)
+		}
+		return items[i].Queued_At.Before(items[j].Queued_At)
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (s *MemoryKitchenStore) QueuedBetween(ctx context.Context, stationId string, from time.Time, to time.Time) ([]models.StationItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []models.StationItem
+	for _, item := range s.items {
+		if item.Station_id == stationId && !item.Queued_At.Before(from) && item.Queued_At.Before(to) {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+type MongoKitchenStore struct {
+	stationCollection *mongo.Collection
+	ruleCollection    *mongo.Collection
+	itemCollection    *mongo.Collection
+	foodCollection    *mongo.Collection
+	menuCollection    *mongo.Collection
+	orderCollection   *mongo.Collection
+}
+
+func NewMongoKitchenStore(client *mongo.Client) *MongoKitchenStore {
+	return &MongoKitchenStore{
+		stationCollection: database.OpenCollection(client, "stations"),
+		ruleCollection:    database.OpenCollection(client, "routing_rules"),
+		itemCollection:    database.OpenCollection(client, "station_items"),
+		foodCollection:    database.OpenCollection(client, "food"),
+		menuCollection:    database.OpenCollection(client, "menu"),
+		orderCollection:   database.OpenCollection(client, "order"),
+	}
+}
+
+// EnsureIndexes keeps one rule per match and one queue entry per order item,
+// so an item fired again is only queued once, and indexes the queues by
+// status and by when items were queued.
+func (s *MongoKitchenStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.stationCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "station_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ruleCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "rule_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "match", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.itemCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_item_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "station_id", Value: 1}, {Key: "status", Value: 1}, {Key: "queued_at", Value: 1}}},
+		{Keys: bson.D{{Key: "station_id", Value: 1}, {Key: "queued_at", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoKitchenStore) InsertStation(ctx context.Context, station models.Station) error {
+	_, err := s.stationCollection.InsertOne(ctx, station)
+	return err
+}
+
+func (s *MongoKitchenStore) FindStation(ctx context.Context, stationId string) (models.Station, error) {
+	var station models.Station
+
+	err := s.stationCollection.FindOne(ctx, bson.M{"station_id": stationId}).Decode(&station)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return station, ErrStationNotFound
+	}
+	return station, err
+}
+
+func (s *MongoKitchenStore) Stations(ctx context.Context) ([]models.Station, error) {
+	results, err := s.stationCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var stations []models.Station
+	if err := results.All(ctx, &stations); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (s *MongoKitchenStore) ReplaceStation(ctx context.Context, station models.Station, version int64) error {
+	result, err := s.stationCollection.ReplaceOne(ctx, bson.M{"station_id": station.Station_id, "version": version}, station)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return s.mismatch(ctx, station.Station_id)
+	}
+	return nil
+}
+
+func (s *MongoKitchenStore) DeleteStation(ctx context.Context, stationId string, version int64) error {
+	result, err := s.stationCollection.DeleteOne(ctx, bson.M{"station_id": stationId, "version": version})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return s.mismatch(ctx, stationId)
+	}
+	return nil
+}
+
+// mismatch tells a missing station apart from a stale version after a
+// write matched nothing.
+func (s *MongoKitchenStore) mismatch(ctx context.Context, stationId string) error {
+	count, err := s.stationCollection.CountDocuments(ctx, bson.M{"station_id": stationId})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrStationNotFound
+	}
+	return ErrStationChanged
+}
+
+func (s *MongoKitchenStore) InsertRule(ctx context.Context, rule models.RoutingRule) error {
+	_, err := s.ruleCollection.InsertOne(ctx, rule)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrRoutingRuleExists
+	}
+	return err
+}
+
+func (s *MongoKitchenStore) Rules(ctx context.Context) ([]models.RoutingRule, error) {
+	return s.findRules(ctx, bson.M{})
+}
+
+func (s *MongoKitchenStore) MatchingRules(ctx context.Context, matches []string) ([]models.RoutingRule, error) {
+	return s.findRules(ctx, bson.M{"match": bson.M{"$in": matches}})
+}
+
+func (s *MongoKitchenStore) findRules(ctx context.Context, filter bson.M) ([]models.RoutingRule, error) {
+	results, err := s.ruleCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "match", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []models.RoutingRule
+	if err := results.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *MongoKitchenStore) DeleteRule(ctx context.Context, ruleId string) error {
+	result, err := s.ruleCollection.DeleteOne(ctx, bson.M{"rule_id": ruleId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrRoutingRuleNotFound
+	}
+	return nil
+}
+
+func (s *MongoKitchenStore) FindFood(ctx context.Context, foodId string) (models.Food, string, error) {
+	var food models.Food
+
+	err := s.foodCollection.FindOne(ctx, bson.M{"food_id": foodId}).Decode(&food)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return food, "", ErrFoodNotFound
+	}
+	if err != nil || food.Menu_ID == nil {
+		return food, "", err
+	}
+
+	var menu models.Menu
+	err = s.menuCollection.FindOne(ctx, bson.M{"menu_id": *food.Menu_ID}).Decode(&menu)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return food, "", nil
+	}
+	return food, menu.Category, err
+}
+
+func (s *MongoKitchenStore) FindOrder(ctx context.Context, orderId string) (models.Order, error) {
+	var order models.Order
+
+	err := s.orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrOrderNotFound
+	}
+	return order, err
+}
+
+func (s *MongoKitchenStore) InsertItem(ctx context.Context, item models.StationItem) error {
+	_, err := s.itemCollection.InsertOne(ctx, item)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *MongoKitchenStore) FindItem(ctx context.Context, orderItemId string) (models.StationItem, error) {
+	var item models.StationItem
+
+	err := s.itemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemId}).Decode(&item)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return item, ErrStationItemNotFound
+	}
+	return item, err
+}
+
+func (s *MongoKitchenStore) ReplaceItem(ctx context.Context, item models.StationItem, version int64) error {
+	result, err := s.itemCollection.ReplaceOne(ctx, bson.M{"order_item_id": item.Order_item_id, "version": version}, item)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrStationItemChanged
+	}
+	return nil
+}
+
+func (s *MongoKitchenStore) Queue(ctx context.Context, stationId string, statuses []string, limit int) ([]models.StationItem, error) {
+	sortBy := bson.D{{Key: "queued_at", Value: 1}}
+	if len(statuses) == 1 && statuses[0] == StationItemBumped {
+		sortBy = bson.D{{Key: "bumped_at", Value: -1}}
+	}
+
+	opts := options.Find().SetSort(sortBy).SetLimit(int64(limit))
+	return s.findItems(ctx, bson.M{"station_id": stationId, "status": bson.M{"$in": statuses}}, opts)
+}
+
+func (s *MongoKitchenStore) QueuedBetween(ctx context.Context, stationId string, from time.Time, to time.Time) ([]models.StationItem, error) {
+	return s.findItems(ctx, bson.M{"station_id": stationId, "queued_at": bson.M{"$gte": from, "$lt": to}})
+}
+
+func (s *MongoKitchenStore) findItems(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]models.StationItem, error) {
+	results, err := s.itemCollection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []models.StationItem
+	if err := results.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
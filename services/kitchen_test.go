@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// newTestKitchen returns a kitchen with a burger and a steak on a Mains menu
+// and a cola on a Drinks menu.
+func newTestKitchen(t *testing.T) (*Kitchen, *MemoryKitchenStore, *MemoryGuestOrderStore) {
+	t.Helper()
+
+	guest := NewMemoryGuestOrderStore(NewMemoryTableStore())
+	guest.SaveMenu(models.Menu{Menu_Id: "menu-mains", Name: "Mains", Category: "Mains"})
+	guest.SaveMenu(models.Menu{Menu_Id: "menu-drinks", Name: "Drinks", Category: "Drinks"})
+	for foodId, menuId := range map[string]string{"food-burger": "menu-mains", "food-steak": "menu-mains", "food-cola": "menu-drinks"} {
+		name, menuId := foodId[len("food-"):], menuId
+		guest.SaveFood(models.Food{Food_ID: foodId, Name: &name, Menu_ID: &menuId})
+	}
+
+	store := NewMemoryKitchenStore(guest)
+	return NewKitchen(store), store, guest
+}
+
+func createStation(t *testing.T, kitchen *Kitchen, name string) string {
+	t.Helper()
+
+	station, err := kitchen.CreateStation(context.Background(), models.Station{Name: &name}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return station.Station_id
+}
+
+func createRule(t *testing.T, kitchen *Kitchen, rule models.RoutingRule) models.RoutingRule {
+	t.Helper()
+
+	rule, err := kitchen.CreateRule(context.Background(), rule, nil)
+	if err != nil {
+		t.Fatalf("CreateRule: %v", err)
+	}
+	return rule
+}
+
+// fire fires an order item of foodId as the order item routes do.
+func fire(kitchen *Kitchen, orderItemId string, orderId string, foodId string) {
+	quantity := "M"
+	kitchen.handle(context.Background(), events.Event{
+		Type:        events.OrderItemFired,
+		Occurred_At: fixedTime,
+		Data:        models.OrderItem{Order_item_id: orderItemId, Order_id: &orderId, Food_id: &foodId, Quantity: &quantity},
+	})
+}
+
+func TestKitchenRoutingPrecedence(t *testing.T) {
+	kitchen, store, guest := newTestKitchen(t)
+	ctx := context.Background()
+
+	expo, grill, flatTop := createStation(t, kitchen, "Expo"), createStation(t, kitchen, "Grill"), createStation(t, kitchen, "Flat top")
+	burger, mains := "food-burger", "Mains"
+
+	// The catch-all rule is created first so the order rules are found in
+	// doesn't decide which wins.
+	catchAll := createRule(t, kitchen, models.RoutingRule{Station_id: &expo})
+	createRule(t, kitchen, models.RoutingRule{Category: &mains, Station_id: &grill})
+	createRule(t, kitchen, models.RoutingRule{Food_id: &burger, Station_id: &flatTop})
+
+	tableId := "t1"
+	guest.InsertOrder(ctx, models.Order{Order_id: "o1", Table_id: &tableId})
+
+	tests := []struct {
+		orderItemId string
+		foodId      string
+		station     string
+	}{
+		{"burger", "food-burger", flatTop}, // food over category
+		{"steak", "food-steak", grill},     // category over everything else
+		{"cola", "food-cola", expo},        // no rule for Drinks
+		{"gone", "food-deleted", expo},     // the food has been deleted since
+	}
+	for _, test := range tests {
+		fire(kitchen, test.orderItemId, "o1", test.foodId)
+
+		item, err := store.FindItem(ctx, test.orderItemId)
+		if err != nil {
+			t.Errorf("%s: not queued: %v", test.foodId, err)
+			continue
+		}
+		if item.Station_id != test.station {
+			t.Errorf("%s: queued at %s, want %s", test.foodId, item.Station_id, test.station)
+		}
+		if item.Status != StationItemQueued || !item.Queued_At.Equal(fixedTime) || item.Table_id == nil || *item.Table_id != "t1" {
+			t.Errorf("%s: queued as %s at %v for table %v, want QUEUED at %v for t1", test.foodId, item.Status, item.Queued_At, item.Table_id, fixedTime)
+		}
+	}
+
+	// An item fired again stays where it was first queued.
+	fire(kitchen, "burger", "o1", "food-steak")
+	if item, _ := store.FindItem(ctx, "burger"); item.Station_id != flatTop {
+		t.Errorf("refired item moved to %s", item.Station_id)
+	}
+
+	// Without the catch-all, items nothing else matches aren't queued.
+	if err := kitchen.DeleteRule(ctx, catchAll.Rule_id); err != nil {
+		t.Fatal(err)
+	}
+	fire(kitchen, "cola-2", "o1", "food-cola")
+	if _, err := store.FindItem(ctx, "cola-2"); !errors.Is(err, ErrStationItemNotFound) {
+		t.Errorf("unmatched item: err = %v, want it not queued", err)
+	}
+}
+
+func TestKitchenCreateRule(t *testing.T) {
+	kitchen, _, _ := newTestKitchen(t)
+	ctx := context.Background()
+
+	grill := createStation(t, kitchen, "Grill")
+	burger, mains, missing, unknownFood := "food-burger", "Mains", "missing", "food-unknown"
+	createRule(t, kitchen, models.RoutingRule{Category: &mains, Station_id: &grill})
+
+	tests := []struct {
+		name  string
+		rule  models.RoutingRule
+		field string
+	}{
+		{"food and category", models.RoutingRule{Food_id: &burger, Category: &mains, Station_id: &grill}, "category"},
+		{"unknown station", models.RoutingRule{Food_id: &burger, Station_id: &missing}, "station_id"},
+		{"unknown food", models.RoutingRule{Food_id: &unknownFood, Station_id: &grill}, "food_id"},
+	}
+	for _, test := range tests {
+		_, err := kitchen.CreateRule(ctx, test.rule, nil)
+
+		var appErr *apperrors.Error
+		if !errors.As(err, &appErr) || len(appErr.Fields) != 1 || appErr.Fields[0].Field != test.field {
+			t.Errorf("%s: err = %v, want a validation error on %s", test.name, err, test.field)
+		}
+	}
+
+	if _, err := kitchen.CreateRule(ctx, models.RoutingRule{Category: &mains, Station_id: &grill}, nil); !errors.Is(err, ErrRoutingRuleExists) {
+		t.Errorf("second rule for Mains: err = %v, want %v", err, ErrRoutingRuleExists)
+	}
+}
+
+func TestKitchenBumpAndRecall(t *testing.T) {
+	kitchen, store, _ := newTestKitchen(t)
+	ctx := context.Background()
+
+	grill := createStation(t, kitchen, "Grill")
+	readyAt := fixedTime.Add(5 * time.Minute)
+	store.InsertItem(ctx, models.StationItem{Order_item_id: "ready", Station_id: grill, Status: StationItemReady, Queued_At: fixedTime, Ready_At: &readyAt, Version: 1})
+	store.InsertItem(ctx, models.StationItem{Order_item_id: "queued", Station_id: grill, Status: StationItemQueued, Queued_At: fixedTime, Version: 1})
+
+	bumped, err := kitchen.Bump(ctx, grill, "ready", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bumped.Status != StationItemBumped || bumped.Bumped_At == nil || !bumped.Ready_At.Equal(readyAt) {
+		t.Errorf("bumped item is %s, ready at %v, want BUMPED still ready at %v", bumped.Status, bumped.Ready_At, readyAt)
+	}
+	if queue, _ := kitchen.Queue(ctx, grill, StationQueueFilter{}); len(queue) != 1 || queue[0].Order_item_id != "queued" {
+		t.Errorf("the screen shows %v, want only the queued item", queue)
+	}
+
+	// Recalling puts the item back as ready without moving its ready time.
+	recalled, err := kitchen.Recall(ctx, grill, "ready", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recalled.Status != StationItemReady || recalled.Bumped_At != nil || recalled.Recalls != 1 || !recalled.Ready_At.Equal(readyAt) {
+		t.Errorf("recalled item is %s, bumped at %v, %d recalls, ready at %v, want READY, not bumped, 1 recall, ready at %v", recalled.Status, recalled.Bumped_At, recalled.Recalls, recalled.Ready_At, readyAt)
+	}
+	if bumped, _ := kitchen.Bump(ctx, grill, "ready", nil); !bumped.Ready_At.Equal(readyAt) {
+		t.Errorf("bumped again, ready at %v, want %v", bumped.Ready_At, readyAt)
+	}
+
+	// An item bumped before it was marked ready is ready as of the bump.
+	bumped, err = kitchen.Bump(ctx, grill, "queued", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bumped.Ready_At == nil || !bumped.Ready_At.Equal(*bumped.Bumped_At) {
+		t.Errorf("item bumped while queued is ready at %v, want the bump at %v", bumped.Ready_At, bumped.Bumped_At)
+	}
+
+	if _, err := kitchen.Recall(ctx, grill, "missing", nil); !errors.Is(err, ErrStationItemNotFound) {
+		t.Errorf("recall of an item not queued: err = %v, want %v", err, ErrStationItemNotFound)
+	}
+	if _, err := kitchen.Bump(ctx, "another-station", "ready", nil); !errors.Is(err, ErrStationItemNotFound) {
+		t.Errorf("bump at another station: err = %v, want %v", err, ErrStationItemNotFound)
+	}
+	kitchen.Recall(ctx, grill, "ready", nil)
+	if _, err := kitchen.Recall(ctx, grill, "ready", nil); !errors.Is(err, ErrStationItemStatus) {
+		t.Errorf("recall of an item on the screen: err = %v, want %v", err, ErrStationItemStatus)
+	}
+}
+
+func TestKitchenTicketTimes(t *testing.T) {
+	kitchen, store, _ := newTestKitchen(t)
+	ctx := context.Background()
+
+	grill, bar := createStation(t, kitchen, "Grill"), createStation(t, kitchen, "Bar")
+	at := func(minutes float64) *time.Time {
+		when := fixedTime.Add(time.Duration(minutes * float64(time.Minute)))
+		return &when
+	}
+	item := func(orderItemId string, orderId string, stationId string, queued float64, started *time.Time, ready *time.Time) {
+		status := StationItemQueued
+		if ready != nil {
+			status = StationItemReady
+		}
+		store.InsertItem(ctx, models.StationItem{Order_item_id: orderItemId, Order_id: &orderId, Station_id: stationId, Status: status, Queued_At: *at(queued), Started_At: started, Ready_At: ready, Version: 1})
+	}
+
+	// o1 is a ticket of 8 minutes, from a being queued to b being ready.
+	item("a", "o1", grill, 0, at(1), at(5))
+	item("b", "o1", grill, 0.5, at(2), at(8))
+	// o2 is a ticket of 2 minutes.
+	item("c", "o2", grill, 30, at(31), at(32))
+	// o3 isn't done, so only its ready item counts.
+	item("d", "o3", grill, 20, nil, nil)
+	item("e", "o3", grill, 21, nil, at(25))
+	// Items queued before the period, or at another station, are left out.
+	item("f", "o4", grill, -10, at(-9), at(-5))
+	item("g", "o1", bar, 0, at(1), at(30))
+
+	// Bumping and recalling doesn't change the times.
+	for _, step := range []func(context.Context, string, string, *models.Actor) (models.StationItem, error){kitchen.Bump, kitchen.Recall, kitchen.Bump} {
+		if _, err := step(ctx, grill, "b", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	times, err := kitchen.TicketTimes(ctx, grill, TicketTimesRequest{From: at(0), To: at(60)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := models.TicketTimes{
+		Station_id:             grill,
+		From:                   *at(0),
+		To:                     *at(60),
+		Tickets:                2,
+		Items:                  4,
+		Open_Items:             1,
+		Average_Ticket_Seconds: 300, // (480 + 120) / 2
+		P90_Ticket_Seconds:     480,
+		Longest_Ticket_Seconds: 480,
+		Average_Wait_Seconds:   70,  // (60 + 90 + 60) / 3
+		Average_Cook_Seconds:   220, // (240 + 360 + 60) / 3
+	}
+	if times != want {
+		t.Errorf("ticket times = %+v\nwant %+v", times, want)
+	}
+
+	if _, err := kitchen.TicketTimes(ctx, grill, TicketTimesRequest{From: at(60), To: at(0)}); err == nil {
+		t.Error("a period ending before it starts was accepted")
+	}
+	if _, err := kitchen.TicketTimes(ctx, "missing", TicketTimesRequest{}); !errors.Is(err, ErrStationNotFound) {
+		t.Errorf("unknown station: err = %v, want %v", err, ErrStationNotFound)
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginAttempts is the failed login history of one account.
+type LoginAttempts struct {
+	Failures     int
+	Last_Failure time.Time
+	Locked_Until time.Time
+}
+
+// LoginAttemptStore keeps LoginAttempts by account key.
+type LoginAttemptStore interface {
+	// Update changes key's history in one atomic step. change is given the
+	// current history, the zero value for accounts with none, and returns
+	// the history to save, or an error to save nothing and return.
+	Update(ctx context.Context, key string, change func(LoginAttempts) (LoginAttempts, error)) error
+	Delete(ctx context.Context, key string) error
+}
+
+// LoginPolicy decides how hard repeated failures are throttled. After
+// DelayAfter failures each attempt has to wait Delay, doubling with every
+// further failure up to MaxDelay. After MaxFailures the account is locked for
+// Lockout. Failures older than Lockout are forgotten.
+type LoginPolicy struct {
+	DelayAfter  int
+	Delay       time.Duration
+	MaxDelay    time.Duration
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+var DefaultLoginPolicy = LoginPolicy{
+	DelayAfter:  3,
+	Delay:       time.Second,
+	MaxDelay:    30 * time.Second,
+	MaxFailures: 10,
+	Lockout:     15 * time.Minute,
+}
+
+// PinLoginPolicy is stricter than DefaultLoginPolicy because a PIN has at
+// most a million values.
+var PinLoginPolicy = LoginPolicy{
+	DelayAfter:  3,
+	Delay:       time.Second,
+	MaxDelay:    30 * time.Second,
+	MaxFailures: 5,
+	Lockout:     30 * time.Minute,
+}
+
+// LoginGuard throttles password guessing against a single account. Accounts
+// are keyed by email whether or not they exist, so the responses don't reveal
+// which emails are registered.
+type LoginGuard struct {
+	store  LoginAttemptStore
+	policy LoginPolicy
+	now    func() time.Time
+}
+
+func NewLoginGuard(store LoginAttemptStore, policy LoginPolicy) *LoginGuard {
+	return &LoginGuard{store: store, policy: policy, now: time.Now}
+}
+
+// Attempt reports whether email may try to log in now and, if it may, counts
+// the attempt as a failure until RecordSuccess clears it. Both happen in one
+// step, so guesses sent in parallel can't all get past the check before any
+// of them is counted. It returns an ACCOUNT_LOCKED or RATE_LIMITED error,
+// with a retry delay, when it may not.
+func (g *LoginGuard) Attempt(ctx context.Context, email string) error {
+	now := g.now()
+
+	return g.store.Update(ctx, loginKey(email), func(attempts LoginAttempts) (LoginAttempts, error) {
+		if now.Before(attempts.Locked_Until) {
+			return attempts, &apperrors.Error{
+				Code:       apperrors.AccountLocked,
+				Message:    "This account is temporarily locked after too many failed logins",
+				RetryAfter: attempts.Locked_Until.Sub(now),
+			}
+		}
+
+		if next := attempts.Last_Failure.Add(g.delay(attempts.Failures)); now.Before(next) {
+			return attempts, &apperrors.Error{
+				Code:       apperrors.RateLimited,
+				Message:    "Too many failed logins. Please wait before trying again",
+				RetryAfter: next.Sub(now),
+			}
+		}
+
+		if now.Sub(attempts.Last_Failure) > g.policy.Lockout {
+			attempts = LoginAttempts{}
+		}
+
+		// The account locks once MaxFailures attempts have failed.
+		attempts.Failures++
+		attempts.Last_Failure = now
+		if attempts.Failures >= g.policy.MaxFailures {
+			attempts.Locked_Until = now.Add(g.policy.Lockout)
+		}
+
+		return attempts, nil
+	})
+}
+
+// RecordSuccess clears the history of an account after a good login.
+func (g *LoginGuard) RecordSuccess(ctx context.Context, email string) error {
+	return g.store.Delete(ctx, loginKey(email))
+}
+
+// Unlock lifts a lockout and clears the failure history of an account.
+func (g *LoginGuard) Unlock(ctx context.Context, email string) error {
+	return g.store.Delete(ctx, loginKey(email))
+}
+
+func (g *LoginGuard) delay(failures int) time.Duration {
+	if failures < g.policy.DelayAfter {
+		return 0
+	}
+
+	delay := g.policy.Delay
+	for i := g.policy.DelayAfter; i < failures && delay < g.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.policy.MaxDelay {
+		delay = g.policy.MaxDelay
+	}
+	return delay
+}
+
+func loginKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginAttemptRetention is how long the history of an account that isn't
+// locked is kept after its last failure.
+const loginAttemptRetention = 24 * time.Hour
+
+// MemoryLoginAttemptStore keeps login history in process, so it is lost on
+// restart and not shared between instances.
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]LoginAttempts
+	lastSweep time.Time
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]LoginAttempts{}}
+}
+
+func (s *MemoryLoginAttemptStore) Update(ctx context.Context, key string, change func(LoginAttempts) (LoginAttempts, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, err := change(s.attempts[key])
+	if err != nil {
+		return err
+	}
+	s.attempts[key] = attempts
+
+	// Guesses against made up emails would otherwise pile up forever.
+	if now := time.Now(); now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+		for key, attempts := range s.attempts {
+			if now.Sub(attempts.Last_Failure) > loginAttemptRetention && now.After(attempts.Locked_Until) {
+				delete(s.attempts, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// MongoLoginAttemptStore keeps login history in MongoDB, where every
+// instance counts against the same attempts.
+type MongoLoginAttemptStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoLoginAttemptStore(client *mongo.Client) *MongoLoginAttemptStore {
+	return &MongoLoginAttemptStore{collection: database.OpenCollection(client, "login_attempts")}
+}
+
+// loginAttemptDocument is how LoginAttempts are stored. Version changes with
+// every write, so Update can tell whether someone wrote in between.
+type loginAttemptDocument struct {
+	Key           string `bson:"_id"`
+	LoginAttempts `bson:",inline"`
+	Version       int64     `bson:"version"`
+	Expires_At    time.Time `bson:"expires_at"`
+}
+
+// loginAttemptUpdateTries is how many times Update reads and writes again
+// when the history changed in between.
+const loginAttemptUpdateTries = 5
+
+func (s *MongoLoginAttemptStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Update reads the history, changes it and writes it back only if it is
+// still the version that was read, trying again if it isn't.
+func (s *MongoLoginAttemptStore) Update(ctx context.Context, key string, change func(LoginAttempts) (LoginAttempts, error)) error {
+	for try := 0; try < loginAttemptUpdateTries; try++ {
+		var current loginAttemptDocument
+		err := s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&current)
+		exists := err == nil
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		attempts, err := change(current.LoginAttempts)
+		if err != nil {
+			return err
+		}
+
+		document := loginAttemptDocument{Key: key, LoginAttempts: attempts, Version: current.Version + 1, Expires_At: attempts.Last_Failure.Add(loginAttemptRetention)}
+		if attempts.Locked_Until.After(document.Expires_At) {
+			document.Expires_At = attempts.Locked_Until
+		}
+
+		if !exists {
+			_, err = s.collection.InsertOne(ctx, document)
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return err
+		}
+
+		result, err := s.collection.ReplaceOne(ctx, bson.M{"_id": key, "version": current.Version}, document)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+	}
+
+	// Only many attempts on one account at once keep changing it under us.
+	return apperrors.New(apperrors.RateLimited, "Too many logins at once. Please wait before trying again")
+}
+
+func (s *MongoLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
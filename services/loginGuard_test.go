@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+)
+
+var testLoginPolicy = LoginPolicy{
+	DelayAfter:  3,
+	Delay:       time.Second,
+	MaxDelay:    4 * time.Second,
+	MaxFailures: 6,
+	Lockout:     time.Minute,
+}
+
+// newTestLoginGuard returns a guard whose clock only moves when the returned
+// function is called.
+func newTestLoginGuard() (*LoginGuard, func(time.Duration)) {
+	guard := NewLoginGuard(NewMemoryLoginAttemptStore(), testLoginPolicy)
+	// The store forgets history a day old by the wall clock.
+	now := time.Now()
+	guard.now = func() time.Time { return now }
+	return guard, func(d time.Duration) { now = now.Add(d) }
+}
+
+// refusal returns the code and retry delay of an error from Attempt.
+func refusal(t *testing.T, err error) (apperrors.Code, time.Duration) {
+	t.Helper()
+
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) {
+		t.Fatalf("Attempt: err = %v, want an *apperrors.Error", err)
+	}
+	return appErr.Code, appErr.RetryAfter
+}
+
+func TestLoginGuardDelays(t *testing.T) {
+	guard, advance := newTestLoginGuard()
+	ctx := context.Background()
+
+	for i := 0; i < testLoginPolicy.DelayAfter; i++ {
+		if err := guard.Attempt(ctx, "ada@example.com"); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+
+	// Each further failure doubles the wait, up to MaxDelay.
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		code, retryAfter := refusal(t, guard.Attempt(ctx, "ada@example.com"))
+		if code != apperrors.RateLimited || retryAfter != wait {
+			t.Fatalf("refused with %s after %v, want %s after %v", code, retryAfter, apperrors.RateLimited, wait)
+		}
+
+		advance(wait)
+		if err := guard.Attempt(ctx, "ada@example.com"); err != nil {
+			t.Fatalf("after waiting %v: %v", wait, err)
+		}
+	}
+
+	// Other accounts, and the same email written differently, are counted
+	// on their own and together respectively.
+	if err := guard.Attempt(ctx, "grace@example.com"); err != nil {
+		t.Errorf("another account: %v", err)
+	}
+	if code, _ := refusal(t, guard.Attempt(ctx, " ADA@example.com")); code != apperrors.AccountLocked {
+		t.Errorf("the same email in capitals: refused with %s, want %s", code, apperrors.AccountLocked)
+	}
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	guard, advance := newTestLoginGuard()
+	ctx := context.Background()
+
+	for i := 0; i < testLoginPolicy.MaxFailures; i++ {
+		if err := guard.Attempt(ctx, "ada@example.com"); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+		advance(testLoginPolicy.MaxDelay)
+	}
+
+	code, retryAfter := refusal(t, guard.Attempt(ctx, "ada@example.com"))
+	if code != apperrors.AccountLocked || retryAfter != testLoginPolicy.Lockout-testLoginPolicy.MaxDelay {
+		t.Fatalf("refused with %s after %v, want %s after %v", code, retryAfter, apperrors.AccountLocked, testLoginPolicy.Lockout-testLoginPolicy.MaxDelay)
+	}
+
+	// Once the lockout has passed the failures are forgotten.
+	advance(testLoginPolicy.Lockout)
+	for i := 0; i < testLoginPolicy.DelayAfter; i++ {
+		if err := guard.Attempt(ctx, "ada@example.com"); err != nil {
+			t.Fatalf("attempt %d after the lockout: %v", i+1, err)
+		}
+	}
+}
+
+func TestLoginGuardSuccessClears(t *testing.T) {
+	guard, _ := newTestLoginGuard()
+	ctx := context.Background()
+
+	for i := 0; i < testLoginPolicy.DelayAfter; i++ {
+		guard.Attempt(ctx, "ada@example.com")
+	}
+	if err := guard.RecordSuccess(ctx, "ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Attempt(ctx, "ada@example.com"); err != nil {
+		t.Errorf("after a good login: %v", err)
+	}
+}
+
+// TestLoginGuardParallel sends guesses all at once, which must not get more
+// attempts than guesses sent one after another.
+func TestLoginGuardParallel(t *testing.T) {
+	guard, _ := newTestLoginGuard()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var allowed int
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if guard.Attempt(context.Background(), "ada@example.com") == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != testLoginPolicy.DelayAfter {
+		t.Errorf("%d parallel attempts allowed, want %d", allowed, testLoginPolicy.DelayAfter)
+	}
+}
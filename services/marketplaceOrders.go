@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/marketplace"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrMarketplaceNotFound  = apperrors.New(apperrors.NotFound, "No marketplace with that name is set up")
+	ErrMarketplaceSignature = apperrors.New(apperrors.Unauthorized, "The webhook signature is not valid")
+	ErrMenuMappingNotFound  = apperrors.New(apperrors.NotFound, "Menu mapping for that external_item_id not found")
+	// ErrExternalOrderExists is returned by MarketplaceStore.InsertOrder when
+	// the marketplace order was already taken, by a webhook sent twice.
+	ErrExternalOrderExists = errors.New("marketplace order already exists")
+)
+
+// statusRetries are the waits between attempts to send a status update.
+var statusRetries = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second}
+
+type MarketplaceStore interface {
+	Mappings(ctx context.Context, provider string) ([]models.MenuMapping, error)
+	// SaveMapping creates or replaces the mapping of its external item.
+	SaveMapping(ctx context.Context, mapping models.MenuMapping) error
+	DeleteMapping(ctx context.Context, provider string, externalItemId string) error
+	FindOrder(ctx context.Context, orderId string) (models.Order, error)
+	// FindExternal finds the order taken from a marketplace order.
+	FindExternal(ctx context.Context, provider string, externalId string) (models.Order, error)
+	// InsertOrder inserts an order and its items together.
+	InsertOrder(ctx context.Context, order models.Order, orderItems []models.OrderItem) error
+	SetLastStatus(ctx context.Context, orderId string, status string) error
+}
+
+// MarketplaceOrders takes orders from delivery marketplaces and tells them
+// how the orders are getting on.
+type MarketplaceOrders struct {
+	store    MarketplaceStore
+	adapters map[string]marketplace.Adapter
+	client   *http.Client
+}
+
+func NewMarketplaceOrders(store MarketplaceStore, adapters map[string]marketplace.Adapter) *MarketplaceOrders {
+	return &MarketplaceOrders{store: store, adapters: adapters, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Subscribe reports the orders' progress to their marketplaces as events are
+// published on bus.
+func (m *MarketplaceOrders) Subscribe(bus *events.Bus) {
+	bus.Subscribe(m.handle)
+}
+
+func (m *MarketplaceOrders) Adapter(provider string) (marketplace.Adapter, error) {
+	adapter, ok := m.adapters[provider]
+	if !ok {
+		return nil, ErrMarketplaceNotFound
+	}
+	return adapter, nil
+}
+
+// Receive verifies and reads a webhook from provider.
+func (m *MarketplaceOrders) Receive(provider string, header http.Header, body []byte) (marketplace.Order, error) {
+	adapter, err := m.Adapter(provider)
+	if err != nil {
+		return marketplace.Order{}, err
+	}
+
+	if err := adapter.Verify(header, body, time.Now()); err != nil {
+		return marketplace.Order{}, ErrMarketplaceSignature
+	}
+
+	order, err := adapter.Parse(body)
+	if err != nil {
+		return order, apperrors.Wrap(apperrors.BadRequest, "The webhook payload could not be read", err)
+	}
+	return order, nil
+}
+
+// Ingest creates the order and order items for a marketplace order, with
+// its items mapped to foods, and fires the items. A marketplace order that
+// was already taken is returned as it is, since marketplaces resend
+// webhooks they aren't sure arrived.
+func (m *MarketplaceOrders) Ingest(ctx context.Context, provider string, incoming marketplace.Order) (models.Order, bool, error) {
+	existing, err := m.store.FindExternal(ctx, provider, incoming.External_id)
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.Is(err, ErrOrderNotFound) {
+		return existing, false, err
+	}
+
+	foods, err := m.foods(ctx, provider)
+	if err != nil {
+		return models.Order{}, false, err
+	}
+
+	var unmapped []apperrors.FieldError
+	for i, item := range incoming.Items {
+		if _, ok := foods[item.External_item_id]; !ok {
+			unmapped = append(unmapped, apperrors.FieldError{Field: fmt.Sprintf("items[%d].external_item_id", i), Rule: "mapped", Message: "is not mapped to a food"})
+		}
+	}
+	if len(unmapped) > 0 {
+		return models.Order{}, false, &apperrors.Error{Code: apperrors.ValidationFailed, Message: "Some items are not on the menu mapping for " + provider, Fields: unmapped}
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	by := &models.Actor{Marketplace: provider}
+	order := m.newOrder(provider, incoming, now, by)
+
+	var orderItems []models.OrderItem
+	for _, item := range incoming.Items {
+		size, price := item.Size, item.Unit_Price
+		if size == "" {
+			size = "M"
+		}
+
+		// Order items are one of a food each.
+		for n := 0; n < item.Count; n++ {
+			orderItem := models.OrderItem{
+				ID:         primitive.NewObjectID(),
+				Quantity:   &size,
+				Unit_Price: &price,
+				Food_id:    foods[item.External_item_id],
+				Order_id:   &order.Order_id,
+				Created_At: now,
+				Updated_At: now,
+				Version:    1,
+				Created_By: by,
+			}
+			orderItem.Order_item_id = orderItem.ID.Hex()
+			orderItems = append(orderItems, orderItem)
+		}
+	}
+
+	if err := m.store.InsertOrder(ctx, order, orderItems); err != nil {
+		if errors.Is(err, ErrExternalOrderExists) {
+			existing, err := m.store.FindExternal(ctx, provider, incoming.External_id)
+			return existing, false, err
+		}
+		return order, false, err
+	}
+
+	events.Publish(ctx, events.Event{Type: events.OrderCreated, Subject: order.Order_id, Data: order})
+	for _, orderItem := range orderItems {
+		events.Publish(ctx, events.Event{Type: events.OrderItemFired, Subject: orderItem.Order_item_id, Data: orderItem})
+	}
+
+	return order, true, nil
+}
+
+func (m *MarketplaceOrders) newOrder(provider string, incoming marketplace.Order, now time.Time, by *models.Actor) models.Order {
+	status := OrderStatusOpen
+	orderType := incoming.Order_type
+
+	order := models.Order{
+		ID:               primitive.NewObjectID(),
+		Order_Date:       incoming.Placed_At,
+		Created_At:       now,
+		Updated_At:       now,
+		Version:          1,
+		Order_type:       &orderType,
+		Customer:         incoming.Customer,
+		Delivery_Address: incoming.Delivery_Address,
+		Promised_At:      incoming.Promised_At,
+		Order_status:     &status,
+		External_Ref: &models.ExternalRef{
+			Provider:    provider,
+			External_id: incoming.External_id,
+			Display_id:  incoming.Display_id,
+		},
+		Created_By: by,
+	}
+	order.Order_id = order.ID.Hex()
+
+	if order.Order_Date.IsZero() {
+		order.Order_Date = now
+	}
+	return order
+}
+
+// foods maps the provider's menu item ids to food ids.
+func (m *MarketplaceOrders) foods(ctx context.Context, provider string) (map[string]*string, error) {
+	mappings, err := m.store.Mappings(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	foods := make(map[string]*string, len(mappings))
+	for _, mapping := range mappings {
+		foods[mapping.External_item_id] = mapping.Food_id
+	}
+	return foods, nil
+}
+
+// Mappings lists the menu mapping of provider.
+func (m *MarketplaceOrders) Mappings(ctx context.Context, provider string) ([]models.MenuMapping, error) {
+	if _, err := m.Adapter(provider); err != nil {
+		return nil, err
+	}
+
+	mappings, err := m.store.Mappings(ctx, provider)
+	if mappings == nil {
+		mappings = []models.MenuMapping{}
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].External_item_id < mappings[j].External_item_id })
+	return mappings, err
+}
+
+// MapItem says which food provider's menu item externalItemId is.
+func (m *MarketplaceOrders) MapItem(ctx context.Context, mapping models.MenuMapping) (models.MenuMapping, error) {
+	if _, err := m.Adapter(mapping.Provider); err != nil {
+		return mapping, err
+	}
+
+	mapping.ID = primitive.NewObjectID()
+	mapping.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if err := m.store.SaveMapping(ctx, mapping); err != nil {
+		return mapping, err
+	}
+	return mapping, nil
+}
+
+func (m *MarketplaceOrders) UnmapItem(ctx context.Context, provider string, externalItemId string) error {
+	if _, err := m.Adapter(provider); err != nil {
+		return err
+	}
+	return m.store.DeleteMapping(ctx, provider, externalItemId)
+}
+
+func (m *MarketplaceOrders) handle(ctx context.Context, event events.Event) {
+	switch data := event.Data.(type) {
+	case models.Order:
+		if event.Type == events.OrderCreated && data.External_Ref != nil {
+			m.report(ctx, data, marketplace.StatusAccepted)
+		}
+	case models.Invoice:
+		if event.Type == events.InvoicePaid {
+			order, err := m.store.FindOrder(ctx, data.Order_id)
+			if err != nil {
+				if !errors.Is(err, ErrOrderNotFound) {
+					logging.FromContext(ctx).Error("could not find order to report to marketplace", "order_id", data.Order_id, "error", err)
+				}
+				return
+			}
+			if order.External_Ref != nil {
+				m.report(ctx, order, marketplace.StatusCompleted)
+			}
+		}
+	}
+}
+
+// report sends status to the marketplace order came from in the background,
+// retrying a few times if the marketplace can't be reached.
+func (m *MarketplaceOrders) report(ctx context.Context, order models.Order, status string) {
+	ref := order.External_Ref
+	adapter, ok := m.adapters[ref.Provider]
+	if !ok {
+		return
+	}
+
+	update := marketplace.StatusUpdate{External_id: ref.External_id, Order_id: order.Order_id, Status: status, Occurred_At: time.Now()}
+	ctx = context.WithoutCancel(ctx)
+	logger := logging.FromContext(ctx).With("provider", ref.Provider, "order_id", order.Order_id, "status", status)
+
+	go func() {
+		for attempt := 0; ; attempt++ {
+			sent, err := m.send(ctx, adapter, update)
+			if err == nil && !sent {
+				// The marketplace takes no status updates.
+				return
+			}
+			if err == nil {
+				break
+			}
+			if attempt == len(statusRetries) {
+				logger.Error("could not report order status to marketplace", "error", err)
+				return
+			}
+			logger.Warn("retrying order status report to marketplace", "error", err)
+			time.Sleep(statusRetries[attempt])
+		}
+
+		if err := m.store.SetLastStatus(ctx, order.Order_id, status); err != nil {
+			logger.Error("could not record order status reported to marketplace", "error", err)
+		}
+	}()
+}
+
+// send posts update, reporting false when the marketplace takes no status
+// updates.
+func (m *MarketplaceOrders) send(ctx context.Context, adapter marketplace.Adapter, update marketplace.StatusUpdate) (bool, error) {
+	req, err := adapter.StatusRequest(ctx, update)
+	if err != nil || req == nil {
+		return false, err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return true, fmt.Errorf("marketplace answered %s: %s", resp.Status, detail)
+	}
+	return true, nil
+}
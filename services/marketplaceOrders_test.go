@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/marketplace"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+var marketplaceSecret = []byte("whsec_test_0123456789")
+
+// newTestMarketplace returns marketplace orders taking webhooks from
+// "ubereats", which reports status updates to callbackUrl, with its
+// "burger-classic" and "fries" mapped to foods.
+func newTestMarketplace(t *testing.T, callbackUrl string) (*MarketplaceOrders, *marketplace.StandardAdapter, *MemoryGuestOrderStore) {
+	t.Helper()
+
+	guest := NewMemoryGuestOrderStore(NewMemoryTableStore())
+	adapter := marketplace.NewStandardAdapter(marketplaceSecret, callbackUrl)
+	orders := NewMarketplaceOrders(NewMemoryMarketplaceStore(guest), map[string]marketplace.Adapter{"ubereats": adapter})
+
+	for externalId, foodId := range map[string]string{"burger-classic": "food-burger", "fries": "food-fries"} {
+		foodId := foodId
+		if _, err := orders.MapItem(context.Background(), models.MenuMapping{Provider: "ubereats", External_item_id: externalId, Food_id: &foodId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return orders, adapter, guest
+}
+
+// receiveFixture signs the order in testdata/name as ubereats would and
+// receives it.
+func receiveFixture(t *testing.T, orders *MarketplaceOrders, adapter *marketplace.StandardAdapter, name string) marketplace.Order {
+	t.Helper()
+
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{marketplace.SignatureHeader: []string{adapter.SignatureHeader(time.Now(), body)}}
+	order, err := orders.Receive("ubereats", header, body)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	return order
+}
+
+func TestMarketplaceReceive(t *testing.T) {
+	orders, _, _ := newTestMarketplace(t, "")
+	body, _ := os.ReadFile("testdata/marketplace_order.json")
+
+	forged := marketplace.NewStandardAdapter([]byte("whsec_forged_0123456789"), "")
+	header := http.Header{marketplace.SignatureHeader: []string{forged.SignatureHeader(time.Now(), body)}}
+	if _, err := orders.Receive("ubereats", header, body); !errors.Is(err, ErrMarketplaceSignature) {
+		t.Errorf("forged webhook: err = %v, want %v", err, ErrMarketplaceSignature)
+	}
+	if _, err := orders.Receive("doordash", header, body); !errors.Is(err, ErrMarketplaceNotFound) {
+		t.Errorf("unknown marketplace: err = %v, want %v", err, ErrMarketplaceNotFound)
+	}
+}
+
+func TestMarketplaceIngest(t *testing.T) {
+	orders, adapter, guest := newTestMarketplace(t, "")
+	ctx := context.Background()
+
+	incoming := receiveFixture(t, orders, adapter, "marketplace_order.json")
+	order, created, err := orders.Ingest(ctx, "ubereats", incoming)
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	if !created {
+		t.Error("Ingest reported a new order as taken before")
+	}
+
+	if ref := order.External_Ref; ref == nil || ref.Provider != "ubereats" || ref.External_id != "ue-7f3a9c" || ref.Display_id != "7F3A" {
+		t.Errorf("external_ref = %+v, want ubereats order ue-7f3a9c (7F3A)", order.External_Ref)
+	}
+	if order.Order_type == nil || *order.Order_type != "DELIVERY" || order.Customer == nil || order.Customer.Name != "Ada Lovelace" {
+		t.Errorf("order is %v for %+v, want DELIVERY for Ada Lovelace", order.Order_type, order.Customer)
+	}
+
+	// Each item is one of a food, in the marketplace's size or M.
+	orderItems, err := guest.OrderItems(ctx, order.Order_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, orderItem := range orderItems {
+		counts[*orderItem.Food_id+" "+*orderItem.Quantity]++
+	}
+	if want := map[string]int{"food-burger L": 2, "food-fries M": 1}; len(counts) != len(want) || counts["food-burger L"] != 2 || counts["food-fries M"] != 1 {
+		t.Errorf("order items = %v, want %v", counts, want)
+	}
+}
+
+func TestMarketplaceIngestDedupes(t *testing.T) {
+	orders, adapter, guest := newTestMarketplace(t, "")
+	ctx := context.Background()
+	incoming := receiveFixture(t, orders, adapter, "marketplace_order.json")
+
+	// Marketplaces resend webhooks, sometimes before the first is answered.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	orderIds := map[string]bool{}
+	var createdCount int
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			order, created, err := orders.Ingest(ctx, "ubereats", incoming)
+			if err != nil {
+				t.Errorf("Ingest: %v", err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			orderIds[order.Order_id] = true
+			if created {
+				createdCount++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if createdCount != 1 || len(orderIds) != 1 {
+		t.Fatalf("%d webhooks created orders, answered with %d orders, want 1 and 1", createdCount, len(orderIds))
+	}
+	for orderId := range orderIds {
+		orderItems, _ := guest.OrderItems(ctx, orderId)
+		if len(orderItems) != 3 {
+			t.Errorf("the order has %d items, want 3", len(orderItems))
+		}
+	}
+}
+
+func TestMarketplaceIngestRefusesUnmappedItems(t *testing.T) {
+	orders, adapter, _ := newTestMarketplace(t, "")
+	ctx := context.Background()
+
+	incoming := receiveFixture(t, orders, adapter, "marketplace_order_unmapped.json")
+	_, _, err := orders.Ingest(ctx, "ubereats", incoming)
+
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) || appErr.Code != apperrors.ValidationFailed {
+		t.Fatalf("Ingest: err = %v, want a %s error", err, apperrors.ValidationFailed)
+	}
+	if len(appErr.Fields) != 1 || appErr.Fields[0].Field != "items[1].external_item_id" {
+		t.Errorf("fields = %+v, want only items[1].external_item_id", appErr.Fields)
+	}
+
+	// Nothing of the order was taken, so it goes through once mapped.
+	foodId := "food-shake"
+	orders.MapItem(ctx, models.MenuMapping{Provider: "ubereats", External_item_id: "milkshake-seasonal", Food_id: &foodId})
+	if _, created, err := orders.Ingest(ctx, "ubereats", incoming); err != nil || !created {
+		t.Errorf("Ingest once mapped = %v, %v, want the order created", created, err)
+	}
+}
+
+// statusReceiver is a marketplace's status callback, which fails the first
+// failures updates it is sent.
+type statusReceiver struct {
+	mu       sync.Mutex
+	failures int
+	updates  []marketplace.StatusUpdate
+	received chan struct{}
+}
+
+func newStatusReceiver(t *testing.T, failures int) (*statusReceiver, *httptest.Server) {
+	// The marketplace checks updates with the secret it signs webhooks with.
+	signer := marketplace.NewStandardAdapter(marketplaceSecret, "")
+	receiver := &statusReceiver{failures: failures, received: make(chan struct{}, 10)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := signer.Verify(r.Header, body, time.Now()); err != nil {
+			t.Errorf("status update signature: %v", err)
+		}
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		if receiver.failures > 0 {
+			receiver.failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		var update marketplace.StatusUpdate
+		json.Unmarshal(body, &update)
+		receiver.updates = append(receiver.updates, update)
+		w.WriteHeader(http.StatusOK)
+		receiver.received <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+	return receiver, server
+}
+
+// waitForStatus waits for the marketplace to be told of status.
+func (r *statusReceiver) waitForStatus(t *testing.T, status string) marketplace.StatusUpdate {
+	t.Helper()
+
+	select {
+	case <-r.received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the marketplace was not told the order is %s", status)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update := r.updates[len(r.updates)-1]
+	if update.Status != status {
+		t.Fatalf("the marketplace was told the order is %s, want %s", update.Status, status)
+	}
+	return update
+}
+
+func TestMarketplaceReportsStatus(t *testing.T) {
+	previous := statusRetries
+	statusRetries = []time.Duration{time.Millisecond, time.Millisecond}
+	defer func() { statusRetries = previous }()
+
+	receiver, server := newStatusReceiver(t, 2)
+	orders, adapter, guest := newTestMarketplace(t, server.URL)
+	ctx := context.Background()
+
+	order, _, err := orders.Ingest(ctx, "ubereats", receiveFixture(t, orders, adapter, "marketplace_order.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The callback fails twice before the update gets through.
+	orders.handle(ctx, events.Event{Type: events.OrderCreated, Subject: order.Order_id, Data: order})
+	update := receiver.waitForStatus(t, marketplace.StatusAccepted)
+	if update.External_id != "ue-7f3a9c" || update.Order_id != order.Order_id {
+		t.Errorf("update is about %s (%s), want ue-7f3a9c (%s)", update.External_id, update.Order_id, order.Order_id)
+	}
+
+	orders.handle(ctx, events.Event{Type: events.InvoicePaid, Subject: "i1", Data: models.Invoice{Invoice_id: "i1", Order_id: order.Order_id}})
+	receiver.waitForStatus(t, marketplace.StatusCompleted)
+
+	// The last status is recorded once it has been sent.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, _ := guest.tables.FindOrder(ctx, order.Order_id)
+		if stored.External_Ref.Last_Status == marketplace.StatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last_status = %q, want %s", stored.External_Ref.Last_Status, marketplace.StatusCompleted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Orders taken in the restaurant are not reported.
+	orders.handle(ctx, events.Event{Type: events.OrderCreated, Data: models.Order{Order_id: "walk-in"}})
+	select {
+	case <-receiver.received:
+		t.Error("an order that isn't the marketplace's was reported to it")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
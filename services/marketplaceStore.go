@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// MemoryMarketplaceStore keeps menu mappings in process and puts orders and
+// their items on a MemoryGuestOrderStore, where checkout finds them.
+type MemoryMarketplaceStore struct {
+	mu       sync.Mutex
+	mappings map[string]models.MenuMapping
+	guest    *MemoryGuestOrderStore
+	tables   *MemoryTableStore
+}
+
+func NewMemoryMarketplaceStore(guest *MemoryGuestOrderStore) *MemoryMarketplaceStore {
+	return &MemoryMarketplaceStore{
+		mappings: map[string]models.MenuMapping{},
+		guest:    guest,
+		tables:   guest.tables,
+	}
+}
+
+func (s *MemoryMarketplaceStore) Mappings(ctx context.Context, provider string) ([]models.MenuMapping, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var mappings []models.MenuMapping
+	for _, mapping := range s.mappings {
+		if mapping.Provider == provider {
+			mappings = append(mappings, mapping)
+		}
+	}
+	return mappings, nil
+}
+
+func (s *MemoryMarketplaceStore) SaveMapping(ctx context.Context, mapping models.MenuMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mappings[mapping.Provider+"/"+mapping.External_item_id] = mapping
+	return nil
+}
+
+func (s *MemoryMarketplaceStore) DeleteMapping(ctx context.Context, provider string, externalItemId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := provider + "/" + externalItemId
+	if _, ok := s.mappings[key]; !ok {
+		return ErrMenuMappingNotFound
+	}
+	delete(s.mappings, key)
+	return nil
+}
+
+func (s *MemoryMarketplaceStore) FindOrder(ctx context.Context, orderId string) (models.Order, error) {
+	return s.tables.FindOrder(ctx, orderId)
+}
+
+func (s *MemoryMarketplaceStore) FindExternal(ctx context.Context, provider string, externalId string) (models.Order, error) {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	for _, order := range s.tables.orders {
+		if ref := order.External_Ref; ref != nil && ref.Provider == provider && ref.External_id == externalId {
+			return order, nil
+		}
+	}
+	return models.Order{}, ErrOrderNotFound
+}
+
+func (s *MemoryMarketplaceStore) InsertOrder(ctx context.Context, order models.Order, orderItems []models.OrderItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Holding s.mu keeps two deliveries of the same webhook from both
+	// getting past the check.
+	if _, err := s.FindExternal(ctx, order.External_Ref.Provider, order.External_Ref.External_id); err == nil {
+		return ErrExternalOrderExists
+	}
+
+	s.tables.SaveOrder(order)
+	return s.guest.InsertOrderItems(ctx, orderItems)
+}
+
+func (s *MemoryMarketplaceStore) SetLastStatus(ctx context.Context, orderId string, status string) error {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	order, ok := s.tables.orders[orderId]
+	if !ok || order.External_Ref == nil {
+		return ErrOrderNotFound
+	}
+
+	ref := *order.External_Ref
+	ref.Last_Status = status
+	order.External_Ref = &ref
+	s.tables.orders[orderId] = order
+	return nil
+}
+
+// MongoMarketplaceStore inserts marketplace orders and their items inside a
+// MongoDB multi-document transaction, like MongoCheckoutStore.
+type MongoMarketplaceStore struct {
+	client              *mongo.Client
+	mappingCollection   *mongo.Collection
+	orderCollection     *mongo.Collection
+	orderItemCollection *mongo.Collection
+}
+
+func NewMongoMarketplaceStore(client *mongo.Client) *MongoMarketplaceStore {
+	return &MongoMarketplaceStore{
+		client:              client,
+		mappingCollection:   database.OpenCollection(client, "marketplace_menu_map"),
+		orderCollection:     database.OpenCollection(client, "order"),
+		orderItemCollection: database.OpenCollection(client, "orderitems"),
+	}
+}
+
+// EnsureIndexes makes a marketplace order unique on the order collection, so
+// a webhook delivered twice at once creates one order.
+func (s *MongoMarketplaceStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.mappingCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "external_item_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "external_ref.provider", Value: 1}, {Key: "external_ref.external_id", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"external_ref": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+func (s *MongoMarketplaceStore) Mappings(ctx context.Context, provider string) ([]models.MenuMapping, error) {
+	results, err := s.mappingCollection.Find(ctx, bson.M{"provider": provider})
+	if err != nil {
+		return nil, err
+	}
+
+	var mappings []models.MenuMapping
+	if err := results.All(ctx, &mappings); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+func (s *MongoMarketplaceStore) SaveMapping(ctx context.Context, mapping models.MenuMapping) error {
+	filter := bson.M{"provider": mapping.Provider, "external_item_id": mapping.External_item_id}
+	update := bson.M{
+		"$set":         bson.M{"food_id": mapping.Food_id, "updated_at": mapping.Updated_At, "updated_by": mapping.Updated_By},
+		"$setOnInsert": bson.M{"_id": mapping.ID},
+	}
+
+	_, err := s.mappingCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *MongoMarketplaceStore) DeleteMapping(ctx context.Context, provider string, externalItemId string) error {
+	result, err := s.mappingCollection.DeleteOne(ctx, bson.M{"provider": provider, "external_item_id": externalItemId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrMenuMappingNotFound
+	}
+	return nil
+}
+
+func (s *MongoMarketplaceStore) FindOrder(ctx context.Context, orderId string) (models.Order, error) {
+	return s.findOrder(ctx, bson.M{"order_id": orderId})
+}
+
+func (s *MongoMarketplaceStore) FindExternal(ctx context.Context, provider string, externalId string) (models.Order, error) {
+	return s.findOrder(ctx, bson.M{"external_ref.provider": provider, "external_ref.external_id": externalId})
+}
+
+func (s *MongoMarketplaceStore) findOrder(ctx context.Context, filter bson.M) (models.Order, error) {
+	var order models.Order
+
+	err := s.orderCollection.FindOne(ctx, filter).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrOrderNotFound
+	}
+	return order, err
+}
+
+func (s *MongoMarketplaceStore) InsertOrder(ctx context.Context, order models.Order, orderItems []models.OrderItem) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	txnOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if _, err := s.orderCollection.InsertOne(sessCtx, order); err != nil {
+			return nil, err
+		}
+
+		documents := make([]interface{}, len(orderItems))
+		for i, orderItem := range orderItems {
+			documents[i] = orderItem
+		}
+		_, err := s.orderItemCollection.InsertMany(sessCtx, documents)
+		return nil, err
+	}, txnOpts)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrExternalOrderExists
+	}
+	return err
+}
+
+func (s *MongoMarketplaceStore) SetLastStatus(ctx context.Context, orderId string, status string) error {
+	result, err := s.orderCollection.UpdateOne(ctx, bson.M{"order_id": orderId}, bson.M{"$set": bson.M{"external_ref.last_status": status}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/events"
+)
+
+const (
+	MenuChangeCreated = "created"
+	MenuChangeUpdated = "updated"
+	MenuChangeDeleted = "deleted"
+)
+
+// MenuChange is the data of a menu.updated event, published when a menu or
+// one of its foods is created, updated or deleted. It names what changed
+// rather than carrying it, so consumers fetch the menu again.
+type MenuChange struct {
+	Menu_id    string    `json:"menu_id,omitempty"`
+	Food_id    string    `json:"food_id,omitempty"`
+	Change     string    `json:"change"`
+	Changed_At time.Time `json:"changed_at"`
+}
+
+// PublishMenuChange publishes a menu.updated event about the menu or food.
+func PublishMenuChange(ctx context.Context, change MenuChange) {
+	subject := change.Menu_id
+	if change.Food_id != "" {
+		subject = change.Food_id
+	}
+	if change.Changed_At.IsZero() {
+		change.Changed_At = time.Now()
+	}
+
+	events.Publish(ctx, events.Event{Type: events.MenuUpdated, Subject: subject, Occurred_At: change.Changed_At, Data: change})
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+const (
+	OrderTypeDineIn   = "DINE_IN"
+	OrderTypeTakeout  = "TAKEOUT"
+	OrderTypeDelivery = "DELIVERY"
+	OrderTypePickup   = "PICKUP"
+)
+
+const (
+	// PickupSlotLength is how long each pickup slot is. Slots start on the
+	// quarter hour.
+	PickupSlotLength = 15 * time.Minute
+	// DefaultPickupSlotCapacity is how many pickup orders the kitchen takes
+	// for one slot.
+	DefaultPickupSlotCapacity = 6
+	// DefaultPickupLeadTime is how long the kitchen needs before the first
+	// slot an order can be promised for. Takeout orders are promised this
+	// long after they are placed.
+	DefaultPickupLeadTime = 20 * time.Minute
+	// DefaultDeliveryTime is how long after it is placed a delivery order is
+	// promised for, unless the customer asks for later.
+	DefaultDeliveryTime = 45 * time.Minute
+	// pickupHorizon is how far ahead pickup slots can be booked, and listed.
+	pickupHorizon = 7 * 24 * time.Hour
+	// autoSlotSearch is how far ahead a slot is looked for when the customer
+	// didn't ask for one.
+	autoSlotSearch = 24 * time.Hour
+)
+
+var (
+	ErrPickupSlotFull     = apperrors.New(apperrors.Conflict, "That pickup slot is full. Pick another one")
+	ErrNoPickupSlot       = apperrors.New(apperrors.Conflict, "There are no free pickup slots in the next 24 hours")
+	ErrPickupSlotTooSoon  = apperrors.New(apperrors.Conflict, "That pickup slot is too soon for the kitchen to have the order ready")
+	ErrPickupSlotTooLate  = apperrors.New(apperrors.Conflict, "Pickup slots can only be booked up to a week ahead")
+	ErrPickupRangeInvalid = apperrors.New(apperrors.BadRequest, "to must be after from and at most a week ahead")
+)
+
+// OrderType returns the type of an order. Orders from before there were
+// types are dine-in.
+func OrderType(order models.Order) string {
+	if order.Order_type == nil || *order.Order_type == "" {
+		return OrderTypeDineIn
+	}
+	return *order.Order_type
+}
+
+// ValidateOrderType checks that an order has what its type needs: a table
+// for dine-in, a customer to call for pickups and deliveries, and an address
+// with coordinates for deliveries. Only dine-in orders may have a table.
+func ValidateOrderType(order models.Order) error {
+	var fields []apperrors.FieldError
+	required := func(field string) {
+		fields = append(fields, apperrors.FieldError{Field: field, Rule: "required", Message: "is required for " + OrderType(order) + " orders"})
+	}
+
+	switch OrderType(order) {
+	case OrderTypeDineIn:
+		if order.Table_id == nil || *order.Table_id == "" {
+			required("table_id")
+		}
+	case OrderTypeDelivery:
+		if order.Delivery_Address == nil {
+			required("delivery_address")
+		} else {
+			// Addresses are placed in a delivery zone by their coordinates.
+			if order.Delivery_Address.Latitude == nil {
+				required("delivery_address.latitude")
+			}
+			if order.Delivery_Address.Longitude == nil {
+				required("delivery_address.longitude")
+			}
+		}
+		fallthrough
+	case OrderTypePickup:
+		if order.Customer == nil {
+			required("customer")
+		} else if order.Customer.Phone == "" {
+			required("customer.phone")
+		}
+	}
+
+	if OrderType(order) != OrderTypeDineIn && order.Table_id != nil {
+		fields = append(fields, apperrors.FieldError{Field: "table_id", Rule: "excluded", Message: "can only be set for DINE_IN orders"})
+	}
+
+	if len(fields) > 0 {
+		return &apperrors.Error{Code: apperrors.ValidationFailed, Message: "The request body is not valid", Fields: fields}
+	}
+	return nil
+}
+
+// PickupSlot is one window customers can collect pickup orders in.
+type PickupSlot struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Capacity  int       `json:"capacity"`
+	Booked    int       `json:"booked"`
+	Available int       `json:"available"`
+}
+
+type PickupSlotStore interface {
+	// CountPickups returns how many pickup orders are promised for each slot
+	// starting from from until to, keyed by the slot's start in Unix
+	// seconds.
+	CountPickups(ctx context.Context, from time.Time, to time.Time) (map[int64]int, error)
+	// ReservePickup takes a place in the slot starting at slot, unless
+	// capacity are already taken, and calls insert with a ctx that commits
+	// or rolls back with it. It returns ErrPickupSlotFull when the slot has
+	// no room.
+	ReservePickup(ctx context.Context, slot time.Time, capacity int, insert func(ctx context.Context) error) error
+	// ReleasePickup gives back a place in the slot starting at slot once
+	// its order is gone.
+	ReleasePickup(ctx context.Context, slot time.Time) error
+}
+
+// PickupScheduler hands out pickup slots so the kitchen isn't promised more
+// orders for one slot than it can make.
+type PickupScheduler struct {
+	store    PickupSlotStore
+	capacity int
+	leadTime time.Duration
+}
+
+func NewPickupScheduler(store PickupSlotStore, capacity int, leadTime time.Duration) *PickupScheduler {
+	return &PickupScheduler{store: store, capacity: capacity, leadTime: leadTime}
+}
+
+// LeadTime is how long the kitchen needs to get an order ready.
+func (s *PickupScheduler) LeadTime() time.Duration {
+	return s.leadTime
+}
+
+// Slots lists the slots from the first the kitchen can make, or from, if
+// later, until to.
+func (s *PickupScheduler) Slots(ctx context.Context, from time.Time, to time.Time) ([]PickupSlot, error) {
+	now := time.Now()
+	if earliest := s.earliest(now); from.Before(earliest) {
+		from = earliest
+	}
+	from = slotStart(from.Add(PickupSlotLength - time.Nanosecond))
+
+	if !to.After(from) || to.After(now.Add(pickupHorizon)) {
+		return nil, ErrPickupRangeInvalid
+	}
+
+	counts, err := s.store.CountPickups(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var slots []PickupSlot
+	for start := from; start.Before(to); start = start.Add(PickupSlotLength) {
+		booked := counts[start.Unix()]
+		slots = append(slots, PickupSlot{
+			Start:     start,
+			End:       start.Add(PickupSlotLength),
+			Capacity:  s.capacity,
+			Booked:    booked,
+			Available: max(s.capacity-booked, 0),
+		})
+	}
+	return slots, nil
+}
+
+// Book promises a pickup order the slot containing requested, or the first
+// slot with room when requested is nil, and calls insert with the slot's
+// start in the same transaction as the place it takes.
+func (s *PickupScheduler) Book(ctx context.Context, requested *time.Time, insert func(ctx context.Context, slot time.Time) error) (time.Time, error) {
+	now := time.Now()
+	earliest := slotStart(s.earliest(now).Add(PickupSlotLength - time.Nanosecond))
+
+	if requested != nil {
+		slot := slotStart(*requested)
+		if slot.Before(earliest) {
+			return slot, ErrPickupSlotTooSoon
+		}
+		if slot.After(now.Add(pickupHorizon)) {
+			return slot, ErrPickupSlotTooLate
+		}
+		return slot, s.reserve(ctx, slot, insert)
+	}
+
+	counts, err := s.store.CountPickups(ctx, earliest, earliest.Add(autoSlotSearch))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// The counts only skip slots that were full. One filling up in the
+	// meantime is passed over for the next.
+	for start := earliest; start.Before(earliest.Add(autoSlotSearch)); start = start.Add(PickupSlotLength) {
+		if counts[start.Unix()] >= s.capacity {
+			continue
+		}
+		err := s.reserve(ctx, start, insert)
+		if !errors.Is(err, ErrPickupSlotFull) {
+			return start, err
+		}
+	}
+	return time.Time{}, ErrNoPickupSlot
+}
+
+// Release gives back the place a pickup order promised slot took, once the
+// order is deleted.
+func (s *PickupScheduler) Release(ctx context.Context, slot time.Time) error {
+	return s.store.ReleasePickup(ctx, slotStart(slot))
+}
+
+func (s *PickupScheduler) reserve(ctx context.Context, slot time.Time, insert func(ctx context.Context, slot time.Time) error) error {
+	return s.store.ReservePickup(ctx, slot, s.capacity, func(ctx context.Context) error {
+		return insert(ctx, slot)
+	})
+}
+
+// earliest is the soonest an order placed at now can be ready.
+func (s *PickupScheduler) earliest(now time.Time) time.Time {
+	return now.Add(s.leadTime)
+}
+
+// slotStart returns the start of the slot t falls in.
+func slotStart(t time.Time) time.Time {
+	return t.Truncate(PickupSlotLength)
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// MemoryPickupSlotStore counts pickups among the orders of a
+// MemoryTableStore.
+type MemoryPickupSlotStore struct {
+	tables *MemoryTableStore
+
+	// booking keeps two orders from taking the last place in a slot. The
+	// memory backend only ever runs in one process.
+	booking sync.Mutex
+}
+
+func NewMemoryPickupSlotStore(tables *MemoryTableStore) *MemoryPickupSlotStore {
+	return &MemoryPickupSlotStore{tables: tables}
+}
+
+func (s *MemoryPickupSlotStore) CountPickups(ctx context.Context, from time.Time, to time.Time) (map[int64]int, error) {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	var promised []time.Time
+	for _, order := range s.tables.orders {
+		if OrderType(order) == OrderTypePickup && order.Promised_At != nil {
+			promised = append(promised, *order.Promised_At)
+		}
+	}
+	return countSlots(promised, from, to), nil
+}
+
+func (s *MemoryPickupSlotStore) ReservePickup(ctx context.Context, slot time.Time, capacity int, insert func(ctx context.Context) error) error {
+	s.booking.Lock()
+	defer s.booking.Unlock()
+
+	counts, err := s.CountPickups(ctx, slot, slot.Add(PickupSlotLength))
+	if err != nil {
+		return err
+	}
+	if counts[slot.Unix()] >= capacity {
+		return ErrPickupSlotFull
+	}
+	return insert(ctx)
+}
+
+// ReleasePickup has nothing to give back, since the memory store counts the
+// orders themselves.
+func (s *MemoryPickupSlotStore) ReleasePickup(ctx context.Context, slot time.Time) error {
+	return nil
+}
+
+// MongoPickupSlotStore counts the places taken in each slot in pickup_slots,
+// so instances booking the same slot at once can't promise it more orders
+// than it has room for.
+type MongoPickupSlotStore struct {
+	client               *mongo.Client
+	orderCollection      *mongo.Collection
+	pickupSlotCollection *mongo.Collection
+}
+
+// pickupSlotDocument counts the places taken in the slot starting at Slot.
+// It is removed once the slot is over.
+type pickupSlotDocument struct {
+	Slot       time.Time `bson:"_id"`
+	Count      int       `bson:"count"`
+	Expires_At time.Time `bson:"expires_at"`
+}
+
+func NewMongoPickupSlotStore(client *mongo.Client) *MongoPickupSlotStore {
+	return &MongoPickupSlotStore{
+		client:               client,
+		orderCollection:      database.OpenCollection(client, "order"),
+		pickupSlotCollection: database.OpenCollection(client, "pickup_slots"),
+	}
+}
+
+func (s *MongoPickupSlotStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "order_type", Value: 1}, {Key: "promised_at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pickupSlotCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *MongoPickupSlotStore) CountPickups(ctx context.Context, from time.Time, to time.Time) (map[int64]int, error) {
+	filter := bson.M{"order_type": OrderTypePickup, "promised_at": bson.M{"$gte": from, "$lt": to}}
+	opts := options.Find().SetProjection(bson.M{"promised_at": 1})
+
+	results, err := s.orderCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []models.Order
+	if err := results.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	var promised []time.Time
+	for _, order := range orders {
+		if order.Promised_At != nil {
+			promised = append(promised, *order.Promised_At)
+		}
+	}
+	return countSlots(promised, from, to), nil
+}
+
+// ReservePickup takes the place with a $inc that only matches while the
+// slot has room, in the transaction insert runs in, so the place is given
+// back if the order isn't inserted.
+func (s *MongoPickupSlotStore) ReservePickup(ctx context.Context, slot time.Time, capacity int, insert func(ctx context.Context) error) error {
+	if err := s.startCounting(ctx, slot); err != nil {
+		return err
+	}
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	txnOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		filter := bson.M{"_id": slot, "count": bson.M{"$lt": capacity}}
+		result, err := s.pickupSlotCollection.UpdateOne(sessCtx, filter, bson.M{"$inc": bson.M{"count": 1}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrPickupSlotFull
+		}
+		return nil, insert(sessCtx)
+	}, txnOpts)
+
+	return err
+}
+
+// startCounting counts the pickup orders already promised slot the first
+// time it is booked, for orders placed before slots were counted. Every
+// order after that takes its place through ReservePickup.
+func (s *MongoPickupSlotStore) startCounting(ctx context.Context, slot time.Time) error {
+	err := s.pickupSlotCollection.FindOne(ctx, bson.M{"_id": slot}).Err()
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	counts, err := s.CountPickups(ctx, slot, slot.Add(PickupSlotLength))
+	if err != nil {
+		return err
+	}
+
+	// Another instance counting at the same time found the same orders.
+	_, err = s.pickupSlotCollection.InsertOne(ctx, pickupSlotDocument{Slot: slot, Count: counts[slot.Unix()], Expires_At: slot.Add(PickupSlotLength)})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *MongoPickupSlotStore) ReleasePickup(ctx context.Context, slot time.Time) error {
+	filter := bson.M{"_id": slot, "count": bson.M{"$gt": 0}}
+	_, err := s.pickupSlotCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": -1}})
+	return err
+}
+
+// countSlots counts the promised times from from until to by the slot they
+// fall in.
+func countSlots(promised []time.Time, from time.Time, to time.Time) map[int64]int {
+	counts := map[int64]int{}
+	for _, at := range promised {
+		if !at.Before(from) && at.Before(to) {
+			counts[slotStart(at).Unix()]++
+		}
+	}
+	return counts
+}
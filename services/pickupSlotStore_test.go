@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// newTestPickups returns a scheduler taking two orders a slot, and an insert
+// for it that puts a pickup order for the slot on guest.
+func newTestPickups() (*PickupScheduler, func(orderId string) func(ctx context.Context, slot time.Time) error) {
+	tables := NewMemoryTableStore()
+	guest := NewMemoryGuestOrderStore(tables)
+	scheduler := NewPickupScheduler(NewMemoryPickupSlotStore(tables), 2, 0)
+
+	insert := func(orderId string) func(ctx context.Context, slot time.Time) error {
+		return func(ctx context.Context, slot time.Time) error {
+			orderType := OrderTypePickup
+			return guest.InsertOrder(ctx, models.Order{Order_id: orderId, Order_type: &orderType, Promised_At: &slot})
+		}
+	}
+	return scheduler, insert
+}
+
+func TestPickupBookConcurrent(t *testing.T) {
+	scheduler, insert := newTestPickups()
+	requested := time.Now().Add(2 * time.Hour)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = scheduler.Book(context.Background(), &requested, insert("o"+strconv.Itoa(i)))
+		}(i)
+	}
+	wg.Wait()
+
+	booked := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			booked++
+		case !errors.Is(err, ErrPickupSlotFull):
+			t.Errorf("Book: %v, want it booked or %v", err, ErrPickupSlotFull)
+		}
+	}
+	if booked != 2 {
+		t.Errorf("booked %d orders into a slot for 2", booked)
+	}
+}
+
+func TestPickupBookFirstFreeSlot(t *testing.T) {
+	scheduler, insert := newTestPickups()
+	ctx := context.Background()
+
+	var slots []time.Time
+	for i := 0; i < 3; i++ {
+		slot, err := scheduler.Book(ctx, nil, insert("o"+strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("Book: %v", err)
+		}
+		slots = append(slots, slot)
+	}
+
+	if !slots[0].Equal(slots[1]) || !slots[2].Equal(slots[0].Add(PickupSlotLength)) {
+		t.Errorf("booked %v, want two in the first slot and the third in the next", slots)
+	}
+
+	// An insert that fails doesn't keep the place.
+	failed := errors.New("insert failed")
+	requested := slots[2]
+	if _, err := scheduler.Book(ctx, &requested, func(ctx context.Context, slot time.Time) error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("Book: %v, want %v", err, failed)
+	}
+	if _, err := scheduler.Book(ctx, &requested, insert("o3")); err != nil {
+		t.Errorf("Book after a failed insert: %v, want the place still free", err)
+	}
+}
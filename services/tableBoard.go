@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+const (
+	TableStatusAvailable       = "AVAILABLE"
+	TableStatusSeated          = "SEATED"
+	TableStatusOrdering        = "ORDERING"
+	TableStatusAwaitingPayment = "AWAITING_PAYMENT"
+	TableStatusDirty           = "DIRTY"
+	TableStatusReserved        = "RESERVED"
+)
+
+// TableStatuses lists every status in the order a table usually goes through
+// them.
+var TableStatuses = []string{
+	TableStatusAvailable,
+	TableStatusReserved,
+	TableStatusSeated,
+	TableStatusOrdering,
+	TableStatusAwaitingPayment,
+	TableStatusDirty,
+}
+
+// occupiedStatuses are the statuses of a table guests are sitting at.
+var occupiedStatuses = []string{TableStatusSeated, TableStatusOrdering, TableStatusAwaitingPayment}
+
+var (
+	ErrTableNotFound      = apperrors.New(apperrors.NotFound, "Table with that table_id not found")
+	ErrTableGroupNotFound = apperrors.New(apperrors.NotFound, "Table group with that group_id not found or already split")
+	ErrTableGrouped       = apperrors.New(apperrors.Conflict, "One of the tables is already part of a group")
+	ErrTableOccupied      = apperrors.New(apperrors.Conflict, "The table is not free to seat guests at")
+	ErrOrderNotOpen       = apperrors.New(apperrors.Conflict, "Only open orders can be moved or transferred")
+	ErrOrderChanged       = apperrors.New(apperrors.Conflict, "The order was changed by someone else, try again")
+)
+
+// TableStatusChange is the data of a table.status_changed event.
+type TableStatusChange struct {
+	Table_id   string    `json:"table_id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Reason     string    `json:"reason"`
+	Changed_At time.Time `json:"changed_at"`
+}
+
+type TableStore interface {
+	// SetStatus moves the table to status if its current status is one of
+	// from, or if from is empty and the status differs, recording the status
+	// it had and why it changed. It returns the status the table had and
+	// whether it changed.
+	SetStatus(ctx context.Context, tableId string, status string, from []string, reason string, at time.Time) (string, bool, error)
+	// FindTables returns the tables with the given ids that exist.
+	FindTables(ctx context.Context, tableIds []string) ([]models.Table, error)
+	// GroupTables returns the ids of the tables that share a status with
+	// tableId: the tables of its group, or only tableId when it has none.
+	GroupTables(ctx context.Context, tableId string) ([]string, error)
+	FindOrder(ctx context.Context, orderId string) (models.Order, error)
+	CountOpenOrders(ctx context.Context, tableId string) (int64, error)
+	// CreateGroup puts the group's tables in it and stores it. It returns
+	// ErrTableGrouped, and changes nothing, if any of them already is in one.
+	CreateGroup(ctx context.Context, group models.TableGroup) error
+	FindGroup(ctx context.Context, groupId string) (models.TableGroup, error)
+	ActiveGroups(ctx context.Context) ([]models.TableGroup, error)
+	// SplitGroup takes the tables out of an active group and marks it split.
+	SplitGroup(ctx context.Context, groupId string, at time.Time, by *models.Actor) (models.TableGroup, error)
+	// MoveOrder moves an open order from one table to another. It returns
+	// ErrOrderChanged if the order is no longer open at fromTableId.
+	MoveOrder(ctx context.Context, orderId string, fromTableId string, toTableId string, at time.Time, by *models.Actor) error
+	TransferOrder(ctx context.Context, orderId string, serverId string, at time.Time, by *models.Actor) error
+	InsertAudit(ctx context.Context, audit models.TableAudit) error
+	// FindAudit returns the newest entries involving tableId or orderId.
+	FindAudit(ctx context.Context, tableId string, orderId string) ([]models.TableAudit, error)
+}
+
+// TableBoard keeps table statuses in step with what happens at the table.
+// Guests being seated, reservations and bussing are set by staff; ordering
+// moves the table on by itself, and checkout leaves it to be bussed as part
+// of paying. Tables that are grouped move together.
+type TableBoard struct {
+	store TableStore
+	bus   *events.Bus
+}
+
+func NewTableBoard(store TableStore) *TableBoard {
+	return &TableBoard{store: store, bus: events.Default}
+}
+
+// Subscribe makes the board follow order and invoice events on bus and
+// publish its status changes there.
+func (b *TableBoard) Subscribe(bus *events.Bus) {
+	b.bus = bus
+	bus.Subscribe(b.handle)
+}
+
+// SetStatus changes a table's status by hand.
+func (b *TableBoard) SetStatus(ctx context.Context, tableId string, status string, reason string) (bool, error) {
+	return b.transition(ctx, tableId, status, nil, reason)
+}
+
+// Seat marks a free or reserved table as seated.
+func (b *TableBoard) Seat(ctx context.Context, tableId string, reason string) error {
+	changed, err := b.transition(ctx, tableId, TableStatusSeated, []string{TableStatusAvailable, TableStatusReserved}, reason)
+	if err != nil || changed {
+		return err
+	}
+
+	tables, err := b.store.FindTables(ctx, []string{tableId})
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return ErrTableNotFound
+	}
+	return ErrTableOccupied
+}
+
+func (b *TableBoard) handle(ctx context.Context, event events.Event) {
+	var err error
+
+	switch data := event.Data.(type) {
+	case models.Order:
+		if event.Type == events.OrderCreated && data.Table_id != nil {
+			_, err = b.transition(ctx, *data.Table_id, TableStatusOrdering, []string{TableStatusAvailable, TableStatusReserved, TableStatusSeated}, string(event.Type))
+		}
+	case models.OrderItem:
+		// Guests who order more after asking for the bill are back to
+		// ordering.
+		if event.Type == events.OrderItemFired && data.Order_id != nil {
+			err = b.transitionOrder(ctx, *data.Order_id, TableStatusOrdering, []string{TableStatusSeated, TableStatusAwaitingPayment}, event)
+		}
+	case models.Invoice:
+		if event.Type == events.InvoiceCreated {
+			err = b.transitionOrder(ctx, data.Order_id, TableStatusAwaitingPayment, []string{TableStatusSeated, TableStatusOrdering}, event)
+		}
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Error("could not update table status", "event", string(event.Type), "subject", event.Subject, "error", err)
+	}
+}
+
+func (b *TableBoard) transitionOrder(ctx context.Context, orderId string, status string, from []string, event events.Event) error {
+	order, err := b.store.FindOrder(ctx, orderId)
+	if errors.Is(err, ErrOrderNotFound) {
+		return nil
+	}
+	if err != nil || order.Table_id == nil {
+		return err
+	}
+
+	_, err = b.transition(ctx, *order.Table_id, status, from, string(event.Type))
+	return err
+}
+
+// transition moves tableId, and every table grouped with it, to status.
+func (b *TableBoard) transition(ctx context.Context, tableId string, status string, from []string, reason string) (bool, error) {
+	tableIds, err := b.store.GroupTables(ctx, tableId)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	for _, id := range tableIds {
+		ok, err := b.setStatus(ctx, id, status, from, reason)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || ok
+	}
+
+	return changed, nil
+}
+
+func (b *TableBoard) setStatus(ctx context.Context, tableId string, status string, from []string, reason string) (bool, error) {
+	now := time.Now()
+
+	previous, changed, err := b.store.SetStatus(ctx, tableId, status, from, reason, now)
+	if err != nil || !changed {
+		return changed, err
+	}
+
+	b.bus.Publish(ctx, events.Event{
+		Type:        events.TableStatusChanged,
+		Subject:     tableId,
+		Occurred_At: now,
+		Data:        TableStatusChange{Table_id: tableId, From: previous, To: status, Reason: reason, Changed_At: now},
+	})
+	return true, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func tableStatus(table models.Table) string {
+	if table.Table_status == nil {
+		return ""
+	}
+	return *table.Table_status
+}
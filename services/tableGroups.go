@@ -0,0 +1,329 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TableAuditMerge         = "MERGE"
+	TableAuditSplit         = "SPLIT"
+	TableAuditMoveOrder     = "MOVE_ORDER"
+	TableAuditTransferOrder = "TRANSFER_ORDER"
+)
+
+type MergeTablesRequest struct {
+	Table_ids        []string      `json:"table_ids" validate:"required,min=2,max=20,unique,dive,required"`
+	Primary_table_id *string       `json:"primary_table_id"`
+	Reason           *string       `json:"reason" validate:"omitempty,max=200"`
+	Actor            *models.Actor `json:"-"`
+}
+
+type SplitTablesRequest struct {
+	Group_id string        `json:"-"`
+	Reason   *string       `json:"reason" validate:"omitempty,max=200"`
+	Actor    *models.Actor `json:"-"`
+}
+
+type MoveOrderRequest struct {
+	Order_id string        `json:"-"`
+	Table_id *string       `json:"table_id" validate:"required"`
+	Reason   *string       `json:"reason" validate:"omitempty,max=200"`
+	Actor    *models.Actor `json:"-"`
+}
+
+type TransferOrderRequest struct {
+	Order_id  string        `json:"-"`
+	Server_id *string       `json:"server_id" validate:"required"`
+	Reason    *string       `json:"reason" validate:"omitempty,max=200"`
+	Actor     *models.Actor `json:"-"`
+}
+
+// Merge groups tables for a large party. The tables take on the status of
+// the primary table, which is the first one unless another is named.
+func (b *TableBoard) Merge(ctx context.Context, req MergeTablesRequest) (models.TableGroup, error) {
+	primaryId := req.Table_ids[0]
+	if req.Primary_table_id != nil {
+		primaryId = *req.Primary_table_id
+	}
+
+	if !contains(req.Table_ids, primaryId) {
+		return models.TableGroup{}, &apperrors.Error{
+			Code:    apperrors.ValidationFailed,
+			Message: "The request body is not valid",
+			Fields:  []apperrors.FieldError{{Field: "primary_table_id", Rule: "oneof", Message: "must be one of table_ids"}},
+		}
+	}
+
+	tables, err := b.store.FindTables(ctx, req.Table_ids)
+	if err != nil {
+		return models.TableGroup{}, err
+	}
+	if len(tables) != len(req.Table_ids) {
+		return models.TableGroup{}, ErrTableNotFound
+	}
+
+	now := time.Now()
+	group := models.TableGroup{
+		ID:               primitive.NewObjectID(),
+		Table_ids:        req.Table_ids,
+		Primary_table_id: primaryId,
+		Created_At:       now,
+		Created_By:       req.Actor,
+	}
+	group.Group_id = group.ID.Hex()
+
+	var status string
+	for _, table := range tables {
+		if table.Group_id != nil {
+			return models.TableGroup{}, ErrTableGrouped
+		}
+
+		group.Capacity += tableCapacity(table)
+
+		if table.Table_id == primaryId {
+			status = tableStatus(table)
+		}
+	}
+
+	if err := b.store.CreateGroup(ctx, group); err != nil {
+		return models.TableGroup{}, err
+	}
+
+	if status != "" {
+		if _, err := b.transition(ctx, primaryId, status, nil, "table.merged"); err != nil {
+			return group, err
+		}
+	}
+
+	return group, b.audit(ctx, models.TableAudit{
+		Action:    TableAuditMerge,
+		Table_ids: group.Table_ids,
+		Group_id:  &group.Group_id,
+		Reason:    req.Reason,
+		Actor:     req.Actor,
+	})
+}
+
+// Split breaks a group up again. The primary table keeps its status. The
+// others are left to be bussed if the party used them and has no order open
+// there.
+func (b *TableBoard) Split(ctx context.Context, req SplitTablesRequest) (models.TableGroup, error) {
+	group, err := b.store.SplitGroup(ctx, req.Group_id, time.Now(), req.Actor)
+	if err != nil {
+		return group, err
+	}
+
+	tables, err := b.store.FindTables(ctx, group.Table_ids)
+	if err != nil {
+		return group, err
+	}
+
+	for _, table := range tables {
+		if table.Table_id == group.Primary_table_id || !contains(occupiedStatuses, tableStatus(table)) {
+			continue
+		}
+
+		open, err := b.store.CountOpenOrders(ctx, table.Table_id)
+		if err != nil {
+			return group, err
+		}
+
+		if open == 0 {
+			if _, err := b.setStatus(ctx, table.Table_id, TableStatusDirty, occupiedStatuses, "table.split"); err != nil {
+				return group, err
+			}
+		}
+	}
+
+	return group, b.audit(ctx, models.TableAudit{
+		Action:    TableAuditSplit,
+		Table_ids: group.Table_ids,
+		Group_id:  &group.Group_id,
+		Reason:    req.Reason,
+		Actor:     req.Actor,
+	})
+}
+
+// MoveOrder moves an open order to another table, for guests who change
+// tables. The new table takes over the old one's status and the old one is
+// left to be bussed once it has no open orders.
+func (b *TableBoard) MoveOrder(ctx context.Context, req MoveOrderRequest) (models.Order, error) {
+	order, err := b.store.FindOrder(ctx, req.Order_id)
+	if err != nil {
+		return order, err
+	}
+
+	if order.Order_status == nil || *order.Order_status != OrderStatusOpen {
+		return order, ErrOrderNotOpen
+	}
+
+	if OrderType(order) != OrderTypeDineIn {
+		return order, apperrors.New(apperrors.Conflict, "Only dine-in orders can be moved to a table")
+	}
+
+	toId := *req.Table_id
+	var fromId string
+	if order.Table_id != nil {
+		fromId = *order.Table_id
+	}
+
+	if fromId == toId {
+		return order, apperrors.New(apperrors.BadRequest, "The order is already at that table")
+	}
+
+	tables, err := b.store.FindTables(ctx, []string{fromId, toId})
+	if err != nil {
+		return order, err
+	}
+
+	var from, to *models.Table
+	for i := range tables {
+		switch tables[i].Table_id {
+		case fromId:
+			from = &tables[i]
+		case toId:
+			to = &tables[i]
+		}
+	}
+
+	if to == nil {
+		return order, ErrTableNotFound
+	}
+
+	// Moving within a group changes nothing on the board.
+	sameGroup := from != nil && from.Group_id != nil && to.Group_id != nil && *from.Group_id == *to.Group_id
+
+	if !sameGroup {
+		if !contains([]string{TableStatusAvailable, TableStatusReserved, TableStatusSeated}, tableStatus(*to)) {
+			return order, ErrTableOccupied
+		}
+
+		open, err := b.store.CountOpenOrders(ctx, toId)
+		if err != nil {
+			return order, err
+		}
+		if open > 0 {
+			return order, ErrTableOccupied
+		}
+	}
+
+	now := time.Now()
+	if err := b.store.MoveOrder(ctx, order.Order_id, fromId, toId, now, req.Actor); err != nil {
+		return order, err
+	}
+
+	if !sameGroup {
+		status := TableStatusSeated
+		if from != nil && contains(occupiedStatuses, tableStatus(*from)) {
+			status = tableStatus(*from)
+		}
+
+		if _, err := b.transition(ctx, toId, status, nil, "order.moved"); err != nil {
+			return order, err
+		}
+
+		if from != nil {
+			open, err := b.store.CountOpenOrders(ctx, fromId)
+			if err != nil {
+				return order, err
+			}
+
+			if open == 0 {
+				if _, err := b.transition(ctx, fromId, TableStatusDirty, occupiedStatuses, "order.moved"); err != nil {
+					return order, err
+				}
+			}
+		}
+	}
+
+	err = b.audit(ctx, models.TableAudit{
+		Action:    TableAuditMoveOrder,
+		Table_ids: nonEmpty(fromId, toId),
+		Order_id:  &order.Order_id,
+		From:      order.Table_id,
+		To:        &toId,
+		Reason:    req.Reason,
+		Actor:     req.Actor,
+	})
+	if err != nil {
+		return order, err
+	}
+
+	return b.store.FindOrder(ctx, order.Order_id)
+}
+
+// TransferOrder hands an open order over to another server, e.g. at a shift
+// change.
+func (b *TableBoard) TransferOrder(ctx context.Context, req TransferOrderRequest) (models.Order, error) {
+	order, err := b.store.FindOrder(ctx, req.Order_id)
+	if err != nil {
+		return order, err
+	}
+
+	if order.Order_status == nil || *order.Order_status != OrderStatusOpen {
+		return order, ErrOrderNotOpen
+	}
+
+	if err := b.store.TransferOrder(ctx, order.Order_id, *req.Server_id, time.Now(), req.Actor); err != nil {
+		return order, err
+	}
+
+	var tableIds []string
+	if order.Table_id != nil {
+		tableIds = nonEmpty(*order.Table_id)
+	}
+
+	err = b.audit(ctx, models.TableAudit{
+		Action:    TableAuditTransferOrder,
+		Table_ids: tableIds,
+		Order_id:  &order.Order_id,
+		From:      order.Server_id,
+		To:        req.Server_id,
+		Reason:    req.Reason,
+		Actor:     req.Actor,
+	})
+	if err != nil {
+		return order, err
+	}
+
+	return b.store.FindOrder(ctx, order.Order_id)
+}
+
+func (b *TableBoard) Group(ctx context.Context, groupId string) (models.TableGroup, error) {
+	return b.store.FindGroup(ctx, groupId)
+}
+
+func (b *TableBoard) ActiveGroups(ctx context.Context) ([]models.TableGroup, error) {
+	return b.store.ActiveGroups(ctx)
+}
+
+// Audit returns the merges, splits, moves and transfers involving a table or
+// an order, newest first.
+func (b *TableBoard) Audit(ctx context.Context, tableId string, orderId string) ([]models.TableAudit, error) {
+	return b.store.FindAudit(ctx, tableId, orderId)
+}
+
+func (b *TableBoard) audit(ctx context.Context, audit models.TableAudit) error {
+	audit.ID = primitive.NewObjectID()
+	audit.Audit_id = audit.ID.Hex()
+	audit.Created_At = time.Now()
+	if audit.Table_ids == nil {
+		audit.Table_ids = []string{}
+	}
+	return b.store.InsertAudit(ctx, audit)
+}
+
+func nonEmpty(ids ...string) []string {
+	var result []string
+	for _, id := range ids {
+		if id != "" {
+			result = append(result, id)
+		}
+	}
+	return result
+}
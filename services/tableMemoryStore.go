@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// MemoryTableStore keeps tables, table groups and the audit trail in
+// process, for the memory backend.
+type MemoryTableStore struct {
+	mu     sync.Mutex
+	tables map[string]models.Table
+	orders map[string]models.Order
+	groups map[string]models.TableGroup
+	audit  []models.TableAudit
+}
+
+func NewMemoryTableStore() *MemoryTableStore {
+	return &MemoryTableStore{
+		tables: map[string]models.Table{},
+		orders: map[string]models.Order{},
+		groups: map[string]models.TableGroup{},
+	}
+}
+
+func (s *MemoryTableStore) SaveTable(table models.Table) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[table.Table_id] = table
+}
+
+func (s *MemoryTableStore) SaveOrder(order models.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[order.Order_id] = order
+}
+
+func (s *MemoryTableStore) Table(tableId string) (models.Table, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	table, ok := s.tables[tableId]
+	return table, ok
+}
+
+func (s *MemoryTableStore) SetStatus(ctx context.Context, tableId string, status string, from []string, reason string, at time.Time) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	table, ok := s.tables[tableId]
+	if !ok {
+		return "", false, nil
+	}
+
+	previous, changed := setStatus(&table, status, from, reason, at)
+	if changed {
+		s.tables[tableId] = table
+	}
+	return previous, changed, nil
+}
+
+// setStatus is SetStatus for a table already read.
+func setStatus(table *models.Table, status string, from []string, reason string, at time.Time) (string, bool) {
+	previous := tableStatus(*table)
+	if previous == status || (len(from) > 0 && !contains(from, previous)) {
+		return previous, false
+	}
+
+	table.Table_status = &status
+	table.Previous_Status = &previous
+	table.Status_Reason = &reason
+	table.Status_Changed_At = &at
+	table.Updated_At = at
+	table.Version++
+	return previous, true
+}
+
+func (s *MemoryTableStore) FindTables(ctx context.Context, tableIds []string) ([]models.Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tables []models.Table
+	for _, id := range tableIds {
+		if table, ok := s.tables[id]; ok {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+func (s *MemoryTableStore) GroupTables(ctx context.Context, tableId string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	table, ok := s.tables[tableId]
+	if !ok || table.Group_id == nil {
+		return []string{tableId}, nil
+	}
+	return s.groups[*table.Group_id].Table_ids, nil
+}
+
+func (s *MemoryTableStore) FindOrder(ctx context.Context, orderId string) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderId]
+	if !ok {
+		return order, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+func (s *MemoryTableStore) CountOpenOrders(ctx context.Context, tableId string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, order := range s.orders {
+		if order.Table_id != nil && *order.Table_id == tableId && order.Order_status != nil && *order.Order_status == OrderStatusOpen {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryTableStore) CreateGroup(ctx context.Context, group models.TableGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range group.Table_ids {
+		if table, ok := s.tables[id]; !ok || table.Group_id != nil {
+			return ErrTableGrouped
+		}
+	}
+
+	for _, id := range group.Table_ids {
+		table := s.tables[id]
+		groupId := group.Group_id
+		table.Group_id = &groupId
+		table.Version++
+		s.tables[id] = table
+	}
+
+	s.groups[group.Group_id] = group
+	return nil
+}
+
+func (s *MemoryTableStore) FindGroup(ctx context.Context, groupId string) (models.TableGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[groupId]
+	if !ok {
+		return group, ErrTableGroupNotFound
+	}
+	return group, nil
+}
+
+func (s *MemoryTableStore) ActiveGroups(ctx context.Context) ([]models.TableGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := []models.TableGroup{}
+	for _, group := range s.groups {
+		if group.Split_At == nil {
+			groups = append(groups, group)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Created_At.Before(groups[j].Created_At) })
+	return groups, nil
+}
+
+func (s *MemoryTableStore) SplitGroup(ctx context.Context, groupId string, at time.Time, by *models.Actor) (models.TableGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[groupId]
+	if !ok || group.Split_At != nil {
+		return group, ErrTableGroupNotFound
+	}
+
+	group.Split_At = &at
+	group.Split_By = by
+	s.groups[groupId] = group
+
+	for _, id := range group.Table_ids {
+		if table, ok := s.tables[id]; ok {
+			table.Group_id = nil
+			table.Version++
+			s.tables[id] = table
+		}
+	}
+
+	return group, nil
+}
+
+func (s *MemoryTableStore) MoveOrder(ctx context.Context, orderId string, fromTableId string, toTableId string, at time.Time, by *models.Actor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderId]
+	if !ok || order.Order_status == nil || *order.Order_status != OrderStatusOpen || (order.Table_id != nil && *order.Table_id != fromTableId) {
+		return ErrOrderChanged
+	}
+
+	order.Table_id = &toTableId
+	order.Updated_At = at
+	order.Updated_By = by
+	order.Version++
+	s.orders[orderId] = order
+	return nil
+}
+
+func (s *MemoryTableStore) TransferOrder(ctx context.Context, orderId string, serverId string, at time.Time, by *models.Actor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderId]
+	if !ok || order.Order_status == nil || *order.Order_status != OrderStatusOpen {
+		return ErrOrderChanged
+	}
+
+	order.Server_id = &serverId
+	order.Updated_At = at
+	order.Updated_By = by
+	order.Version++
+	s.orders[orderId] = order
+	return nil
+}
+
+func (s *MemoryTableStore) InsertAudit(ctx context.Context, audit models.TableAudit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, audit)
+	return nil
+}
+
+func (s *MemoryTableStore) FindAudit(ctx context.Context, tableId string, orderId string) ([]models.TableAudit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []models.TableAudit{}
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < auditLimit; i-- {
+		entry := s.audit[i]
+		if (tableId != "" && contains(entry.Table_ids, tableId)) || (orderId != "" && entry.Order_id != nil && *entry.Order_id == orderId) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
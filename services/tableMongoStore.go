@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditLimit caps how many audit entries are returned for one table or order.
+const auditLimit = 200
+
+type MongoTableStore struct {
+	tableCollection *mongo.Collection
+	orderCollection *mongo.Collection
+	groupCollection *mongo.Collection
+	auditCollection *mongo.Collection
+}
+
+func NewMongoTableStore(client *mongo.Client) *MongoTableStore {
+	return &MongoTableStore{
+		tableCollection: database.OpenCollection(client, "table"),
+		orderCollection: database.OpenCollection(client, "order"),
+		groupCollection: database.OpenCollection(client, "table_groups"),
+		auditCollection: database.OpenCollection(client, "table_audit"),
+	}
+}
+
+func (s *MongoTableStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.groupCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "group_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.auditCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "table_ids", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Migrate moves tables still using the old OPEN and CLOSED statuses to the
+// current ones. A CLOSED table had just been paid for, so it needs bussing.
+func (s *MongoTableStore) Migrate(ctx context.Context) error {
+	renames := []struct {
+		filter bson.M
+		status string
+	}{
+		{bson.M{"table_status": bson.M{"$in": bson.A{"OPEN", nil}}}, TableStatusAvailable},
+		{bson.M{"table_status": "CLOSED"}, TableStatusDirty},
+	}
+
+	for _, rename := range renames {
+		update := bson.M{"$set": bson.M{"table_status": rename.status}}
+		if _, err := s.tableCollection.UpdateMany(ctx, rename.filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStatus updates the table with a pipeline so the status it had is
+// copied into previous_status by the same write, where change streams see
+// it.
+func (s *MongoTableStore) SetStatus(ctx context.Context, tableId string, status string, from []string, reason string, at time.Time) (string, bool, error) {
+	filter := bson.M{"table_id": tableId, "table_status": bson.M{"$ne": status}}
+	if len(from) > 0 {
+		filter["table_status"] = bson.M{"$in": from}
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "previous_status", Value: bson.M{"$ifNull": bson.A{"$table_status", TableStatusAvailable}}},
+			{Key: "table_status", Value: status},
+			{Key: "status_reason", Value: reason},
+			{Key: "status_changed_at", Value: at},
+			{Key: "updated_at", Value: at},
+			{Key: "version", Value: bson.M{"$add": bson.A{"$version", 1}}},
+		}}},
+	}
+
+	var before models.Table
+	err := s.tableCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return tableStatus(before), true, nil
+}
+
+func (s *MongoTableStore) FindTables(ctx context.Context, tableIds []string) ([]models.Table, error) {
+	results, err := s.tableCollection.Find(ctx, bson.M{"table_id": bson.M{"$in": tableIds}})
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []models.Table
+	if err := results.All(ctx, &tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+func (s *MongoTableStore) GroupTables(ctx context.Context, tableId string) ([]string, error) {
+	var table models.Table
+
+	opts := options.FindOne().SetProjection(bson.M{"group_id": 1})
+	err := s.tableCollection.FindOne(ctx, bson.M{"table_id": tableId}, opts).Decode(&table)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return []string{tableId}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if table.Group_id == nil {
+		return []string{tableId}, nil
+	}
+
+	group, err := s.FindGroup(ctx, *table.Group_id)
+	if err != nil {
+		return nil, err
+	}
+	return group.Table_ids, nil
+}
+
+func (s *MongoTableStore) FindOrder(ctx context.Context, orderId string) (models.Order, error) {
+	var order models.Order
+
+	err := s.orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return order, ErrOrderNotFound
+	}
+	return order, err
+}
+
+func (s *MongoTableStore) CountOpenOrders(ctx context.Context, tableId string) (int64, error) {
+	return s.orderCollection.CountDocuments(ctx, bson.M{"table_id": tableId, "order_status": OrderStatusOpen})
+}
+
+func (s *MongoTableStore) CreateGroup(ctx context.Context, group models.TableGroup) error {
+	// Only tables that aren't grouped yet are claimed. If another merge got
+	// to one of them first, the tables claimed here are let go again.
+	filter := bson.M{"table_id": bson.M{"$in": group.Table_ids}, "group_id": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "group_id", Value: group.Group_id}}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+
+	result, err := s.tableCollection.UpdateMany(ctx, filter, update)
+	if err == nil && result.ModifiedCount != int64(len(group.Table_ids)) {
+		err = ErrTableGrouped
+	}
+	if err == nil {
+		_, err = s.groupCollection.InsertOne(ctx, group)
+	}
+
+	if err != nil {
+		s.releaseTables(ctx, group.Group_id)
+	}
+	return err
+}
+
+func (s *MongoTableStore) releaseTables(ctx context.Context, groupId string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "group_id", Value: nil}}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+	_, err := s.tableCollection.UpdateMany(ctx, bson.M{"group_id": groupId}, update)
+	return err
+}
+
+func (s *MongoTableStore) FindGroup(ctx context.Context, groupId string) (models.TableGroup, error) {
+	var group models.TableGroup
+
+	err := s.groupCollection.FindOne(ctx, bson.M{"group_id": groupId}).Decode(&group)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return group, ErrTableGroupNotFound
+	}
+	return group, err
+}
+
+func (s *MongoTableStore) ActiveGroups(ctx context.Context) ([]models.TableGroup, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	results, err := s.groupCollection.Find(ctx, bson.M{"split_at": nil}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []models.TableGroup{}
+	if err := results.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (s *MongoTableStore) SplitGroup(ctx context.Context, groupId string, at time.Time, by *models.Actor) (models.TableGroup, error) {
+	filter := bson.M{"group_id": groupId, "split_at": nil}
+	update := bson.M{"$set": bson.M{"split_at": at, "split_by": by}}
+
+	var group models.TableGroup
+	err := s.groupCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&group)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return group, ErrTableGroupNotFound
+	}
+	if err != nil {
+		return group, err
+	}
+
+	return group, s.releaseTables(ctx, groupId)
+}
+
+func (s *MongoTableStore) MoveOrder(ctx context.Context, orderId string, fromTableId string, toTableId string, at time.Time, by *models.Actor) error {
+	filter := bson.M{"order_id": orderId, "order_status": OrderStatusOpen, "table_id": fromTableId}
+	if fromTableId == "" {
+		filter["table_id"] = nil
+	}
+
+	return s.updateOpenOrder(ctx, filter, bson.E{Key: "table_id", Value: toTableId}, at, by)
+}
+
+func (s *MongoTableStore) TransferOrder(ctx context.Context, orderId string, serverId string, at time.Time, by *models.Actor) error {
+	filter := bson.M{"order_id": orderId, "order_status": OrderStatusOpen}
+	return s.updateOpenOrder(ctx, filter, bson.E{Key: "server_id", Value: serverId}, at, by)
+}
+
+func (s *MongoTableStore) updateOpenOrder(ctx context.Context, filter bson.M, change bson.E, at time.Time, by *models.Actor) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		change,
+		{Key: "updated_at", Value: at},
+		{Key: "updated_by", Value: by},
+	}}, {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+
+	result, err := s.orderCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrOrderChanged
+	}
+	return nil
+}
+
+func (s *MongoTableStore) InsertAudit(ctx context.Context, audit models.TableAudit) error {
+	_, err := s.auditCollection.InsertOne(ctx, audit)
+	return err
+}
+
+func (s *MongoTableStore) FindAudit(ctx context.Context, tableId string, orderId string) ([]models.TableAudit, error) {
+	var or bson.A
+	if tableId != "" {
+		or = append(or, bson.M{"table_ids": tableId})
+	}
+	if orderId != "" {
+		or = append(or, bson.M{"order_id": orderId})
+	}
+
+	entries := []models.TableAudit{}
+	if len(or) == 0 {
+		return entries, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(auditLimit)
+	results, err := s.auditCollection.Find(ctx, bson.M{"$or": or}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := results.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
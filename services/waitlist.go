@@ -0,0 +1,433 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/models"
+	"github.com/teandresmith/restaurant-project/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WaitlistStatusWaiting  = "WAITING"
+	WaitlistStatusNotified = "NOTIFIED"
+	WaitlistStatusSeated   = "SEATED"
+	WaitlistStatusRemoved  = "REMOVED"
+)
+
+const (
+	// defaultTurnTime is assumed until there are enough paid orders to go by.
+	defaultTurnTime = 60 * time.Minute
+	// turnTimeWindow is how far back paid orders are used for turn times.
+	turnTimeWindow = 28 * 24 * time.Hour
+	minTurnSamples = 10
+	// Orders open for less or longer than this are left out of turn times:
+	// takeaways paid straight away and orders nobody closed are not meals.
+	minTurnTime = 10 * time.Minute
+	maxTurnTime = 6 * time.Hour
+	// turnTimeTTL is how long a computed turn time is reused for.
+	turnTimeTTL = 5 * time.Minute
+	// busTime is how long it takes to clear and reset a table.
+	busTime = 5 * time.Minute
+	// quoteStep is what quotes are rounded up to.
+	quoteStep = 5 * time.Minute
+)
+
+var (
+	ErrWaitlistEntryNotFound = apperrors.New(apperrors.NotFound, "Waitlist entry with that entry_id not found")
+	ErrWaitlistEntryClosed   = apperrors.New(apperrors.Conflict, "The party has already been seated or removed")
+	ErrWaitlistNoContact     = apperrors.New(apperrors.BadRequest, "The party left no phone number or email to notify")
+)
+
+// activeWaitlistStatuses are the statuses of parties still waiting.
+var activeWaitlistStatuses = []string{WaitlistStatusWaiting, WaitlistStatusNotified}
+
+// WaitlistChange is what an action does to a waitlist entry.
+type WaitlistChange struct {
+	Status        string
+	At            time.Time
+	By            *models.Actor
+	Notified      bool
+	Table_id      *string
+	Remove_Reason *string
+}
+
+type WaitlistStore interface {
+	Insert(ctx context.Context, entry models.WaitlistEntry) error
+	Find(ctx context.Context, entryId string) (models.WaitlistEntry, error)
+	// Active returns the parties still waiting, longest waiting first.
+	Active(ctx context.Context) ([]models.WaitlistEntry, error)
+	// Update applies change if the entry's status is one of from and returns
+	// the entry after the change.
+	Update(ctx context.Context, entryId string, from []string, change WaitlistChange) (models.WaitlistEntry, error)
+	Tables(ctx context.Context) ([]models.Table, error)
+	OpenOrders(ctx context.Context) ([]models.Order, error)
+	// TurnTimes returns how long each dine-in order paid since since was
+	// open.
+	TurnTimes(ctx context.Context, since time.Time) ([]time.Duration, error)
+}
+
+// WaitQuote is the estimated wait for a party.
+type WaitQuote struct {
+	Party_size    int `json:"party_size"`
+	Minutes       int `json:"minutes"`
+	Parties_Ahead int `json:"parties_ahead"`
+	Tables        int `json:"tables"`
+	Turn_Time     int `json:"turn_time"`
+}
+
+// WaitingParty is a waitlist entry with its place in the queue and how much
+// longer it is expected to wait.
+type WaitingParty struct {
+	models.WaitlistEntry
+	Position       int `json:"position"`
+	Estimated_Wait int `json:"estimated_wait"`
+}
+
+type RemoveFromWaitlistRequest struct {
+	Reason *string `json:"reason" validate:"omitempty,eq=CANCELLED|eq=LEFT|eq=NO_SHOW"`
+}
+
+type SeatWaitlistRequest struct {
+	Table_id *string `json:"table_id"`
+}
+
+// Waitlist keeps the queue of walk-in parties and quotes their wait from how
+// full the floor is and how long tables have been taking to turn.
+type Waitlist struct {
+	store      WaitlistStore
+	board      *TableBoard
+	notify     notifier.Notifier
+	restaurant string
+
+	mu         sync.Mutex
+	turnTime   time.Duration
+	turnTimeAt time.Time
+}
+
+func NewWaitlist(store WaitlistStore, board *TableBoard, notify notifier.Notifier, restaurant string) *Waitlist {
+	return &Waitlist{store: store, board: board, notify: notify, restaurant: restaurant}
+}
+
+// Quote estimates the wait for a party that joins the list now.
+func (w *Waitlist) Quote(ctx context.Context, partySize int) (WaitQuote, error) {
+	active, err := w.store.Active(ctx)
+	if err != nil {
+		return WaitQuote{}, err
+	}
+
+	floor, err := w.floor(ctx)
+	if err != nil {
+		return WaitQuote{}, err
+	}
+
+	return floor.estimate(partySize, active), nil
+}
+
+// Add puts a party on the list and quotes its wait.
+func (w *Waitlist) Add(ctx context.Context, entry models.WaitlistEntry) (models.WaitlistEntry, error) {
+	quote, err := w.Quote(ctx, *entry.Party_size)
+	if err != nil {
+		return entry, err
+	}
+
+	now := time.Now()
+	entry.ID = primitive.NewObjectID()
+	entry.Entry_id = entry.ID.Hex()
+	entry.Status = WaitlistStatusWaiting
+	entry.Quoted_Wait = quote.Minutes
+	entry.Quoted_At = now
+	entry.Created_At = now
+	entry.Updated_At = now
+	entry.Version = 1
+
+	return entry, w.store.Insert(ctx, entry)
+}
+
+func (w *Waitlist) Find(ctx context.Context, entryId string) (models.WaitlistEntry, error) {
+	return w.store.Find(ctx, entryId)
+}
+
+// Active returns the parties still waiting in the order they'll be seated,
+// each with a fresh estimate of its wait.
+func (w *Waitlist) Active(ctx context.Context) ([]WaitingParty, error) {
+	active, err := w.store.Active(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	floor, err := w.floor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parties := make([]WaitingParty, 0, len(active))
+	for i, entry := range active {
+		quote := floor.estimate(*entry.Party_size, active[:i])
+		parties = append(parties, WaitingParty{WaitlistEntry: entry, Position: i + 1, Estimated_Wait: quote.Minutes})
+	}
+	return parties, nil
+}
+
+// Notify tells the party their table is ready, by text if they left a phone
+// number and by email otherwise. It can be repeated as a reminder.
+func (w *Waitlist) Notify(ctx context.Context, entryId string, by *models.Actor) (models.WaitlistEntry, error) {
+	entry, err := w.store.Find(ctx, entryId)
+	if err != nil {
+		return entry, err
+	}
+
+	if !contains(activeWaitlistStatuses, entry.Status) {
+		return entry, ErrWaitlistEntryClosed
+	}
+
+	notification := notifier.Notification{
+		Subject: "Your table is ready",
+		Body:    fmt.Sprintf("Hi %s, your table for %d at %s is ready. Please come to the host stand.", *entry.Party_name, *entry.Party_size, w.restaurant),
+	}
+
+	switch {
+	case entry.Phone != nil && *entry.Phone != "":
+		notification.Channel, notification.To = notifier.ChannelSMS, *entry.Phone
+	case entry.Email != nil && *entry.Email != "":
+		notification.Channel, notification.To = notifier.ChannelEmail, *entry.Email
+	default:
+		return entry, ErrWaitlistNoContact
+	}
+
+	if err := w.notify.Notify(ctx, notification); err != nil {
+		return entry, apperrors.Wrap(apperrors.Internal, "The party could not be notified", err)
+	}
+
+	return w.store.Update(ctx, entryId, activeWaitlistStatuses, WaitlistChange{
+		Status:   WaitlistStatusNotified,
+		At:       time.Now(),
+		By:       by,
+		Notified: true,
+	})
+}
+
+// Seat takes the party off the list. When a table is given it is marked
+// seated, which it can only be while free.
+func (w *Waitlist) Seat(ctx context.Context, entryId string, tableId *string, by *models.Actor) (models.WaitlistEntry, error) {
+	entry, err := w.store.Find(ctx, entryId)
+	if err != nil {
+		return entry, err
+	}
+
+	if !contains(activeWaitlistStatuses, entry.Status) {
+		return entry, ErrWaitlistEntryClosed
+	}
+
+	if tableId != nil {
+		if err := w.board.Seat(ctx, *tableId, "waitlist.seated"); err != nil {
+			return entry, err
+		}
+	}
+
+	return w.store.Update(ctx, entryId, activeWaitlistStatuses, WaitlistChange{
+		Status:   WaitlistStatusSeated,
+		At:       time.Now(),
+		By:       by,
+		Table_id: tableId,
+	})
+}
+
+// Remove takes a party that left or didn't show up off the list.
+func (w *Waitlist) Remove(ctx context.Context, entryId string, reason *string, by *models.Actor) (models.WaitlistEntry, error) {
+	return w.store.Update(ctx, entryId, activeWaitlistStatuses, WaitlistChange{
+		Status:        WaitlistStatusRemoved,
+		At:            time.Now(),
+		By:            by,
+		Remove_Reason: reason,
+	})
+}
+
+// turnTimeNow returns the median time tables have been taking to turn, from
+// when their order was opened to when it was paid.
+func (w *Waitlist) turnTimeNow(ctx context.Context, now time.Time) (time.Duration, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.turnTimeAt.IsZero() && now.Sub(w.turnTimeAt) < turnTimeTTL {
+		return w.turnTime, nil
+	}
+
+	durations, err := w.store.TurnTimes(ctx, now.Add(-turnTimeWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	var meals []time.Duration
+	for _, duration := range durations {
+		if duration >= minTurnTime && duration <= maxTurnTime {
+			meals = append(meals, duration)
+		}
+	}
+
+	w.turnTime = defaultTurnTime
+	if len(meals) >= minTurnSamples {
+		sort.Slice(meals, func(i, j int) bool { return meals[i] < meals[j] })
+		w.turnTime = meals[len(meals)/2]
+	}
+	w.turnTimeAt = now
+
+	return w.turnTime, nil
+}
+
+// floorState is what a quote is worked out from.
+type floorState struct {
+	now       time.Time
+	turnTime  time.Duration
+	tables    []models.Table
+	openSince map[string]time.Time
+}
+
+func (w *Waitlist) floor(ctx context.Context) (floorState, error) {
+	now := time.Now()
+
+	turnTime, err := w.turnTimeNow(ctx, now)
+	if err != nil {
+		return floorState{}, err
+	}
+
+	tables, err := w.store.Tables(ctx)
+	if err != nil {
+		return floorState{}, err
+	}
+
+	orders, err := w.store.OpenOrders(ctx)
+	if err != nil {
+		return floorState{}, err
+	}
+
+	openSince := map[string]time.Time{}
+	for _, order := range orders {
+		if order.Table_id == nil {
+			continue
+		}
+		if since, ok := openSince[*order.Table_id]; !ok || order.Created_At.Before(since) {
+			openSince[*order.Table_id] = order.Created_At
+		}
+	}
+
+	return floorState{now: now, turnTime: turnTime, tables: tables, openSince: openSince}, nil
+}
+
+// tableSlot is a table and when it is expected to be ready.
+type tableSlot struct {
+	seats  int
+	freeAt time.Time
+}
+
+// estimate works out when each table that fits the party will be free, then
+// hands them out in turn to the parties ahead that fit them too. The party
+// gets the first table left over. A party too big for any one table waits
+// until enough tables are free to push together.
+func (f floorState) estimate(partySize int, ahead []models.WaitlistEntry) WaitQuote {
+	quote := WaitQuote{Party_size: partySize, Turn_Time: int(f.turnTime / time.Minute)}
+
+	var fits, all []tableSlot
+	largest := 0
+	for _, table := range f.tables {
+		status := tableStatus(table)
+		if status == TableStatusReserved {
+			continue
+		}
+
+		slot := tableSlot{seats: tableCapacity(table), freeAt: f.freeAt(table, status)}
+		all = append(all, slot)
+
+		if slot.seats >= partySize {
+			fits = append(fits, slot)
+			if slot.seats > largest {
+				largest = slot.seats
+			}
+		}
+	}
+
+	merging := len(fits) == 0
+	if merging {
+		fits = all
+		largest = partySize
+	}
+
+	quote.Tables = len(fits)
+	for _, entry := range ahead {
+		if entry.Party_size != nil && *entry.Party_size <= largest {
+			quote.Parties_Ahead++
+		}
+	}
+
+	if len(fits) == 0 {
+		quote.Minutes = roundUpMinutes(f.turnTime * time.Duration(quote.Parties_Ahead+1))
+		return quote
+	}
+
+	sort.Slice(fits, func(i, j int) bool { return fits[i].freeAt.Before(fits[j].freeAt) })
+
+	// Tables go to the parties ahead in the order they free up. Once every
+	// table has been handed out, each one turns again.
+	var readyAt time.Time
+	seats := 0
+	for next := quote.Parties_Ahead; next < quote.Parties_Ahead+len(fits); next++ {
+		slot := fits[next%len(fits)]
+		readyAt = slot.freeAt.Add(time.Duration(next/len(fits)) * (f.turnTime + busTime))
+
+		seats += slot.seats
+		if !merging || seats >= partySize {
+			break
+		}
+	}
+
+	quote.Minutes = roundUpMinutes(readyAt.Sub(f.now))
+	return quote
+}
+
+// freeAt estimates when a table will be ready for the next party.
+func (f floorState) freeAt(table models.Table, status string) time.Time {
+	switch status {
+	case TableStatusAvailable, "":
+		return f.now
+	case TableStatusDirty:
+		return f.now.Add(busTime)
+	}
+
+	since, ok := f.openSince[table.Table_id]
+	if !ok && table.Status_Changed_At != nil {
+		since = *table.Status_Changed_At
+	} else if !ok {
+		since = f.now
+	}
+
+	// Guests who have outstayed the usual turn time are still expected to
+	// take a few more minutes.
+	leaving := since.Add(f.turnTime)
+	if earliest := f.now.Add(busTime); leaving.Before(earliest) {
+		leaving = earliest
+	}
+	return leaving.Add(busTime)
+}
+
+func tableCapacity(table models.Table) int {
+	switch {
+	case table.Seats != nil:
+		return *table.Seats
+	case table.Number_of_guests != nil:
+		return *table.Number_of_guests
+	}
+	return 0
+}
+
+func roundUpMinutes(wait time.Duration) int {
+	if wait <= 0 {
+		return 0
+	}
+	steps := (wait + quoteStep - 1) / quoteStep
+	return int(steps * quoteStep / time.Minute)
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryWaitlistStore keeps the waitlist in process and reads the floor from
+// a MemoryTableStore.
+type MemoryWaitlistStore struct {
+	mu      sync.Mutex
+	entries map[string]models.WaitlistEntry
+	tables  *MemoryTableStore
+}
+
+func NewMemoryWaitlistStore(tables *MemoryTableStore) *MemoryWaitlistStore {
+	return &MemoryWaitlistStore{entries: map[string]models.WaitlistEntry{}, tables: tables}
+}
+
+func (s *MemoryWaitlistStore) Insert(ctx context.Context, entry models.WaitlistEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.Entry_id] = entry
+	return nil
+}
+
+func (s *MemoryWaitlistStore) Find(ctx context.Context, entryId string) (models.WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[entryId]
+	if !ok {
+		return entry, ErrWaitlistEntryNotFound
+	}
+	return entry, nil
+}
+
+func (s *MemoryWaitlistStore) Active(ctx context.Context) ([]models.WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var active []models.WaitlistEntry
+	for _, entry := range s.entries {
+		if contains(activeWaitlistStatuses, entry.Status) {
+			active = append(active, entry)
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool { return active[i].Created_At.Before(active[j].Created_At) })
+	return active, nil
+}
+
+func (s *MemoryWaitlistStore) Update(ctx context.Context, entryId string, from []string, change WaitlistChange) (models.WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[entryId]
+	if !ok {
+		return entry, ErrWaitlistEntryNotFound
+	}
+	if !contains(from, entry.Status) {
+		return entry, ErrWaitlistEntryClosed
+	}
+
+	entry.Status = change.Status
+	entry.Updated_At = change.At
+	entry.Updated_By = change.By
+	entry.Version++
+
+	switch {
+	case change.Notified:
+		entry.Notified_At = &change.At
+		entry.Notify_Count++
+	case change.Status == WaitlistStatusSeated:
+		entry.Seated_At = &change.At
+		entry.Table_id = change.Table_id
+	case change.Status == WaitlistStatusRemoved:
+		entry.Removed_At = &change.At
+		entry.Remove_Reason = change.Remove_Reason
+	}
+
+	s.entries[entryId] = entry
+	return entry, nil
+}
+
+func (s *MemoryWaitlistStore) Tables(ctx context.Context) ([]models.Table, error) {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	tables := make([]models.Table, 0, len(s.tables.tables))
+	for _, table := range s.tables.tables {
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func (s *MemoryWaitlistStore) OpenOrders(ctx context.Context) ([]models.Order, error) {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	var orders []models.Order
+	for _, order := range s.tables.orders {
+		if order.Order_status != nil && *order.Order_status == OrderStatusOpen {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (s *MemoryWaitlistStore) TurnTimes(ctx context.Context, since time.Time) ([]time.Duration, error) {
+	s.tables.mu.Lock()
+	defer s.tables.mu.Unlock()
+
+	var durations []time.Duration
+	for _, order := range s.tables.orders {
+		if OrderType(order) == OrderTypeDineIn && order.Paid_At != nil && !order.Paid_At.Before(since) {
+			durations = append(durations, order.Paid_At.Sub(order.Created_At))
+		}
+	}
+	return durations, nil
+}
+
+type MongoWaitlistStore struct {
+	waitlistCollection *mongo.Collection
+	tableCollection    *mongo.Collection
+	orderCollection    *mongo.Collection
+}
+
+func NewMongoWaitlistStore(client *mongo.Client) *MongoWaitlistStore {
+	return &MongoWaitlistStore{
+		waitlistCollection: database.OpenCollection(client, "waitlist"),
+		tableCollection:    database.OpenCollection(client, "table"),
+		orderCollection:    database.OpenCollection(client, "order"),
+	}
+}
+
+func (s *MongoWaitlistStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.waitlistCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entry_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Turn times are read from recently paid orders.
+	_, err = s.orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "order_status", Value: 1}, {Key: "paid_at", Value: 1}},
+	})
+	return err
+}
+
+func (s *MongoWaitlistStore) Insert(ctx context.Context, entry models.WaitlistEntry) error {
+	_, err := s.waitlistCollection.InsertOne(ctx, entry)
+	return err
+}
+
+func (s *MongoWaitlistStore) Find(ctx context.Context, entryId string) (models.WaitlistEntry, error) {
+	var entry models.WaitlistEntry
+
+	err := s.waitlistCollection.FindOne(ctx, bson.M{"entry_id": entryId}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return entry, ErrWaitlistEntryNotFound
+	}
+	return entry, err
+}
+
+func (s *MongoWaitlistStore) Active(ctx context.Context) ([]models.WaitlistEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	results, err := s.waitlistCollection.Find(ctx, bson.M{"status": bson.M{"$in": activeWaitlistStatuses}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var active []models.WaitlistEntry
+	if err := results.All(ctx, &active); err != nil {
+		return nil, err
+	}
+	return active, nil
+}
+
+func (s *MongoWaitlistStore) Update(ctx context.Context, entryId string, from []string, change WaitlistChange) (models.WaitlistEntry, error) {
+	set := bson.D{
+		{Key: "status", Value: change.Status},
+		{Key: "updated_at", Value: change.At},
+		{Key: "updated_by", Value: change.By},
+	}
+	inc := bson.D{{Key: "version", Value: 1}}
+
+	switch {
+	case change.Notified:
+		set = append(set, bson.E{Key: "notified_at", Value: change.At})
+		inc = append(inc, bson.E{Key: "notify_count", Value: 1})
+	case change.Status == WaitlistStatusSeated:
+		set = append(set, bson.E{Key: "seated_at", Value: change.At}, bson.E{Key: "table_id", Value: change.Table_id})
+	case change.Status == WaitlistStatusRemoved:
+		set = append(set, bson.E{Key: "removed_at", Value: change.At}, bson.E{Key: "remove_reason", Value: change.Remove_Reason})
+	}
+	update := bson.D{{Key: "$set", Value: set}, {Key: "$inc", Value: inc}}
+
+	filter := bson.M{"entry_id": entryId, "status": bson.M{"$in": from}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var entry models.WaitlistEntry
+	err := s.waitlistCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.Find(ctx, entryId); err != nil {
+			return entry, err
+		}
+		return entry, ErrWaitlistEntryClosed
+	}
+	return entry, err
+}
+
+func (s *MongoWaitlistStore) Tables(ctx context.Context) ([]models.Table, error) {
+	results, err := s.tableCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []models.Table
+	if err := results.All(ctx, &tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+func (s *MongoWaitlistStore) OpenOrders(ctx context.Context) ([]models.Order, error) {
+	opts := options.Find().SetProjection(bson.M{"order_id": 1, "table_id": 1, "created_at": 1})
+	results, err := s.orderCollection.Find(ctx, bson.M{"order_status": OrderStatusOpen}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []models.Order
+	if err := results.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (s *MongoWaitlistStore) TurnTimes(ctx context.Context, since time.Time) ([]time.Duration, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"order_status": OrderStatusPaid,
+			"paid_at":      bson.M{"$gte": since},
+			// Orders from before there were types are dine-in.
+			"order_type": bson.M{"$in": bson.A{nil, OrderTypeDineIn}},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "millis": bson.M{"$subtract": bson.A{"$paid_at", "$created_at"}}}}},
+	}
+
+	results, err := s.orderCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Millis int64 `bson:"millis"`
+	}
+	if err := results.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	durations := make([]time.Duration, 0, len(rows))
+	for _, row := range rows {
+		durations = append(durations, time.Duration(row.Millis)*time.Millisecond)
+	}
+	return durations, nil
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+func testTable(tableId string, seats int, status string) models.Table {
+	return models.Table{Table_id: tableId, Seats: &seats, Table_status: &status}
+}
+
+func testParty(entryId string, size int, joined time.Time) models.WaitlistEntry {
+	return models.WaitlistEntry{Entry_id: entryId, Party_size: &size, Status: WaitlistStatusWaiting, Created_At: joined}
+}
+
+func TestWaitlistEstimate(t *testing.T) {
+	now := fixedTime
+	changed := now.Add(-50 * time.Minute)
+	ordering := testTable("t-changed", 4, TableStatusOrdering)
+	ordering.Status_Changed_At = &changed
+
+	tests := []struct {
+		name      string
+		tables    []models.Table
+		openSince map[string]time.Duration
+		partySize int
+		ahead     []int
+		want      WaitQuote
+	}{
+		{
+			name:      "free table",
+			tables:    []models.Table{testTable("t1", 4, TableStatusAvailable)},
+			partySize: 2,
+			want:      WaitQuote{Minutes: 0, Tables: 1},
+		},
+		{
+			name:      "table being cleared",
+			tables:    []models.Table{testTable("t1", 4, TableStatusDirty)},
+			partySize: 2,
+			want:      WaitQuote{Minutes: 5, Tables: 1},
+		},
+		{
+			// Open 30 minutes into a 60 minute turn, then bussed.
+			name:      "occupied table",
+			tables:    []models.Table{testTable("t1", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t1": 30 * time.Minute},
+			partySize: 2,
+			want:      WaitQuote{Minutes: 35, Tables: 1},
+		},
+		{
+			name:      "rounded up",
+			tables:    []models.Table{testTable("t1", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t1": 28 * time.Minute},
+			partySize: 2,
+			want:      WaitQuote{Minutes: 40, Tables: 1},
+		},
+		{
+			name:      "guests who outstayed the turn time",
+			tables:    []models.Table{testTable("t1", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t1": 90 * time.Minute},
+			partySize: 2,
+			want:      WaitQuote{Minutes: 10, Tables: 1},
+		},
+		{
+			name:      "occupied without an open order",
+			tables:    []models.Table{ordering},
+			partySize: 2,
+			want:      WaitQuote{Minutes: 15, Tables: 1},
+		},
+		{
+			name:      "party ahead takes the first table",
+			tables:    []models.Table{testTable("t1", 4, TableStatusAvailable), testTable("t2", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t2": 30 * time.Minute},
+			partySize: 2,
+			ahead:     []int{2},
+			want:      WaitQuote{Minutes: 35, Parties_Ahead: 1, Tables: 2},
+		},
+		{
+			// The third party gets the free table after it has turned once.
+			name:      "every table handed out",
+			tables:    []models.Table{testTable("t1", 4, TableStatusAvailable), testTable("t2", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t2": 30 * time.Minute},
+			partySize: 2,
+			ahead:     []int{2, 3},
+			want:      WaitQuote{Minutes: 65, Parties_Ahead: 2, Tables: 2},
+		},
+		{
+			name:      "party ahead too big for the tables",
+			tables:    []models.Table{testTable("t1", 4, TableStatusAvailable)},
+			partySize: 2,
+			ahead:     []int{6},
+			want:      WaitQuote{Minutes: 0, Tables: 1},
+		},
+		{
+			name:      "tables too small for the party",
+			tables:    []models.Table{testTable("t1", 2, TableStatusAvailable), testTable("t2", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t2": 30 * time.Minute},
+			partySize: 4,
+			want:      WaitQuote{Minutes: 35, Tables: 1},
+		},
+		{
+			name:      "reserved tables",
+			tables:    []models.Table{testTable("t1", 4, TableStatusReserved), testTable("t2", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t2": 30 * time.Minute},
+			partySize: 2,
+			want:      WaitQuote{Minutes: 35, Tables: 1},
+		},
+		{
+			// No one table seats eight, so it waits for two to push together.
+			name:      "party bigger than any table",
+			tables:    []models.Table{testTable("t1", 4, TableStatusAvailable), testTable("t2", 4, TableStatusSeated)},
+			openSince: map[string]time.Duration{"t2": 30 * time.Minute},
+			partySize: 8,
+			want:      WaitQuote{Minutes: 35, Tables: 2},
+		},
+		{
+			name:      "no tables",
+			partySize: 2,
+			ahead:     []int{2},
+			want:      WaitQuote{Minutes: 120, Parties_Ahead: 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			floor := floorState{now: now, turnTime: time.Hour, tables: test.tables, openSince: map[string]time.Time{}}
+			for tableId, open := range test.openSince {
+				floor.openSince[tableId] = now.Add(-open)
+			}
+
+			var ahead []models.WaitlistEntry
+			for i, size := range test.ahead {
+				ahead = append(ahead, testParty(fmt.Sprint("e", i), size, now))
+			}
+
+			test.want.Party_size, test.want.Turn_Time = test.partySize, 60
+			if got := floor.estimate(test.partySize, ahead); got != test.want {
+				t.Errorf("estimate = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWaitlistTurnTime(t *testing.T) {
+	tables := NewMemoryTableStore()
+	waitlist := NewWaitlist(NewMemoryWaitlistStore(tables), NewTableBoard(tables), nil, "Test")
+	ctx := context.Background()
+
+	paid := func(orderId string, open time.Duration) {
+		paidAt := fixedTime
+		tables.SaveOrder(models.Order{Order_id: orderId, Created_At: fixedTime.Add(-open), Paid_At: &paidAt})
+	}
+
+	// Takeaways paid straight away and orders left open all night aren't
+	// meals, and nine meals aren't enough to go by.
+	paid("quick", 2*time.Minute)
+	paid("forgotten", 8*time.Hour)
+	for i := 0; i < 9; i++ {
+		paid(fmt.Sprint("o", i), time.Duration(30+10*i)*time.Minute)
+	}
+	if turnTime, err := waitlist.turnTimeNow(ctx, fixedTime); err != nil || turnTime != defaultTurnTime {
+		t.Fatalf("turn time from nine meals = %v, %v, want the default %v", turnTime, err, defaultTurnTime)
+	}
+
+	// A tenth meal is only seen once the cached turn time has expired.
+	paid("o9", 120*time.Minute)
+	if turnTime, _ := waitlist.turnTimeNow(ctx, fixedTime.Add(time.Minute)); turnTime != defaultTurnTime {
+		t.Errorf("turn time within its TTL = %v, want the cached %v", turnTime, defaultTurnTime)
+	}
+	if turnTime, _ := waitlist.turnTimeNow(ctx, fixedTime.Add(turnTimeTTL)); turnTime != 80*time.Minute {
+		t.Errorf("turn time from 30 to 120 minute meals = %v, want the median 80m", turnTime)
+	}
+}
+
+func TestWaitlistSeatingOrder(t *testing.T) {
+	tables := NewMemoryTableStore()
+	store := NewMemoryWaitlistStore(tables)
+	waitlist := NewWaitlist(store, NewTableBoard(tables), nil, "Test")
+	ctx := context.Background()
+	now := time.Now()
+
+	// A four-top half way through its meal and a free two-top.
+	tableId, open := "t1", OrderStatusOpen
+	tables.SaveTable(testTable("t1", 4, TableStatusSeated))
+	tables.SaveTable(testTable("t2", 2, TableStatusAvailable))
+	tables.SaveOrder(models.Order{Order_id: "o1", Table_id: &tableId, Order_status: &open, Created_At: now.Add(-30 * time.Minute)})
+
+	// Inserted out of order: the list goes by when each party joined.
+	for _, party := range []models.WaitlistEntry{
+		testParty("c", 2, now.Add(-5*time.Minute)),
+		testParty("a", 4, now.Add(-20*time.Minute)),
+		testParty("b", 2, now.Add(-10*time.Minute)),
+	} {
+		if err := store.Insert(ctx, party); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := func(want string) {
+		t.Helper()
+
+		parties, err := waitlist.Active(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, party := range parties {
+			got += fmt.Sprintf("%d:%s:%d ", party.Position, party.Entry_id, party.Estimated_Wait)
+		}
+		if got != want {
+			t.Errorf("waiting parties (position:entry:wait) = %q, want %q", got, want)
+		}
+	}
+
+	// Quotes hand the tables out in the order they free up: a is counted
+	// against the two-top, so b is quoted the four-top and c the two-top's
+	// next turn.
+	expect("1:a:35 2:b:35 3:c:65 ")
+
+	party, err := waitlist.Add(ctx, models.WaitlistEntry{Party_size: intPtr(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if party.Quoted_Wait != 100 {
+		t.Errorf("quoted a new party of 2 %d minutes, want 100 behind three parties", party.Quoted_Wait)
+	}
+	if _, err := waitlist.Remove(ctx, party.Entry_id, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The four-top isn't free for a yet.
+	twoTop := "t2"
+	if _, err := waitlist.Seat(ctx, "a", &tableId, nil); !errors.Is(err, ErrTableOccupied) {
+		t.Fatalf("Seat at an occupied table: %v, want %v", err, ErrTableOccupied)
+	}
+	if _, err := waitlist.Seat(ctx, "b", &twoTop, nil); err != nil {
+		t.Fatalf("Seat: %v", err)
+	}
+
+	// b is off the list and the two-top has just started its turn.
+	expect("1:a:35 2:c:65 ")
+
+	if _, err := waitlist.Seat(ctx, "b", nil, nil); !errors.Is(err, ErrWaitlistEntryClosed) {
+		t.Errorf("seating b twice: %v, want %v", err, ErrWaitlistEntryClosed)
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
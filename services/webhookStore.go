@@ -0,0 +1,489 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/database"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	// outboxRetention is how long dispatched events stay in the outbox.
+	outboxRetention = 7 * 24 * time.Hour
+	// deliveredRetention is how long delivered webhooks are kept, so they can
+	// still be looked up and redelivered. Dead ones are kept until redelivered.
+	deliveredRetention = 30 * 24 * time.Hour
+)
+
+// MemoryWebhookStore keeps subscriptions, the outbox and deliveries in
+// process, so they don't survive a restart.
+type MemoryWebhookStore struct {
+	mu            sync.Mutex
+	subscriptions map[string]models.WebhookSubscription
+	outbox        map[string]models.OutboxEvent
+	deliveries    map[string]models.WebhookDelivery
+}
+
+func NewMemoryWebhookStore() *MemoryWebhookStore {
+	return &MemoryWebhookStore{
+		subscriptions: map[string]models.WebhookSubscription{},
+		outbox:        map[string]models.OutboxEvent{},
+		deliveries:    map[string]models.WebhookDelivery{},
+	}
+}
+
+func (s *MemoryWebhookStore) InsertSubscription(ctx context.Context, subscription models.WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[subscription.Subscription_id] = subscription
+	return nil
+}
+
+func (s *MemoryWebhookStore) FindSubscription(ctx context.Context, subscriptionId string) (models.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionId]
+	if !ok {
+		return subscription, ErrWebhookSubscriptionNotFound
+	}
+	return subscription, nil
+}
+
+func (s *MemoryWebhookStore) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subscriptions []models.WebhookSubscription
+	for _, subscription := range s.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].Created_At.Before(subscriptions[j].Created_At) })
+	return subscriptions, nil
+}
+
+func (s *MemoryWebhookStore) ReplaceSubscription(ctx context.Context, subscription models.WebhookSubscription, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.subscriptions[subscription.Subscription_id]
+	if !ok {
+		return ErrWebhookSubscriptionNotFound
+	}
+	if current.Version != version {
+		return ErrWebhookSubscriptionChanged
+	}
+	s.subscriptions[subscription.Subscription_id] = subscription
+	return nil
+}
+
+func (s *MemoryWebhookStore) DeleteSubscription(ctx context.Context, subscriptionId string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.subscriptions[subscriptionId]
+	if !ok {
+		return ErrWebhookSubscriptionNotFound
+	}
+	if current.Version != version {
+		return ErrWebhookSubscriptionChanged
+	}
+	delete(s.subscriptions, subscriptionId)
+	return nil
+}
+
+func (s *MemoryWebhookStore) Subscribers(ctx context.Context, eventType string) ([]models.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subscriptions []models.WebhookSubscription
+	for _, subscription := range s.subscriptions {
+		if subscription.Active && contains(subscription.Event_Types, eventType) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+func (s *MemoryWebhookStore) RecordEvent(ctx context.Context, event models.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox[event.Event_id] = event
+	return nil
+}
+
+func (s *MemoryWebhookStore) PendingEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []models.OutboxEvent
+	for _, event := range s.outbox {
+		if event.Dispatched_At == nil {
+			pending = append(pending, event)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Occurred_At.Before(pending[j].Occurred_At) })
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+func (s *MemoryWebhookStore) Dispatch(ctx context.Context, eventId string, deliveries []models.WebhookDelivery, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.outbox[eventId]
+	if !ok || event.Dispatched_At != nil {
+		return ErrOutboxDispatched
+	}
+
+	for _, delivery := range deliveries {
+		s.deliveries[delivery.Delivery_id] = delivery
+	}
+
+	// Dispatched events are only needed until they are.
+	delete(s.outbox, eventId)
+	return nil
+}
+
+func (s *MemoryWebhookStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due *models.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		if delivery.Status != WebhookPending || delivery.Next_Attempt_At.After(now) {
+			continue
+		}
+		if due == nil || delivery.Next_Attempt_At.Before(due.Next_Attempt_At) {
+			delivery := delivery
+			due = &delivery
+		}
+	}
+
+	if due == nil {
+		return models.WebhookDelivery{}, ErrNoDueDelivery
+	}
+
+	due.Next_Attempt_At = now.Add(lease)
+	s.deliveries[due.Delivery_id] = *due
+	return *due, nil
+}
+
+func (s *MemoryWebhookStore) SaveDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[delivery.Delivery_id] = delivery
+	return nil
+}
+
+func (s *MemoryWebhookStore) FindDelivery(ctx context.Context, deliveryId string) (models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, ok := s.deliveries[deliveryId]
+	if !ok {
+		return delivery, ErrWebhookDeliveryNotFound
+	}
+	return delivery, nil
+}
+
+func (s *MemoryWebhookStore) ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		if (filter.Subscription_id == "" || delivery.Subscription_id == filter.Subscription_id) && (filter.Status == "" || delivery.Status == filter.Status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Created_At.After(deliveries[j].Created_At) })
+	if len(deliveries) > filter.Limit {
+		deliveries = deliveries[:filter.Limit]
+	}
+	return deliveries, nil
+}
+
+func (s *MemoryWebhookStore) Redeliver(ctx context.Context, deliveryId string, at time.Time) (models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, ok := s.deliveries[deliveryId]
+	if !ok {
+		return delivery, ErrWebhookDeliveryNotFound
+	}
+	if delivery.Status == WebhookPending {
+		return delivery, ErrWebhookDeliveryPending
+	}
+
+	delivery.Status = WebhookPending
+	delivery.Attempts = 0
+	delivery.Next_Attempt_At = at
+	delivery.Delivered_At = nil
+	s.deliveries[deliveryId] = delivery
+	return delivery, nil
+}
+
+// MongoWebhookStore keeps the outbox and deliveries in MongoDB, where they
+// outlive restarts and are shared by every instance. Dispatching an event
+// runs in a multi-document transaction, like MongoCheckoutStore.
+type MongoWebhookStore struct {
+	client                 *mongo.Client
+	subscriptionCollection *mongo.Collection
+	outboxCollection       *mongo.Collection
+	deliveryCollection     *mongo.Collection
+}
+
+func NewMongoWebhookStore(client *mongo.Client) *MongoWebhookStore {
+	return &MongoWebhookStore{
+		client:                 client,
+		subscriptionCollection: database.OpenCollection(client, "webhook_subscriptions"),
+		outboxCollection:       database.OpenCollection(client, "webhook_outbox"),
+		deliveryCollection:     database.OpenCollection(client, "webhook_deliveries"),
+	}
+}
+
+func (s *MongoWebhookStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.subscriptionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "event_types", Value: 1}, {Key: "active", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.outboxCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "dispatched_at", Value: 1}, {Key: "occurred_at", Value: 1}}},
+		{Keys: bson.D{{Key: "dispatched_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(outboxRetention.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.deliveryCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "delivery_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "delivered_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(deliveredRetention.Seconds()))},
+	})
+	return err
+}
+
+func (s *MongoWebhookStore) InsertSubscription(ctx context.Context, subscription models.WebhookSubscription) error {
+	_, err := s.subscriptionCollection.InsertOne(ctx, subscription)
+	return err
+}
+
+func (s *MongoWebhookStore) FindSubscription(ctx context.Context, subscriptionId string) (models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+
+	err := s.subscriptionCollection.FindOne(ctx, bson.M{"subscription_id": subscriptionId}).Decode(&subscription)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return subscription, ErrWebhookSubscriptionNotFound
+	}
+	return subscription, err
+}
+
+func (s *MongoWebhookStore) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return s.findSubscriptions(ctx, bson.M{})
+}
+
+func (s *MongoWebhookStore) ReplaceSubscription(ctx context.Context, subscription models.WebhookSubscription, version int64) error {
+	result, err := s.subscriptionCollection.ReplaceOne(ctx, bson.M{"subscription_id": subscription.Subscription_id, "version": version}, subscription)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return s.mismatch(ctx, subscription.Subscription_id)
+	}
+	return nil
+}
+
+func (s *MongoWebhookStore) DeleteSubscription(ctx context.Context, subscriptionId string, version int64) error {
+	result, err := s.subscriptionCollection.DeleteOne(ctx, bson.M{"subscription_id": subscriptionId, "version": version})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return s.mismatch(ctx, subscriptionId)
+	}
+	return nil
+}
+
+func (s *MongoWebhookStore) Subscribers(ctx context.Context, eventType string) ([]models.WebhookSubscription, error) {
+	return s.findSubscriptions(ctx, bson.M{"event_types": eventType, "active": true})
+}
+
+func (s *MongoWebhookStore) findSubscriptions(ctx context.Context, filter bson.M) ([]models.WebhookSubscription, error) {
+	results, err := s.subscriptionCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []models.WebhookSubscription
+	if err := results.All(ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// mismatch tells a missing subscription apart from a stale version after a
+// write matched nothing.
+func (s *MongoWebhookStore) mismatch(ctx context.Context, subscriptionId string) error {
+	count, err := s.subscriptionCollection.CountDocuments(ctx, bson.M{"subscription_id": subscriptionId})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrWebhookSubscriptionNotFound
+	}
+	return ErrWebhookSubscriptionChanged
+}
+
+// RecordEvent relies on the unique event_id index to record an event that
+// was published again, after a change stream resumed, only once.
+func (s *MongoWebhookStore) RecordEvent(ctx context.Context, event models.OutboxEvent) error {
+	_, err := s.outboxCollection.InsertOne(ctx, event)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *MongoWebhookStore) PendingEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "occurred_at", Value: 1}}).SetLimit(int64(limit))
+
+	results, err := s.outboxCollection.Find(ctx, bson.M{"dispatched_at": nil}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []models.OutboxEvent
+	if err := results.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Dispatch marks the event dispatched first, so an instance that lost the
+// race to another aborts before inserting any deliveries.
+func (s *MongoWebhookStore) Dispatch(ctx context.Context, eventId string, deliveries []models.WebhookDelivery, at time.Time) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	txnOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		result, err := s.outboxCollection.UpdateOne(sessCtx, bson.M{"event_id": eventId, "dispatched_at": nil}, bson.M{"$set": bson.M{"dispatched_at": at}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrOutboxDispatched
+		}
+
+		if len(deliveries) == 0 {
+			return nil, nil
+		}
+
+		documents := make([]interface{}, len(deliveries))
+		for i, delivery := range deliveries {
+			documents[i] = delivery
+		}
+		_, err = s.deliveryCollection.InsertMany(sessCtx, documents)
+		return nil, err
+	}, txnOpts)
+
+	return err
+}
+
+func (s *MongoWebhookStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+
+	filter := bson.M{"status": WebhookPending, "next_attempt_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	err := s.deliveryCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return delivery, ErrNoDueDelivery
+	}
+	return delivery, err
+}
+
+func (s *MongoWebhookStore) SaveDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	_, err := s.deliveryCollection.ReplaceOne(ctx, bson.M{"delivery_id": delivery.Delivery_id}, delivery)
+	return err
+}
+
+func (s *MongoWebhookStore) FindDelivery(ctx context.Context, deliveryId string) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+
+	err := s.deliveryCollection.FindOne(ctx, bson.M{"delivery_id": deliveryId}).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return delivery, ErrWebhookDeliveryNotFound
+	}
+	return delivery, err
+}
+
+func (s *MongoWebhookStore) ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	query := bson.M{}
+	if filter.Subscription_id != "" {
+		query["subscription_id"] = filter.Subscription_id
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(filter.Limit))
+
+	results, err := s.deliveryCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := results.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (s *MongoWebhookStore) Redeliver(ctx context.Context, deliveryId string, at time.Time) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+
+	filter := bson.M{"delivery_id": deliveryId, "status": bson.M{"$ne": WebhookPending}}
+	update := bson.M{"$set": bson.M{"status": WebhookPending, "attempts": 0, "next_attempt_at": at, "delivered_at": nil}}
+
+	err := s.deliveryCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&delivery)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return delivery, err
+	}
+
+	if _, err := s.FindDelivery(ctx, deliveryId); err != nil {
+		return delivery, err
+	}
+	return delivery, ErrWebhookDeliveryPending
+}
@@ -0,0 +1,531 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/apperrors"
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/logging"
+	"github.com/teandresmith/restaurant-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WebhookPending   = "PENDING"
+	WebhookDelivered = "DELIVERED"
+	WebhookDead      = "DEAD"
+)
+
+// WebhookSignatureHeader carries the signature of every delivery, as
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of t.body>" with the subscription's
+// secret.
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it is
+	// dead-lettered.
+	webhookMaxAttempts = 10
+	webhookFirstRetry  = 30 * time.Second
+	webhookMaxRetry    = 6 * time.Hour
+	// webhookLease is how long an instance has to send a delivery it claimed
+	// before another may claim it again.
+	webhookLease   = time.Minute
+	webhookPoll    = 5 * time.Second
+	webhookWorkers = 4
+	outboxBatch    = 100
+	// webhookStalled is how long Run may go without getting round to the
+	// outbox before the instance is reported not ready. Sending one
+	// delivery is bounded by the client timeout, well within it.
+	webhookStalled = 2 * time.Minute
+)
+
+// WebhookEventTypes are the events subscriptions can ask for.
+var WebhookEventTypes = []events.Type{
+	events.OrderCreated,
+	events.OrderItemFired,
+	events.InvoiceCreated,
+	events.InvoicePaid,
+	events.InvoiceRefunded,
+	events.TableStatusChanged,
+	events.MenuUpdated,
+}
+
+var (
+	ErrWebhookSubscriptionNotFound = apperrors.New(apperrors.NotFound, "Webhook subscription with that subscription_id not found")
+	ErrWebhookSubscriptionChanged  = apperrors.New(apperrors.PreconditionFailed, "The webhook subscription was modified after the provided ETag. Fetch it again and retry")
+	ErrWebhookDeliveryNotFound     = apperrors.New(apperrors.NotFound, "Webhook delivery with that delivery_id not found")
+	ErrWebhookDeliveryPending      = apperrors.New(apperrors.Conflict, "The delivery is still being attempted")
+	// ErrOutboxDispatched is returned by WebhookStore.Dispatch when another
+	// instance dispatched the event first.
+	ErrOutboxDispatched = errors.New("outbox event already dispatched")
+	// ErrNoDueDelivery is returned by WebhookStore.ClaimDelivery when no
+	// delivery is due.
+	ErrNoDueDelivery        = errors.New("no webhook delivery is due")
+	errSubscriptionInactive = errors.New("the subscription is inactive")
+)
+
+type WebhookSubscriptionRequest struct {
+	Url         string   `json:"url" validate:"required,url,max=2000"`
+	Secret      string   `json:"secret" validate:"required,min=16,max=200"`
+	Event_Types []string `json:"event_types" validate:"required,min=1,dive,eq=order.created|eq=order_item.fired|eq=invoice.created|eq=invoice.paid|eq=invoice.refunded|eq=table.status_changed|eq=menu.updated"`
+	Active      *bool    `json:"active"`
+}
+
+// WebhookSubscriptionUpdate changes the fields of a subscription that are
+// set.
+type WebhookSubscriptionUpdate struct {
+	Url         *string  `json:"url" validate:"omitempty,url,max=2000"`
+	Secret      *string  `json:"secret" validate:"omitempty,min=16,max=200"`
+	Event_Types []string `json:"event_types" validate:"omitempty,min=1,dive,eq=order.created|eq=order_item.fired|eq=invoice.created|eq=invoice.paid|eq=invoice.refunded|eq=table.status_changed|eq=menu.updated"`
+	Active      *bool    `json:"active"`
+}
+
+type WebhookDeliveryFilter struct {
+	Subscription_id string `form:"subscription_id" json:"subscription_id"`
+	Status          string `form:"status" json:"status" validate:"omitempty,eq=PENDING|eq=DELIVERED|eq=DEAD"`
+	Limit           int    `form:"limit" json:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+type WebhookStore interface {
+	InsertSubscription(ctx context.Context, subscription models.WebhookSubscription) error
+	FindSubscription(ctx context.Context, subscriptionId string) (models.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	// ReplaceSubscription overwrites the subscription if it is still at
+	// version.
+	ReplaceSubscription(ctx context.Context, subscription models.WebhookSubscription, version int64) error
+	// DeleteSubscription removes the subscription if it is still at version.
+	DeleteSubscription(ctx context.Context, subscriptionId string, version int64) error
+	// Subscribers returns the active subscriptions to eventType.
+	Subscribers(ctx context.Context, eventType string) ([]models.WebhookSubscription, error)
+	// RecordEvent adds an event to the outbox, unless an event with its id
+	// already was.
+	RecordEvent(ctx context.Context, event models.OutboxEvent) error
+	// PendingEvents returns the oldest outbox events not yet dispatched.
+	PendingEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error)
+	// Dispatch inserts the deliveries of an outbox event and marks the event
+	// dispatched, together. It returns ErrOutboxDispatched, and inserts
+	// nothing, if the event already was.
+	Dispatch(ctx context.Context, eventId string, deliveries []models.WebhookDelivery, at time.Time) error
+	// ClaimDelivery takes the pending delivery that has been due longest,
+	// pushing its next attempt back by lease so no one else sends it
+	// meanwhile. It returns ErrNoDueDelivery when none is due.
+	ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (models.WebhookDelivery, error)
+	SaveDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	FindDelivery(ctx context.Context, deliveryId string) (models.WebhookDelivery, error)
+	// ListDeliveries returns the newest deliveries matching filter.
+	ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error)
+	// Redeliver sets a delivered or dead delivery pending again, due at. It
+	// returns ErrWebhookDeliveryPending if it still is pending.
+	Redeliver(ctx context.Context, deliveryId string, at time.Time) (models.WebhookDelivery, error)
+}
+
+// webhookPayload is the body every delivery of an event is sent.
+type webhookPayload struct {
+	Id          string      `json:"id"`
+	Type        string      `json:"type"`
+	Subject     string      `json:"subject"`
+	Occurred_At time.Time   `json:"occurred_at"`
+	Data        interface{} `json:"data"`
+}
+
+// Webhooks sends published events to the URLs subscribed to them.
+//
+// Events go through an outbox, and a relay turns each into one delivery per
+// subscription, in the same transaction that marks it dispatched. With
+// MongoDB the outbox is fed by the change stream, which doesn't move past a
+// change until its event is recorded, so a committed change can't be missed
+// between the commit and the outbox; in memory events are recorded as they
+// are published. Deliveries are retried with exponential backoff and
+// dead-lettered after webhookMaxAttempts, so a subscriber being down loses
+// nothing.
+type Webhooks struct {
+	store  WebhookStore
+	client *http.Client
+	wake   chan struct{}
+	// alive is when Run last made progress, in Unix nanoseconds.
+	alive atomic.Int64
+}
+
+func NewWebhooks(store WebhookStore) *Webhooks {
+	return &Webhooks{store: store, client: &http.Client{Timeout: 10 * time.Second}, wake: make(chan struct{}, 1)}
+}
+
+// Subscribe records the events published on bus in the outbox. It is for
+// the memory backend, where nothing outlives the process anyway.
+func (w *Webhooks) Subscribe(bus *events.Bus) {
+	bus.Subscribe(w.handle)
+}
+
+func (w *Webhooks) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	subscriptions, err := w.store.ListSubscriptions(ctx)
+	if subscriptions == nil {
+		subscriptions = []models.WebhookSubscription{}
+	}
+	return subscriptions, err
+}
+
+func (w *Webhooks) FindSubscription(ctx context.Context, subscriptionId string) (models.WebhookSubscription, error) {
+	return w.store.FindSubscription(ctx, subscriptionId)
+}
+
+func (w *Webhooks) CreateSubscription(ctx context.Context, req WebhookSubscriptionRequest, by *models.Actor) (models.WebhookSubscription, error) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	subscription := models.WebhookSubscription{
+		ID:          primitive.NewObjectID(),
+		Url:         req.Url,
+		Secret:      req.Secret,
+		Event_Types: req.Event_Types,
+		Active:      req.Active == nil || *req.Active,
+		Created_At:  now,
+		Updated_At:  now,
+		Version:     1,
+		Created_By:  by,
+	}
+	subscription.Subscription_id = subscription.ID.Hex()
+
+	if err := w.store.InsertSubscription(ctx, subscription); err != nil {
+		return subscription, err
+	}
+	return subscription, nil
+}
+
+// UpdateSubscription applies change to the subscription if it is still at
+// version. Deliveries already queued go out with the new URL and secret.
+func (w *Webhooks) UpdateSubscription(ctx context.Context, subscriptionId string, version int64, change WebhookSubscriptionUpdate, by *models.Actor) (models.WebhookSubscription, error) {
+	subscription, err := w.store.FindSubscription(ctx, subscriptionId)
+	if err != nil {
+		return subscription, err
+	}
+	if subscription.Version != version {
+		return subscription, ErrWebhookSubscriptionChanged
+	}
+
+	if change.Url != nil {
+		subscription.Url = *change.Url
+	}
+	if change.Secret != nil {
+		subscription.Secret = *change.Secret
+	}
+	if change.Event_Types != nil {
+		subscription.Event_Types = change.Event_Types
+	}
+	if change.Active != nil {
+		subscription.Active = *change.Active
+	}
+
+	subscription.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	subscription.Updated_By = by
+	subscription.Version++
+
+	if err := w.store.ReplaceSubscription(ctx, subscription, version); err != nil {
+		return subscription, err
+	}
+	return subscription, nil
+}
+
+// DeleteSubscription stops sending events to the subscription. Deliveries
+// still queued for it are dead-lettered.
+func (w *Webhooks) DeleteSubscription(ctx context.Context, subscriptionId string, version int64) error {
+	return w.store.DeleteSubscription(ctx, subscriptionId, version)
+}
+
+func (w *Webhooks) ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+
+	deliveries, err := w.store.ListDeliveries(ctx, filter)
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	return deliveries, err
+}
+
+func (w *Webhooks) FindDelivery(ctx context.Context, deliveryId string) (models.WebhookDelivery, error) {
+	return w.store.FindDelivery(ctx, deliveryId)
+}
+
+// Redeliver sends a dead-lettered or already delivered event again, with a
+// fresh set of attempts.
+func (w *Webhooks) Redeliver(ctx context.Context, deliveryId string) (models.WebhookDelivery, error) {
+	delivery, err := w.store.Redeliver(ctx, deliveryId, time.Now())
+	if err != nil {
+		return delivery, err
+	}
+
+	w.nudge()
+	return delivery, nil
+}
+
+// Run relays the outbox and sends due deliveries until ctx is cancelled.
+// Several instances can run it at once.
+func (w *Webhooks) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPoll)
+	defer ticker.Stop()
+
+	for {
+		w.beat()
+		w.relay(ctx)
+		w.deliver(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// Check is the readiness check for Run. It fails until Run has started and
+// once Run has made no progress for webhookStalled, because it is stuck or
+// has stopped.
+func (w *Webhooks) Check(ctx context.Context) error {
+	alive := w.alive.Load()
+	if alive == 0 {
+		return errors.New("the webhook dispatcher is not running")
+	}
+	if since := time.Since(time.Unix(0, alive)); since > webhookStalled {
+		return fmt.Errorf("the webhook dispatcher has made no progress for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (w *Webhooks) beat() {
+	w.alive.Store(time.Now().UnixNano())
+}
+
+// handle records the event in the outbox as it is published in process.
+func (w *Webhooks) handle(ctx context.Context, event events.Event) {
+	// The request that published the event may be cancelled the moment it
+	// has answered, but the event is already committed.
+	if err := w.Record(context.WithoutCancel(ctx), event); err != nil {
+		logging.FromContext(ctx).Error("could not record event for webhooks", "event", event.Type, "subject", event.Subject, "error", err)
+	}
+}
+
+// Record adds the event to the outbox, unless no subscription could ask for
+// it. Once it is there the event is sent even if this instance stops before
+// sending it. An event that can't be encoded is logged and dropped, since
+// recording it again would fail the same way.
+func (w *Webhooks) Record(ctx context.Context, event events.Event) error {
+	if !isWebhookEvent(event.Type) {
+		return nil
+	}
+
+	payload, err := json.Marshal(webhookPayload{Id: event.Id, Type: string(event.Type), Subject: event.Subject, Occurred_At: event.Occurred_At, Data: event.Data})
+	if err != nil {
+		logging.FromContext(ctx).Error("could not encode event for webhooks", "event", event.Type, "subject", event.Subject, "error", err)
+		return nil
+	}
+
+	outbox := models.OutboxEvent{ID: primitive.NewObjectID(), Event_id: event.Id, Event_type: string(event.Type), Payload: string(payload), Occurred_At: event.Occurred_At}
+	if err := w.store.RecordEvent(ctx, outbox); err != nil {
+		return err
+	}
+
+	w.nudge()
+	return nil
+}
+
+// nudge wakes Run without waiting for its next poll.
+func (w *Webhooks) nudge() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// relay turns the events in the outbox into deliveries.
+func (w *Webhooks) relay(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	for ctx.Err() == nil {
+		pending, err := w.store.PendingEvents(ctx, outboxBatch)
+		if err != nil {
+			logger.Error("could not read webhook outbox", "error", err)
+			return
+		}
+		w.beat()
+
+		for _, event := range pending {
+			if err := w.dispatch(ctx, event); err != nil && !errors.Is(err, ErrOutboxDispatched) {
+				logger.Error("could not dispatch event to webhooks", "event_id", event.Event_id, "error", err)
+				return
+			}
+		}
+
+		if len(pending) < outboxBatch {
+			return
+		}
+	}
+}
+
+func (w *Webhooks) dispatch(ctx context.Context, event models.OutboxEvent) error {
+	subscriptions, err := w.store.Subscribers(ctx, event.Event_type)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		delivery := models.WebhookDelivery{
+			ID:              primitive.NewObjectID(),
+			Subscription_id: subscription.Subscription_id,
+			Event_id:        event.Event_id,
+			Event_type:      event.Event_type,
+			Payload:         event.Payload,
+			Status:          WebhookPending,
+			Next_Attempt_At: now,
+			Created_At:      now,
+		}
+		delivery.Delivery_id = delivery.ID.Hex()
+		deliveries = append(deliveries, delivery)
+	}
+
+	return w.store.Dispatch(ctx, event.Event_id, deliveries, now)
+}
+
+// deliver sends the deliveries that are due, a few at a time.
+func (w *Webhooks) deliver(ctx context.Context) {
+	workers := make(chan struct{}, webhookWorkers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for ctx.Err() == nil {
+		delivery, err := w.store.ClaimDelivery(ctx, time.Now(), webhookLease)
+		if errors.Is(err, ErrNoDueDelivery) {
+			return
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("could not claim webhook delivery", "error", err)
+			return
+		}
+
+		workers <- struct{}{}
+		w.beat()
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			w.attempt(ctx, delivery)
+		}()
+	}
+}
+
+func (w *Webhooks) attempt(ctx context.Context, delivery models.WebhookDelivery) {
+	logger := logging.FromContext(ctx).With("delivery_id", delivery.Delivery_id, "subscription_id", delivery.Subscription_id, "event", delivery.Event_type)
+
+	now := time.Now()
+	code, err := w.send(ctx, delivery, now)
+	if ctx.Err() != nil {
+		// Shutting down; the lease runs out and the delivery is tried again.
+		return
+	}
+
+	delivery.Attempts++
+	delivery.Last_Attempt_At = &now
+	delivery.Last_Status_Code = code
+	delivery.Last_Error = ""
+
+	switch {
+	case err == nil:
+		delivery.Status = WebhookDelivered
+		delivery.Delivered_At = &now
+	case delivery.Attempts >= webhookMaxAttempts || errors.Is(err, ErrWebhookSubscriptionNotFound) || errors.Is(err, errSubscriptionInactive):
+		delivery.Status = WebhookDead
+		delivery.Last_Error = err.Error()
+		logger.Warn("webhook delivery dead-lettered", "attempts", delivery.Attempts, "error", err)
+	default:
+		delivery.Last_Error = err.Error()
+		delivery.Next_Attempt_At = now.Add(webhookBackoff(delivery.Attempts))
+	}
+
+	if err := w.store.SaveDelivery(ctx, delivery); err != nil {
+		logger.Error("could not record webhook delivery attempt", "error", err)
+	}
+}
+
+// send posts the delivery to its subscription's URL and returns the status
+// code the subscriber answered with.
+func (w *Webhooks) send(ctx context.Context, delivery models.WebhookDelivery, now time.Time) (int, error) {
+	subscription, err := w.store.FindSubscription(ctx, delivery.Subscription_id)
+	if err != nil {
+		return 0, err
+	}
+	if !subscription.Active {
+		return 0, errSubscriptionInactive
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", delivery.Event_id)
+	req.Header.Set("X-Webhook-Event", delivery.Event_type)
+	req.Header.Set("X-Webhook-Delivery", delivery.Delivery_id)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, now, []byte(delivery.Payload)))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("subscriber answered %s: %s", resp.Status, detail)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the WebhookSignatureHeader value for body sent at t.
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is how long to wait after a delivery's attempts-th failed
+// attempt: webhookFirstRetry, doubling each time, up to webhookMaxRetry.
+func webhookBackoff(attempts int) time.Duration {
+	wait := webhookFirstRetry
+	for i := 1; i < attempts && wait < webhookMaxRetry; i++ {
+		wait *= 2
+	}
+	if wait > webhookMaxRetry {
+		wait = webhookMaxRetry
+	}
+	return wait
+}
+
+func isWebhookEvent(eventType events.Type) bool {
+	for _, t := range WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teandresmith/restaurant-project/events"
+	"github.com/teandresmith/restaurant-project/models"
+)
+
+// webhookReceiver is a subscriber that answers every delivery with status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newWebhookReceiver(t *testing.T, status int) (*webhookReceiver, *httptest.Server) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, string(body))
+		receiver.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return receiver, server
+}
+
+func (r *webhookReceiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// newTestWebhooks returns webhooks with one subscription to invoice.paid at
+// url, and the subscription's id.
+func newTestWebhooks(t *testing.T, url string) (*Webhooks, *MemoryWebhookStore, string) {
+	t.Helper()
+
+	store := NewMemoryWebhookStore()
+	webhooks := NewWebhooks(store)
+
+	subscription, err := webhooks.CreateSubscription(context.Background(), WebhookSubscriptionRequest{
+		Url:         url,
+		Secret:      "0123456789abcdef",
+		Event_Types: []string{string(events.InvoicePaid)},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webhooks, store, subscription.Subscription_id
+}
+
+// onlyDelivery relays the outbox and returns the one delivery it made.
+func onlyDelivery(t *testing.T, webhooks *Webhooks, store *MemoryWebhookStore) models.WebhookDelivery {
+	t.Helper()
+
+	webhooks.relay(context.Background())
+	deliveries, _ := store.ListDeliveries(context.Background(), WebhookDeliveryFilter{Limit: 10})
+	if len(deliveries) != 1 {
+		t.Fatalf("relayed %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		9:  128 * time.Minute,
+		10: 256 * time.Minute,
+		11: webhookMaxRetry,
+		50: webhookMaxRetry,
+	} {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookDelivered(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusNoContent)
+	webhooks, store, _ := newTestWebhooks(t, server.URL)
+	ctx := context.Background()
+
+	event := events.Event{Id: "e1", Type: events.InvoicePaid, Subject: "i1", Occurred_At: fixedTime, Data: map[string]string{"invoice_id": "i1"}}
+	if err := webhooks.Record(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	// Events no one can subscribe to aren't recorded, and repeats of one
+	// that was are relayed once.
+	webhooks.Record(ctx, events.Event{Id: "e2", Type: "order.archived"})
+	webhooks.Record(ctx, event)
+
+	delivery := onlyDelivery(t, webhooks, store)
+	webhooks.deliver(ctx)
+
+	delivery, _ = store.FindDelivery(ctx, delivery.Delivery_id)
+	if delivery.Status != WebhookDelivered || delivery.Attempts != 1 || delivery.Last_Status_Code != http.StatusNoContent {
+		t.Errorf("delivery is %s after %d attempts with %d, want %s after 1 with 204", delivery.Status, delivery.Attempts, delivery.Last_Status_Code, WebhookDelivered)
+	}
+
+	if receiver.count() != 1 {
+		t.Fatalf("subscriber was sent %d requests, want 1", receiver.count())
+	}
+	req, body := receiver.requests[0], receiver.bodies[0]
+	if req.Header.Get("X-Webhook-Id") != "e1" || req.Header.Get("X-Webhook-Event") != string(events.InvoicePaid) {
+		t.Errorf("headers = %v, want the event's id and type", req.Header)
+	}
+	if !strings.Contains(body, `"subject":"i1"`) || !strings.Contains(body, `"invoice_id":"i1"`) {
+		t.Errorf("body = %s, want the event", body)
+	}
+
+	// The signature is over the timestamp it carries and the body.
+	signature := req.Header.Get(WebhookSignatureHeader)
+	var sent int64
+	for _, part := range strings.Split(signature, ",") {
+		if strings.HasPrefix(part, "t=") {
+			sent, _ = strconv.ParseInt(part[2:], 10, 64)
+		}
+	}
+	if want := SignWebhook("0123456789abcdef", time.Unix(sent, 0), []byte(body)); signature != want {
+		t.Errorf("signature = %s, want %s", signature, want)
+	}
+}
+
+// TestWebhookEndToEnd publishes an event on a bus the webhooks subscribe
+// to, as the memory backend does, and follows it through the outbox to the
+// subscriber.
+func TestWebhookEndToEnd(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusOK)
+	webhooks, store, subscriptionId := newTestWebhooks(t, server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := events.NewBus()
+	webhooks.Subscribe(bus)
+	bus.Publish(ctx, events.Event{Id: "e1", Type: events.InvoicePaid, Subject: "i1", Occurred_At: fixedTime, Data: map[string]string{"invoice_id": "i1"}})
+
+	pending, err := store.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Event_id != "e1" || pending[0].Event_type != string(events.InvoicePaid) {
+		t.Fatalf("outbox = %+v, want the published event", pending)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		webhooks.Run(ctx)
+		close(done)
+	}()
+	delivered := func() bool {
+		deliveries, _ := store.ListDeliveries(ctx, WebhookDeliveryFilter{Status: WebhookDelivered, Limit: 10})
+		return len(deliveries) > 0
+	}
+	for deadline := time.Now().Add(5 * time.Second); !delivered(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the event was never delivered")
+		}
+	}
+	cancel()
+	<-done
+
+	if pending, _ := store.PendingEvents(context.Background(), 10); len(pending) != 0 {
+		t.Errorf("outbox still holds %d events after relaying", len(pending))
+	}
+	if delivery := onlyDelivery(t, webhooks, store); delivery.Subscription_id != subscriptionId {
+		t.Errorf("delivered to %s, want %s", delivery.Subscription_id, subscriptionId)
+	}
+	if receiver.count() != 1 {
+		t.Fatalf("subscriber was sent %d requests, want 1", receiver.count())
+	}
+
+	req, body := receiver.requests[0], receiver.bodies[0]
+	if req.Header.Get("X-Webhook-Id") != "e1" || !strings.Contains(body, `"invoice_id":"i1"`) {
+		t.Errorf("subscriber was sent %s with id %q, want event e1", body, req.Header.Get("X-Webhook-Id"))
+	}
+
+	// Subscribers check the signature as the docs describe: an HMAC-SHA256,
+	// keyed with their secret, of the timestamp, a dot and the body.
+	var timestamp, signature string
+	for _, part := range strings.Split(req.Header.Get(WebhookSignatureHeader), ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	mac := hmac.New(sha256.New, []byte("0123456789abcdef"))
+	mac.Write([]byte(timestamp + "." + body))
+	if want := hex.EncodeToString(mac.Sum(nil)); timestamp == "" || signature != want {
+		t.Errorf("%s = %q, want t=<timestamp>,v1=%s", WebhookSignatureHeader, req.Header.Get(WebhookSignatureHeader), want)
+	}
+}
+
+func TestWebhookRetriesThenDeadLetters(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	webhooks, store, _ := newTestWebhooks(t, server.URL)
+	ctx := context.Background()
+
+	webhooks.Record(ctx, events.Event{Id: "e1", Type: events.InvoicePaid, Subject: "i1"})
+	delivery := onlyDelivery(t, webhooks, store)
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		before := time.Now()
+		webhooks.deliver(ctx)
+
+		delivery, _ = store.FindDelivery(ctx, delivery.Delivery_id)
+		if delivery.Attempts != attempt || delivery.Last_Status_Code != http.StatusServiceUnavailable {
+			t.Fatalf("after attempt %d: %d attempts recorded, last answered %d", attempt, delivery.Attempts, delivery.Last_Status_Code)
+		}
+		if attempt == webhookMaxAttempts {
+			break
+		}
+
+		if delivery.Status != WebhookPending {
+			t.Fatalf("after attempt %d the delivery is %s, want it still %s", attempt, delivery.Status, WebhookPending)
+		}
+		wait := delivery.Next_Attempt_At.Sub(before)
+		if backoff := webhookBackoff(attempt); wait < backoff || wait > backoff+time.Minute {
+			t.Fatalf("after attempt %d the next is due in %v, want %v", attempt, wait, backoff)
+		}
+
+		// Nothing is sent again before it is due.
+		webhooks.deliver(ctx)
+		if receiver.count() != attempt {
+			t.Fatalf("sent %d requests after %d attempts", receiver.count(), attempt)
+		}
+
+		delivery.Next_Attempt_At = time.Now()
+		store.SaveDelivery(ctx, delivery)
+	}
+
+	if delivery.Status != WebhookDead || !strings.Contains(delivery.Last_Error, "503") {
+		t.Errorf("delivery is %s with %q, want %s with the subscriber's answer", delivery.Status, delivery.Last_Error, WebhookDead)
+	}
+
+	dead, _ := webhooks.ListDeliveries(ctx, WebhookDeliveryFilter{Status: WebhookDead})
+	if len(dead) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(dead))
+	}
+
+	redelivered, err := webhooks.Redeliver(ctx, delivery.Delivery_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redelivered.Status != WebhookPending || redelivered.Attempts != 0 {
+		t.Errorf("redelivered delivery is %s with %d attempts, want %s with none", redelivered.Status, redelivered.Attempts, WebhookPending)
+	}
+}
+
+func TestWebhookInactiveSubscriptionDeadLetters(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusOK)
+	webhooks, store, subscriptionId := newTestWebhooks(t, server.URL)
+	ctx := context.Background()
+
+	webhooks.Record(ctx, events.Event{Id: "e1", Type: events.InvoicePaid, Subject: "i1"})
+	delivery := onlyDelivery(t, webhooks, store)
+
+	inactive := false
+	if _, err := webhooks.UpdateSubscription(ctx, subscriptionId, 1, WebhookSubscriptionUpdate{Active: &inactive}, nil); err != nil {
+		t.Fatal(err)
+	}
+	webhooks.deliver(ctx)
+
+	delivery, _ = store.FindDelivery(ctx, delivery.Delivery_id)
+	if delivery.Status != WebhookDead || delivery.Attempts != 1 {
+		t.Errorf("delivery is %s after %d attempts, want %s after 1", delivery.Status, delivery.Attempts, WebhookDead)
+	}
+	if receiver.count() != 0 {
+		t.Errorf("an inactive subscription was sent %d requests", receiver.count())
+	}
+}
+
+func TestWebhookCheck(t *testing.T) {
+	webhooks := NewWebhooks(NewMemoryWebhookStore())
+	if err := webhooks.Check(context.Background()); err == nil {
+		t.Error("check passed before Run started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		webhooks.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for webhooks.Check(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("check still failing once Run started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// Run last got round to the outbox longer ago than it may.
+	webhooks.alive.Store(time.Now().Add(-webhookStalled - time.Second).UnixNano())
+	if err := webhooks.Check(context.Background()); err == nil {
+		t.Error("check passed for a stalled dispatcher")
+	}
+}
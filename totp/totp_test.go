@@ -0,0 +1,118 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, cut to six digits.
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("code at %d = %s, want %s", unix, code, want)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a secret that isn't base32")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	codeAt := func(step int64) string {
+		code, _ := Code(rfcSecret, step)
+		return code
+	}
+
+	tests := []struct {
+		name  string
+		code  string
+		valid bool
+	}{
+		{"current", codeAt(step), true},
+		{"typed with spaces", codeAt(step)[:3] + " " + codeAt(step)[3:], true},
+		{"one period behind", codeAt(step - 1), true},
+		{"one period ahead", codeAt(step + 1), true},
+		{"two periods behind", codeAt(step - 2), false},
+		{"too short", codeAt(step)[:5], false},
+		{"wrong", "000000", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, valid := Validate(rfcSecret, test.code, now, 1)
+			if valid != test.valid {
+				t.Errorf("Validate(%q) = %v, want %v", test.code, valid, test.valid)
+			}
+		})
+	}
+
+	if matched, _ := Validate(rfcSecret, codeAt(step-1), now, 1); matched != step-1 {
+		t.Errorf("matched step %d, want %d", matched, step-1)
+	}
+}
+
+func TestValidateAfterRefusesReplays(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code, _ := Code(rfcSecret, step)
+
+	used, ok := ValidateAfter(rfcSecret, code, now, 1, 0)
+	if !ok || used != step {
+		t.Fatalf("first use = %d, %v, want step %d accepted", used, ok, step)
+	}
+
+	// The same code, or an older one still in the skew window, is refused
+	// once its step has been used.
+	if _, ok := ValidateAfter(rfcSecret, code, now.Add(time.Second), 1, used); ok {
+		t.Error("the same code was accepted twice")
+	}
+	previous, _ := Code(rfcSecret, step-1)
+	if _, ok := ValidateAfter(rfcSecret, previous, now, 1, used); ok {
+		t.Error("a code older than the last one used was accepted")
+	}
+
+	// The next period's code still works.
+	next, _ := Code(rfcSecret, step+1)
+	if _, ok := ValidateAfter(rfcSecret, next, now.Add(Period), 1, used); !ok {
+		t.Error("the next code was refused")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI(rfcSecret, "Restaurant Project", "ada@example.com")
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/Restaurant Project:ada@example.com" {
+		t.Errorf("URI = %s, want an otpauth://totp/ URI labelled issuer:account", uri)
+	}
+	query := parsed.Query()
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "Restaurant Project" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("query = %v", query)
+	}
+	if strings.Contains(uri, "+") {
+		t.Errorf("URI = %s, want spaces encoded as %%20", uri)
+	}
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware continues the trace described by an incoming traceparent header,
+// or starts a new one, and wraps the rest of the request in a server span.
+// The span is stored in the request context, so handlers must pass
+// c.Request.Context() down to the database for its spans to join the trace.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		spanName := c.Request.Method + " " + route
+		if route == "" {
+			spanName = c.Request.Method
+		}
+
+		ctx, span := tracer().Start(ctx, spanName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(serverAttributes(route, c.Request)...),
+			trace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", c.Request)...),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		code, message := semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer)
+		span.SetStatus(code, message)
+
+		if err := c.Request.Context().Err(); err != nil {
+			// The client went away before the response was written.
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+}
+
+// serverAttributes describes the request for its span. The target is the path
+// alone, as in the access log, since query strings can carry tokens.
+func serverAttributes(route string, req *http.Request) []attribute.KeyValue {
+	attributes := semconv.HTTPServerAttributesFromHTTPRequest("", route, req)
+	for i, kv := range attributes {
+		if kv.Key == semconv.HTTPTargetKey {
+			attributes[i] = semconv.HTTPTargetKey.String(req.URL.Path)
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MongoMonitor returns a command monitor that records a client span for every
+// command, parented to the span in the context the command was run with.
+func MongoMonitor() *event.CommandMonitor {
+	var inFlight sync.Map
+
+	finish := func(connectionID string, requestID int64) trace.Span {
+		span, ok := inFlight.LoadAndDelete(commandKey{connectionID, requestID})
+		if !ok {
+			return nil
+		}
+		return span.(trace.Span)
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			collection, _ := evt.Command.Lookup(evt.CommandName).StringValueOK()
+
+			spanName := evt.CommandName
+			if collection != "" {
+				spanName = collection + "." + evt.CommandName
+			}
+
+			_, span := tracer().Start(ctx, spanName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemMongoDB,
+					semconv.DBNameKey.String(evt.DatabaseName),
+					semconv.DBOperationKey.String(evt.CommandName),
+					semconv.DBMongoDBCollectionKey.String(collection),
+				),
+			)
+			inFlight.Store(commandKey{evt.ConnectionID, evt.RequestID}, span)
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			if span := finish(evt.ConnectionID, evt.RequestID); span != nil {
+				span.End()
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			if span := finish(evt.ConnectionID, evt.RequestID); span != nil {
+				span.SetStatus(codes.Error, evt.Failure)
+				span.End()
+			}
+		},
+	}
+}
+
+type commandKey struct {
+	connectionID string
+	requestID    int64
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/teandresmith/restaurant-project"
+	serviceName         = "restaurant-project"
+)
+
+// tracer is resolved through the global provider on every use so it picks up
+// the provider installed by Setup.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup installs the W3C trace-context propagator and a tracer provider
+// chosen by OTEL_TRACES_EXPORTER:
+//
+//   - "none" or unset: trace context is still propagated, but no spans are
+//     recorded.
+//   - "stdout": spans are written to stdout as JSON.
+//   - "file": spans are appended as JSON to OTEL_TRACES_FILE (default
+//     traces.json), for reading them from outside the process.
+//
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	setPropagator()
+
+	var writer io.Writer
+	var file *os.File
+
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		writer = os.Stdout
+	case "file":
+		path := os.Getenv("OTEL_TRACES_FILE")
+		if path == "" {
+			path = "traces.json"
+		}
+
+		var err error
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: could not open %s: %w", path, err)
+		}
+		writer = file
+	default:
+		return nil, fmt.Errorf("tracing: unknown OTEL_TRACES_EXPORTER %q", exporter)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(writer))
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := install(sdktrace.WithBatcher(exporter))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Install records spans with exporter instead of the one OTEL_TRACES_EXPORTER
+// names, handing each over as soon as it ends. Tests use it with an
+// in-memory exporter to read the spans a request made. The returned function
+// stops the exporter.
+func Install(exporter sdktrace.SpanExporter) (func(context.Context) error, error) {
+	setPropagator()
+
+	provider, err := install(sdktrace.WithSyncer(exporter))
+	if err != nil {
+		return nil, err
+	}
+	return provider.Shutdown, nil
+}
+
+func setPropagator() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// install makes a provider sending spans through export the global one.
+func install(export sdktrace.TracerProviderOption) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(export, sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider, nil
+}